@@ -179,8 +179,10 @@ type LinuxParams struct {
 	Pages         uint16    `offset:"0x32"`
 	_             [12]uint8 `offset:"0x34"` //-- 0x3f reserved for future expansion
 
-	// struct apmbiosinfo apmbiosinfo;
-	Apmbiosinfo [0x40]uint8 `offset:"0x40"`
+	// struct apmbiosinfo apmbiosinfo, tboot_addr and struct ist_info;
+	Apmbiosinfo  [0x30]uint8 `offset:"0x40"`
+	AcpiRsdpAddr uint64      `offset:"0x70"` // 2.14+
+	_            [8]uint8    `offset:"0x78"`
 	// struct driveinfostruct driveinfo;
 	Driveinfo [0x20]uint8 `offset:"0x80"`
 	// struct sysdesctable sysdesctable;
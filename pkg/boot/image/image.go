@@ -2,8 +2,8 @@
 // Use of this source code is governed by a BSD-style
 // license that can be found in the LICENSE file.
 
-// Package image contains a parser for Arm64 Linux Image format. It
-// assumes little endian arm.
+// Package image contains parsers for the Arm64 and RISC-V Linux Image
+// formats. It assumes little endian kernels.
 package image
 
 import (
@@ -17,6 +17,9 @@ import (
 const (
 	// Magic values used in Image header.
 	Magic = 0x644d5241
+
+	// RISCVMagic2 is the magic of the RISC-V Image header, "RSC\x05".
+	RISCVMagic2 = 0x05435352
 )
 
 var (
@@ -24,6 +27,7 @@ var (
 
 	errBadMagic      = errors.New("bad header magic")
 	errBadEndianness = errors.New("invalid Image endianness, expected little")
+	errNoImageSize   = errors.New("no image_size in Image header")
 )
 
 // Arm64Header is header for Arm64 Image.
@@ -76,3 +80,51 @@ func ParseFromBytes(data []byte) (*Image, error) {
 
 	return img, nil
 }
+
+// RISCVHeader is header for RISC-V Image, see
+// Documentation/arch/riscv/boot-image-header.rst.
+type RISCVHeader struct {
+	Code0      uint32 `offset:"0x00"`
+	Code1      uint32 `offset:"0x04"`
+	TextOffset uint64 `offset:"0x08"`
+	ImageSize  uint64 `offset:"0x10"`
+	Flags      uint64 `offset:"0x18"`
+	Version    uint32 `offset:"0x20"`
+	Res1       uint32 `offset:"0x24"`
+	Res2       uint64 `offset:"0x28"`
+	Magic      uint64 `offset:"0x30"` // Deprecated "RISCV".
+	Magic2     uint32 `offset:"0x38"`
+	Res3       uint32 `offset:"0x3c"`
+}
+
+// RISCVImage abstracts RISC-V Image.
+type RISCVImage struct {
+	Header RISCVHeader
+	Data   []byte
+}
+
+// ParseRISCVFromBytes parses a RISC-V Image from bytes slice.
+func ParseRISCVFromBytes(data []byte) (*RISCVImage, error) {
+	img := &RISCVImage{}
+
+	if err := binary.Read(bytes.NewBuffer(data), binary.LittleEndian, &img.Header); err != nil {
+		return img, fmt.Errorf("unmarshaling riscv header: %w", err)
+	}
+
+	if img.Header.Magic2 != RISCVMagic2 {
+		return img, errBadMagic
+	}
+
+	// Every header version with magic2, 0.2 and up, has image_size.
+	if img.Header.ImageSize == 0 {
+		return img, errNoImageSize
+	}
+
+	if int(img.Header.Flags&0x1) != 0 {
+		return img, errBadEndianness
+	}
+
+	img.Data = data
+
+	return img, nil
+}
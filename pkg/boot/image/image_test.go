@@ -5,6 +5,9 @@
 package image
 
 import (
+	"bytes"
+	"encoding/binary"
+	"errors"
 	"os"
 	"reflect"
 	"testing"
@@ -41,3 +44,60 @@ func TestParseFromBytes(t *testing.T) {
 		t.Errorf("got %+v, want %+v", got.Header, wantImage.Header)
 	}
 }
+
+// riscvImage returns a RISC-V Image header followed by code.
+func riscvImage(h RISCVHeader) []byte {
+	var b bytes.Buffer
+	if err := binary.Write(&b, binary.LittleEndian, h); err != nil {
+		panic(err)
+	}
+	return append(b.Bytes(), "code"...)
+}
+
+func TestParseRISCVFromBytes(t *testing.T) {
+	header := RISCVHeader{
+		TextOffset: 0x200000,
+		ImageSize:  0x1400000,
+		Version:    0x2,
+		Magic:      0x5643534952,
+		Magic2:     RISCVMagic2,
+	}
+	data := riscvImage(header)
+
+	got, err := ParseRISCVFromBytes(data)
+	if err != nil {
+		t.Fatalf("ParseRISCVFromBytes = %v, want nil", err)
+	}
+	if !reflect.DeepEqual(got.Header, header) {
+		t.Errorf("got %+v, want %+v", got.Header, header)
+	}
+	if !bytes.Equal(got.Data, data) {
+		t.Errorf("Data = %x, want %x", got.Data, data)
+	}
+
+	for _, tt := range []struct {
+		name string
+		data []byte
+		want error
+	}{
+		{name: "arm64", data: readArm64Image(t), want: errBadMagic},
+		{name: "no image_size", data: riscvImage(RISCVHeader{Magic2: RISCVMagic2}), want: errNoImageSize},
+		{name: "big endian", data: riscvImage(RISCVHeader{ImageSize: 0x1000, Flags: 1, Magic2: RISCVMagic2}), want: errBadEndianness},
+	} {
+		if _, err := ParseRISCVFromBytes(tt.data); !errors.Is(err, tt.want) {
+			t.Errorf("%s: ParseRISCVFromBytes = %v, want %v", tt.name, err, tt.want)
+		}
+	}
+	if _, err := ParseRISCVFromBytes(data[:32]); err == nil {
+		t.Errorf("ParseRISCVFromBytes(truncated) = nil, want an error")
+	}
+}
+
+func readArm64Image(t *testing.T) []byte {
+	t.Helper()
+	b, err := os.ReadFile("testdata/Image")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return b
+}
@@ -0,0 +1,126 @@
+// Copyright 2024 the u-root Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package kexec
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+var (
+	vmcoreInfoPath = "/sys/kernel/vmcoreinfo"
+	cpuSysfsRoot   = "/sys/devices/system/cpu"
+)
+
+// defaultCrashNotesSize is the size of a CPU's crash notes if the kernel does
+// not show it, like kexec-tools assumes.
+const defaultCrashNotesSize = 4096
+
+// ErrNoCrashInfo is returned by VMCoreInfo and CrashNotes if the kernel does
+// not provide the information, e.g. because it lacks CONFIG_CRASH_DUMP.
+var ErrNoCrashInfo = errors.New("kernel does not provide crash dump information")
+
+// VMCoreInfo returns the physical address and size of the vmcoreinfo note,
+// which describes the running kernel to kdump tools, as shown in
+// /sys/kernel/vmcoreinfo.
+func VMCoreInfo() (uintptr, uintptr, error) {
+	return vmcoreInfo(vmcoreInfoPath)
+}
+
+func vmcoreInfo(path string) (uintptr, uintptr, error) {
+	b, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return 0, 0, fmt.Errorf("%w: %w", ErrNoCrashInfo, err)
+	}
+	if err != nil {
+		return 0, 0, err
+	}
+
+	// The kernel shows "%lx %x\n".
+	fields := strings.Fields(string(b))
+	if len(fields) != 2 {
+		return 0, 0, fmt.Errorf("%s: want address and size, got %q", path, b)
+	}
+	addr, err := strconv.ParseUint(fields[0], 16, 64)
+	if err != nil {
+		return 0, 0, fmt.Errorf("%s: %w", path, err)
+	}
+	size, err := strconv.ParseUint(fields[1], 16, 64)
+	if err != nil {
+		return 0, 0, fmt.Errorf("%s: %w", path, err)
+	}
+	if addr == 0 || size == 0 {
+		return 0, 0, fmt.Errorf("%w: %s is %q", ErrNoCrashInfo, path, strings.TrimSpace(string(b)))
+	}
+	return uintptr(addr), uintptr(size), nil
+}
+
+// CrashNotes returns the physical ranges of the per-CPU crash notes, in which
+// the kernel saves the registers of each CPU on a crash, sorted by CPU
+// number. They are read from /sys/devices/system/cpu/cpu*/crash_notes.
+func CrashNotes() ([]Range, error) {
+	return crashNotes(cpuSysfsRoot)
+}
+
+func crashNotes(root string) ([]Range, error) {
+	dirs, err := filepath.Glob(filepath.Join(root, "cpu[0-9]*"))
+	if err != nil {
+		return nil, err
+	}
+
+	type cpuNotes struct {
+		cpu int
+		r   Range
+	}
+	var notes []cpuNotes
+	for _, dir := range dirs {
+		cpu, err := strconv.Atoi(strings.TrimPrefix(filepath.Base(dir), "cpu"))
+		if err != nil {
+			continue
+		}
+
+		b, err := os.ReadFile(filepath.Join(dir, "crash_notes"))
+		if errors.Is(err, os.ErrNotExist) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		// The kernel shows "%llx\n".
+		addr, err := strconv.ParseUint(strings.TrimSpace(string(b)), 16, 64)
+		if err != nil {
+			return nil, fmt.Errorf("cpu%d crash_notes: %w", cpu, err)
+		}
+
+		size := uint64(defaultCrashNotesSize)
+		// crash_notes_size is missing from kernels before 3.10.
+		if b, err := os.ReadFile(filepath.Join(dir, "crash_notes_size")); err == nil {
+			if size, err = strconv.ParseUint(strings.TrimSpace(string(b)), 10, 64); err != nil {
+				return nil, fmt.Errorf("cpu%d crash_notes_size: %w", cpu, err)
+			}
+		} else if !errors.Is(err, os.ErrNotExist) {
+			return nil, err
+		}
+		if addr == 0 || size == 0 {
+			return nil, fmt.Errorf("cpu%d has empty crash notes at %#x, size %#x", cpu, addr, size)
+		}
+		notes = append(notes, cpuNotes{cpu: cpu, r: Range{Start: uintptr(addr), Size: uint(size)}})
+	}
+	if len(notes) == 0 {
+		return nil, fmt.Errorf("%w: no crash_notes in %s", ErrNoCrashInfo, root)
+	}
+
+	sort.Slice(notes, func(i, j int) bool { return notes[i].cpu < notes[j].cpu })
+	rs := make([]Range, 0, len(notes))
+	for _, n := range notes {
+		rs = append(rs, n.r)
+	}
+	return rs, nil
+}
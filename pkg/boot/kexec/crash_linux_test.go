@@ -0,0 +1,150 @@
+// Copyright 2024 the u-root Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package kexec
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+// writeFiles creates files with their contents below dir.
+func writeFiles(t *testing.T, dir string, files map[string]string) {
+	t.Helper()
+	for name, content := range files {
+		p := filepath.Join(dir, name)
+		if err := os.MkdirAll(filepath.Dir(p), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(p, []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestVMCoreInfo(t *testing.T) {
+	for _, tt := range []struct {
+		name     string
+		content  string
+		wantAddr uintptr
+		wantSize uintptr
+		wantErr  error
+	}{
+		{
+			name:     "ok",
+			content:  "3e5ed000 1024\n",
+			wantAddr: 0x3e5ed000,
+			wantSize: 0x1024,
+		},
+		{
+			name:    "missing",
+			wantErr: ErrNoCrashInfo,
+		},
+		{
+			name:    "zero",
+			content: "0 0\n",
+			wantErr: ErrNoCrashInfo,
+		},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			if tt.content != "" {
+				writeFiles(t, dir, map[string]string{"vmcoreinfo": tt.content})
+			}
+
+			addr, size, err := vmcoreInfo(filepath.Join(dir, "vmcoreinfo"))
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("vmcoreInfo() = %v, want %v", err, tt.wantErr)
+			}
+			if addr != tt.wantAddr || size != tt.wantSize {
+				t.Errorf("vmcoreInfo() = %#x, %#x, want %#x, %#x", addr, size, tt.wantAddr, tt.wantSize)
+			}
+		})
+	}
+
+	for _, content := range []string{"3e5ed000\n", "3e5ed000 1024 1\n", "xyz 1024\n", "3e5ed000 xyz\n"} {
+		dir := t.TempDir()
+		writeFiles(t, dir, map[string]string{"vmcoreinfo": content})
+		if _, _, err := vmcoreInfo(filepath.Join(dir, "vmcoreinfo")); err == nil {
+			t.Errorf("vmcoreInfo(%q) = nil, want error", content)
+		}
+	}
+}
+
+func TestCrashNotes(t *testing.T) {
+	for _, tt := range []struct {
+		name    string
+		files   map[string]string
+		want    []Range
+		wantErr bool
+	}{
+		{
+			name: "ok",
+			files: map[string]string{
+				"cpu0/crash_notes":       "7fc1cb80\n",
+				"cpu0/crash_notes_size":  "424\n",
+				"cpu1/crash_notes":       "7fc5cb80\n",
+				"cpu1/crash_notes_size":  "424\n",
+				"cpu10/crash_notes":      "7fe1cb80\n",
+				"cpu10/crash_notes_size": "424\n",
+				"cpu2/crash_notes":       "7fc9cb80\n",
+				"cpu2/crash_notes_size":  "424\n",
+				"cpufreq/boost":          "1\n",
+				"cpuidle/current_driver": "intel_idle\n",
+				"online":                 "0-2,10\n",
+			},
+			want: []Range{
+				{Start: 0x7fc1cb80, Size: 424},
+				{Start: 0x7fc5cb80, Size: 424},
+				{Start: 0x7fc9cb80, Size: 424},
+				{Start: 0x7fe1cb80, Size: 424},
+			},
+		},
+		{
+			name: "no size",
+			files: map[string]string{
+				"cpu0/crash_notes": "7fc1cb80\n",
+			},
+			want: []Range{{Start: 0x7fc1cb80, Size: defaultCrashNotesSize}},
+		},
+		{
+			name: "no crash notes",
+			files: map[string]string{
+				"cpu0/online": "1\n",
+			},
+			wantErr: true,
+		},
+		{
+			name: "bad address",
+			files: map[string]string{
+				"cpu0/crash_notes": "0x7fc1cb80\n",
+			},
+			wantErr: true,
+		},
+		{
+			name: "empty",
+			files: map[string]string{
+				"cpu0/crash_notes":      "7fc1cb80\n",
+				"cpu0/crash_notes_size": "0\n",
+			},
+			wantErr: true,
+		},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			writeFiles(t, dir, tt.files)
+
+			got, err := crashNotes(dir)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("crashNotes() = %v, want error %v", err, tt.wantErr)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("crashNotes() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,80 @@
+// Copyright 2024 the u-root Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+//go:build integration
+
+package kexec
+
+// These tests issue real kexec_load calls through TestLoad, so the running
+// kernel validates the segments. They need CAP_SYS_BOOT and replace any
+// kernel loaded with kexec_load, so they only run with the integration tag:
+//
+//	sudo go test -tags integration -run TestIntegration ./pkg/boot/kexec
+
+import (
+	"errors"
+	"testing"
+
+	"golang.org/x/sys/unix"
+)
+
+func sysBootOrSkip(t *testing.T) {
+	t.Helper()
+	ok, err := hasSysBoot()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !ok {
+		t.Skip("skipping, CAP_SYS_BOOT is required")
+	}
+}
+
+// testLoad calls TestLoad, skipping the test if the kernel lacks kexec_load.
+func testLoad(t *testing.T, entry uintptr, segs Segments) error {
+	t.Helper()
+	err := TestLoad(entry, segs, 0)
+	if errors.Is(err, unix.ENOSYS) {
+		t.Skip("skipping, kexec_load is not supported by this kernel")
+	}
+	return err
+}
+
+func TestIntegrationTestLoad(t *testing.T) {
+	sysBootOrSkip(t)
+
+	mm, err := MemoryMapFromIOMem()
+	if err != nil {
+		t.Fatal(err)
+	}
+	r, err := mm.RAM().FindSpace(0x1000, WithMinimumAddr(M1))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	segs := Segments{NewSegment([]byte("kernel"), r)}
+	if err := testLoad(t, r.Start, segs); err != nil {
+		t.Errorf("TestLoad(%s) = %v, want nil", r, err)
+	}
+}
+
+func TestIntegrationTestLoadOutsideRAM(t *testing.T) {
+	sysBootOrSkip(t)
+
+	mm, err := MemoryMapFromIOMem()
+	if err != nil {
+		t.Fatal(err)
+	}
+	ram := mm.RAM()
+	if len(ram) == 0 {
+		t.Fatal("no RAM in the memory map")
+	}
+	// Right past the end of the highest RAM range.
+	last := ram[len(ram)-1]
+	r := Range{Start: last.Start + uintptr(last.Size), Size: 0x1000}
+
+	segs := Segments{NewSegment([]byte("kernel"), r)}
+	if err := testLoad(t, r.Start, segs); !errors.Is(err, ErrSegmentPlacement) {
+		t.Errorf("TestLoad(%s) = %v, want %v", r, err, ErrSegmentPlacement)
+	}
+}
@@ -5,30 +5,228 @@
 package kexec
 
 import (
+	"errors"
 	"fmt"
+	"log"
+	"os"
 	"runtime"
+	"strings"
 	"syscall"
 	"unsafe"
 
 	"golang.org/x/sys/unix"
 )
 
+// MaxSegments is the maximum number of segments kexec_load accepts
+// (KEXEC_SEGMENT_MAX).
+const MaxSegments = 16
+
+// ErrTooManySegments is returned by Load if the segments exceed MaxSegments.
+var ErrTooManySegments = errors.New("too many kexec segments")
+
+// ErrSegmentPlacement is returned by Load if the kernel refused to place the
+// segments at their physical addresses.
+var ErrSegmentPlacement = errors.New("kernel could not place kexec segments")
+
+// ErrKexecDisabled is returned by Load if kexec_load has been disabled with
+// the kernel.kexec_load_disabled sysctl. It cannot be enabled again until
+// reboot.
+var ErrKexecDisabled = errors.New("kexec_load is disabled by kernel.kexec_load_disabled")
+
+// kexecLoadDisabledPath is the kernel.kexec_load_disabled sysctl. Tests
+// replace it.
+var kexecLoadDisabledPath = "/proc/sys/kernel/kexec_load_disabled"
+
+// LoadDisabled returns whether kexec_load has been disabled with the
+// kernel.kexec_load_disabled sysctl.
+func LoadDisabled() (bool, error) {
+	b, err := os.ReadFile(kexecLoadDisabledPath)
+	if err != nil {
+		return false, err
+	}
+	v := strings.TrimSpace(string(b))
+	switch v {
+	case "0":
+		return false, nil
+	case "1":
+		return true, nil
+	}
+	return false, fmt.Errorf("%s: unexpected value %q", kexecLoadDisabledPath, v)
+}
+
+// placementMemoryMap returns the memory map that segments are checked
+// against when the kernel refuses to place them.
+var placementMemoryMap = MemoryMapFromIOMem
+
 // Load loads the given segments into memory to be executed on a kexec-reboot.
 //
 // It is assumed that segments is made up of the next kernel's code and text
 // segments, and that `entry` is the entry point, either kernel entry point or trampoline.
 //
 // Load will align segments to page boundaries and deduplicate overlapping ranges.
+// segments do not need to be sorted. Buffers not starting on a page boundary
+// are copied to one that does, as some kernels reject them otherwise.
+//
+// If kexec_load has been disabled at runtime, Load returns ErrKexecDisabled.
 func Load(entry uintptr, segments Segments, flags uint64) error {
+	return load(entry, segments, flags, false)
+}
+
+// LoadLocked loads segments like Load, but locks the segment buffers in
+// memory with mlock(2) while the kernel copies them, so that they are not
+// paged in from swap one by one.
+//
+// If the buffers cannot be locked, e.g. because they exceed RLIMIT_MEMLOCK,
+// a warning is logged and the segments are loaded regardless.
+func LoadLocked(entry uintptr, segments Segments, flags uint64) error {
+	return load(entry, segments, flags, true)
+}
+
+func load(entry uintptr, segments Segments, flags uint64, lock bool) error {
 	segments, err := AlignAndMerge(segments)
 	if err != nil {
 		return fmt.Errorf("could not align segments: %w", err)
 	}
 
+	// The kernel would reject this with a bare EINVAL.
+	if len(segments) > MaxSegments {
+		return fmt.Errorf("%w: %d segments, at most %d allowed", ErrTooManySegments, len(segments), MaxSegments)
+	}
+
 	if !segments.PhysContains(entry) {
 		return fmt.Errorf("entry point %#v is not contained by any segment", entry)
 	}
-	return rawLoad(entry, segments, flags)
+	segments = alignBuffers(segments)
+	if lock {
+		defer lockSegments(segments)()
+	}
+	if err := rawLoad(entry, segments, flags); err != nil {
+		var kerr ErrKexec
+		if errors.As(err, &kerr) && (kerr.Errno == unix.ENOMEM || kerr.Errno == unix.EADDRNOTAVAIL) {
+			return placementError(segments, err)
+		}
+		// EPERM also means a lack of CAP_SYS_BOOT, so only blame the
+		// sysctl if it is set.
+		if errors.Is(err, unix.EPERM) {
+			if disabled, derr := LoadDisabled(); derr == nil && disabled {
+				return fmt.Errorf("%w: %w", ErrKexecDisabled, err)
+			}
+		}
+		return err
+	}
+	return nil
+}
+
+// alignBuffers returns segments with each buffer starting on a page boundary.
+// Buffers built by AlignAndMerge usually are, others are copied.
+func alignBuffers(segments Segments) Segments {
+	aligned := make(Segments, len(segments))
+	for i, s := range segments {
+		if !isPageAligned(s.Buf) {
+			buf := alignedBuffer(len(s.Buf))
+			copy(buf, s.Buf)
+			s.Buf = buf
+		}
+		aligned[i] = s
+	}
+	return aligned
+}
+
+// mlock and munlock lock and unlock segment buffers. Tests replace them.
+var (
+	mlock   = unix.Mlock
+	munlock = unix.Munlock
+)
+
+// lockSegments locks the buffers of segments in memory and returns a function
+// unlocking them again. Locking stops at the first buffer that cannot be
+// locked, the buffers locked up to then stay locked.
+func lockSegments(segments Segments) func() {
+	var locked [][]byte
+	for _, s := range segments {
+		if len(s.Buf) == 0 {
+			continue
+		}
+		if err := mlock(s.Buf); err != nil {
+			log.Printf("Warning: could not lock kexec segment buffers in memory, they may be swapped out (check RLIMIT_MEMLOCK): %v", err)
+			break
+		}
+		locked = append(locked, s.Buf)
+	}
+	return func() {
+		for _, b := range locked {
+			if err := munlock(b); err != nil {
+				log.Printf("Warning: could not unlock kexec segment buffer: %v", err)
+			}
+		}
+	}
+}
+
+// ErrNoSysBoot is returned by TestLoad if the process lacks CAP_SYS_BOOT.
+var ErrNoSysBoot = errors.New("CAP_SYS_BOOT is required to load kexec segments")
+
+// hasSysBoot reports whether CAP_SYS_BOOT is in the effective capability set.
+// Tests replace it.
+var hasSysBoot = func() (bool, error) {
+	hdr := unix.CapUserHeader{Version: unix.LINUX_CAPABILITY_VERSION_3}
+	var data [2]unix.CapUserData
+	if err := unix.Capget(&hdr, &data[0]); err != nil {
+		return false, fmt.Errorf("capget: %w", err)
+	}
+	return data[unix.CAP_SYS_BOOT/32].Effective&(1<<(unix.CAP_SYS_BOOT%32)) != 0, nil
+}
+
+// TestLoad loads segments like Load, so that the kernel validates them and
+// their physical addresses, and unloads them again right away. It returns
+// the error Load would have returned.
+//
+// TestLoad requires CAP_SYS_BOOT and returns ErrNoSysBoot without it. Since
+// there is only one kexec image slot (one more for KEXEC_ON_CRASH in flags),
+// any previously loaded kernel in that slot is unloaded as well.
+func TestLoad(entry uintptr, segments Segments, flags uint64) error {
+	ok, err := hasSysBoot()
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return ErrNoSysBoot
+	}
+
+	if err := Load(entry, segments, flags); err != nil {
+		return err
+	}
+	return Unload(flags & unix.KEXEC_ON_CRASH)
+}
+
+// Unload unloads the kernel loaded with kexec_load. flags may contain
+// KEXEC_ON_CRASH to unload the crash kernel instead.
+func Unload(flags uint64) error {
+	return rawLoad(0, nil, flags)
+}
+
+// placementError wraps a failed kexec_load with the segments that are not
+// backed by RAM in the current memory map, as those likely caused it.
+func placementError(segments Segments, err error) error {
+	mm, merr := placementMemoryMap()
+	if merr != nil {
+		return fmt.Errorf("%w (reading memory map: %v): %w", ErrSegmentPlacement, merr, err)
+	}
+
+	ram := mm.RAM()
+	var culprits []string
+	for _, s := range segments {
+		outside := Ranges{s.Phys}
+		for _, r := range ram {
+			outside = outside.Minus(r)
+		}
+		if len(outside) > 0 {
+			culprits = append(culprits, fmt.Sprintf("segment %s is not in RAM at %v", s.Phys, outside))
+		}
+	}
+	if len(culprits) == 0 {
+		return fmt.Errorf("%w: all segments are in RAM, the kernel may have reserved parts of it: %w", ErrSegmentPlacement, err)
+	}
+	return fmt.Errorf("%w: %s: %w", ErrSegmentPlacement, strings.Join(culprits, "; "), err)
 }
 
 // ErrKexec is returned by Load if the kexec failed. It describes entry point,
@@ -45,6 +243,11 @@ func (e ErrKexec) Error() string {
 	return fmt.Sprintf("kexec_load(entry=%#x, segments=%s, flags %#x) = errno %s", e.Entry, e.Segments, e.Flags, e.Errno)
 }
 
+// Unwrap returns the errno.
+func (e ErrKexec) Unwrap() error {
+	return e.Errno
+}
+
 // kexecSegment defines kernel memory layout.
 type kexecSegment struct {
 	// Buf points to a buffer in user space.
@@ -55,7 +258,7 @@ type kexecSegment struct {
 }
 
 func (s Segment) toKexecSegment() kexecSegment {
-	if s.Buf == nil {
+	if len(s.Buf) == 0 {
 		return kexecSegment{
 			Buf:  Range{Start: 0, Size: 0},
 			Phys: s.Phys,
@@ -78,22 +281,30 @@ func (segs Segments) toKexecSegments() []kexecSegment {
 	return ks
 }
 
-// rawLoad is a wrapper around kexec_load(2) syscall.
+// rawLoad is a wrapper around kexec_load(2) syscall. Tests replace it to
+// simulate the kernel's errors.
 // Preconditions:
 // - segments must not overlap
 // - segments must be full pages
-func rawLoad(entry uintptr, segments Segments, flags uint64) error {
+var rawLoad = func(entry uintptr, segments Segments, flags uint64) error {
 	ks := segments.toKexecSegments()
-	_, _, errno := unix.Syscall6(
-		unix.SYS_KEXEC_LOAD,
-		entry,
-		uintptr(len(segments)),
-		uintptr(unsafe.Pointer(&ks[0])),
-		uintptr(flags),
-		0, 0)
-	// segments (and all the buffers therein) may have gotten freed after
-	// evaluating Syscall6 arguments, but before the syscall actually
-	// happens.
+	var errno syscall.Errno
+	if len(ks) > 0 {
+		// The conversion of &ks[0] must stay in the Syscall6 argument
+		// list, see unsafe.Pointer.
+		_, _, errno = unix.Syscall6(
+			unix.SYS_KEXEC_LOAD,
+			entry,
+			uintptr(len(ks)),
+			uintptr(unsafe.Pointer(&ks[0])),
+			uintptr(flags),
+			0, 0)
+	} else {
+		_, _, errno = unix.Syscall6(unix.SYS_KEXEC_LOAD, entry, 0, 0, uintptr(flags), 0, 0)
+	}
+	// The kernel reads ks and the buffers it points to, which may
+	// otherwise be freed before the syscall is done with them.
+	runtime.KeepAlive(ks)
 	for _, seg := range segments {
 		runtime.KeepAlive(seg.Buf)
 	}
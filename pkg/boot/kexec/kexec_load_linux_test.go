@@ -0,0 +1,325 @@
+// Copyright 2024 the u-root Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package kexec
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"syscall"
+	"testing"
+
+	"golang.org/x/sys/unix"
+)
+
+// errnoErr returns the error rawLoad returns if kexec_load fails with errno.
+func errnoErr(errno syscall.Errno) error {
+	if errno == 0 {
+		return nil
+	}
+	return ErrKexec{Errno: errno}
+}
+
+func TestLoadTooManySegments(t *testing.T) {
+	var segs Segments
+	for i := 0; i < MaxSegments+1; i++ {
+		// Leave a gap so that AlignAndMerge keeps the segments apart.
+		segs = append(segs, NewSegment([]byte("segment"), Range{Start: uintptr(i) * 0x2000, Size: 0x1000}))
+	}
+
+	// The entry point is outside of all segments, so that Load could not
+	// reach the syscall even if the segment check was missing.
+	if err := Load(0x1000, segs, 0); !errors.Is(err, ErrTooManySegments) {
+		t.Errorf("Load(%d segments) = %v, want %v", len(segs), err, ErrTooManySegments)
+	}
+}
+
+func TestLoadSegmentPlacement(t *testing.T) {
+	oldLoad, oldMM := rawLoad, placementMemoryMap
+	defer func() { rawLoad, placementMemoryMap = oldLoad, oldMM }()
+	placementMemoryMap = func() (MemoryMap, error) {
+		return MemoryMap{
+			{Range: Range{Start: 0x100000, Size: 0x100000}, Type: RangeRAM},
+			{Range: Range{Start: 0x200000, Size: 0x100000}, Type: RangeReserved},
+		}, nil
+	}
+
+	segs := Segments{
+		NewSegment([]byte("kernel"), Range{Start: 0x100000, Size: 0x1000}),
+		NewSegment([]byte("initrd"), Range{Start: 0x200000, Size: 0x1000}),
+	}
+
+	for _, tt := range []struct {
+		errno       syscall.Errno
+		wantCulprit bool
+	}{
+		{errno: unix.ENOMEM, wantCulprit: true},
+		{errno: unix.EADDRNOTAVAIL, wantCulprit: true},
+		{errno: unix.EPERM},
+	} {
+		t.Run(tt.errno.Error(), func(t *testing.T) {
+			rawLoad = func(uintptr, Segments, uint64) error {
+				return errnoErr(tt.errno)
+			}
+
+			err := Load(0x100000, segs, 0)
+			if !errors.Is(err, tt.errno) {
+				t.Errorf("Load() = %v, want %v", err, tt.errno)
+			}
+			if got := errors.Is(err, ErrSegmentPlacement); got != tt.wantCulprit {
+				t.Errorf("Load() = %v, is ErrSegmentPlacement = %v, want %v", err, got, tt.wantCulprit)
+			}
+			if tt.wantCulprit && !strings.Contains(err.Error(), "segment [0x200000, 0x201000) is not in RAM") {
+				t.Errorf("Load() = %v, want the initrd segment named", err)
+			}
+			if tt.wantCulprit && strings.Contains(err.Error(), "segment [0x100000") {
+				t.Errorf("Load() = %v, want the kernel segment not named", err)
+			}
+		})
+	}
+}
+
+func TestTestLoadUnloads(t *testing.T) {
+	oldLoad, oldCap := rawLoad, hasSysBoot
+	defer func() { rawLoad, hasSysBoot = oldLoad, oldCap }()
+
+	segs := Segments{NewSegment([]byte("kernel"), Range{Start: 0x100000, Size: 0x1000})}
+	for _, tt := range []struct {
+		name      string
+		sysBoot   bool
+		flags     uint64
+		loadErrno syscall.Errno
+		wantCalls []int
+		wantFlags []uint64
+		wantErr   error
+	}{
+		{
+			name:      "load and unload",
+			sysBoot:   true,
+			wantCalls: []int{1, 0},
+			wantFlags: []uint64{0, 0},
+		},
+		{
+			name:      "crash kernel",
+			sysBoot:   true,
+			flags:     unix.KEXEC_ON_CRASH | unix.KEXEC_PRESERVE_CONTEXT,
+			wantCalls: []int{1, 0},
+			wantFlags: []uint64{unix.KEXEC_ON_CRASH | unix.KEXEC_PRESERVE_CONTEXT, unix.KEXEC_ON_CRASH},
+		},
+		{
+			name:      "load fails",
+			sysBoot:   true,
+			loadErrno: unix.EINVAL,
+			wantCalls: []int{1},
+			wantFlags: []uint64{0},
+			wantErr:   unix.EINVAL,
+		},
+		{
+			name:    "no CAP_SYS_BOOT",
+			wantErr: ErrNoSysBoot,
+		},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			hasSysBoot = func() (bool, error) { return tt.sysBoot, nil }
+			var calls []int
+			var flags []uint64
+			rawLoad = func(_ uintptr, segs Segments, f uint64) error {
+				calls = append(calls, len(segs))
+				flags = append(flags, f)
+				if len(segs) > 0 {
+					return errnoErr(tt.loadErrno)
+				}
+				return nil
+			}
+
+			if err := TestLoad(0x100000, segs, tt.flags); !errors.Is(err, tt.wantErr) {
+				t.Errorf("TestLoad() = %v, want %v", err, tt.wantErr)
+			}
+			if !reflect.DeepEqual(calls, tt.wantCalls) {
+				t.Errorf("kexec_load segment counts = %v, want %v", calls, tt.wantCalls)
+			}
+			if !reflect.DeepEqual(flags, tt.wantFlags) {
+				t.Errorf("kexec_load flags = %#x, want %#x", flags, tt.wantFlags)
+			}
+		})
+	}
+}
+
+func TestLoadLocked(t *testing.T) {
+	oldLoad, oldLock, oldUnlock := rawLoad, mlock, munlock
+	defer func() { rawLoad, mlock, munlock = oldLoad, oldLock, oldUnlock }()
+
+	segs := Segments{
+		NewSegment([]byte("kernel"), Range{Start: 0x100000, Size: 0x1000}),
+		// Without a buffer, there is nothing to lock.
+		NewSegment(nil, Range{Start: 0x200000, Size: 0x1000}),
+		NewSegment([]byte("initrd"), Range{Start: 0x300000, Size: 0x1000}),
+	}
+
+	for _, tt := range []struct {
+		name    string
+		lockErr error
+		want    []string
+	}{
+		{
+			name: "locked",
+			want: []string{"mlock kernel", "mlock initrd", "kexec_load", "munlock kernel", "munlock initrd"},
+		},
+		{
+			// Like exceeding RLIMIT_MEMLOCK.
+			name:    "cannot lock",
+			lockErr: unix.ENOMEM,
+			want:    []string{"mlock kernel", "kexec_load"},
+		},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			var calls []string
+			// The buffers are padded to full pages by AlignAndMerge.
+			name := func(b []byte) string { return strings.TrimRight(string(b), "\x00") }
+			mlock = func(b []byte) error {
+				calls = append(calls, "mlock "+name(b))
+				return tt.lockErr
+			}
+			munlock = func(b []byte) error {
+				calls = append(calls, "munlock "+name(b))
+				return nil
+			}
+			rawLoad = func(uintptr, Segments, uint64) error {
+				calls = append(calls, "kexec_load")
+				return nil
+			}
+
+			if err := LoadLocked(0x100000, segs, 0); err != nil {
+				t.Fatalf("LoadLocked() = %v, want nil", err)
+			}
+			if !reflect.DeepEqual(calls, tt.want) {
+				t.Errorf("LoadLocked() calls = %v, want %v", calls, tt.want)
+			}
+
+			calls = nil
+			if err := Load(0x100000, segs, 0); err != nil {
+				t.Fatalf("Load() = %v, want nil", err)
+			}
+			if want := []string{"kexec_load"}; !reflect.DeepEqual(calls, want) {
+				t.Errorf("Load() calls = %v, want %v", calls, want)
+			}
+		})
+	}
+}
+
+func TestLoadAlignsBuffers(t *testing.T) {
+	oldLoad := rawLoad
+	defer func() { rawLoad = oldLoad }()
+
+	// A buffer starting one byte into a page.
+	unaligned := alignedBuffer(0x1001)[1:]
+	segs := Segments{
+		NewSegment(unaligned, Range{Start: 0x100000, Size: 0x1000}),
+		// Not starting on a page, so AlignAndMerge prepends zeros.
+		NewSegment([]byte("cmdline"), Range{Start: 0x200010, Size: 0x10}),
+		// Sharing a page, so AlignAndMerge merges the buffers.
+		NewSegment([]byte("dtb"), Range{Start: 0x300000, Size: 0x10}),
+		NewSegment([]byte("initrd"), Range{Start: 0x300800, Size: 0x10}),
+		NewSegment(nil, Range{Start: 0x400000, Size: 0x1000}),
+	}
+
+	rawLoad = func(_ uintptr, segs Segments, _ uint64) error {
+		for _, s := range segs {
+			if len(s.Buf) != 0 && !isPageAligned(s.Buf) {
+				t.Errorf("segment %v has buffer at %p, want page-aligned", s.Phys, &s.Buf[0])
+			}
+		}
+		return nil
+	}
+	if err := Load(0x100000, segs, 0); err != nil {
+		t.Fatalf("Load() = %v, want nil", err)
+	}
+}
+
+func TestAlignBuffers(t *testing.T) {
+	unaligned := alignedBuffer(0x11)[1:]
+	copy(unaligned, "kernel")
+	segs := Segments{
+		NewSegment(unaligned, Range{Start: 0x100000, Size: 0x1000}),
+		NewSegment(nil, Range{Start: 0x200000, Size: 0x1000}),
+	}
+
+	got := alignBuffers(segs)
+	for i, s := range got {
+		if !isPageAligned(s.Buf) {
+			t.Errorf("alignBuffers()[%d].Buf is not page-aligned", i)
+		}
+		if !bytes.Equal(s.Buf, segs[i].Buf) || s.Phys != segs[i].Phys {
+			t.Errorf("alignBuffers()[%d] = %v, want %v", i, s, segs[i])
+		}
+	}
+	// The segments passed in are left alone.
+	if &segs[0].Buf[0] != &unaligned[0] {
+		t.Errorf("alignBuffers() modified its argument")
+	}
+}
+
+func TestLoadDisabled(t *testing.T) {
+	oldLoad, oldPath := rawLoad, kexecLoadDisabledPath
+	defer func() { rawLoad, kexecLoadDisabledPath = oldLoad, oldPath }()
+
+	segs := Segments{NewSegment([]byte("kernel"), Range{Start: 0x100000, Size: 0x1000})}
+	for _, tt := range []struct {
+		name         string
+		sysctl       string
+		loadErrno    syscall.Errno
+		wantDisabled bool
+		wantErr      error
+	}{
+		{
+			name:      "enabled",
+			sysctl:    "0\n",
+			loadErrno: unix.EPERM,
+			wantErr:   unix.EPERM,
+		},
+		{
+			name:         "disabled",
+			sysctl:       "1\n",
+			loadErrno:    unix.EPERM,
+			wantDisabled: true,
+			wantErr:      ErrKexecDisabled,
+		},
+		{
+			name:         "disabled, other error",
+			sysctl:       "1\n",
+			loadErrno:    unix.EINVAL,
+			wantDisabled: true,
+			wantErr:      unix.EINVAL,
+		},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			kexecLoadDisabledPath = filepath.Join(t.TempDir(), "kexec_load_disabled")
+			if err := os.WriteFile(kexecLoadDisabledPath, []byte(tt.sysctl), 0o644); err != nil {
+				t.Fatal(err)
+			}
+			rawLoad = func(uintptr, Segments, uint64) error { return errnoErr(tt.loadErrno) }
+
+			disabled, err := LoadDisabled()
+			if err != nil || disabled != tt.wantDisabled {
+				t.Errorf("LoadDisabled() = %t, %v, want %t, nil", disabled, err, tt.wantDisabled)
+			}
+			err = Load(0x100000, segs, 0)
+			if !errors.Is(err, tt.wantErr) {
+				t.Errorf("Load() = %v, want %v", err, tt.wantErr)
+			}
+			if tt.wantDisabled && tt.loadErrno == unix.EINVAL && errors.Is(err, ErrKexecDisabled) {
+				t.Errorf("Load() = %v, want no %v", err, ErrKexecDisabled)
+			}
+		})
+	}
+
+	kexecLoadDisabledPath = filepath.Join(t.TempDir(), "missing")
+	if _, err := LoadDisabled(); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("LoadDisabled() = %v, want %v", err, os.ErrNotExist)
+	}
+}
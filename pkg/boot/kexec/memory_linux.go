@@ -7,11 +7,14 @@ package kexec
 import (
 	"bytes"
 	"debug/elf"
+	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"os"
 	"reflect"
 	"sort"
+	"strconv"
 	"strings"
 	"unsafe"
 
@@ -24,6 +27,10 @@ var pageMask = uint(os.Getpagesize() - 1)
 // range is large enough to accommodate the request.
 var ErrNotEnoughSpace = fmt.Errorf("not enough space to allocate bytes")
 
+// ErrAlignOverflow is returned by Range.AlignUp and Range.AlignDown if the
+// aligned range does not fit the address space.
+var ErrAlignOverflow = errors.New("aligned range overflows")
+
 // Range represents a contiguous uintptr interval [Start, Start+Size).
 type Range struct {
 	// Start is the inclusive start of the range.
@@ -56,6 +63,52 @@ func (r Range) String() string {
 	return fmt.Sprintf("[%#x, %#x)", r.Start, r.End())
 }
 
+// rangeJSON is the JSON representation of a Range, with the addresses as
+// hex strings.
+type rangeJSON struct {
+	Start hexAddr
+	Size  hexAddr
+}
+
+// hexAddr is an address encoded in JSON as a 0x-prefixed hex string. It also
+// decodes plain numbers, as written before Range had a JSON representation.
+type hexAddr uint64
+
+func (a hexAddr) MarshalJSON() ([]byte, error) {
+	return json.Marshal(fmt.Sprintf("%#x", uint64(a)))
+}
+
+func (a *hexAddr) UnmarshalJSON(b []byte) error {
+	var s string
+	if err := json.Unmarshal(b, &s); err != nil {
+		// Not a string, so a number.
+		s = string(b)
+	}
+	n, err := strconv.ParseUint(s, 0, 64)
+	if err != nil {
+		return fmt.Errorf("invalid address %s: %w", b, err)
+	}
+	*a = hexAddr(n)
+	return nil
+}
+
+// MarshalJSON implements json.Marshaler. Start and Size are written as
+// 0x-prefixed hex strings, which are easier to read than decimals.
+func (r Range) MarshalJSON() ([]byte, error) {
+	return json.Marshal(rangeJSON{Start: hexAddr(r.Start), Size: hexAddr(r.Size)})
+}
+
+// UnmarshalJSON implements json.Unmarshaler for the format written by
+// MarshalJSON.
+func (r *Range) UnmarshalJSON(b []byte) error {
+	var j rangeJSON
+	if err := json.Unmarshal(b, &j); err != nil {
+		return err
+	}
+	*r = Range{Start: uintptr(j.Start), Size: uint(j.Size)}
+	return nil
+}
+
 // End returns the uintptr *after* the end of the interval.
 func (r Range) End() uintptr {
 	return r.Start + uintptr(r.Size)
@@ -181,6 +234,36 @@ type findSpaceOptions struct {
 	limit      Range
 	size       uint
 	startAlign uint
+	fit        FitStrategy
+}
+
+// FitStrategy is how FindSpace picks among the free ranges large enough.
+type FitStrategy int
+
+// Fit strategies.
+const (
+	// FirstFit picks the lowest free range large enough.
+	FirstFit FitStrategy = iota
+
+	// BestFit picks the smallest free range large enough, keeping larger
+	// ranges for later allocations.
+	BestFit
+
+	// WorstFit picks the largest free range, leaving the largest
+	// remainder behind.
+	WorstFit
+)
+
+func (f FitStrategy) String() string {
+	switch f {
+	case FirstFit:
+		return "first-fit"
+	case BestFit:
+		return "best-fit"
+	case WorstFit:
+		return "worst-fit"
+	}
+	return fmt.Sprintf("FitStrategy(%d)", int(f))
 }
 
 // FindOptioner is a config option for FindSpace.
@@ -219,6 +302,14 @@ func WithStartAlignment(alignSize uint) FindOptioner {
 	}
 }
 
+// WithFit requires FindSpace to pick the free range with the given strategy.
+// Ties go to the lowest range. The default is FirstFit.
+func WithFit(fit FitStrategy) FindOptioner {
+	return func(o *findSpaceOptions) {
+		o.fit = fit
+	}
+}
+
 // FindSpace finds a continuous piece of sz points within Ranges and the given
 // options and returns the Range pointing to it.
 func (rs Ranges) FindSpace(sz uint, opts ...FindOptioner) (Range, error) {
@@ -229,16 +320,31 @@ func (rs Ranges) FindSpace(sz uint, opts ...FindOptioner) (Range, error) {
 	for _, opt := range opts {
 		opt(o)
 	}
-	if o.startAlign != 0 && !align.IsAligned(o.limit.Start, uintptr(o.startAlign)) {
-		o.limit = o.limit.WithStart(align.Up(o.limit.Start, uintptr(o.startAlign)))
+	limit, err := o.limit.AlignUp(o.startAlign)
+	if err != nil {
+		return Range{}, fmt.Errorf("%w: %#x bytes: %w", ErrNotEnoughSpace, sz, err)
 	}
+	o.limit = limit
+	var found *Range
 	for _, r := range rs {
-		if o.startAlign != 0 && !align.IsAligned(r.Start, uintptr(o.startAlign)) {
-			r = r.WithStart(align.Up(r.Start, uintptr(o.startAlign)))
+		r, err := r.AlignUp(o.startAlign)
+		if err != nil {
+			// No aligned address left in r.
+			continue
 		}
-		if overlap := r.Intersect(o.limit); overlap != nil && overlap.Size >= o.size {
+		overlap := r.Intersect(o.limit)
+		if overlap == nil || overlap.Size < o.size {
+			continue
+		}
+		if o.fit == FirstFit {
 			return Range{Start: overlap.Start, Size: o.size}, nil
 		}
+		if found == nil || (o.fit == BestFit && overlap.Size < found.Size) || (o.fit == WorstFit && overlap.Size > found.Size) {
+			found = overlap
+		}
+	}
+	if found != nil {
+		return Range{Start: found.Start, Size: o.size}, nil
 	}
 	return Range{}, fmt.Errorf("%w: %#x bytes", ErrNotEnoughSpace, sz)
 }
@@ -274,28 +380,50 @@ func NewSegment(buf []byte, phys Range) Segment {
 	}
 }
 
+var errSegmentWindow = errors.New("invalid segment window")
+
+// NewSegmentFromReaderAt creates a Segment of length bytes at physical address
+// start, with the data read from r at offset off. Only that window is read,
+// e.g. the kernel Image embedded in a larger PE executable.
+func NewSegmentFromReaderAt(r io.ReaderAt, off, length int64, start uintptr) (Segment, error) {
+	if off < 0 || length < 0 {
+		return Segment{}, fmt.Errorf("%w: %#x bytes at offset %#x", errSegmentWindow, length, off)
+	}
+	buf := make([]byte, length)
+	n, err := r.ReadAt(buf, off)
+	// ReadAt may return io.EOF along with the last bytes.
+	if n < len(buf) {
+		if err == nil || errors.Is(err, io.EOF) {
+			err = io.ErrUnexpectedEOF
+		}
+		return Segment{}, fmt.Errorf("reading %#x bytes at offset %#x: %w", length, off, err)
+	}
+	return NewSegment(buf, Range{Start: start, Size: uint(length)}), nil
+}
+
 // SegmentEqual returns whether s and t point at the same physical region and
 // contain the same data.
 func SegmentEqual(s, t Segment) bool {
 	return s.Phys == t.Phys && bytes.Equal(s.Buf, t.Buf)
 }
 
+// RangeEqual returns whether s and o point at the same physical region,
+// regardless of the data they contain.
+func (s Segment) RangeEqual(o Segment) bool {
+	return s.Phys == o.Phys
+}
+
 func (s Segment) String() string {
 	return fmt.Sprintf("(phys: %s, buffer: size %#x)", s.Phys, len(s.Buf))
 }
 
 // AlignAndMerge adjusts segs to the preconditions of kexec_load.
 //
-// Pre-conditions: segs physical ranges are disjoint.
+// Pre-conditions: segs physical ranges are disjoint. segs need not be sorted;
+// AlignAndMerge sorts them in place.
 // Post-conditions: segs physical start addresses & size aligned to page size.
 func AlignAndMerge(segs Segments) (Segments, error) {
-	sort.Slice(segs, func(i, j int) bool {
-		if segs[i].Phys.Start == segs[j].Phys.Start {
-			// let segs[i] be the superset of segs[j]
-			return segs[i].Phys.Size > segs[j].Phys.Size
-		}
-		return segs[i].Phys.Start < segs[j].Phys.Start
-	})
+	segs.Sort()
 
 	// We index 0 below.
 	if len(segs) == 0 {
@@ -424,6 +552,42 @@ func (r Range) Align(alignSize uint) Range {
 	}
 }
 
+// AlignUp moves the start of r up to the next multiple of alignSize, keeping
+// the end of r. The result is a subset of r, and empty if r contains no
+// aligned address.
+//
+// alignSize need be a power of 2.
+func (r Range) AlignUp(alignSize uint) (Range, error) {
+	if alignSize == 0 {
+		return r, nil
+	}
+	mask := uintptr(alignSize - 1)
+	if r.Start > ^uintptr(0)-mask {
+		return Range{}, fmt.Errorf("%w: %s aligned up to %#x", ErrAlignOverflow, r, alignSize)
+	}
+	start := align.Up(r.Start, uintptr(alignSize))
+	if diff := uint(start - r.Start); diff < r.Size {
+		return Range{Start: start, Size: r.Size - diff}, nil
+	}
+	return Range{Start: start}, nil
+}
+
+// AlignDown moves the start of r down to the previous multiple of alignSize,
+// keeping the end of r. The result is a superset of r.
+//
+// alignSize need be a power of 2.
+func (r Range) AlignDown(alignSize uint) (Range, error) {
+	if alignSize == 0 {
+		return r, nil
+	}
+	start := r.Start &^ uintptr(alignSize-1)
+	diff := uint(r.Start - start)
+	if r.Size > ^uint(0)-diff {
+		return Range{}, fmt.Errorf("%w: %s aligned down to %#x", ErrAlignOverflow, r, alignSize)
+	}
+	return Range{Start: start, Size: r.Size + diff}, nil
+}
+
 // AlignPage aligns start and size by page size.
 //
 // The resulting range is guaranteed to be superset of r.
@@ -448,10 +612,30 @@ func AlignPhysStart(s Segment) Segment {
 	diff := orig - s.Phys.Start
 	s.Phys.Size = s.Phys.Size + uint(diff)
 
-	s.Buf = append(make([]byte, diff), s.Buf...)
+	buf := alignedBuffer(int(diff) + len(s.Buf))
+	copy(buf[diff:], s.Buf)
+	s.Buf = buf
 	return s
 }
 
+// alignedBuffer returns a zeroed buffer of size bytes that starts on a page
+// boundary of the caller's address space, as some kernels' kexec_load
+// require of segment buffers.
+func alignedBuffer(size int) []byte {
+	if size == 0 {
+		return []byte{}
+	}
+	buf := make([]byte, size+int(pageMask))
+	off := align.Up(uint(uintptr(unsafe.Pointer(&buf[0]))), pageMask+1) - uint(uintptr(unsafe.Pointer(&buf[0])))
+	return buf[off : off+uint(size) : off+uint(size)]
+}
+
+// isPageAligned reports whether buf starts on a page boundary. Empty buffers
+// are not passed to the kernel and count as aligned.
+func isPageAligned(buf []byte) bool {
+	return len(buf) == 0 || uint(uintptr(unsafe.Pointer(&buf[0])))&pageMask == 0
+}
+
 // Segments is a collection of segments.
 type Segments []Segment
 
@@ -484,6 +668,15 @@ func (segs Segments) Phys() Ranges {
 	return r
 }
 
+// TotalSize returns the sum of the sizes of all physical ranges of segs.
+func (segs Segments) TotalSize() uint {
+	var size uint
+	for _, s := range segs {
+		size += s.Phys.Size
+	}
+	return size
+}
+
 // SegmentsEqual returns whether the contents of all segments are the same,
 // while pointing to the same physical memory region.
 func SegmentsEqual(s, t Segments) bool {
@@ -498,6 +691,20 @@ func SegmentsEqual(s, t Segments) bool {
 	return true
 }
 
+// RangesEqual returns whether segs and o point at the same physical memory
+// regions, in the same order, regardless of the data they contain.
+func (segs Segments) RangesEqual(o Segments) bool {
+	if len(segs) != len(o) {
+		return false
+	}
+	for i := range segs {
+		if !segs[i].RangeEqual(o[i]) {
+			return false
+		}
+	}
+	return true
+}
+
 // IsSupersetOf checks whether all segments in o are present in s and contain
 // the same buffer content.
 func (segs Segments) IsSupersetOf(o Segments) error {
@@ -534,11 +741,13 @@ func (segs Segments) GetPhys(r Range) []byte {
 // Insert inserts s assuming it does not overlap with an existing segment.
 func (segs *Segments) Insert(s Segment) {
 	*segs = append(*segs, s)
-	segs.sort()
+	segs.Sort()
 }
 
-func (segs Segments) sort() {
-	sort.Slice(segs, func(i, j int) bool {
+// Sort sorts segs by their physical start address. Segments with the same
+// start address keep their relative order.
+func (segs Segments) Sort() {
+	sort.SliceStable(segs, func(i, j int) bool {
 		return segs[i].Phys.Start < segs[j].Phys.Start
 	})
 }
@@ -555,10 +764,22 @@ type Memory struct {
 	//
 	// Each segment also contains a physical memory region it maps to.
 	Segments Segments
+
+	// Fit is how new segments and reservations are placed in available
+	// RAM. The default is FirstFit.
+	Fit FitStrategy
 }
 
 // LoadElfSegments loads loadable ELF segments.
 func (m *Memory) LoadElfSegments(r io.ReaderAt) (Object, error) {
+	return m.LoadElfSegmentsOffset(r, 0)
+}
+
+// LoadElfSegmentsOffset loads loadable ELF segments like LoadElfSegments, but
+// moves their physical addresses by offset bytes. This is used to load
+// position independent objects such as a relocatable kernel elsewhere than
+// where they were linked.
+func (m *Memory) LoadElfSegmentsOffset(r io.ReaderAt, offset int64) (Object, error) {
 	f, err := ObjectNewFile(r)
 	if err != nil {
 		return nil, err
@@ -585,7 +806,7 @@ func (m *Memory) LoadElfSegments(r io.ReaderAt) (Object, error) {
 		}
 		// TODO(hugelgupf): check if this is within availableRAM??
 		s := NewSegment(d, Range{
-			Start: uintptr(p.Paddr),
+			Start: uintptr(int64(p.Paddr) + offset),
 			Size:  uint(p.Memsz),
 		})
 		m.Segments.Insert(s)
@@ -607,7 +828,7 @@ func (m Memory) FindSpace(sz, alignSizeBytes uint) (Range, error) {
 	sz = align.Up(sz, alignSizeBytes)
 
 	// Don't use memory below 1M, just in case.
-	return m.AvailableRAM().FindSpaceAbove(sz, M1)
+	return m.AvailableRAM().FindSpace(sz, WithMinimumAddr(M1), WithFit(m.Fit))
 }
 
 // ReservePhys reserves page-aligned sz bytes in the physical memmap within
@@ -615,7 +836,7 @@ func (m Memory) FindSpace(sz, alignSizeBytes uint) (Range, error) {
 func (m *Memory) ReservePhys(sz uint, limit Range) (Range, error) {
 	sz = align.UpPage(sz)
 
-	r, err := m.AvailableRAM().FindSpaceIn(sz, limit)
+	r, err := m.AvailableRAM().FindSpace(sz, WithinRange(limit), WithFit(m.Fit))
 	if err != nil {
 		return Range{}, err
 	}
@@ -640,7 +861,21 @@ func (m *Memory) AddPhysSegment(d []byte, limit Range) (Range, error) {
 
 // AddKexecSegment adds d to a new kexec segment
 func (m *Memory) AddKexecSegment(d []byte) (Range, error) {
-	r, err := m.FindSpace(uint(len(d)), uint(os.Getpagesize()))
+	return m.AddKexecSegmentAligned(d, uint(os.Getpagesize()))
+}
+
+// AddKexecSegmentAligned adds d to a new kexec segment starting at an address
+// aligned to alignSizeBytes.
+//
+// kexec segments always start on a page boundary, so alignments smaller than
+// the page size are rounded up to it.
+func (m *Memory) AddKexecSegmentAligned(d []byte, alignSizeBytes uint) (Range, error) {
+	if pageSize := uint(os.Getpagesize()); alignSizeBytes < pageSize {
+		alignSizeBytes = pageSize
+	}
+
+	// Don't use memory below 1M, just in case.
+	r, err := m.AvailableRAM().FindSpace(align.UpPage(uint(len(d))), WithMinimumAddr(M1), WithStartAlignment(alignSizeBytes), WithFit(m.Fit))
 	if err != nil {
 		return Range{}, err
 	}
@@ -651,7 +886,7 @@ func (m *Memory) AddKexecSegment(d []byte) (Range, error) {
 // AddKexecSegmentExplicit adds d to a new kexec segment, but allows asking
 // for extra space, secifying alignment size, and setting text_offset.
 func (m *Memory) AddKexecSegmentExplicit(d []byte, sz, offset, alignSizeBytes uint) (Range, error) {
-	r, err := m.AvailableRAM().FindSpace(offset+sz, WithAlignment(alignSizeBytes))
+	r, err := m.AvailableRAM().FindSpace(offset+sz, WithAlignment(alignSizeBytes), WithFit(m.Fit))
 	if err != nil {
 		return Range{}, err
 	}
@@ -662,8 +897,8 @@ func (m *Memory) AddKexecSegmentExplicit(d []byte, sz, offset, alignSizeBytes ui
 
 // AvailableRAM returns page-aligned unused regions of RAM.
 //
-// AvailableRAM takes all RAM-marked pages in the memory map and subtracts the
-// kexec segments already allocated. RAM segments begin at a page boundary.
+// AvailableRAM takes the usable RAM of the memory map, see MemoryMap.Usable,
+// and subtracts the kexec segments already allocated. RAM segments begin at a page boundary.
 //
 // E.g if page size is 4K and RAM segments are
 //
@@ -677,7 +912,7 @@ func (m *Memory) AddKexecSegmentExplicit(d []byte, sz, offset, alignSizeBytes ui
 //
 //	[{start:0 size:40} {start:4096 end:8000 - 4096}]
 func (m Memory) AvailableRAM() Ranges {
-	ram := m.Phys.RAM()
+	ram := m.Phys.Usable()
 
 	// Remove all points we've already reserved from available RAM.
 	for _, s := range m.Segments {
@@ -687,12 +922,8 @@ func (m Memory) AvailableRAM() Ranges {
 	// Only return Ranges starting at an aligned size.
 	var alignedRanges Ranges
 	for _, r := range ram {
-		alignedStart := uintptr(align.UpPage(uint(r.Start)))
-		if alignedStart < r.End() {
-			alignedRanges = append(alignedRanges, Range{
-				Start: alignedStart,
-				Size:  r.Size - uint(alignedStart-r.Start),
-			})
+		if aligned, err := r.AlignUp(pageMask + 1); err == nil && aligned.Size > 0 {
+			alignedRanges = append(alignedRanges, aligned)
 		}
 	}
 	return alignedRanges
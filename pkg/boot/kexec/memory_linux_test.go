@@ -5,12 +5,17 @@
 package kexec
 
 import (
+	"bytes"
+	"encoding/json"
 	"errors"
 	"fmt"
+	"io"
+	"os"
 	"reflect"
 	"testing"
 
 	"github.com/google/go-cmp/cmp"
+	"github.com/u-root/u-root/pkg/align"
 )
 
 func TestAvailableRAM(t *testing.T) {
@@ -509,6 +514,49 @@ func TestFindSpace(t *testing.T) {
 			opts: []FindOptioner{WithinRange(RangeFromInterval(0x500, MaxAddr)), WithAlignment(0x1000)},
 			want: Range{Start: 0x3000, Size: 0x1000},
 		},
+		{
+			name: "best fit",
+			rs: Ranges{
+				Range{Start: 0x0, Size: 0x3000},
+				Range{Start: 0x4000, Size: 0x1000},
+				Range{Start: 0x6000, Size: 0x2000},
+			},
+			size: 0x1000,
+			opts: []FindOptioner{WithFit(BestFit)},
+			want: Range{Start: 0x4000, Size: 0x1000},
+		},
+		{
+			name: "best fit with limit",
+			rs: Ranges{
+				Range{Start: 0x0, Size: 0x3000},
+				Range{Start: 0x4000, Size: 0x1000},
+				Range{Start: 0x6000, Size: 0x2000},
+			},
+			size: 0x1000,
+			opts: []FindOptioner{WithFit(BestFit), WithinRange(RangeFromInterval(0x4800, MaxAddr))},
+			want: Range{Start: 0x6000, Size: 0x1000},
+		},
+		{
+			name: "worst fit",
+			rs: Ranges{
+				Range{Start: 0x0, Size: 0x2000},
+				Range{Start: 0x4000, Size: 0x1000},
+				Range{Start: 0x6000, Size: 0x3000},
+			},
+			size: 0x1000,
+			opts: []FindOptioner{WithFit(WorstFit)},
+			want: Range{Start: 0x6000, Size: 0x1000},
+		},
+		{
+			name: "best fit too large",
+			rs: Ranges{
+				Range{Start: 0x0, Size: 0x1000},
+				Range{Start: 0x4000, Size: 0x1000},
+			},
+			size: 0x2000,
+			opts: []FindOptioner{WithFit(BestFit)},
+			err:  ErrNotEnoughSpace,
+		},
 	} {
 		t.Run(fmt.Sprintf("test_%d_%s", i, tt.name), func(t *testing.T) {
 			got, err := tt.rs.FindSpace(tt.size, tt.opts...)
@@ -522,6 +570,46 @@ func TestFindSpace(t *testing.T) {
 	}
 }
 
+func TestMemoryFit(t *testing.T) {
+	// The first segment fits exactly into the second hole. First-fit puts
+	// it into the first hole instead, which then is too small for the
+	// second segment.
+	mm := MemoryMap{
+		TypedRange{Range: Range{Start: 0x1000000, Size: 0x400000}, Type: RangeRAM},
+		TypedRange{Range: Range{Start: 0x1800000, Size: 0x200000}, Type: RangeRAM},
+	}
+	segs := [][]byte{make([]byte, 0x200000), make([]byte, 0x400000)}
+
+	for _, tt := range []struct {
+		fit  FitStrategy
+		want []Range
+		err  error
+	}{
+		{fit: FirstFit, err: ErrNotEnoughSpace},
+		{fit: BestFit, want: []Range{{Start: 0x1800000, Size: 0x200000}, {Start: 0x1000000, Size: 0x400000}}},
+		{fit: WorstFit, err: ErrNotEnoughSpace},
+	} {
+		t.Run(tt.fit.String(), func(t *testing.T) {
+			m := &Memory{Phys: mm, Fit: tt.fit}
+			var got []Range
+			var err error
+			for _, seg := range segs {
+				var r Range
+				if r, err = m.AddKexecSegment(seg); err != nil {
+					break
+				}
+				got = append(got, r)
+			}
+			if !errors.Is(err, tt.err) {
+				t.Fatalf("AddKexecSegment = %v, want %v", err, tt.err)
+			}
+			if tt.err == nil && !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("AddKexecSegment ranges = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
 func TestFindSpaceAbove(t *testing.T) {
 	for i, tt := range []struct {
 		name string
@@ -829,6 +917,38 @@ func TestAdjacent(t *testing.T) {
 	}
 }
 
+func TestNewSegmentFromReaderAt(t *testing.T) {
+	data := []byte("MZ header, then the embedded Image, then the rest")
+	for _, tt := range []struct {
+		name   string
+		off    int64
+		length int64
+		want   []byte
+		err    error
+	}{
+		{name: "window", off: 16, length: 18, want: []byte("the embedded Image")},
+		{name: "up to end", off: 36, length: int64(len(data)) - 36, want: data[36:]},
+		{name: "empty", off: 4, length: 0, want: []byte{}},
+		{name: "past end", off: 36, length: int64(len(data)), err: io.ErrUnexpectedEOF},
+		{name: "negative offset", off: -1, length: 4, err: errSegmentWindow},
+		{name: "negative length", off: 0, length: -4, err: errSegmentWindow},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := NewSegmentFromReaderAt(bytes.NewReader(data), tt.off, tt.length, 0x100000)
+			if !errors.Is(err, tt.err) {
+				t.Fatalf("NewSegmentFromReaderAt(%d, %d) = %v, want %v", tt.off, tt.length, err, tt.err)
+			}
+			if err != nil {
+				return
+			}
+			want := NewSegment(tt.want, Range{Start: 0x100000, Size: uint(len(tt.want))})
+			if !SegmentEqual(got, want) {
+				t.Errorf("NewSegmentFromReaderAt(%d, %d) = %v %q, want %v %q", tt.off, tt.length, got, got.Buf, want, want.Buf)
+			}
+		})
+	}
+}
+
 func TestSegmentsInsert(t *testing.T) {
 	for i, tt := range []struct {
 		segs Segments
@@ -866,6 +986,114 @@ func TestSegmentsInsert(t *testing.T) {
 	}
 }
 
+func TestRangeEqual(t *testing.T) {
+	a := NewSegment([]byte("foo"), Range{Start: 0x1000, Size: 0x1000})
+	b := NewSegment([]byte("bar"), Range{Start: 0x1000, Size: 0x1000})
+	c := NewSegment([]byte("foo"), Range{Start: 0x2000, Size: 0x1000})
+
+	if !a.RangeEqual(b) {
+		t.Errorf("%v.RangeEqual(%v) = false, want true", a, b)
+	}
+	if SegmentEqual(a, b) {
+		t.Errorf("SegmentEqual(%v, %v) = true, want false", a, b)
+	}
+	if a.RangeEqual(c) {
+		t.Errorf("%v.RangeEqual(%v) = true, want false", a, c)
+	}
+
+	for _, tt := range []struct {
+		name string
+		s, o Segments
+		want bool
+	}{
+		{
+			name: "different data",
+			s:    Segments{a, c},
+			o:    Segments{b, c},
+			want: true,
+		},
+		{
+			name: "different ranges",
+			s:    Segments{a, c},
+			o:    Segments{a, a},
+			want: false,
+		},
+		{
+			name: "different length",
+			s:    Segments{a, c},
+			o:    Segments{a},
+			want: false,
+		},
+		{
+			name: "empty",
+			want: true,
+		},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.s.RangesEqual(tt.o); got != tt.want {
+				t.Errorf("%v.RangesEqual(%v) = %v, want %v", tt.s, tt.o, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestAddKexecSegmentAligned(t *testing.T) {
+	pageSize := uint(os.Getpagesize())
+
+	// RAM starts neither page- nor 2M-aligned.
+	mem := Memory{
+		Phys: MemoryMap{
+			TypedRange{Range: Range{Start: 0x100123, Size: 0x800000}, Type: RangeRAM},
+		},
+	}
+
+	for _, tt := range []struct {
+		name  string
+		size  int
+		align uint
+	}{
+		{name: "sub-page alignment", size: 0x20, align: 8},
+		{name: "page alignment", size: 0x1800, align: pageSize},
+		{name: "2M alignment", size: 0x100, align: 1 << 21},
+		{name: "sub-page after 2M", size: 0x20, align: 8},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			r, err := mem.AddKexecSegmentAligned(make([]byte, tt.size), tt.align)
+			if err != nil {
+				t.Fatalf("AddKexecSegmentAligned(%#x, %#x) = %v, want nil", tt.size, tt.align, err)
+			}
+			if !align.IsAligned(r.Start, uintptr(tt.align)) || !align.IsAligned(r.Start, uintptr(pageSize)) {
+				t.Errorf("AddKexecSegmentAligned(%#x, %#x) = %s, not aligned", tt.size, tt.align, r)
+			}
+			if r.Size < uint(tt.size) {
+				t.Errorf("AddKexecSegmentAligned(%#x, %#x) = %s, too small", tt.size, tt.align, r)
+			}
+		})
+	}
+
+	// Segments must not overlap.
+	for i, s := range mem.Segments {
+		for _, o := range mem.Segments[i+1:] {
+			if s.Phys.Overlaps(o.Phys) {
+				t.Errorf("segments %s and %s overlap", s.Phys, o.Phys)
+			}
+		}
+	}
+}
+
+func TestTotalSize(t *testing.T) {
+	segs := Segments{
+		NewSegment([]byte("foo"), Range{Start: 0x1000, Size: 0x1000}),
+		NewSegment(nil, Range{Start: 0x4000, Size: 0x3000}),
+	}
+	if got, want := segs.TotalSize(), uint(0x4000); got != want {
+		t.Errorf("%v.TotalSize() = %#x, want %#x", segs, got, want)
+	}
+	if got := (Segments{}).TotalSize(); got != 0 {
+		t.Errorf("Segments{}.TotalSize() = %#x, want 0", got)
+	}
+}
+
 func TestIsSupersetOf(t *testing.T) {
 	for _, tt := range []struct {
 		r    Range
@@ -1019,3 +1247,192 @@ func TestAlignPage(t *testing.T) {
 		}
 	}
 }
+
+func TestAlignUpDown(t *testing.T) {
+	const maxAddr = ^uintptr(0)
+	for _, tt := range []struct {
+		r         Range
+		alignSize uint
+		up        Range
+		upErr     error
+		down      Range
+		downErr   error
+	}{
+		{
+			r:         Range{Start: 0x1010, Size: 0x2000},
+			alignSize: 0x1000,
+			up:        Range{Start: 0x2000, Size: 0x1010},
+			down:      Range{Start: 0x1000, Size: 0x2010},
+		},
+		{
+			// Already aligned.
+			r:         Range{Start: 0x200000, Size: 0x1000},
+			alignSize: 0x200000,
+			up:        Range{Start: 0x200000, Size: 0x1000},
+			down:      Range{Start: 0x200000, Size: 0x1000},
+		},
+		{
+			// No aligned address inside the range.
+			r:         Range{Start: 0x1010, Size: 0x10},
+			alignSize: 0x1000,
+			up:        Range{Start: 0x2000},
+			down:      Range{Start: 0x1000, Size: 0x20},
+		},
+		{
+			// The aligned start is the end of the range.
+			r:         Range{Start: 0x1800, Size: 0x800},
+			alignSize: 0x1000,
+			up:        Range{Start: 0x2000},
+			down:      Range{Start: 0x1000, Size: 0x1000},
+		},
+		{
+			r:         Range{Start: 0x1010, Size: 0x10},
+			alignSize: 0,
+			up:        Range{Start: 0x1010, Size: 0x10},
+			down:      Range{Start: 0x1010, Size: 0x10},
+		},
+		{
+			r:         Range{Start: 0x1010, Size: 0x10},
+			alignSize: 1,
+			up:        Range{Start: 0x1010, Size: 0x10},
+			down:      Range{Start: 0x1010, Size: 0x10},
+		},
+		{
+			// Up to the last aligned address.
+			r:         Range{Start: maxAddr - 0x1ffe, Size: 0x1000},
+			alignSize: 0x1000,
+			up:        Range{Start: maxAddr - 0xfff, Size: 1},
+			down:      Range{Start: maxAddr - 0x1fff, Size: 0x1001},
+		},
+		{
+			// Rounding up past the end of the address space.
+			r:         Range{Start: maxAddr - 0x10, Size: 0x10},
+			alignSize: 0x1000,
+			upErr:     ErrAlignOverflow,
+			down:      Range{Start: maxAddr - 0xfff, Size: 0xfff},
+		},
+		{
+			// Growing the size past its maximum.
+			r:         Range{Start: 0x1010, Size: ^uint(0) - 0x8},
+			alignSize: 0x1000,
+			up:        Range{Start: 0x2000, Size: ^uint(0) - 0x8 - 0xff0},
+			downErr:   ErrAlignOverflow,
+		},
+	} {
+		up, err := tt.r.AlignUp(tt.alignSize)
+		if !errors.Is(err, tt.upErr) || up != tt.up {
+			t.Errorf("%v.AlignUp(%#x) = (%v, %v), want (%v, %v)", tt.r, tt.alignSize, up, err, tt.up, tt.upErr)
+		}
+		down, err := tt.r.AlignDown(tt.alignSize)
+		if !errors.Is(err, tt.downErr) || down != tt.down {
+			t.Errorf("%v.AlignDown(%#x) = (%v, %v), want (%v, %v)", tt.r, tt.alignSize, down, err, tt.down, tt.downErr)
+		}
+		if err == nil && !down.IsSupersetOf(tt.r) {
+			t.Errorf("%v.AlignDown(%#x) = %v, not a superset", tt.r, tt.alignSize, down)
+		}
+	}
+}
+
+func TestSegmentsSort(t *testing.T) {
+	segs := Segments{
+		NewSegment([]byte("c"), Range{Start: 0x3000, Size: 0x1000}),
+		NewSegment([]byte("a"), Range{Start: 0x1000, Size: 0x1000}),
+		NewSegment([]byte("empty1"), Range{Start: 0x5000, Size: 0}),
+		NewSegment([]byte("b"), Range{Start: 0x2000, Size: 0x1000}),
+		NewSegment([]byte("empty2"), Range{Start: 0x5000, Size: 0}),
+	}
+	want := Segments{
+		NewSegment([]byte("a"), Range{Start: 0x1000, Size: 0x1000}),
+		NewSegment([]byte("b"), Range{Start: 0x2000, Size: 0x1000}),
+		NewSegment([]byte("c"), Range{Start: 0x3000, Size: 0x1000}),
+		NewSegment([]byte("empty1"), Range{Start: 0x5000, Size: 0}),
+		NewSegment([]byte("empty2"), Range{Start: 0x5000, Size: 0}),
+	}
+
+	segs.Sort()
+	if !reflect.DeepEqual(segs, want) {
+		t.Errorf("Sort() = %v, want %v", segs, want)
+	}
+
+	// Out-of-order input still validates.
+	unsorted := Segments{
+		NewSegment([]byte("c"), Range{Start: 0x3000, Size: 0x1000}),
+		NewSegment([]byte("a"), Range{Start: 0x1000, Size: 0x1000}),
+	}
+	if _, err := AlignAndMerge(unsorted); err != nil {
+		t.Errorf("AlignAndMerge(%v) = %v, want nil", unsorted, err)
+	}
+	overlapping := Segments{
+		NewSegment([]byte("c"), Range{Start: 0x3000, Size: 0x1000}),
+		NewSegment([]byte("a"), Range{Start: 0x1000, Size: 0x1000}),
+		NewSegment([]byte("b"), Range{Start: 0x1800, Size: 0x1000}),
+	}
+	if _, err := AlignAndMerge(overlapping); err == nil {
+		t.Errorf("AlignAndMerge(%v) = nil, want error", overlapping)
+	}
+}
+
+func TestRangeJSON(t *testing.T) {
+	top := ^uintptr(0) &^ 0xfff
+	for _, tt := range []struct {
+		r    Range
+		want string
+	}{
+		{r: Range{}, want: `{"Start":"0x0","Size":"0x0"}`},
+		{r: Range{Start: 0x100000, Size: 0x1000}, want: `{"Start":"0x100000","Size":"0x1000"}`},
+		{r: Range{Start: top, Size: 0x1000}, want: fmt.Sprintf(`{"Start":"%#x","Size":"0x1000"}`, top)},
+	} {
+		b, err := json.Marshal(tt.r)
+		if err != nil {
+			t.Fatalf("json.Marshal(%v) = %v, want nil", tt.r, err)
+		}
+		if string(b) != tt.want {
+			t.Errorf("json.Marshal(%v) = %s, want %s", tt.r, b, tt.want)
+		}
+
+		var got Range
+		if err := json.Unmarshal(b, &got); err != nil {
+			t.Fatalf("json.Unmarshal(%s) = %v, want nil", b, err)
+		}
+		if got != tt.r {
+			t.Errorf("json.Unmarshal(%s) = %v, want %v", b, got, tt.r)
+		}
+	}
+}
+
+func TestSegmentJSON(t *testing.T) {
+	s := NewSegment([]byte("kernel"), Range{Start: 0x200000, Size: 0x6})
+	b, err := json.Marshal(s)
+	if err != nil {
+		t.Fatalf("json.Marshal(%v) = %v, want nil", s, err)
+	}
+	if want := `{"Buf":"a2VybmVs","Phys":{"Start":"0x200000","Size":"0x6"}}`; string(b) != want {
+		t.Errorf("json.Marshal(%v) = %s, want %s", s, b, want)
+	}
+}
+
+func TestRangeUnmarshalJSON(t *testing.T) {
+	for _, tt := range []struct {
+		in      string
+		want    Range
+		wantErr bool
+	}{
+		// Plans saved before Range had a JSON representation.
+		{in: `{"Start":1048576,"Size":4096}`, want: Range{Start: 0x100000, Size: 0x1000}},
+		{in: `{"Start":"0X10","Size":"16"}`, want: Range{Start: 0x10, Size: 0x10}},
+		{in: `{"Start":"0xg","Size":"0x1"}`, wantErr: true},
+		{in: `{"Start":"0x10000000000000000","Size":"0x1"}`, wantErr: true},
+		{in: `{"Start":-1,"Size":"0x1"}`, wantErr: true},
+		{in: `[]`, wantErr: true},
+	} {
+		var got Range
+		err := json.Unmarshal([]byte(tt.in), &got)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("json.Unmarshal(%s) = %v, want error %t", tt.in, err, tt.wantErr)
+			continue
+		}
+		if err == nil && got != tt.want {
+			t.Errorf("json.Unmarshal(%s) = %v, want %v", tt.in, got, tt.want)
+		}
+	}
+}
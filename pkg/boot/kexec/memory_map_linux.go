@@ -6,6 +6,8 @@ package kexec
 
 import (
 	"bufio"
+	"encoding/binary"
+	"errors"
 	"fmt"
 	"io"
 	"log"
@@ -88,6 +90,33 @@ func (mm MemoryMap) RAM() Ranges {
 	return mm.FilterByType(RangeRAM)
 }
 
+// Usable returns the RAM in the memory map that no other range claims, sorted
+// and with adjacent ranges coalesced.
+//
+// Unlike RAM, it also handles memory maps whose RAM ranges overlap reserved,
+// ACPI or NVS ranges, as maps merged from several sources may.
+func (mm MemoryMap) Usable() Ranges {
+	var ram MemoryMap
+	for _, tr := range mm {
+		if tr.Type == RangeRAM && tr.Size > 0 {
+			ram = append(ram, tr)
+		}
+	}
+	ram.sort()
+	ram.mergeAdjacent()
+
+	var usable Ranges
+	for _, tr := range ram {
+		usable = append(usable, tr.Range)
+	}
+	for _, tr := range mm {
+		if tr.Type != RangeRAM {
+			usable = usable.Minus(tr.Range)
+		}
+	}
+	return usable
+}
+
 func (mm MemoryMap) sort() {
 	sort.Slice(mm, func(i, j int) bool {
 		return mm[i].Start < mm[j].Start
@@ -170,25 +199,9 @@ func MemoryMapFromFDT(fdt *dt.FDT) (MemoryMap, error) {
 		return nil, err
 	}
 
-	reserveMemory := func(n *dt.Node) error {
-		p, found := n.LookProperty("reg")
-		if found {
-			r, err := p.AsRegion()
-			if err != nil {
-				return err
-			}
-
-			mm.Insert(TypedRange{
-				Range: Range{Start: uintptr(r.Start), Size: uint(r.Size)},
-				Type:  RangeReserved,
-			})
-		}
-		return nil
-	}
-	resv, found := fdt.NodeByName("reserved-memory")
-	if found {
-		err := resv.Walk(reserveMemory)
-		if err != nil {
+	if resv, found := fdt.NodeByName("reserved-memory"); found {
+		addrCells, sizeCells := fdtCells(fdt.RootNode, 2, 2)
+		if err := reserveFDTMemory(&mm, resv, addrCells, sizeCells); err != nil {
 			return nil, err
 		}
 	}
@@ -205,6 +218,79 @@ func MemoryMapFromFDT(fdt *dt.FDT) (MemoryMap, error) {
 	return mm, nil
 }
 
+// fdtCells returns the #address-cells and #size-cells that n declares for
+// its children, defaulting to those of its parent.
+func fdtCells(n *dt.Node, addrCells, sizeCells uint32) (uint32, uint32) {
+	if p, ok := n.LookProperty("#address-cells"); ok {
+		if c, err := p.AsU32(); err == nil {
+			addrCells = c
+		}
+	}
+	if p, ok := n.LookProperty("#size-cells"); ok {
+		if c, err := p.AsU32(); err == nil {
+			sizeCells = c
+		}
+	}
+	return addrCells, sizeCells
+}
+
+var errFDTReg = errors.New("invalid reg property")
+
+// fdtRegRanges decodes a reg property of (address, size) pairs with the
+// given number of 32-bit cells each.
+func fdtRegRanges(p *dt.Property, addrCells, sizeCells uint32) ([]Range, error) {
+	if addrCells == 0 || addrCells > 2 || sizeCells == 0 || sizeCells > 2 {
+		return nil, fmt.Errorf("%w: %d address and %d size cells", errFDTReg, addrCells, sizeCells)
+	}
+	entry := int(addrCells+sizeCells) * 4
+	if len(p.Value) == 0 || len(p.Value)%entry != 0 {
+		return nil, fmt.Errorf("%w: %d bytes for %d address and %d size cells", errFDTReg, len(p.Value), addrCells, sizeCells)
+	}
+	cells := func(b []byte, n uint32) uint64 {
+		if n == 1 {
+			return uint64(binary.BigEndian.Uint32(b))
+		}
+		return binary.BigEndian.Uint64(b)
+	}
+	var rs []Range
+	for b := p.Value; len(b) > 0; b = b[entry:] {
+		rs = append(rs, Range{
+			Start: uintptr(cells(b, addrCells)),
+			Size:  uint(cells(b[addrCells*4:], sizeCells)),
+		})
+	}
+	return rs, nil
+}
+
+// reserveFDTMemory inserts the regions of the /reserved-memory node n and
+// its children into mm. The regions of n are given in addrCells and
+// sizeCells, those of its children in the cells n declares. Disabled
+// regions are left out, as the kernel does.
+func reserveFDTMemory(mm *MemoryMap, n *dt.Node, addrCells, sizeCells uint32) error {
+	if p, ok := n.LookProperty("status"); ok {
+		if s, err := p.AsString(); err == nil && s != "okay" && s != "ok" {
+			return nil
+		}
+	}
+	if p, ok := n.LookProperty("reg"); ok {
+		rs, err := fdtRegRanges(p, addrCells, sizeCells)
+		if err != nil {
+			return fmt.Errorf("%s: %w", n.Name, err)
+		}
+		for _, r := range rs {
+			mm.Insert(TypedRange{Range: r, Type: RangeReserved})
+		}
+	}
+
+	addrCells, sizeCells = fdtCells(n, addrCells, sizeCells)
+	for _, c := range n.Children {
+		if err := reserveFDTMemory(mm, c, addrCells, sizeCells); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
 var memoryMapRoot = "/sys/firmware/memmap/"
 
 // MemoryMapFromSysfsMemmap reads a firmware-provided memory map from /sys/firmware/memmap.
@@ -366,37 +452,46 @@ func rangeType(s string) RangeType {
 	return RangeType(s)
 }
 
+// parseIOMemLine parses a line of /proc/iomem into the range and its name.
+func parseIOMemLine(s string) (Range, string, bool) {
+	// Format:
+	//   740100000000-7401001fffff : PCI Bus 0001:01
+	els := strings.Split(s, ":")
+	if len(els) != 2 {
+		return Range{}, "", false
+	}
+	name := strings.TrimSpace(els[1])
+	addrs := strings.Split(strings.TrimSpace(els[0]), "-")
+	if len(addrs) != 2 {
+		return Range{}, "", false
+	}
+	start, err := strconv.ParseUint(addrs[0], 16, 64)
+	if err != nil {
+		return Range{}, "", false
+	}
+	end, err := strconv.ParseUint(addrs[1], 16, 64)
+	if err != nil {
+		return Range{}, "", false
+	}
+	// Special case -- empty ranges are represented as "000-000"
+	// even though the non-inclusive end would make that a 1-sized
+	// region.
+	if start == end {
+		return Range{}, "", false
+	}
+	return RangeFromInclusiveInterval(uintptr(start), uintptr(end)), name, true
+}
+
 func memoryMapFromIOMem(r io.Reader) (MemoryMap, error) {
 	var mm MemoryMap
 	b := bufio.NewScanner(r)
 	for b.Scan() {
-		// Format:
-		//   740100000000-7401001fffff : PCI Bus 0001:01
-		els := strings.Split(b.Text(), ":")
-		if len(els) != 2 {
-			continue
-		}
-		typ := strings.TrimSpace(els[1])
-		addrs := strings.Split(strings.TrimSpace(els[0]), "-")
-		if len(addrs) != 2 {
-			continue
-		}
-		start, err := strconv.ParseUint(addrs[0], 16, 64)
-		if err != nil {
-			continue
-		}
-		end, err := strconv.ParseUint(addrs[1], 16, 64)
-		if err != nil {
-			continue
-		}
-		// Special case -- empty ranges are represented as "000-000"
-		// even though the non-inclusive end would make that a 1-sized
-		// region.
-		if start == end {
+		r, typ, ok := parseIOMemLine(b.Text())
+		if !ok {
 			continue
 		}
 		mm.Insert(TypedRange{
-			Range: RangeFromInclusiveInterval(uintptr(start), uintptr(end)),
+			Range: r,
 			Type:  rangeType(typ),
 		})
 	}
@@ -418,6 +513,74 @@ func memoryMapFromIOMemFile(path string) (MemoryMap, error) {
 	return memoryMapFromIOMem(f)
 }
 
+// iomemCrashKernel names the memory reserved for a crash kernel in
+// /proc/iomem.
+const iomemCrashKernel = "Crash kernel"
+
+// CurrentMemoryMap returns the physical memory layout of the running kernel,
+// e.g. to stage a kexec with the same layout.
+//
+// The RAM and the reserved, ACPI and NVS ranges come from /proc/iomem. The
+// memory reserved for a crash kernel is reserved as well, so that it stays
+// free for kexec_load with KEXEC_ON_CRASH. The ranges the running kernel
+// itself occupies, like "Kernel code", are RAM, as they are for kexec-tools:
+// the kexec'd kernel may be placed there. Where /sys/firmware/memmap exists,
+// its ACPI, NVS and reserved ranges are added, as /proc/iomem may lack them.
+func CurrentMemoryMap() (MemoryMap, error) {
+	return currentMemoryMap("/proc/iomem", memoryMapRoot)
+}
+
+func currentMemoryMap(iomemPath, memmapDir string) (MemoryMap, error) {
+	f, err := os.Open(iomemPath)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var ram, other MemoryMap
+	b := bufio.NewScanner(f)
+	for b.Scan() {
+		r, name, ok := parseIOMemLine(b.Text())
+		if !ok {
+			continue
+		}
+		typ, ok := sysfsToRangeType[name]
+		switch {
+		case name == iomemCrashKernel:
+			other = append(other, TypedRange{Range: r, Type: RangeReserved})
+		case !ok:
+			// MMIO and the parts of the running kernel.
+		case typ == RangeRAM:
+			ram = append(ram, TypedRange{Range: r, Type: typ})
+		default:
+			other = append(other, TypedRange{Range: r, Type: typ})
+		}
+	}
+	if err := b.Err(); err != nil {
+		return nil, err
+	}
+
+	// The firmware map only exists on x86.
+	fw, err := memoryMapFromSysfsMemmap(memmapDir)
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return nil, err
+	}
+	for _, tr := range fw {
+		if tr.Type != RangeRAM && tr.Type != RangeDefault {
+			other = append(other, tr)
+		}
+	}
+
+	// Reservations nest in the RAM, so they are inserted after it.
+	mm := ram
+	for _, tr := range other {
+		mm.Insert(tr)
+	}
+	mm.sort()
+	mm.mergeAdjacent()
+	return mm, nil
+}
+
 func rangeFromMemblockLine(s string) *Range {
 	// Format:
 	//    0: 0x0000004000000000..0x00000040113fffff
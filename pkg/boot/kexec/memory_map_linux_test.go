@@ -5,6 +5,7 @@
 package kexec
 
 import (
+	"errors"
 	"fmt"
 	"os"
 	"path"
@@ -208,6 +209,54 @@ func TestMemoryMapFromFDT(t *testing.T) {
 	}
 }
 
+func TestMemoryMapFromFDTReservedCells(t *testing.T) {
+	fdt := &dt.FDT{
+		ReserveEntries: []dt.ReserveEntry{{Address: 0x8000000, Size: 0x1000}},
+		RootNode: dt.NewNode("/", dt.WithChildren(
+			dt.NewNode("memory", dt.WithProperty(
+				dt.PropertyString("device_type", "memory"),
+				dt.PropertyRegion("reg", 0, 0x10000000),
+			)),
+			dt.NewNode("reserved-memory",
+				dt.WithProperty(dt.PropertyU32("#address-cells", 1), dt.PropertyU32("#size-cells", 1)),
+				dt.WithChildren(
+					// Two regions in 32-bit cells.
+					dt.NewNode("coprocessor@1000000", dt.WithProperty(
+						dt.Property{Name: "reg", Value: []byte{
+							0x01, 0x00, 0x00, 0x00, 0x00, 0x10, 0x00, 0x00,
+							0x02, 0x00, 0x00, 0x00, 0x00, 0x00, 0x10, 0x00,
+						}},
+					)),
+					dt.NewNode("unused@4000000", dt.WithProperty(
+						dt.PropertyU32("reg", 0x4000000),
+						dt.PropertyString("status", "disabled"),
+					)),
+				),
+			),
+		)),
+	}
+	mm, err := MemoryMapFromFDT(fdt)
+	if err != nil {
+		t.Fatalf("MemoryMapFromFDT() = %v, want nil", err)
+	}
+	checkMemoryMap(t, mm, MemoryMap{
+		{Range{Start: 0, Size: 0x1000000}, RangeRAM},
+		{Range{Start: 0x1000000, Size: 0x100000}, RangeReserved},
+		{Range{Start: 0x1100000, Size: 0xf00000}, RangeRAM},
+		{Range{Start: 0x2000000, Size: 0x1000}, RangeReserved},
+		{Range{Start: 0x2001000, Size: 0x5fff000}, RangeRAM},
+		{Range{Start: 0x8000000, Size: 0x1000}, RangeReserved},
+		{Range{Start: 0x8001000, Size: 0x7fff000}, RangeRAM},
+	})
+
+	// 3 cells do not divide into 1 address and 1 size cell.
+	n, _ := fdt.NodeByName("coprocessor@1000000")
+	n.UpdateProperty("reg", make([]byte, 12))
+	if _, err := MemoryMapFromFDT(fdt); !errors.Is(err, errFDTReg) {
+		t.Errorf("MemoryMapFromFDT() = %v, want %v", err, errFDTReg)
+	}
+}
+
 func TestMemoryMapFromSysfsMemmap(t *testing.T) {
 	root := t.TempDir()
 
@@ -387,6 +436,93 @@ func TestMemoryMapFromIOMem(t *testing.T) {
 	}
 }
 
+func TestCurrentMemoryMap(t *testing.T) {
+	root := t.TempDir()
+	iomem := path.Join(root, "iomem")
+	if err := os.WriteFile(iomem, []byte(`00000000-00000fff : Reserved
+00001000-0009ffff : System RAM
+000a0000-000fffff : Reserved
+  000a0000-000bffff : PCI Bus 0000:00
+00100000-7ffdffff : System RAM
+  01000000-01ffffff : Kernel code
+  02000000-0260ffff : Kernel data
+  30000000-3fffffff : Crash kernel
+7ffe0000-7fffffff : ACPI Tables
+80000000-febfffff : PCI Bus 0000:00
+100000000-17fffffff : System RAM
+`), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	// The firmware map knows an NVS range that the kernel took as RAM.
+	memmap := path.Join(root, "memmap")
+	for dir, r := range map[string]TypedRange{
+		"0": {Range: RangeFromInterval(0x1000, 0xa0000), Type: RangeRAM},
+		"1": {Range: RangeFromInterval(0x7ff00000, 0x7ffe0000), Type: RangeNVS},
+		"2": {Range: RangeFromInterval(0x7ffe0000, 0x80000000), Type: RangeACPI},
+	} {
+		p := path.Join(memmap, dir)
+		if err := os.MkdirAll(p, 0o755); err != nil {
+			t.Fatal(err)
+		}
+		for name, content := range map[string]string{
+			"start": fmt.Sprintf("%#x\n", r.Start),
+			"end":   fmt.Sprintf("%#x\n", r.Last()),
+			"type":  r.Type.String() + "\n",
+		} {
+			if err := os.WriteFile(path.Join(p, name), []byte(content), 0o644); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+
+	iomemMap := MemoryMap{
+		{Range: RangeFromInterval(0, 0x1000), Type: RangeReserved},
+		{Range: RangeFromInterval(0x1000, 0xa0000), Type: RangeRAM},
+		{Range: RangeFromInterval(0xa0000, 0x100000), Type: RangeReserved},
+		{Range: RangeFromInterval(0x100000, 0x30000000), Type: RangeRAM},
+		{Range: RangeFromInterval(0x30000000, 0x40000000), Type: RangeReserved},
+		{Range: RangeFromInterval(0x40000000, 0x7ffe0000), Type: RangeRAM},
+		{Range: RangeFromInterval(0x7ffe0000, 0x80000000), Type: RangeACPI},
+		{Range: RangeFromInterval(0x100000000, 0x180000000), Type: RangeRAM},
+	}
+	for _, tt := range []struct {
+		name   string
+		memmap string
+		want   MemoryMap
+	}{
+		{
+			name:   "without firmware map",
+			memmap: path.Join(root, "none"),
+			want:   iomemMap,
+		},
+		{
+			name:   "with firmware map",
+			memmap: memmap,
+			want: MemoryMap{
+				iomemMap[0], iomemMap[1], iomemMap[2], iomemMap[3], iomemMap[4],
+				{Range: RangeFromInterval(0x40000000, 0x7ff00000), Type: RangeRAM},
+				{Range: RangeFromInterval(0x7ff00000, 0x7ffe0000), Type: RangeNVS},
+				iomemMap[6], iomemMap[7],
+			},
+		},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			mm, err := currentMemoryMap(iomem, tt.memmap)
+			if err != nil {
+				t.Fatalf("currentMemoryMap() = %v, want nil", err)
+			}
+			if !reflect.DeepEqual(mm, tt.want) {
+				t.Errorf("currentMemoryMap() =\n%v, want\n%v", mm, tt.want)
+			}
+		})
+	}
+
+	if _, err := currentMemoryMap(path.Join(root, "none"), memmap); !os.IsNotExist(err) {
+		t.Errorf("currentMemoryMap() without iomem = %v, want not exist", err)
+	}
+}
+
 func TestMemoryMapFromMemblock(t *testing.T) {
 	memory := `  0: 0x0000004000000000..0x00000040113fffff
    1: 0x0000004011400000..0x00000040123fffff
@@ -461,3 +597,65 @@ func TestMemoryMapMerge(t *testing.T) {
 		t.Errorf("Merge() got %v, want %v", mm, want)
 	}
 }
+
+func TestMemoryMapUsable(t *testing.T) {
+	for _, tt := range []struct {
+		name string
+		mm   MemoryMap
+		want Ranges
+	}{
+		{
+			name: "empty",
+		},
+		{
+			name: "interleaved",
+			mm: MemoryMap{
+				TypedRange{Range: Range{Start: 0x1000, Size: 0x9e000}, Type: RangeRAM},
+				TypedRange{Range: Range{Start: 0x9f000, Size: 0x61000}, Type: RangeReserved},
+				TypedRange{Range: Range{Start: 0x100000, Size: 0x7ee00000}, Type: RangeRAM},
+				TypedRange{Range: Range{Start: 0x7ef00000, Size: 0x80000}, Type: RangeACPI},
+				TypedRange{Range: Range{Start: 0x7ef80000, Size: 0x80000}, Type: RangeNVS},
+				TypedRange{Range: Range{Start: 0x7f000000, Size: 0x1000000}, Type: RangeRAM},
+			},
+			want: Ranges{
+				Range{Start: 0x1000, Size: 0x9e000},
+				Range{Start: 0x100000, Size: 0x7ee00000},
+				Range{Start: 0x7f000000, Size: 0x1000000},
+			},
+		},
+		{
+			name: "unsorted and adjacent",
+			mm: MemoryMap{
+				TypedRange{Range: Range{Start: 0x3000, Size: 0x1000}, Type: RangeRAM},
+				TypedRange{Range: Range{Start: 0x8000, Size: 0x1000}, Type: RangeReserved},
+				TypedRange{Range: Range{Start: 0x1000, Size: 0x2000}, Type: RangeRAM},
+				TypedRange{Range: Range{Start: 0x9000, Size: 0x1000}, Type: RangeRAM},
+			},
+			want: Ranges{
+				Range{Start: 0x1000, Size: 0x3000},
+				Range{Start: 0x9000, Size: 0x1000},
+			},
+		},
+		{
+			name: "reserved overlapping RAM",
+			mm: MemoryMap{
+				TypedRange{Range: Range{Start: 0x0, Size: 0x10000}, Type: RangeRAM},
+				TypedRange{Range: Range{Start: 0x8000, Size: 0x10000}, Type: RangeRAM},
+				TypedRange{Range: Range{Start: 0x4000, Size: 0x1000}, Type: RangeReserved},
+				TypedRange{Range: Range{Start: 0xc000, Size: 0x2000}, Type: RangeACPI},
+				TypedRange{Range: Range{Start: 0x16000, Size: 0x4000}, Type: RangeNVS},
+			},
+			want: Ranges{
+				Range{Start: 0x0, Size: 0x4000},
+				Range{Start: 0x5000, Size: 0x7000},
+				Range{Start: 0xe000, Size: 0x8000},
+			},
+		},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.mm.Usable(); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Usable() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,74 @@
+// Copyright 2024 the u-root Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package kexec
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+)
+
+// loadPlanVersion is the version of the LoadPlan file format.
+const loadPlanVersion = 1
+
+// ErrLoadPlanVersion is returned by LoadPlanFromFile for plans saved in an
+// unknown format.
+var ErrLoadPlanVersion = errors.New("unsupported load plan version")
+
+// LoadPlan is everything Load needs to load the next kernel: the segments and
+// the entry point.
+//
+// A plan can be saved and reloaded, e.g. to capture the result of a loader in
+// one environment and replay it in another.
+type LoadPlan struct {
+	// Entry is the entry point, either kernel entry point or trampoline.
+	Entry uintptr
+
+	// Segments are the segments to load.
+	Segments Segments
+}
+
+// loadPlanFile is the JSON representation of a LoadPlan. Segment buffers are
+// base64 encoded by encoding/json, their ranges hex encoded by Range.
+type loadPlanFile struct {
+	Version  int
+	Entry    uintptr
+	Segments Segments
+}
+
+// Save writes the plan to path.
+func (p LoadPlan) Save(path string) error {
+	b, err := json.Marshal(loadPlanFile{
+		Version:  loadPlanVersion,
+		Entry:    p.Entry,
+		Segments: p.Segments,
+	})
+	if err != nil {
+		return fmt.Errorf("encoding load plan: %w", err)
+	}
+	return os.WriteFile(path, b, 0o644)
+}
+
+// LoadPlanFromFile reads a plan written by LoadPlan.Save.
+func LoadPlanFromFile(path string) (LoadPlan, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return LoadPlan{}, err
+	}
+	var f loadPlanFile
+	if err := json.Unmarshal(b, &f); err != nil {
+		return LoadPlan{}, fmt.Errorf("decoding load plan %q: %w", path, err)
+	}
+	if f.Version != loadPlanVersion {
+		return LoadPlan{}, fmt.Errorf("%w: %d in %q", ErrLoadPlanVersion, f.Version, path)
+	}
+	return LoadPlan{Entry: f.Entry, Segments: f.Segments}, nil
+}
+
+// Load loads the plan like Load.
+func (p LoadPlan) Load(flags uint64) error {
+	return Load(p.Entry, p.Segments, flags)
+}
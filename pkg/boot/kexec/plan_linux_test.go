@@ -0,0 +1,84 @@
+// Copyright 2024 the u-root Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package kexec
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestLoadPlanRoundTrip(t *testing.T) {
+	for _, tt := range []struct {
+		name string
+		plan LoadPlan
+	}{
+		{
+			name: "empty",
+			plan: LoadPlan{},
+		},
+		{
+			name: "segments",
+			plan: LoadPlan{
+				Entry: 0x100000,
+				Segments: Segments{
+					NewSegment([]byte("kernel"), Range{Start: 0x100000, Size: 0x1000}),
+					NewSegment([]byte{0, 1, 2, 0xff}, Range{Start: 0x200000, Size: 4}),
+					// On 64-bit, past what a float64 represents exactly.
+					NewSegment(nil, Range{Start: ^uintptr(0) &^ 0xfff, Size: 0x1000}),
+				},
+			},
+		},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "plan")
+			if err := tt.plan.Save(path); err != nil {
+				t.Fatalf("Save() = %v, want nil", err)
+			}
+			got, err := LoadPlanFromFile(path)
+			if err != nil {
+				t.Fatalf("LoadPlanFromFile() = %v, want nil", err)
+			}
+			if got.Entry != tt.plan.Entry {
+				t.Errorf("LoadPlanFromFile() entry = %#x, want %#x", got.Entry, tt.plan.Entry)
+			}
+			if !SegmentsEqual(got.Segments, tt.plan.Segments) {
+				t.Errorf("LoadPlanFromFile() segments = %v, want %v", got.Segments, tt.plan.Segments)
+			}
+		})
+	}
+}
+
+func TestLoadPlanFromFileErrors(t *testing.T) {
+	dir := t.TempDir()
+	for _, tt := range []struct {
+		name    string
+		content string
+		wantErr error
+	}{
+		{
+			name:    "version",
+			content: `{"Version": 2, "Entry": 4096}`,
+			wantErr: ErrLoadPlanVersion,
+		},
+		{
+			name:    "missing",
+			wantErr: os.ErrNotExist,
+		},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(dir, tt.name)
+			if tt.content != "" {
+				if err := os.WriteFile(path, []byte(tt.content), 0o644); err != nil {
+					t.Fatal(err)
+				}
+			}
+			if _, err := LoadPlanFromFile(path); !errors.Is(err, tt.wantErr) {
+				t.Errorf("LoadPlanFromFile() = %v, want %v", err, tt.wantErr)
+			}
+		})
+	}
+}
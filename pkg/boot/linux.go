@@ -251,7 +251,10 @@ func (li *LinuxImage) Load(opts ...LoadOption) error {
 		return nil
 	}
 	if li.LoadSyscall {
-		return linux.KexecLoad(k, i, li.Cmdline, li.DTB, li.ReservedRanges)
+		return linux.KexecLoad(k, i, li.Cmdline, linux.KexecOptions{
+			DTB:            li.DTB,
+			ReservedRanges: li.ReservedRanges,
+		})
 	}
 	return kexec.FileLoad(k, i, li.Cmdline)
 }
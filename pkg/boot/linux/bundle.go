@@ -0,0 +1,96 @@
+// Copyright 2024 the u-root Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package linux
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/u-root/u-root/pkg/cpio"
+)
+
+// Names of the members of a bundle, see LoadBundle.
+const (
+	BundleKernel = "kernel"
+	BundleDTB    = "dtb"
+	BundleInitrd = "initrd"
+)
+
+var errBundleNoKernel = errors.New("bundle has no " + BundleKernel)
+
+// bundleLoad is KexecLoad. Tests replace it.
+var bundleLoad = KexecLoad
+
+// LoadBundle loads the kernel of a bundle, a newc cpio archive that carries
+// everything needed to boot in one blob, e.g. for netboot. The members are
+// regular files at the top level of the archive:
+//
+//	kernel  the kernel, as KexecLoad takes it
+//	dtb     the device tree blob, optional
+//	initrd  the initrd, optional
+//
+// Other members are ignored. The dtb is passed as opts.DTB, so opts must
+// not set a device tree if the bundle has one.
+func LoadBundle(bundle io.ReaderAt, cmdline string, opts KexecOptions) error {
+	archive, err := cpio.ArchiveFromReader(cpio.Newc.Reader(bundle))
+	if err != nil {
+		return fmt.Errorf("reading bundle: %w", err)
+	}
+
+	kernelRec, ok := archive.Get(BundleKernel)
+	if !ok {
+		return errBundleNoKernel
+	}
+	kernel, err := bundleFile(kernelRec)
+	if err != nil {
+		return err
+	}
+	defer kernel.Close()
+
+	var initrd *os.File
+	if rec, ok := archive.Get(BundleInitrd); ok {
+		initrd, err = bundleFile(rec)
+		if err != nil {
+			return err
+		}
+		defer initrd.Close()
+	}
+
+	if rec, ok := archive.Get(BundleDTB); ok {
+		if opts.DTB != nil || opts.DTBBytes != nil {
+			return errMultipleDTBs
+		}
+		opts.DTB = io.NewSectionReader(rec, 0, int64(rec.FileSize))
+	}
+
+	return bundleLoad(kernel, initrd, cmdline, opts)
+}
+
+// bundleFile copies a member of a bundle into an unlinked temporary file,
+// as the loaders and kexec_file_load take files.
+func bundleFile(rec cpio.Record) (*os.File, error) {
+	if rec.Mode&cpio.S_IFMT != cpio.S_IFREG {
+		return nil, fmt.Errorf("bundle member %s is not a regular file", rec.Name)
+	}
+
+	f, err := os.CreateTemp("", "kexec-bundle-"+rec.Name)
+	if err != nil {
+		return nil, err
+	}
+	// The open file stays usable.
+	os.Remove(f.Name())
+
+	if _, err := io.Copy(f, io.NewSectionReader(rec, 0, int64(rec.FileSize))); err != nil {
+		f.Close()
+		return nil, fmt.Errorf("copying bundle member %s: %w", rec.Name, err)
+	}
+	if _, err := f.Seek(0, io.SeekStart); err != nil {
+		f.Close()
+		return nil, err
+	}
+	return f, nil
+}
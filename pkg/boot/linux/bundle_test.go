@@ -0,0 +1,113 @@
+// Copyright 2024 the u-root Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package linux
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"os"
+	"testing"
+
+	"github.com/u-root/u-root/pkg/cpio"
+)
+
+func bundle(t *testing.T, files ...cpio.Record) *bytes.Reader {
+	t.Helper()
+	var b bytes.Buffer
+	w := cpio.Newc.Writer(&b)
+	if err := cpio.WriteRecords(w, files); err != nil {
+		t.Fatal(err)
+	}
+	if err := cpio.WriteTrailer(w); err != nil {
+		t.Fatal(err)
+	}
+	return bytes.NewReader(b.Bytes())
+}
+
+func TestLoadBundle(t *testing.T) {
+	var loaded bool
+	old := bundleLoad
+	bundleLoad = func(kernel, ramfs *os.File, cmdline string, opts KexecOptions) error {
+		loaded = true
+		if got := readAll(t, kernel); got != "kernel image" {
+			t.Errorf("kernel = %q, want %q", got, "kernel image")
+		}
+		if ramfs == nil {
+			t.Errorf("initrd = nil, want the bundle's initrd")
+		} else if got := readAll(t, ramfs); got != "initrd archive" {
+			t.Errorf("initrd = %q, want %q", got, "initrd archive")
+		}
+		if opts.DTB == nil {
+			t.Errorf("DTB = nil, want the bundle's dtb")
+		} else if got := readAll(t, io.NewSectionReader(opts.DTB, 0, 1<<20)); got != "device tree" {
+			t.Errorf("DTB = %q, want %q", got, "device tree")
+		}
+		if cmdline != "console=ttyAMA0" {
+			t.Errorf("cmdline = %q, want %q", cmdline, "console=ttyAMA0")
+		}
+		return nil
+	}
+	t.Cleanup(func() { bundleLoad = old })
+
+	b := bundle(t,
+		cpio.StaticFile("initrd", "initrd archive", 0o644),
+		cpio.StaticFile("README", "ignored", 0o644),
+		cpio.StaticFile("kernel", "kernel image", 0o644),
+		cpio.StaticFile("dtb", "device tree", 0o644),
+	)
+	if err := LoadBundle(b, "console=ttyAMA0", KexecOptions{}); err != nil {
+		t.Fatalf("LoadBundle() = %v, want nil", err)
+	}
+	if !loaded {
+		t.Errorf("LoadBundle() did not load the kernel")
+	}
+}
+
+func TestLoadBundleErrors(t *testing.T) {
+	old := bundleLoad
+	bundleLoad = func(*os.File, *os.File, string, KexecOptions) error {
+		t.Errorf("LoadBundle() loaded a kernel, want error")
+		return nil
+	}
+	t.Cleanup(func() { bundleLoad = old })
+
+	for _, tt := range []struct {
+		name   string
+		bundle *bytes.Reader
+		opts   KexecOptions
+		want   error
+	}{
+		{
+			name:   "no kernel",
+			bundle: bundle(t, cpio.StaticFile("initrd", "initrd archive", 0o644)),
+			want:   errBundleNoKernel,
+		},
+		{
+			name: "two device trees",
+			bundle: bundle(t,
+				cpio.StaticFile("kernel", "kernel image", 0o644),
+				cpio.StaticFile("dtb", "device tree", 0o644),
+			),
+			opts: KexecOptions{DTBBytes: []byte("another device tree")},
+			want: errMultipleDTBs,
+		},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := LoadBundle(tt.bundle, "", tt.opts); !errors.Is(err, tt.want) {
+				t.Errorf("LoadBundle() = %v, want %v", err, tt.want)
+			}
+		})
+	}
+}
+
+func readAll(t *testing.T, r io.Reader) string {
+	t.Helper()
+	b, err := io.ReadAll(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(b)
+}
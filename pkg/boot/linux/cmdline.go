@@ -0,0 +1,138 @@
+// Copyright 2024 the u-root Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package linux
+
+import (
+	"strings"
+	"unicode"
+)
+
+// Cmdline is a kernel command line: parameters of the form key, key=value or
+// key="value with spaces", separated by spaces. Parameters after "--" are
+// passed to init and are left alone by the methods that take a key.
+//
+// Parameters keep their order and quoting. Keys match like the kernel
+// matches them, with dashes and underscores being equivalent.
+type Cmdline struct {
+	params []string
+}
+
+// ParseCmdline splits s into parameters. Spaces within double quotes do not
+// separate parameters.
+func ParseCmdline(s string) *Cmdline {
+	c := &Cmdline{}
+	var param strings.Builder
+	var quoted bool
+	for _, r := range s {
+		switch {
+		case r == '"':
+			quoted = !quoted
+		case unicode.IsSpace(r) && !quoted:
+			if param.Len() > 0 {
+				c.params = append(c.params, param.String())
+				param.Reset()
+			}
+			continue
+		}
+		param.WriteRune(r)
+	}
+	if param.Len() > 0 {
+		c.params = append(c.params, param.String())
+	}
+	return c
+}
+
+// cmdlineKey returns the key of the parameter param, in the form keys are
+// compared in.
+func cmdlineKey(param string) string {
+	key, _, _ := strings.Cut(strings.TrimPrefix(param, `"`), "=")
+	return strings.ReplaceAll(strings.Trim(key, `"`), "-", "_")
+}
+
+// kernelParams returns the number of parameters before "--".
+func (c *Cmdline) kernelParams() int {
+	for i, p := range c.params {
+		if p == "--" {
+			return i
+		}
+	}
+	return len(c.params)
+}
+
+// Has reports whether the command line has a parameter key.
+func (c *Cmdline) Has(key string) bool {
+	key = cmdlineKey(key)
+	for _, p := range c.params[:c.kernelParams()] {
+		if cmdlineKey(p) == key {
+			return true
+		}
+	}
+	return false
+}
+
+// Set sets the parameter key to value, in place of the first parameter key.
+// Other parameters key are deleted, so e.g. Set("console", "ttyS0") selects
+// only that console. If there is no parameter key, it is appended. value is
+// quoted if it contains spaces.
+func (c *Cmdline) Set(key, value string) {
+	if strings.IndexFunc(value, unicode.IsSpace) >= 0 {
+		value = `"` + value + `"`
+	}
+	param := key + "=" + value
+
+	ckey := cmdlineKey(key)
+	n := c.kernelParams()
+	params := make([]string, 0, len(c.params)+1)
+	var set bool
+	for i, p := range c.params {
+		if i < n && cmdlineKey(p) == ckey {
+			if !set {
+				params = append(params, param)
+				set = true
+			}
+			continue
+		}
+		if i == n && !set {
+			params = append(params, param)
+			set = true
+		}
+		params = append(params, p)
+	}
+	if !set {
+		params = append(params, param)
+	}
+	c.params = params
+}
+
+// Append appends the parameters of s, e.g. a bare flag like "quiet" or
+// several parameters. They are inserted before "--", if any.
+func (c *Cmdline) Append(s string) {
+	n := c.kernelParams()
+	params := append([]string{}, c.params[:n]...)
+	params = append(params, ParseCmdline(s).params...)
+	c.params = append(params, c.params[n:]...)
+}
+
+// Delete deletes all parameters key and returns them.
+func (c *Cmdline) Delete(key string) []string {
+	key = cmdlineKey(key)
+	n := c.kernelParams()
+	var params, deleted []string
+	for i, p := range c.params {
+		if i < n && cmdlineKey(p) == key {
+			deleted = append(deleted, p)
+			continue
+		}
+		params = append(params, p)
+	}
+	c.params = params
+	return deleted
+}
+
+// String returns the command line, with the parameters separated by one
+// space.
+func (c *Cmdline) String() string {
+	return strings.Join(c.params, " ")
+}
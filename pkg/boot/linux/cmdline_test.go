@@ -0,0 +1,114 @@
+// Copyright 2024 the u-root Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package linux
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParseCmdline(t *testing.T) {
+	for _, tt := range []struct {
+		cmdline string
+		want    []string
+	}{
+		{cmdline: "", want: nil},
+		{cmdline: "  quiet\troot=/dev/sda1\n", want: []string{"quiet", "root=/dev/sda1"}},
+		{cmdline: `dyndbg="file  x.c +p" quiet`, want: []string{`dyndbg="file  x.c +p"`, "quiet"}},
+		{cmdline: `"opt=a b" ro`, want: []string{`"opt=a b"`, "ro"}},
+		{cmdline: "quiet -- init arg", want: []string{"quiet", "--", "init", "arg"}},
+	} {
+		if got := ParseCmdline(tt.cmdline).params; !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("ParseCmdline(%q) = %q, want %q", tt.cmdline, got, tt.want)
+		}
+	}
+}
+
+func TestCmdline(t *testing.T) {
+	for _, tt := range []struct {
+		name    string
+		cmdline string
+		edit    func(c *Cmdline)
+		want    string
+	}{
+		{
+			name:    "set-override",
+			cmdline: "quiet console=tty0 root=/dev/sda1 console=ttyS0,115200 ro",
+			edit:    func(c *Cmdline) { c.Set("console", "ttyAMA0") },
+			want:    "quiet console=ttyAMA0 root=/dev/sda1 ro",
+		},
+		{
+			name:    "set-append",
+			cmdline: "quiet",
+			edit:    func(c *Cmdline) { c.Set("root", "/dev/vda") },
+			want:    "quiet root=/dev/vda",
+		},
+		{
+			name:    "set-before-init-args",
+			cmdline: "quiet -- console=x",
+			edit:    func(c *Cmdline) { c.Set("console", "ttyS0") },
+			want:    "quiet console=ttyS0 -- console=x",
+		},
+		{
+			name:    "set-quotes-spaces",
+			cmdline: "quiet",
+			edit:    func(c *Cmdline) { c.Set("dyndbg", "file x.c +p") },
+			want:    `quiet dyndbg="file x.c +p"`,
+		},
+		{
+			name:    "set-dash-underscore",
+			cmdline: "rcu_nocbs=1 quiet",
+			edit:    func(c *Cmdline) { c.Set("rcu-nocbs", "2") },
+			want:    "rcu-nocbs=2 quiet",
+		},
+		{
+			name:    "delete",
+			cmdline: `initrd=/a opts="x initrd=y" quiet initrd=/b noinitrd rd.initrd=1`,
+			edit:    func(c *Cmdline) { c.Delete("initrd") },
+			want:    `opts="x initrd=y" quiet noinitrd rd.initrd=1`,
+		},
+		{
+			name:    "delete-flag",
+			cmdline: "quiet splash quiet=1",
+			edit:    func(c *Cmdline) { c.Delete("quiet") },
+			want:    "splash",
+		},
+		{
+			name:    "delete-quoted-param",
+			cmdline: `"initrd=/a b" quiet`,
+			edit:    func(c *Cmdline) { c.Delete("initrd") },
+			want:    "quiet",
+		},
+		{
+			name:    "append",
+			cmdline: "quiet -- single",
+			edit:    func(c *Cmdline) { c.Append(`nokaslr opts="a b"`) },
+			want:    `quiet nokaslr opts="a b" -- single`,
+		},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			c := ParseCmdline(tt.cmdline)
+			tt.edit(c)
+			if got := c.String(); got != tt.want {
+				t.Errorf("%q edited = %q, want %q", tt.cmdline, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCmdlineHasDelete(t *testing.T) {
+	c := ParseCmdline("console=tty0 earlycon quiet console=ttyS0 -- console=init")
+	for key, want := range map[string]bool{"console": true, "earlycon": true, "root": false, "init": false} {
+		if got := c.Has(key); got != want {
+			t.Errorf("Has(%q) = %t, want %t", key, got, want)
+		}
+	}
+	if got, want := c.Delete("console"), []string{"console=tty0", "console=ttyS0"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("Delete(console) = %q, want %q", got, want)
+	}
+	if got := c.Delete("console"); got != nil {
+		t.Errorf("Delete(console) again = %q, want nothing", got)
+	}
+}
@@ -0,0 +1,195 @@
+// Copyright 2024 the u-root Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package linux
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/u-root/u-root/pkg/dt"
+)
+
+// uartDriver maps a UART's compatible string to the kernel's earlycon
+// driver and the name of its tty devices.
+type uartDriver struct {
+	compatible string
+	earlycon   string
+	tty        string
+}
+
+var uartDrivers = []uartDriver{
+	{compatible: "arm,pl011", earlycon: "pl011", tty: "ttyAMA"},
+	{compatible: "arm,sbsa-uart", earlycon: "pl011", tty: "ttyAMA"},
+	{compatible: "ns16550a", earlycon: "uart8250,mmio", tty: "ttyS"},
+	{compatible: "ns16550", earlycon: "uart8250,mmio", tty: "ttyS"},
+	{compatible: "snps,dw-apb-uart", earlycon: "uart8250,mmio32", tty: "ttyS"},
+}
+
+// errNoConsole is returned by consoleArgs if the UART stdout-path names
+// cannot be used as a console.
+var errNoConsole = errors.New("no usable console")
+
+// consoleArgs returns the console= and earlycon= arguments for the UART
+// that /chosen/stdout-path of fdt points to.
+//
+// stdout-path is either a path or an alias, optionally followed by
+// ":<options>", e.g. "serial0:115200n8". The console= argument needs the
+// tty index, which is only known if an alias such as serial0 is used.
+//
+// No arguments are returned if stdout-path is absent.
+func consoleArgs(fdt *dt.FDT) ([]string, error) {
+	chosen, ok := fdt.NodeByName("chosen")
+	if !ok {
+		return nil, nil
+	}
+	prop, ok := chosen.LookProperty("stdout-path")
+	if !ok {
+		return nil, nil
+	}
+	stdoutPath, err := prop.AsString()
+	if err != nil {
+		return nil, err
+	}
+
+	name, options, _ := strings.Cut(stdoutPath, ":")
+	nodePath := name
+	index := -1
+	if !strings.HasPrefix(name, "/") {
+		nodePath, err = resolveAlias(fdt, name)
+		if err != nil {
+			return nil, fmt.Errorf("%w: %w", errNoConsole, err)
+		}
+		if i := strings.TrimLeft(name, "abcdefghijklmnopqrstuvwxyz"); i != "" {
+			if index, err = strconv.Atoi(i); err != nil {
+				index = -1
+			}
+		}
+	}
+
+	uart, addressCells, err := nodeByPath(fdt, nodePath)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", errNoConsole, err)
+	}
+	drv, ok := uartDriverFor(uart)
+	if !ok {
+		return nil, fmt.Errorf("%w: no known console driver for %s", errNoConsole, nodePath)
+	}
+	addr, err := regAddress(uart, addressCells)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %s: %w", errNoConsole, nodePath, err)
+	}
+
+	var args []string
+	if index >= 0 {
+		console := fmt.Sprintf("console=%s%d", drv.tty, index)
+		if options != "" {
+			console += "," + options
+		}
+		args = append(args, console)
+	}
+	return append(args, fmt.Sprintf("earlycon=%s,%#x", drv.earlycon, addr)), nil
+}
+
+// appendConsole appends the console arguments derived from fdt to cmdline,
+// unless cmdline already selects a console. If the UART cannot be used,
+// cmdline is returned unchanged; only a malformed fdt is an error.
+func appendConsole(cmdline string, fdt *dt.FDT) (string, error) {
+	c := ParseCmdline(cmdline)
+	if c.Has("console") || c.Has("earlycon") {
+		return cmdline, nil
+	}
+
+	args, err := consoleArgs(fdt)
+	if errors.Is(err, errNoConsole) {
+		Debug("Not adding a console: %v", err)
+		return cmdline, nil
+	}
+	if err != nil {
+		return "", err
+	}
+	for _, arg := range args {
+		c.Append(arg)
+	}
+	return c.String(), nil
+}
+
+// resolveAlias returns the node path of alias in /aliases.
+func resolveAlias(fdt *dt.FDT, alias string) (string, error) {
+	aliases, ok := fdt.RootNode.LookupChildByName("aliases")
+	if !ok {
+		return "", fmt.Errorf("alias %q: no /aliases node", alias)
+	}
+	prop, ok := aliases.LookProperty(alias)
+	if !ok {
+		return "", fmt.Errorf("alias %q not found", alias)
+	}
+	return prop.AsString()
+}
+
+// nodeByPath returns the node at the absolute path p and the
+// #address-cells of its parent.
+func nodeByPath(fdt *dt.FDT, p string) (*dt.Node, uint32, error) {
+	n := fdt.RootNode
+	addressCells := uint32(2)
+	for _, name := range strings.Split(strings.Trim(p, "/"), "/") {
+		if name == "" {
+			continue
+		}
+		addressCells = nodeAddressCells(n)
+		child, ok := n.LookupChildByName(name)
+		if !ok {
+			return nil, 0, fmt.Errorf("node %s not found", p)
+		}
+		n = child
+	}
+	return n, addressCells, nil
+}
+
+// nodeAddressCells returns the #address-cells of n, defaulting to 2.
+func nodeAddressCells(n *dt.Node) uint32 {
+	if prop, ok := n.LookProperty("#address-cells"); ok {
+		if cells, err := prop.AsU32(); err == nil {
+			return cells
+		}
+	}
+	return 2
+}
+
+// uartDriverFor returns the console driver for n's compatible strings.
+func uartDriverFor(n *dt.Node) (uartDriver, bool) {
+	prop, ok := n.LookProperty("compatible")
+	if !ok {
+		return uartDriver{}, false
+	}
+	for _, compatible := range bytes.Split(bytes.TrimSuffix(prop.Value, []byte{0}), []byte{0}) {
+		for _, drv := range uartDrivers {
+			if string(compatible) == drv.compatible {
+				return drv, true
+			}
+		}
+	}
+	return uartDriver{}, false
+}
+
+// regAddress returns the first address in n's reg property.
+func regAddress(n *dt.Node, addressCells uint32) (uint64, error) {
+	prop, ok := n.LookProperty("reg")
+	if !ok {
+		return 0, fmt.Errorf("no reg property")
+	}
+	if addressCells == 0 || addressCells > 2 || len(prop.Value) < int(addressCells)*4 {
+		return 0, fmt.Errorf("cannot decode reg with %d address cells", addressCells)
+	}
+
+	var addr uint64
+	for i := uint32(0); i < addressCells; i++ {
+		addr = addr<<32 | uint64(binary.BigEndian.Uint32(prop.Value[i*4:]))
+	}
+	return addr, nil
+}
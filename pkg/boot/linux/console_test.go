@@ -0,0 +1,152 @@
+// Copyright 2024 the u-root Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package linux
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/u-root/u-root/pkg/dt"
+)
+
+// consoleFDT returns a device tree with a PL011 and a 16550 UART.
+func consoleFDT(stdoutPath string) *dt.FDT {
+	chosen := dt.NewNode("chosen")
+	if stdoutPath != "" {
+		chosen = dt.NewNode("chosen", dt.WithProperty(dt.PropertyString("stdout-path", stdoutPath)))
+	}
+	return &dt.FDT{
+		RootNode: dt.NewNode("/", dt.WithChildren(
+			chosen,
+			dt.NewNode("aliases", dt.WithProperty(
+				dt.PropertyString("serial0", "/pl011@9000000"),
+				dt.PropertyString("serial1", "/soc/serial@10000000"),
+			)),
+			dt.NewNode("test memory", dt.WithProperty(
+				dt.PropertyString("device_type", "memory"),
+				dt.PropertyRegion("reg", 0x100000, 0xf00000),
+			)),
+			dt.NewNode("pl011@9000000", dt.WithProperty(
+				dt.Property{Name: "compatible", Value: []byte("arm,pl011\x00arm,primecell\x00")},
+				dt.PropertyRegion("reg", 0x9000000, 0x1000),
+			)),
+			dt.NewNode("soc", dt.WithProperty(
+				dt.Property{Name: "#address-cells", Value: []byte{0, 0, 0, 1}},
+				dt.Property{Name: "#size-cells", Value: []byte{0, 0, 0, 1}},
+			), dt.WithChildren(
+				dt.NewNode("serial@10000000", dt.WithProperty(
+					dt.PropertyString("compatible", "ns16550a"),
+					dt.Property{Name: "reg", Value: []byte{0x10, 0, 0, 0, 0, 0, 0x1, 0}},
+				)),
+			)),
+		)),
+	}
+}
+
+func TestAppendConsole(t *testing.T) {
+	for _, tt := range []struct {
+		name       string
+		stdoutPath string
+		// unterminated stores stdout-path without its NUL terminator.
+		unterminated bool
+		cmdline      string
+		want         string
+		wantErr      bool
+	}{
+		{
+			name:    "no-stdout-path",
+			cmdline: "quiet",
+			want:    "quiet",
+		},
+		{
+			name:       "alias-with-options",
+			stdoutPath: "serial0:115200n8",
+			cmdline:    "quiet",
+			want:       "quiet console=ttyAMA0,115200n8 earlycon=pl011,0x9000000",
+		},
+		{
+			name:       "alias-one-address-cell",
+			stdoutPath: "serial1",
+			want:       "console=ttyS1 earlycon=uart8250,mmio,0x10000000",
+		},
+		{
+			name:       "path",
+			stdoutPath: "/pl011@9000000:115200n8",
+			want:       "earlycon=pl011,0x9000000",
+		},
+		{
+			name:       "console-already-set",
+			stdoutPath: "serial0:115200n8",
+			cmdline:    "console=ttyS0",
+			want:       "console=ttyS0",
+		},
+		{
+			name:       "console-in-quoted-value",
+			stdoutPath: "serial0:115200n8",
+			cmdline:    `dyndbg="console=none" -- console=init`,
+			want:       `dyndbg="console=none" console=ttyAMA0,115200n8 earlycon=pl011,0x9000000 -- console=init`,
+		},
+		{
+			name:       "unknown-alias",
+			stdoutPath: "serial7",
+			cmdline:    "quiet",
+			want:       "quiet",
+		},
+		{
+			name:       "unknown-node",
+			stdoutPath: "/serial@20000000",
+			cmdline:    "quiet",
+			want:       "quiet",
+		},
+		{
+			name:       "unknown-uart",
+			stdoutPath: "/soc",
+			cmdline:    "quiet",
+			want:       "quiet",
+		},
+		{
+			name:         "malformed-stdout-path",
+			stdoutPath:   "serial0",
+			unterminated: true,
+			wantErr:      true,
+		},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			fdt := consoleFDT(tt.stdoutPath)
+			if tt.unterminated {
+				chosen, _ := fdt.NodeByName("chosen")
+				chosen.UpdateProperty("stdout-path", []byte(tt.stdoutPath))
+			}
+			got, err := appendConsole(tt.cmdline, fdt)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("appendConsole(%q) = %v, want error %v", tt.cmdline, err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("appendConsole(%q) = %q, want %q", tt.cmdline, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestKexecLoadImageAutoConsole(t *testing.T) {
+	setImageLoaderArch(t, "arm64")
+	Debug = t.Logf
+
+	got, err := kexecLoadImage(openFile(t, "../image/testdata/Image"), nil, "quiet", KexecOptions{
+		DTBBytes:    fdtBytes(t, consoleFDT("serial0:115200n8")),
+		AutoConsole: true,
+	})
+	if err != nil {
+		t.Fatalf("kexecLoad Arm Image = %v, want nil", err)
+	}
+
+	want := []byte("quiet console=ttyAMA0,115200n8 earlycon=pl011,0x9000000\x00")
+	for _, s := range got.segments {
+		if bytes.Contains(s.Buf, want) {
+			return
+		}
+	}
+	t.Errorf("kexecLoad Arm Image: no segment contains bootargs %q", want)
+}
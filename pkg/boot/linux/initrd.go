@@ -0,0 +1,232 @@
+// Copyright 2024 the u-root Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package linux
+
+import (
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/klauspost/compress/zstd"
+	"github.com/u-root/u-root/pkg/align"
+	"github.com/ulikunitz/xz"
+)
+
+// InitrdSource is one archive of an initrd made of several concatenated
+// archives.
+type InitrdSource struct {
+	// Order determines the position of the archive in the initrd.
+	// Archives with a lower order come first; archives with equal order
+	// keep their relative order.
+	//
+	// The kernel unpacks the archives in sequence and the last file of a
+	// given name wins, so e.g. a firmware or microcode archive should
+	// have a lower order than the rootfs archive.
+	Order int
+
+	// File is the cpio archive, possibly compressed.
+	File *os.File
+}
+
+// OrderedInitrds returns sources for the archives files, ordered as given.
+// E.g. for a dracut-style boot, pass the early microcode archive before the
+// main initramfs.
+func OrderedInitrds(files ...*os.File) []InitrdSource {
+	srcs := make([]InitrdSource, 0, len(files))
+	for i, f := range files {
+		srcs = append(srcs, InitrdSource{Order: i, File: f})
+	}
+	return srcs
+}
+
+// InitrdFormat is the compression of an initrd archive.
+type InitrdFormat int
+
+// Initrd formats.
+const (
+	// InitrdUncompressed is a plain cpio archive, or any other file not
+	// recognized as compressed.
+	InitrdUncompressed InitrdFormat = iota
+	InitrdGzip
+	InitrdXZ
+	InitrdZstd
+)
+
+// initrdMagics are the magic bytes starting compressed initrds.
+var initrdMagics = []struct {
+	format InitrdFormat
+	magic  []byte
+}{
+	{InitrdGzip, []byte{0x1f, 0x8b}},
+	{InitrdXZ, []byte{0xfd, '7', 'z', 'X', 'Z', 0x00}},
+	{InitrdZstd, []byte{0x28, 0xb5, 0x2f, 0xfd}},
+}
+
+func (f InitrdFormat) String() string {
+	switch f {
+	case InitrdUncompressed:
+		return "uncompressed"
+	case InitrdGzip:
+		return "gzip"
+	case InitrdXZ:
+		return "xz"
+	case InitrdZstd:
+		return "zstd"
+	}
+	return fmt.Sprintf("InitrdFormat(%d)", int(f))
+}
+
+// DetectInitrdFormat returns the compression of the initrd f by its magic
+// bytes. It reads them with ReadAt, so the offset of f is left alone.
+//
+// Only the first archive is looked at. The kernel also takes initrds made
+// of several archives compressed differently.
+func DetectInitrdFormat(f *os.File) (InitrdFormat, error) {
+	head := make([]byte, 6)
+	n, err := f.ReadAt(head, 0)
+	if err != nil && !errors.Is(err, io.EOF) {
+		return InitrdUncompressed, fmt.Errorf("reading initrd magic: %w", err)
+	}
+	for _, m := range initrdMagics {
+		if bytes.HasPrefix(head[:n], m.magic) {
+			return m.format, nil
+		}
+	}
+	return InitrdUncompressed, nil
+}
+
+var errInitrdCompression = errors.New("unsupported initrd compression")
+
+// decompressInitrd returns the decompressed contents of f, which is of the
+// given format.
+func decompressInitrd(f *os.File, format InitrdFormat) ([]byte, error) {
+	r := io.NewSectionReader(f, 0, math.MaxInt64)
+	switch format {
+	case InitrdGzip:
+		gr, err := gzip.NewReader(r)
+		if err != nil {
+			return nil, err
+		}
+		return io.ReadAll(gr)
+	case InitrdXZ:
+		xr, err := xz.NewReader(r)
+		if err != nil {
+			return nil, err
+		}
+		return io.ReadAll(xr)
+	case InitrdZstd:
+		zr, err := zstd.NewReader(r)
+		if err != nil {
+			return nil, err
+		}
+		defer zr.Close()
+		return io.ReadAll(zr)
+	}
+	return nil, fmt.Errorf("%w: %v", errInitrdCompression, format)
+}
+
+// getInitrdFile returns the contents of the initrd archive f, decompressed
+// if decompress is set.
+func getInitrdFile(f *os.File, decompress bool) ([]byte, func() error, error) {
+	if !decompress {
+		return getFile(f)
+	}
+	format, err := DetectInitrdFormat(f)
+	if err != nil {
+		return nil, nil, err
+	}
+	if format == InitrdUncompressed {
+		return getFile(f)
+	}
+	Debug("Decompressing %s initrd %s", format, f.Name())
+	buf, err := decompressInitrd(f, format)
+	if err != nil {
+		return nil, nil, fmt.Errorf("decompressing %s initrd %s: %w", format, f.Name(), err)
+	}
+	return buf, func() error { return nil }, nil
+}
+
+// initrdAlign is the alignment of each archive in a concatenated initrd.
+// The kernel's initramfs unpacker expects each archive to start on a 4-byte
+// boundary.
+const initrdAlign = 4
+
+// concatInitrds concatenates the given archives sorted by their order,
+// padding each to initrdAlign bytes. With decompress, compressed archives
+// are decompressed first.
+func concatInitrds(srcs []InitrdSource, decompress bool) ([]byte, error) {
+	sorted := make([]InitrdSource, len(srcs))
+	copy(sorted, srcs)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		return sorted[i].Order < sorted[j].Order
+	})
+
+	var initrd []byte
+	for i, src := range sorted {
+		// Pad the previous archive.
+		initrd = append(initrd, make([]byte, align.Up(uint(len(initrd)), initrdAlign)-uint(len(initrd)))...)
+
+		buf, cleanup, err := getInitrdFile(src.File, decompress)
+		if err != nil {
+			return nil, fmt.Errorf("failed to get initrd %d contents: %w", i, err)
+		}
+		initrd = append(initrd, buf...)
+		if err := cleanup(); err != nil {
+			return nil, err
+		}
+	}
+	return initrd, nil
+}
+
+// getInitrd returns the initrd built from ramfs and initrds. ramfs is treated
+// as an archive of order 0. It returns a nil buffer if there is no initrd.
+// With decompress, compressed archives are decompressed.
+func getInitrd(ramfs *os.File, initrds []InitrdSource, decompress bool) ([]byte, func() error, error) {
+	if len(initrds) == 0 {
+		if ramfs == nil {
+			return nil, func() error { return nil }, nil
+		}
+		return getInitrdFile(ramfs, decompress)
+	}
+
+	srcs := initrds
+	if ramfs != nil {
+		srcs = append([]InitrdSource{{File: ramfs}}, srcs...)
+	}
+	buf, err := concatInitrds(srcs, decompress)
+	if err != nil {
+		return nil, nil, err
+	}
+	return buf, func() error { return nil }, nil
+}
+
+// initrdArg handles initrd= arguments on the command line, which
+// bootloaders like GRUB use to name the initrd file.
+//
+// kexec cannot load an initrd by its path. The initrd must be passed as a
+// file instead, and the loader hands it to the kernel in the device tree or
+// the boot parameters. So if there is an initrd, the initrd= arguments are
+// stripped, because they do not describe the initrd the kernel gets.
+// Otherwise, they are left alone and a warning is logged.
+func initrdArg(cmdline string, haveInitrd bool) string {
+	c := ParseCmdline(cmdline)
+	initrdArgs := c.Delete("initrd")
+	if len(initrdArgs) == 0 {
+		return cmdline
+	}
+
+	if !haveInitrd {
+		Debug("Warning: kexec cannot load %s from the command line, pass the initrd file instead", strings.Join(initrdArgs, " "))
+		return cmdline
+	}
+	Debug("Removed %s from the command line, the initrd is passed to the kernel directly", strings.Join(initrdArgs, " "))
+	return c.String()
+}
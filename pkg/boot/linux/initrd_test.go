@@ -0,0 +1,197 @@
+// Copyright 2024 the u-root Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package linux
+
+import (
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/klauspost/compress/zstd"
+	"github.com/ulikunitz/xz"
+)
+
+func TestInitrdArg(t *testing.T) {
+	for _, tt := range []struct {
+		name        string
+		cmdline     string
+		haveInitrd  bool
+		want        string
+		wantWarning bool
+	}{
+		{
+			name:       "no-initrd-arg",
+			cmdline:    "console=ttyS0  root=/dev/sda1",
+			haveInitrd: true,
+			want:       "console=ttyS0  root=/dev/sda1",
+		},
+		{
+			name:       "strip",
+			cmdline:    "console=ttyS0 initrd=/boot/initrd.img root=/dev/sda1",
+			haveInitrd: true,
+			want:       "console=ttyS0 root=/dev/sda1",
+		},
+		{
+			name:       "strip-several",
+			cmdline:    "initrd=\\microcode.img initrd=\\initrd.img quiet",
+			haveInitrd: true,
+			want:       "quiet",
+		},
+		{
+			name:        "warn",
+			cmdline:     "console=ttyS0 initrd=/boot/initrd.img",
+			want:        "console=ttyS0 initrd=/boot/initrd.img",
+			wantWarning: true,
+		},
+		{
+			name:       "strip-keeps-quoted",
+			cmdline:    `opts="a  initrd=b" initrd=/boot/initrd.img quiet`,
+			haveInitrd: true,
+			want:       `opts="a  initrd=b" quiet`,
+		},
+		{
+			name:       "similar-arg",
+			cmdline:    "rd.initrd=1 noinitrd",
+			haveInitrd: true,
+			want:       "rd.initrd=1 noinitrd",
+		},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			var logs []string
+			Debug = func(format string, v ...interface{}) {
+				logs = append(logs, fmt.Sprintf(format, v...))
+			}
+			defer func() { Debug = func(string, ...interface{}) {} }()
+
+			if got := initrdArg(tt.cmdline, tt.haveInitrd); got != tt.want {
+				t.Errorf("initrdArg(%q, %v) = %q, want %q", tt.cmdline, tt.haveInitrd, got, tt.want)
+			}
+			var warned bool
+			for _, l := range logs {
+				if strings.HasPrefix(l, "Warning: kexec cannot load initrd=") {
+					warned = true
+				}
+			}
+			if warned != tt.wantWarning {
+				t.Errorf("initrdArg(%q, %v) warned = %v, want %v; logs: %q", tt.cmdline, tt.haveInitrd, warned, tt.wantWarning, logs)
+			}
+		})
+	}
+}
+
+func TestDetectInitrdFormat(t *testing.T) {
+	for _, tt := range []struct {
+		name string
+		head string
+		want InitrdFormat
+	}{
+		{name: "empty", head: "", want: InitrdUncompressed},
+		{name: "cpio", head: "070701", want: InitrdUncompressed},
+		{name: "gzip", head: "\x1f\x8b\x08\x00", want: InitrdGzip},
+		{name: "xz", head: "\xfd7zXZ\x00\x00\x04", want: InitrdXZ},
+		{name: "xz-short", head: "\xfd7z", want: InitrdUncompressed},
+		{name: "zstd", head: "\x28\xb5\x2f\xfd\x04", want: InitrdZstd},
+		{name: "gzip-only", head: "\x1f", want: InitrdUncompressed},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			f := createFile(t, []byte(tt.head))
+			got, err := DetectInitrdFormat(f)
+			if err != nil {
+				t.Fatalf("DetectInitrdFormat() = %v, want nil", err)
+			}
+			if got != tt.want {
+				t.Errorf("DetectInitrdFormat() = %v, want %v", got, tt.want)
+			}
+			if off, _ := f.Seek(0, io.SeekCurrent); off != 0 {
+				t.Errorf("offset after DetectInitrdFormat = %d, want 0", off)
+			}
+		})
+	}
+}
+
+func TestGetInitrdDecompress(t *testing.T) {
+	cpio := []byte("070701 not really a cpio archive")
+	compress := func(t *testing.T, newWriter func(io.Writer) (io.WriteCloser, error)) []byte {
+		t.Helper()
+		var b bytes.Buffer
+		w, err := newWriter(&b)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := w.Write(cpio); err != nil {
+			t.Fatal(err)
+		}
+		if err := w.Close(); err != nil {
+			t.Fatal(err)
+		}
+		return b.Bytes()
+	}
+
+	for _, tt := range []struct {
+		name      string
+		newWriter func(io.Writer) (io.WriteCloser, error)
+	}{
+		{
+			name: "gzip",
+			newWriter: func(w io.Writer) (io.WriteCloser, error) {
+				return gzip.NewWriter(w), nil
+			},
+		},
+		{
+			name: "xz",
+			newWriter: func(w io.Writer) (io.WriteCloser, error) {
+				return xz.NewWriter(w)
+			},
+		},
+		{
+			name: "zstd",
+			newWriter: func(w io.Writer) (io.WriteCloser, error) {
+				return zstd.NewWriter(w)
+			},
+		},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			compressed := compress(t, tt.newWriter)
+
+			got, cleanup, err := getInitrd(createFile(t, compressed), nil, false)
+			if err != nil {
+				t.Fatalf("getInitrd(decompress=false) = %v, want nil", err)
+			}
+			defer cleanup()
+			if !bytes.Equal(got, compressed) {
+				t.Errorf("getInitrd(decompress=false) changed the initrd")
+			}
+
+			got, cleanup, err = getInitrd(createFile(t, compressed), nil, true)
+			if err != nil {
+				t.Fatalf("getInitrd(decompress=true) = %v, want nil", err)
+			}
+			defer cleanup()
+			if !bytes.Equal(got, cpio) {
+				t.Errorf("getInitrd(decompress=true) = %q, want %q", got, cpio)
+			}
+
+			srcs := []InitrdSource{{File: createFile(t, compressed)}, {File: createFile(t, cpio), Order: 1}}
+			got, cleanup, err = getInitrd(nil, srcs, true)
+			if err != nil {
+				t.Fatalf("getInitrd(initrds, decompress=true) = %v, want nil", err)
+			}
+			defer cleanup()
+			if !bytes.HasPrefix(got, cpio) || !bytes.Contains(got[len(cpio):], cpio) {
+				t.Errorf("getInitrd(initrds, decompress=true) = %q, want both archives decompressed", got)
+			}
+		})
+	}
+}
+
+func TestGetInitrdDecompressCorrupt(t *testing.T) {
+	f := createFile(t, []byte("\x1f\x8b\x08\x00 truncated"))
+	if _, _, err := getInitrd(f, nil, true); err == nil || !strings.Contains(err.Error(), "gzip") {
+		t.Errorf("getInitrd(corrupt gzip) = %v, want decompression error", err)
+	}
+}
@@ -5,15 +5,284 @@
 package linux
 
 import (
+	"errors"
 	"fmt"
 	"io"
 	"os"
+	"strings"
 	"syscall"
 
+	"github.com/u-root/u-root/pkg/boot/kexec"
 	"github.com/u-root/uio/uio"
 	"golang.org/x/sys/unix"
 )
 
+// KexecOptions abstract a collection of options to be passed in KexecLoad.
+//
+// Arch agnostic. Each arch knows to just look for options they care about.
+type KexecOptions struct {
+	// DTB is used as the device tree blob, if specified.
+	DTB io.ReaderAt
+
+	// DTBBytes is used as the device tree blob, if specified. It is
+	// meant for callers that already hold the DTB in memory, e.g. after
+	// fetching it over the network. Only one of DTB and DTBBytes may be
+	// set.
+	DTBBytes []byte
+
+	// DTBOverlays are device tree overlays, compiled with dtc -@, that
+	// are applied in order to the device tree before it is passed to an
+	// arm64 or riscv64 kernel. Loading fails if an overlay does not
+	// apply, e.g. if its target is not in the device tree. See
+	// dt.FDT.ApplyOverlay.
+	DTBOverlays []io.ReaderAt
+
+	// FDTExtraSpace is the number of bytes of free space appended to the
+	// device tree passed to an arm64 or riscv64 kernel, for properties the
+	// kernel adds in place at boot. The FDT header's totalsize includes
+	// it.
+	FDTExtraSpace uint
+
+	// ReservedRanges are additional pieces of physical memory that are
+	// not used for kexec segment allocation. They are not transmitted to
+	// the next kernel to be considered reserved.
+	ReservedRanges kexec.Ranges
+
+	// NoTrampoline omits the trampoline segment and uses the kernel's
+	// physical entry as the kexec entry point. The riscv64 kexec core
+	// finds the DTB among the segments and passes it in a1 itself.
+	//
+	// Only supported for riscv64 Images. On arm64, kexec_load leaves x0
+	// zero, so the kernel would start without a DTB.
+	NoTrampoline bool
+
+	// Initrds are archives concatenated into the initrd, sorted by their
+	// Order. If a ramfs file is also given, it is treated as an archive
+	// of order 0.
+	Initrds []InitrdSource
+
+	// AutoConsole appends console= and earlycon= arguments for the UART
+	// that the device tree's /chosen/stdout-path names, unless the
+	// command line already selects a console. If no usable UART is
+	// found, the command line is left as is.
+	AutoConsole bool
+
+	// MinFDTCompatVersion, if non-zero, is the device tree version the
+	// target kernel parses. A DTB whose last compatible version is newer
+	// may contain structures the kernel ignores; this is only logged.
+	MinFDTCompatVersion uint32
+
+	// MaxTotalBytes, if non-zero, caps the physical memory taken by all
+	// segments of the loaded image. Loading fails if the cap is exceeded.
+	MaxTotalBytes uint64
+
+	// MeasureDTB records the SHA-256 of the device tree handed to the
+	// kernel in the /chosen/dtb-hash property. The hash covers the
+	// flattened tree without the dtb-hash property itself, so a verifier
+	// has to remove it and flatten the tree again before hashing.
+	MeasureDTB bool
+
+	// ACPIRSDP, if non-zero, is the physical address of the ACPI RSDP
+	// that is passed to an x86 kernel in boot_params.acpi_rsdp_addr.
+	// Kernels booted by kexec on UEFI systems may not find the ACPI
+	// tables otherwise. Requires boot protocol 2.14.
+	ACPIRSDP uintptr
+
+	// KernelHeadroom is the number of bytes after the kernel's memory
+	// footprint that are kept free of the initrd and the other segments,
+	// for kernels whose decompressor needs room beyond their declared
+	// size. If zero, x86 bzImages get DefaultKernelHeadroom and arm64
+	// and riscv64 Images none, as their image_size covers all the memory
+	// they need.
+	KernelHeadroom uint64
+
+	// UEFI, if set, is written to the device tree's /chosen node, so that
+	// an arm64 or riscv64 kernel can use the UEFI runtime services. Without it, the
+	// properties of the device tree are kept.
+	UEFI *UEFIInfo
+
+	// VerifySignature, if set, is called with the kernel before any
+	// segments are built, e.g. to check a detached signature against a
+	// keyring, as kexec_file_load would. The kernel is the arm64 or
+	// riscv64 Image after unwrapping a PE executable, or the decompressed
+	// kernel of an x86 bzImage. An error aborts the load and is returned.
+	VerifySignature func(kernel []byte) error
+
+	// RandSource, if set, replaces crypto/rand.Reader as the source of
+	// the kaslr-seed and rng-seed passed to an arm64 or riscv64 kernel.
+	// Seeds are only passed if the device tree of the running kernel has
+	// a kaslr-seed, i.e. if firmware supports KASLR.
+	RandSource io.Reader
+
+	// FileLoad makes KexecLoad try kexec_file_load first. The running
+	// kernel then reads the kernel and initrd files itself and, with
+	// lockdown or secure boot, verifies the kernel's signature. If
+	// kexec_file_load is not available (ENOSYS) or not permitted
+	// (EPERM), the kernel is loaded with kexec_load as without FileLoad.
+	//
+	// kexec_file_load takes one initrd file and no device tree, so it
+	// is not tried if Initrds is set. The DTB and the other options that
+	// shape the segments cannot be honoured and are rejected with
+	// FileLoad. VerifySignature is called with the kernel file as is.
+	FileLoad bool
+
+	// DecompressInitrd decompresses gzip, xz and zstd compressed initrd
+	// archives before loading them, for kernels built without the
+	// decompressor. See DetectInitrdFormat.
+	DecompressInitrd bool
+
+	// Fit is how segments are placed in RAM. BestFit keeps large free
+	// ranges for later segments like the initrd. The default is
+	// kexec.FirstFit.
+	Fit kexec.FitStrategy
+}
+
+// LoadMechanism is the system call that loaded a kernel.
+type LoadMechanism int
+
+// Load mechanisms.
+const (
+	// MechanismKexecLoad is kexec_load, with the segments built by this
+	// package.
+	MechanismKexecLoad LoadMechanism = iota
+	// MechanismKexecFileLoad is kexec_file_load, with the segments built
+	// by the running kernel.
+	MechanismKexecFileLoad
+)
+
+func (m LoadMechanism) String() string {
+	switch m {
+	case MechanismKexecLoad:
+		return "kexec_load"
+	case MechanismKexecFileLoad:
+		return "kexec_file_load"
+	}
+	return fmt.Sprintf("LoadMechanism(%d)", int(m))
+}
+
+// kexecFileLoad is kexec.FileLoad. Tests replace it.
+var kexecFileLoad = kexec.FileLoad
+
+// KexecLoad loads kernel as the to-be-kexeced kernel with the given ramfs
+// and cmdline. See KexecLoadMechanism.
+func KexecLoad(kernel, ramfs *os.File, cmdline string, opts KexecOptions) error {
+	_, err := KexecLoadMechanism(kernel, ramfs, cmdline, opts)
+	return err
+}
+
+// errFileLoadOption is returned if KexecOptions.FileLoad is set together
+// with options kexec_file_load cannot honour.
+var errFileLoadOption = errors.New("option not supported with kexec_file_load")
+
+// fileLoadOptions returns the names of the options set in opts that
+// kexec_file_load would ignore.
+func fileLoadOptions(opts KexecOptions) []string {
+	var names []string
+	for _, o := range []struct {
+		name string
+		set  bool
+	}{
+		{"DTB", opts.DTB != nil},
+		{"DTBBytes", opts.DTBBytes != nil},
+		{"DTBOverlays", len(opts.DTBOverlays) > 0},
+		{"FDTExtraSpace", opts.FDTExtraSpace != 0},
+		{"ReservedRanges", len(opts.ReservedRanges) > 0},
+		{"NoTrampoline", opts.NoTrampoline},
+		{"AutoConsole", opts.AutoConsole},
+		{"MinFDTCompatVersion", opts.MinFDTCompatVersion != 0},
+		{"MaxTotalBytes", opts.MaxTotalBytes != 0},
+		{"MeasureDTB", opts.MeasureDTB},
+		{"ACPIRSDP", opts.ACPIRSDP != 0},
+		{"KernelHeadroom", opts.KernelHeadroom != 0},
+		{"UEFI", opts.UEFI != nil},
+		{"RandSource", opts.RandSource != nil},
+		{"DecompressInitrd", opts.DecompressInitrd},
+		{"Fit", opts.Fit != kexec.FirstFit},
+	} {
+		if o.set {
+			names = append(names, o.name)
+		}
+	}
+	return names
+}
+
+// KexecLoadMechanism is KexecLoad, and also returns the system call that
+// loaded the kernel, or failed to. Unless opts.FileLoad is set, that is
+// always kexec_load.
+func KexecLoadMechanism(kernel, ramfs *os.File, cmdline string, opts KexecOptions) (LoadMechanism, error) {
+	if opts.FileLoad && len(opts.Initrds) == 0 {
+		if names := fileLoadOptions(opts); len(names) > 0 {
+			return MechanismKexecFileLoad, fmt.Errorf("%w: %s", errFileLoadOption, strings.Join(names, ", "))
+		}
+		if opts.VerifySignature != nil {
+			kernelBuf, cleanup, err := getFile(kernel)
+			if err != nil {
+				return MechanismKexecFileLoad, fmt.Errorf("failed to get kernel contents: %w", err)
+			}
+			err = verifyKernel(kernelBuf, opts)
+			cleanup()
+			if err != nil {
+				return MechanismKexecFileLoad, err
+			}
+		}
+
+		err := kexecFileLoad(kernel, ramfs, cmdline)
+		if err == nil {
+			Debug("Loaded with %s", MechanismKexecFileLoad)
+			return MechanismKexecFileLoad, nil
+		}
+		if !errors.Is(err, unix.ENOSYS) && !errors.Is(err, unix.EPERM) {
+			return MechanismKexecFileLoad, err
+		}
+		Debug("%s failed, falling back to %s: %v", MechanismKexecFileLoad, MechanismKexecLoad, err)
+	}
+	return MechanismKexecLoad, kexecLoad(kernel, ramfs, cmdline, opts)
+}
+
+// DefaultKernelHeadroom is the headroom kept free after a self-decompressing
+// kernel if KexecOptions.KernelHeadroom is zero.
+const DefaultKernelHeadroom = 1 << 20
+
+// reserveKernelHeadroom marks kernel, the memory the kernel occupies, and the
+// headroom after it as reserved, so that no segments added afterwards are
+// placed there. defaultHeadroom applies if opts.KernelHeadroom is zero. It
+// returns the reserved range.
+func reserveKernelHeadroom(kmem *kexec.Memory, kernel kexec.Range, opts KexecOptions, defaultHeadroom uint64) kexec.Range {
+	headroom := opts.KernelHeadroom
+	if headroom == 0 {
+		headroom = defaultHeadroom
+	}
+	r := kexec.Range{Start: kernel.Start, Size: kernel.Size + uint(headroom)}
+	kmem.Phys.Insert(kexec.TypedRange{Range: r, Type: kexec.RangeReserved})
+	return r
+}
+
+// verifyKernel calls opts.VerifySignature, if set, on kernel.
+func verifyKernel(kernel []byte, opts KexecOptions) error {
+	if opts.VerifySignature == nil {
+		return nil
+	}
+	if err := opts.VerifySignature(kernel); err != nil {
+		return fmt.Errorf("verifying kernel signature: %w", err)
+	}
+	return nil
+}
+
+// ErrImageTooLarge is returned if the loaded image exceeds
+// KexecOptions.MaxTotalBytes.
+var ErrImageTooLarge = errors.New("image exceeds the configured size cap")
+
+// checkTotalSize verifies that segs fit in opts.MaxTotalBytes.
+func checkTotalSize(segs kexec.Segments, opts KexecOptions) error {
+	if opts.MaxTotalBytes == 0 {
+		return nil
+	}
+	if total := uint64(segs.TotalSize()); total > opts.MaxTotalBytes {
+		return fmt.Errorf("%w: total %#x bytes, cap %#x bytes", ErrImageTooLarge, total, opts.MaxTotalBytes)
+	}
+	return nil
+}
+
 func mmap(f *os.File) ([]byte, func() error, error) {
 	s, err := f.Stat()
 	if err != nil {
@@ -6,19 +6,14 @@ package linux
 
 import (
 	"fmt"
-	"io"
 	"os"
 
 	"github.com/u-root/u-root/pkg/boot/kexec"
 )
 
-// KexecLoad loads arm64 Image, with the given ramfs and kernel cmdline.
-//
-// reservedRanges are additional pieces of physical memory that are not used
-// for kexec segment allocation. They are not transmitted to the next kernel to
-// be considered reserved.
-func KexecLoad(kernel, ramfs *os.File, cmdline string, dtb io.ReaderAt, reservedRanges kexec.Ranges) error {
-	img, err := kexecLoadImage(kernel, ramfs, cmdline, dtb, reservedRanges)
+// kexecLoad loads arm64 Image, with the given ramfs and kernel cmdline.
+func kexecLoad(kernel, ramfs *os.File, cmdline string, opts KexecOptions) error {
+	img, err := kexecLoadImage(kernel, ramfs, cmdline, opts)
 	if err != nil {
 		return err
 	}
@@ -0,0 +1,287 @@
+// Copyright 2024 the u-root Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package linux
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/u-root/u-root/pkg/boot/bzimage"
+	"github.com/u-root/u-root/pkg/boot/kexec"
+	"github.com/u-root/u-root/pkg/boot/purgatory"
+	"github.com/u-root/u-root/pkg/dt"
+)
+
+// bootParamsPath holds the boot_params of the running x86 kernel. Tests
+// replace it.
+var bootParamsPath = "/sys/kernel/boot_params/data"
+
+var (
+	errBzImageProtocol      = errors.New("bzImage boot protocol has no preferred address and init size, need 2.10")
+	errBadKernelAlignment   = errors.New("bzImage kernel_alignment is not a power of 2")
+	errKernelNotRelocatable = errors.New("kernel is not relocatable and its preferred address is not in RAM")
+	errACPIRSDPProtocol     = errors.New("bzImage boot protocol does not support acpi_rsdp_addr, need 2.14")
+)
+
+// bzImageLoadAddr returns the physical address to load the kernel described
+// by the bzImage header h at, given the available RAM.
+//
+// The kernel needs init_size bytes of RAM from where it is loaded. Its
+// preferred address is used if that much RAM is free there. Otherwise, a
+// relocatable kernel is placed at the first address aligned to
+// kernel_alignment that has enough free RAM; the x86 kernel adjusts to the
+// physical address it runs at by itself, as long as it is aligned.
+//
+// Requires boot protocol 2.10 or later, which added pref_address and
+// init_size.
+func bzImageLoadAddr(h *bzimage.LinuxHeader, ram kexec.Ranges) (uintptr, error) {
+	if h.Protocolversion < 0x020a {
+		return 0, fmt.Errorf("%w: got %#x", errBzImageProtocol, h.Protocolversion)
+	}
+	size := uint(h.InitSize)
+	if size == 0 {
+		return 0, fmt.Errorf("bzImage init_size is zero")
+	}
+
+	preferred := kexec.Range{Start: uintptr(h.PrefAddress), Size: size}
+	for _, r := range ram {
+		if r.IsSupersetOf(preferred) {
+			return preferred.Start, nil
+		}
+	}
+
+	if h.RelocatableKernel == 0 {
+		return 0, fmt.Errorf("%w: %s", errKernelNotRelocatable, preferred)
+	}
+	kernelAlign := uint(h.Kernelalignment)
+	if kernelAlign == 0 || kernelAlign&(kernelAlign-1) != 0 {
+		return 0, fmt.Errorf("%w: %#x", errBadKernelAlignment, kernelAlign)
+	}
+
+	r, err := ram.FindSpace(size, kexec.WithMinimumAddr(kexec.M1), kexec.WithStartAlignment(kernelAlign))
+	if err != nil {
+		return 0, fmt.Errorf("no %#x bytes aligned to %#x for the kernel: %w", size, kernelAlign, err)
+	}
+	return r.Start, nil
+}
+
+// bzImageFootprint returns the memory the kernel of the bzImage described by
+// h occupies once its ELF segments segs are loaded: the segments and, with
+// boot protocol 2.10 or later, the init_size bytes from loadAddr that the
+// decompressor and early boot code use.
+func bzImageFootprint(h *bzimage.LinuxHeader, segs kexec.Segments, loadAddr uintptr) kexec.Range {
+	var start, end uintptr
+	for i, s := range segs {
+		if i == 0 || s.Phys.Start < start {
+			start = s.Phys.Start
+		}
+		end = max(end, s.Phys.End())
+	}
+	if h.Protocolversion >= 0x020a && h.InitSize != 0 {
+		if len(segs) == 0 || loadAddr < start {
+			start = loadAddr
+		}
+		end = max(end, loadAddr+uintptr(h.InitSize))
+	}
+	return kexec.Range{Start: start, Size: uint(end - start)}
+}
+
+// setACPIRSDP writes the ACPI RSDP address from opts into the boot_params lp
+// of the kernel described by the bzImage header h.
+func setACPIRSDP(lp *bzimage.LinuxParams, h *bzimage.LinuxHeader, opts KexecOptions) error {
+	if opts.ACPIRSDP == 0 {
+		return nil
+	}
+	if h.Protocolversion < 0x020e {
+		return fmt.Errorf("%w: have %#x", errACPIRSDPProtocol, h.Protocolversion)
+	}
+	lp.AcpiRsdpAddr = uint64(opts.ACPIRSDP)
+	Debug("Passing ACPI RSDP at %#x", opts.ACPIRSDP)
+	return nil
+}
+
+// bzImageLoader loads x86 bzImages.
+type bzImageLoader struct{}
+
+func init() {
+	RegisterImageLoader(bzImageLoader{})
+}
+
+// Probe implements ImageLoader.Probe.
+func (bzImageLoader) Probe(kernel io.ReaderAt) bool {
+	if imageLoaderArch != "amd64" {
+		return false
+	}
+	var magic [4]uint8
+	if _, err := kernel.ReadAt(magic[:], 0x202); err != nil {
+		return false
+	}
+	return magic == bzimage.HeaderMagic
+}
+
+// Load implements ImageLoader.Load. The boot_params of the running kernel
+// are passed on with the initrd and command line updated; fdt is unused.
+func (bzImageLoader) Load(mm kexec.MemoryMap, kernelBuf, ramfsBuf []byte, _ *dt.FDT, cmdline string, opts KexecOptions) (kexec.Segments, uintptr, error) {
+	bzimage.Debug = Debug
+
+	// bzimage is the deserialized bzImage from the kernel
+	// io.ReaderAt.
+	var bzimg bzimage.BzImage
+	// kmem is a struct holding kexec segments.
+	//
+	// It has routines to work with physical memory
+	// ranges.
+	var kmem *kexec.Memory
+	// TODO(10000TB): construct default params in go.
+	//
+	// boot_params directory is x86 specific. So for now, following code only
+	// works on x86.
+	// https://www.kernel.org/doc/Documentation/ABI/testing/sysfs-kernel-boot_params
+	bp, err := os.ReadFile(bootParamsPath)
+	if err != nil {
+		return nil, 0, fmt.Errorf("reading boot_param data: %w", err)
+	}
+	var lp = &bzimage.LinuxParams{}
+	if err := lp.UnmarshalBinary(bp); err != nil {
+		return nil, 0, fmt.Errorf("unmarshaling header: %w", err)
+	}
+
+	if err := bzimg.UnmarshalBinary(kernelBuf); err != nil {
+		return nil, 0, fmt.Errorf("parsing bzImage Linux kernel: %w", err)
+	}
+
+	if len(bzimg.KernelCode) < 1024 {
+		return nil, 0, fmt.Errorf("kernel code size smaller than 1024 bytes: %d", len(bzimg.KernelCode))
+	}
+
+	// KernelCode is the decompressed kernel.
+	logKernelVersion(bzimg.KernelCode)
+	if err := verifyKernel(bzimg.KernelCode, opts); err != nil {
+		return nil, 0, err
+	}
+
+	kelf, err := bzimg.ELF()
+	if err != nil {
+		return nil, 0, fmt.Errorf("getting ELF from bzImage: %w", err)
+	}
+	kernelEntry := uintptr(kelf.Entry)
+	Debug("kernelEntry: %v", kernelEntry)
+
+	kmem = &kexec.Memory{
+		Phys: mm,
+		Fit:  opts.Fit,
+	}
+
+	var relocatableKernel bool
+	if bzimg.Header.Protocolversion < 0x0205 {
+		return nil, 0, fmt.Errorf("bzImage boot protocol earlier thatn 2.05 is not supported currently: %v", bzimg.Header.Protocolversion)
+	}
+	relocatableKernel = bzimg.Header.RelocatableKernel != 0
+	// Only protected mode is currently supported.
+	// In protected mode, kernel need be relocatable, or it will need to fall
+	// to real mode executing.
+	if !relocatableKernel {
+		return nil, 0, errors.New("non-relocateable Kernels are not supported")
+	}
+
+	// Kernels with boot protocol 2.10+ tell us how much room they need
+	// and how they may be aligned, so they can be moved away from their
+	// preferred address if that is not in RAM.
+	var kernelOffset int64
+	var loadAddr uintptr
+	if bzimg.Header.Protocolversion >= 0x020a {
+		loadAddr, err = bzImageLoadAddr(&bzimg.Header, kmem.Phys.Usable())
+		if err != nil {
+			return nil, 0, fmt.Errorf("placing kernel: %w", err)
+		}
+		kernelOffset = int64(loadAddr) - int64(bzimg.Header.PrefAddress)
+		Debug("Loading kernel at %#x (offset %#x from preferred address)", loadAddr, kernelOffset)
+	}
+	if _, err := kmem.LoadElfSegmentsOffset(bytes.NewReader(bzimg.KernelCode), kernelOffset); err != nil {
+		return nil, 0, fmt.Errorf("loading kernel ELF segments: %w", err)
+	}
+	kernelEntry = uintptr(int64(kelf.Entry) + kernelOffset)
+	reserved := reserveKernelHeadroom(kmem, bzImageFootprint(&bzimg.Header, kmem.Segments, loadAddr), opts, DefaultKernelHeadroom)
+	Debug("Reserved %s for the kernel", reserved)
+
+	if ramfsBuf != nil {
+		ramfsRange, err := kmem.AddKexecSegment(ramfsBuf)
+		if err != nil {
+			return nil, 0, fmt.Errorf("add initramfs segment: %w", err)
+		}
+		Debug("Added %d byte initramfs at %s", len(ramfsBuf), ramfsRange)
+		lp.Initrdstart = uint32(ramfsRange.Start)
+		lp.Initrdsize = uint32(ramfsRange.Size)
+	}
+
+	if err := setACPIRSDP(lp, &bzimg.Header, opts); err != nil {
+		return nil, 0, err
+	}
+
+	Debug("Kernel cmdline to append: %s", cmdline)
+	if len(cmdline) > 0 {
+		var cmdlineRange kexec.Range
+		Debug("Add cmdline: %s", cmdline)
+
+		// Cmdline must be null-terminated.
+		cmdlineBytes := []byte(cmdline + "\x00")
+		if cmdlineRange, err = kmem.AddKexecSegment(cmdlineBytes); err != nil {
+			return nil, 0, fmt.Errorf("add cmdline segment: %v", err)
+		}
+		Debug("Added %d byte of cmdline at %s", len(cmdlineBytes), cmdlineRange)
+		lp.CLPtr = uint32(cmdlineRange.Start)      // 2.02+
+		lp.CmdLineSize = uint32(cmdlineRange.Size) // 2.06+
+	}
+
+	// The kernel is a bzImage kernel if the protocol >= 2.00 and the 0x01
+	// bit (LOAD_HIGH) in the loadflags field is set.
+	// TODO(10000TB): check on loadflags.
+	linuxParam, err := lp.MarshalBinary()
+	if err != nil {
+		return nil, 0, fmt.Errorf("re-marshaling header: %w", err)
+	}
+
+	setupRange, err := kmem.AddPhysSegment(
+		linuxParam,
+		// We use Linux's 32bit/64bit entry point, so we can place the
+		// setup range anywhere in the low 4G.
+		kexec.RangeFromInterval(
+			uintptr(4096),
+			uintptr(1<<32-1),
+		),
+		// TODO(10000TB): evaluate if we need to provide  option to
+		// reserve from end.
+		//
+		// Our go code defaults to pick up a mem block of requested
+		// size from beginning, e.g.
+		//
+		//   [Range.Start, Range.Start+memsz)
+		//
+		// Kexec userspace use the range from end, e.g.
+		//
+		//   [Range.end-memsz+1, Range.end)
+		//
+	)
+	if err != nil {
+		return nil, 0, fmt.Errorf("add real mode data and cmdline: %v", err)
+	}
+
+	Debug("Loaded real mode data and cmdline at: %v", setupRange)
+
+	// Verify purgatory loads higher than the parameters.
+	// TODO(10000TB): if rel_addr < setupRange.Start then return error.
+
+	// Load purgatory.
+	purgatoryEntry, err := purgatory.Load(kmem, kernelEntry, setupRange.Start)
+	if err != nil {
+		return nil, 0, err
+	}
+	Debug("purgatory entry: %v", purgatoryEntry)
+
+	return kmem.Segments, purgatoryEntry, nil
+}
@@ -0,0 +1,253 @@
+// Copyright 2024 the u-root Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package linux
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/u-root/u-root/pkg/boot/bzimage"
+	"github.com/u-root/u-root/pkg/boot/kexec"
+)
+
+func readBzImageHeader(t *testing.T, path string) *bzimage.LinuxHeader {
+	t.Helper()
+	var h bzimage.LinuxHeader
+	if err := h.UnmarshalBinary(readFile(t, path)); err != nil {
+		t.Fatal(err)
+	}
+	return &h
+}
+
+func TestBzImageLoadAddr(t *testing.T) {
+	// Relocatable, kernel_alignment 0x200000, pref_address 0x1000000,
+	// init_size 0x1265000.
+	relocatable := readBzImageHeader(t, "../bzimage/testdata/bzImage-linux5.10-x86_64-gzip")
+	// Not relocatable, pref_address 0x1000000, init_size 0x6e0000.
+	fixed := readBzImageHeader(t, "../bzimage/testdata/bzImage")
+
+	badAlign := *relocatable
+	badAlign.Kernelalignment = 0x300000
+
+	oldProtocol := *relocatable
+	oldProtocol.Protocolversion = 0x0209
+
+	for _, tt := range []struct {
+		name    string
+		h       *bzimage.LinuxHeader
+		ram     kexec.Ranges
+		want    uintptr
+		wantErr error
+	}{
+		{
+			name: "preferred address",
+			h:    relocatable,
+			ram:  kexec.Ranges{kexec.RangeFromInterval(0x100000, 0x80000000)},
+			want: 0x1000000,
+		},
+		{
+			name: "relocated to alignment",
+			h:    relocatable,
+			ram:  kexec.Ranges{kexec.RangeFromInterval(0x4010000, 0x80000000)},
+			want: 0x4200000,
+		},
+		{
+			name: "relocated past small range",
+			h:    relocatable,
+			ram: kexec.Ranges{
+				kexec.RangeFromInterval(0x100000, 0x1200000),
+				kexec.RangeFromInterval(0x1a00000, 0x2000000),
+				kexec.RangeFromInterval(0x2100000, 0x80000000),
+			},
+			want: 0x2200000,
+		},
+		{
+			name: "not relocatable at preferred address",
+			h:    fixed,
+			ram:  kexec.Ranges{kexec.RangeFromInterval(0x100000, 0x80000000)},
+			want: 0x1000000,
+		},
+		{
+			name:    "not relocatable",
+			h:       fixed,
+			ram:     kexec.Ranges{kexec.RangeFromInterval(0x4000000, 0x80000000)},
+			wantErr: errKernelNotRelocatable,
+		},
+		{
+			name:    "bad alignment",
+			h:       &badAlign,
+			ram:     kexec.Ranges{kexec.RangeFromInterval(0x4000000, 0x80000000)},
+			wantErr: errBadKernelAlignment,
+		},
+		{
+			name:    "no space",
+			h:       relocatable,
+			ram:     kexec.Ranges{kexec.RangeFromInterval(0x4010000, 0x5000000)},
+			wantErr: kexec.ErrNotEnoughSpace,
+		},
+		{
+			name:    "old protocol",
+			h:       &oldProtocol,
+			ram:     kexec.Ranges{kexec.RangeFromInterval(0x100000, 0x80000000)},
+			wantErr: errBzImageProtocol,
+		},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := bzImageLoadAddr(tt.h, tt.ram)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("bzImageLoadAddr = %v, want %v", err, tt.wantErr)
+			}
+			if err != nil {
+				return
+			}
+			if got != tt.want {
+				t.Errorf("bzImageLoadAddr = %#x, want %#x", got, tt.want)
+			}
+			if align := uintptr(tt.h.Kernelalignment); tt.h.RelocatableKernel != 0 && got%align != 0 {
+				t.Errorf("bzImageLoadAddr = %#x, not aligned to kernel_alignment %#x", got, align)
+			}
+		})
+	}
+}
+
+func TestSetACPIRSDP(t *testing.T) {
+	// Boot protocol 2.15.
+	h := readBzImageHeader(t, "../bzimage/testdata/bzImage-linux5.10-x86_64-gzip")
+
+	oldProtocol := *h
+	oldProtocol.Protocolversion = 0x020d
+
+	for _, tt := range []struct {
+		name    string
+		h       *bzimage.LinuxHeader
+		rsdp    uintptr
+		wantErr error
+	}{
+		{
+			name: "unset",
+			h:    h,
+		},
+		{
+			name: "set",
+			h:    h,
+			rsdp: 0x7fb7e014,
+		},
+		{
+			name: "unset old protocol",
+			h:    &oldProtocol,
+		},
+		{
+			name:    "old protocol",
+			h:       &oldProtocol,
+			rsdp:    0x7fb7e014,
+			wantErr: errACPIRSDPProtocol,
+		},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			var lp bzimage.LinuxParams
+			err := setACPIRSDP(&lp, tt.h, KexecOptions{ACPIRSDP: tt.rsdp})
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("setACPIRSDP = %v, want %v", err, tt.wantErr)
+			}
+			if err != nil {
+				return
+			}
+
+			bp, err := lp.MarshalBinary()
+			if err != nil {
+				t.Fatal(err)
+			}
+			// acpi_rsdp_addr is at 0x70 in struct boot_params.
+			if got := binary.LittleEndian.Uint64(bp[0x70:]); got != uint64(tt.rsdp) {
+				t.Errorf("boot_params acpi_rsdp_addr = %#x, want %#x", got, tt.rsdp)
+			}
+		})
+	}
+}
+
+func TestBzImageFootprint(t *testing.T) {
+	segs := kexec.Segments{
+		kexec.NewSegment(nil, kexec.Range{Start: 0x1000000, Size: 0x800000}),
+		kexec.NewSegment(nil, kexec.Range{Start: 0x1a00000, Size: 0x200000}),
+	}
+
+	for _, tt := range []struct {
+		name     string
+		h        bzimage.LinuxHeader
+		loadAddr uintptr
+		want     kexec.Range
+	}{
+		{
+			name:     "init size beyond segments",
+			h:        bzimage.LinuxHeader{Protocolversion: 0x020f, InitSize: 0x2000000},
+			loadAddr: 0x1000000,
+			want:     kexec.Range{Start: 0x1000000, Size: 0x2000000},
+		},
+		{
+			name:     "segments beyond init size",
+			h:        bzimage.LinuxHeader{Protocolversion: 0x020f, InitSize: 0x800000},
+			loadAddr: 0x1000000,
+			want:     kexec.Range{Start: 0x1000000, Size: 0xc00000},
+		},
+		{
+			// Before 2.10, there is no init_size.
+			name: "old protocol",
+			h:    bzimage.LinuxHeader{Protocolversion: 0x0209},
+			want: kexec.Range{Start: 0x1000000, Size: 0xc00000},
+		},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := bzImageFootprint(&tt.h, segs, tt.loadAddr); got != tt.want {
+				t.Errorf("bzImageFootprint = %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestBzImageLoader(t *testing.T) {
+	setImageLoaderArch(t, "amd64")
+	Debug = t.Logf
+
+	lp, err := (&bzimage.LinuxParams{}).MarshalBinary()
+	if err != nil {
+		t.Fatal(err)
+	}
+	bootParamsPath = filepath.Join(t.TempDir(), "data")
+	t.Cleanup(func() { bootParamsPath = "/sys/kernel/boot_params/data" })
+	if err := os.WriteFile(bootParamsPath, lp, 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	kernel := "../bzimage/testdata/bzImage-linux5.10-x86_64-gzip"
+	if !(bzImageLoader{}).Probe(openFile(t, kernel)) {
+		t.Errorf("Probe(%s) = false, want true", kernel)
+	}
+	if (bzImageLoader{}).Probe(openFile(t, "../image/testdata/Image")) {
+		t.Errorf("Probe(arm64 Image) = true, want false")
+	}
+
+	mm := kexec.MemoryMap{
+		{Range: kexec.Range{Start: 0x1000, Size: 0x7ffff000}, Type: kexec.RangeRAM},
+	}
+	img, err := kexecLoadImageMM(mm, openFile(t, kernel), createFile(t, []byte("ramfs")), nil, "quiet", KexecOptions{})
+	if err != nil {
+		t.Fatalf("kexecLoadImageMM(bzImage) = %v, want nil", err)
+	}
+	var cmdline, ramfs bool
+	for _, s := range img.segments {
+		cmdline = cmdline || bytes.HasPrefix(s.Buf, []byte("quiet\x00"))
+		ramfs = ramfs || bytes.HasPrefix(s.Buf, []byte("ramfs"))
+	}
+	if !cmdline || !ramfs {
+		t.Errorf("kexecLoadImageMM(bzImage) = %v, want command line and initramfs segments", img.segments)
+	}
+	if !img.segments.PhysContains(img.entry) {
+		t.Errorf("kexecLoadImageMM(bzImage) entry %#x is not in a segment", img.entry)
+	}
+}
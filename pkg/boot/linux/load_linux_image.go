@@ -6,6 +6,8 @@ package linux
 
 import (
 	"bytes"
+	"crypto/rand"
+	"crypto/sha256"
 	"encoding/binary"
 	"errors"
 	"fmt"
@@ -34,10 +36,20 @@ func (k kimage) clean() {
 
 const (
 	kernelAlignSize = 1 << 21 // 2 MB.
+	initrdAlignSize = 1 << 12 // Page.
+	dtbAlignSize    = 8
+
+	// "The device tree blob (dtb) [...] must not exceed 2 megabytes in
+	// size." (arm64/booting.rst)
+	maxDTBSize = 1 << 21
 )
 
 var errNoChosenNode = fmt.Errorf("no /chosen node in device tree")
 
+// dtbHashProperty is the /chosen property holding the SHA-256 of the device
+// tree, see KexecOptions.MeasureDTB.
+const dtbHashProperty = "dtb-hash"
+
 // sanitizeFDT cleanups boot param properties from chosen node of the given FDT.
 func sanitizeFDT(fdt *dt.FDT) (*dt.Node, error) {
 	// Clear old entries in case we've already been through kexec to get
@@ -46,29 +58,120 @@ func sanitizeFDT(fdt *dt.FDT) (*dt.Node, error) {
 	if chosen == nil {
 		return nil, errNoChosenNode
 	}
-	for _, property := range []string{"linux,elfcorehdr", "linux,usable-memory-range", "kaslr-seed", "rng-seed", "linux,initrd-start", "linux,initrd-end"} {
+	for _, property := range []string{"linux,elfcorehdr", "linux,usable-memory-range", "kaslr-seed", "rng-seed", "linux,initrd-start", "linux,initrd-end", dtbHashProperty} {
 		chosen.RemoveProperty(property)
 	}
 
 	return chosen, nil
 }
 
+// rngSeedSize is the size of the rng-seed the kernel passes on in
+// kexec_file_load, RNG_SEED_SIZE.
+const rngSeedSize = 128
+
+// hasKASLRSeed reports whether the /chosen node of fdt has a kaslr-seed.
+// Firmware only passes one if the platform supports KASLR, and the kernel
+// zeroes it once used, so it stays in the running kernel's device tree.
+func hasKASLRSeed(fdt *dt.FDT) bool {
+	chosen, _ := fdt.NodeByName("chosen")
+	if chosen == nil {
+		return false
+	}
+	_, ok := chosen.LookProperty("kaslr-seed")
+	return ok
+}
+
+// addSeeds adds a fresh kaslr-seed and rng-seed, read from r or
+// crypto/rand.Reader if r is nil, to chosen.
+func addSeeds(chosen *dt.Node, r io.Reader) error {
+	if r == nil {
+		r = rand.Reader
+	}
+	kaslrSeed := make([]byte, 8)
+	if _, err := io.ReadFull(r, kaslrSeed); err != nil {
+		return fmt.Errorf("reading kaslr-seed: %w", err)
+	}
+	rngSeed := make([]byte, rngSeedSize)
+	if _, err := io.ReadFull(r, rngSeed); err != nil {
+		return fmt.Errorf("reading rng-seed: %w", err)
+	}
+	chosen.UpdateProperty("kaslr-seed", kaslrSeed)
+	chosen.UpdateProperty("rng-seed", rngSeed)
+	return nil
+}
+
+// measureDTB adds the SHA-256 of the flattened fdt to chosen. The hash is
+// taken without the hash property, which can only be added afterwards.
+func measureDTB(fdt *dt.FDT, chosen *dt.Node) error {
+	chosen.RemoveProperty(dtbHashProperty)
+	h := sha256.New()
+	if _, err := fdt.Write(h); err != nil {
+		return fmt.Errorf("flattening device tree: %w", err)
+	}
+	chosen.UpdateProperty(dtbHashProperty, h.Sum(nil))
+	return nil
+}
+
+var errDTBTooLarge = errors.New("device tree exceeds 2 MiB")
+
+// padFDT appends extra bytes of free space to the flattened device tree dtb
+// and grows its totalsize to match, so the kernel can add properties in
+// place.
+func padFDT(dtb []byte, extra uint) ([]byte, error) {
+	size := uint64(len(dtb)) + uint64(extra)
+	if size > maxDTBSize {
+		return nil, fmt.Errorf("%w: %#x bytes with %#x bytes of extra space", errDTBTooLarge, size, extra)
+	}
+	if extra == 0 {
+		return dtb, nil
+	}
+	dtb = append(dtb, make([]byte, extra)...)
+	// totalsize follows the magic in the header.
+	binary.BigEndian.PutUint32(dtb[4:], uint32(size))
+	return dtb, nil
+}
+
+// fdtCompatible reports whether a kernel parsing device tree version
+// minVersion can read a DTB with header h.
+func fdtCompatible(h dt.Header, minVersion uint32) bool {
+	return h.LastCompVersion <= minVersion
+}
+
 var ErrMemmapEmpty = errors.New("memory map is empty or contains no information about system RAM")
 
-func kexecLoadImage(kernel, ramfs *os.File, cmdline string, dtb io.ReaderAt, reservedRanges kexec.Ranges) (*kimage, error) {
+var errMultipleDTBs = errors.New("only one of DTB and DTBBytes may be set")
+
+func kexecLoadImage(kernel, ramfs *os.File, cmdline string, opts KexecOptions) (*kimage, error) {
 	var fdt *dt.FDT
 	var err error
 	// We want to fail when a user-supplied FDT is not parseable, not
 	// implicitly fall back to some other FDT. Avoid the dt.LoadFDT API.
-	if dtb != nil {
-		fdt, err = dt.ReadFDT(io.NewSectionReader(dtb, 0, math.MaxInt64))
-	} else {
+	switch {
+	case opts.DTB != nil && opts.DTBBytes != nil:
+		return nil, errMultipleDTBs
+	case opts.DTBBytes != nil:
+		fdt, err = dt.ReadFDT(bytes.NewReader(opts.DTBBytes))
+	case opts.DTB != nil:
+		fdt, err = dt.ReadFDT(io.NewSectionReader(opts.DTB, 0, math.MaxInt64))
+	default:
 		fdt, err = dt.ReadFile("/sys/firmware/fdt")
 	}
 	if err != nil {
 		return nil, fmt.Errorf("read FDT = %w", err)
 	}
+	for i, o := range opts.DTBOverlays {
+		overlay, err := dt.ReadFDT(io.NewSectionReader(o, 0, math.MaxInt64))
+		if err != nil {
+			return nil, fmt.Errorf("read FDT overlay %d = %w", i, err)
+		}
+		if err := fdt.ApplyOverlay(overlay); err != nil {
+			return nil, fmt.Errorf("applying FDT overlay %d: %w", i, err)
+		}
+	}
 	Debug("Loaded FDT: %s", fdt)
+	if opts.MinFDTCompatVersion != 0 && !fdtCompatible(fdt.Header, opts.MinFDTCompatVersion) {
+		Debug("Warning: FDT version %d is only compatible down to version %d, but the kernel expects version %d", fdt.Header.Version, fdt.Header.LastCompVersion, opts.MinFDTCompatVersion)
+	}
 
 	// Prepare segments.
 	Debug("Try parsing memory map...")
@@ -80,10 +183,15 @@ func kexecLoadImage(kernel, ramfs *os.File, cmdline string, dtb io.ReaderAt, res
 	if len(mm.RAM()) == 0 {
 		return nil, ErrMemmapEmpty
 	}
-	for _, r := range reservedRanges {
+	for _, r := range opts.ReservedRanges {
 		mm.Insert(kexec.TypedRange{Range: r, Type: kexec.RangeReserved})
 	}
-	return kexecLoadImageMM(mm, kernel, ramfs, fdt, cmdline)
+	if opts.AutoConsole {
+		if cmdline, err = appendConsole(cmdline, fdt); err != nil {
+			return nil, fmt.Errorf("console from stdout-path: %w", err)
+		}
+	}
+	return kexecLoadImageMM(mm, kernel, ramfs, fdt, cmdline, opts)
 }
 
 var (
@@ -91,13 +199,11 @@ var (
 	errInitramfsSegmentFailed  = errors.New("failed to add initramfs segment")
 	errDTBSegmentFailed        = errors.New("failed to add DTB segment")
 	errTrampolineSegmentFailed = errors.New("failed to add trampolineSegment")
+	errNoTrampolineUnsupported = errors.New("loading without trampoline is only supported for riscv64 Images")
+	errNoFDT                   = errors.New("no device tree to pass to the kernel")
 )
 
-func kexecLoadImageMM(mm kexec.MemoryMap, kernel, ramfs *os.File, fdt *dt.FDT, cmdline string) (*kimage, error) {
-	kmem := &kexec.Memory{
-		Phys: mm,
-	}
-
+func kexecLoadImageMM(mm kexec.MemoryMap, kernel, ramfs *os.File, fdt *dt.FDT, cmdline string, opts KexecOptions) (*kimage, error) {
 	img := &kimage{}
 
 	// Load kernel.
@@ -107,9 +213,76 @@ func kexecLoadImageMM(mm kexec.MemoryMap, kernel, ramfs *os.File, fdt *dt.FDT, c
 	}
 	img.cleanup = append(img.cleanup, cleanup)
 
+	loader, err := probeImageLoader(bytes.NewReader(kernelBuf))
+	if errors.Is(err, ErrNoImageLoader) && bytes.HasPrefix(kernelBuf, []byte(peMagic)) {
+		// The kernel may be wrapped in a PE executable, e.g. vmlinuz.efi.
+		kernelBuf, err = unwrapPE(kernelBuf)
+		if err != nil {
+			return nil, err
+		}
+		loader, err = probeImageLoader(bytes.NewReader(kernelBuf))
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	ramfsBuf, cleanup, err := getInitrd(ramfs, opts.Initrds, opts.DecompressInitrd)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get initramfs contents: %w", err)
+	}
+	img.cleanup = append(img.cleanup, cleanup)
+
+	cmdline = initrdArg(cmdline, ramfsBuf != nil)
+	img.segments, img.entry, err = loader.Load(mm, kernelBuf, ramfsBuf, fdt, cmdline, opts)
+	if err != nil {
+		return nil, err
+	}
+	if err := checkTotalSize(img.segments, opts); err != nil {
+		return nil, err
+	}
+	Debug("Entry: %#x", img.entry)
+	return img, nil
+}
+
+// arm64Loader loads arm64 Images.
+type arm64Loader struct{}
+
+func init() {
+	RegisterImageLoader(arm64Loader{})
+}
+
+// Probe implements ImageLoader.Probe.
+func (arm64Loader) Probe(kernel io.ReaderAt) bool {
+	if imageLoaderArch != "arm64" {
+		return false
+	}
+	var hdr image.Arm64Header
+	if err := binary.Read(io.NewSectionReader(kernel, 0, int64(binary.Size(hdr))), binary.LittleEndian, &hdr); err != nil {
+		return false
+	}
+	return hdr.Magic == image.Magic
+}
+
+// Load implements ImageLoader.Load.
+func (arm64Loader) Load(mm kexec.MemoryMap, kernelBuf, ramfsBuf []byte, fdt *dt.FDT, cmdline string, opts KexecOptions) (kexec.Segments, uintptr, error) {
+	// The arm64 kexec core only sets up x0 for kexec_file_load, kernels
+	// loaded with kexec_load would start without a DTB.
+	if opts.NoTrampoline {
+		return nil, 0, errNoTrampolineUnsupported
+	}
+
+	kmem := &kexec.Memory{
+		Phys: mm,
+		Fit:  opts.Fit,
+	}
+
 	kImage, err := image.ParseFromBytes(kernelBuf)
 	if err != nil {
-		return nil, fmt.Errorf("parse arm64 Image from bytes: %w", err)
+		return nil, 0, fmt.Errorf("parse arm64 Image from bytes: %w", err)
+	}
+	logKernelVersion(kernelBuf)
+	if err := verifyKernel(kernelBuf, opts); err != nil {
+		return nil, 0, err
 	}
 
 	// "The Image must be placed text_offset bytes from a 2MB aligned base
@@ -127,33 +300,71 @@ func kexecLoadImageMM(mm kexec.MemoryMap, kernel, ramfs *os.File, fdt *dt.FDT, c
 	// (arm64/booting.rst)
 	kernelRange, err := kmem.AddKexecSegmentExplicit(kernelBuf, uint(kImage.Header.ImageSize), uint(kImage.Header.TextOffset), kernelAlignSize)
 	if err != nil {
-		return nil, fmt.Errorf("%w: %w", errKernelSegmentFailed, err)
+		return nil, 0, fmt.Errorf("%w: %w", errKernelSegmentFailed, err)
 	}
 
 	Debug("Added %#x byte (size %#x) kernel at %s with offset %#x with alignment %#x", len(kernelBuf), kImage.Header.ImageSize, kernelRange, kImage.Header.TextOffset, kernelAlignSize)
+	// The Image is not compressed, image_size includes all the memory
+	// it needs.
+	reserved := reserveKernelHeadroom(kmem, kernelRange, opts, 0)
+	Debug("Reserved %s for the kernel", reserved)
+
+	dtbRange, err := addInitrdAndDTB(kmem, ramfsBuf, fdt, cmdline, opts)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	// Trampoline.
+	//
+	// We need a trampoline to pass the DTB to the kernel; because
+	// we'll use this code as our entry point, it also needs to know
+	// the real entry point to kernel.
+	trampoline := arm64Trampoline(kernelRange.Start, dtbRange.Start)
+	Debug("trampoline bytes %x", trampoline)
+	trampolineRange, err := kmem.AddKexecSegment(trampoline)
+	if err != nil {
+		return nil, 0, fmt.Errorf("%w: %w", errTrampolineSegmentFailed, err)
+	}
+	Debug("Added %d byte trampoline at %s", len(trampoline), trampolineRange)
 
+	if err := verifyArm64Trampoline(kmem.Segments, trampolineRange.Start, kernelRange.Start, dtbRange.Start); err != nil {
+		return nil, 0, err
+	}
+	return kmem.Segments, trampolineRange.Start, nil
+}
+
+// addInitrdAndDTB adds the initrd and the device tree after the kernel for
+// arm64 and riscv64 Images, and returns where the device tree went. /chosen
+// gets the initrd's location, the command line and the UEFI properties of
+// opts.
+func addInitrdAndDTB(kmem *kexec.Memory, ramfsBuf []byte, fdt *dt.FDT, cmdline string, opts KexecOptions) (kexec.Range, error) {
+	if fdt == nil {
+		return kexec.Range{}, errNoFDT
+	}
+	// Like kexec-tools, only pass seeds on if firmware passed them to the
+	// running kernel.
+	seeds := hasKASLRSeed(fdt)
 	chosen, err := sanitizeFDT(fdt)
 	if err != nil {
-		return nil, fmt.Errorf("sanitizeFDT(%v) = %w", fdt, err)
+		return kexec.Range{}, fmt.Errorf("sanitizeFDT(%v) = %w", fdt, err)
 	}
 	Debug("FDT after sanitization: %s", fdt)
-
-	if ramfs != nil {
-		ramfsBuf, cleanup, err := getFile(ramfs)
-		if err != nil {
-			return nil, fmt.Errorf("failed to get initramfs contents: %w", err)
+	if seeds {
+		if err := addSeeds(chosen, opts.RandSource); err != nil {
+			return kexec.Range{}, err
 		}
-		img.cleanup = append(img.cleanup, cleanup)
+	}
 
+	if ramfsBuf != nil {
 		// NOTE(10000TB): This need be placed after kernel by convention.
 		//
 		// "If an initrd/initramfs is passed to the kernel at boot, it
 		// must reside entirely within a 1 GB aligned physical memory
 		// window of up to 32 GB in size that fully covers the kernel
 		// Image as well." (arm64/booting.rst)
-		ramfsRange, err := kmem.AddKexecSegment(ramfsBuf)
+		ramfsRange, err := kmem.AddKexecSegmentAligned(ramfsBuf, initrdAlignSize)
 		if err != nil {
-			return nil, fmt.Errorf("%w: %w", errInitramfsSegmentFailed, err)
+			return kexec.Range{}, fmt.Errorf("%w: %w", errInitramfsSegmentFailed, err)
 		}
 		Debug("Added %d byte initramfs at %s", len(ramfsBuf), ramfsRange)
 
@@ -173,60 +384,32 @@ func kexecLoadImageMM(mm kexec.MemoryMap, kernel, ramfs *os.File, fdt *dt.FDT, c
 		chosen.RemoveProperty("bootargs")
 	}
 
+	if opts.UEFI != nil {
+		for _, p := range opts.UEFI.properties() {
+			chosen.Update(p)
+		}
+	}
+
+	if opts.MeasureDTB {
+		if err := measureDTB(fdt, chosen); err != nil {
+			return kexec.Range{}, err
+		}
+	}
+
 	var dtbBuffer bytes.Buffer
 	if _, err := fdt.Write(&dtbBuffer); err != nil {
-		return nil, fmt.Errorf("flattening device tree: %v", err)
+		return kexec.Range{}, fmt.Errorf("flattening device tree: %v", err)
 	}
-	dtbBuf := dtbBuffer.Bytes()
-	dtbRange, err := kmem.AddKexecSegment(dtbBuf)
+	dtbBuf, err := padFDT(dtbBuffer.Bytes(), opts.FDTExtraSpace)
 	if err != nil {
-		return nil, fmt.Errorf("%w: %w", errDTBSegmentFailed, err)
+		return kexec.Range{}, err
 	}
-	Debug("Added %d byte device tree at %s", len(dtbBuf), dtbRange)
-
-	// Trampoline.
-	//
-	// We need a trampoline to pass the DTB to the kernel; because
-	// we'll use this code as our entry point, it also needs to know
-	// the real entry point to kernel.
-	//
-	// TODO(10000TB): this assumes a little endian kernel, support
-	// big endian if needed per flag.
-	kernelEntry := kernelRange.Start
-	dtbBase := dtbRange.Start
-
-	var trampoline [10]uint32
-	// Instruction encoding per
-	// "Arm Architecture Reference Manual Armv8, for Armv8-A architecture
-	// profile" [ ARM DDI 0487E.a (ID070919) ]
-	trampoline[0] = 0x580000c4 // ldr x4, #0x18 (PC relative: trampoline[6 and 7])
-	trampoline[1] = 0x580000e0 // ldr x0, #0x1c (PC relative: trampoline[8 and 9])
-	// Zero out x1, x2, x3
-	trampoline[2] = 0xaa1f03e1 // mov x1, xzr
-	trampoline[3] = 0xaa1f03e2 // mov x2, xzr
-	trampoline[4] = 0xaa1f03e3 // mov x3, xzr
-	// Branch register / Jump to instruction from x4.
-	trampoline[5] = 0xd61f0080 // br  x4
-
-	trampoline[6] = uint32(uint64(kernelEntry) & 0xffffffff)
-	trampoline[7] = uint32(uint64(kernelEntry) >> 32)
-	trampoline[8] = uint32(uint64(dtbBase) & 0xffffffff)
-	trampoline[9] = uint32(uint64(dtbBase) >> 32)
-
-	var trampolineBuffer bytes.Buffer
-	if err := binary.Write(&trampolineBuffer, binary.LittleEndian, trampoline); err != nil {
-		return nil, fmt.Errorf("make trampoline: %v", err)
-	}
-	Debug("trampoline bytes %x", trampolineBuffer.Bytes())
-	trampolineRange, err := kmem.AddKexecSegment(trampolineBuffer.Bytes())
+	// "The device tree blob (dtb) must be placed on an 8-byte boundary."
+	// (arm64/booting.rst)
+	dtbRange, err := kmem.AddKexecSegmentAligned(dtbBuf, dtbAlignSize)
 	if err != nil {
-		return nil, fmt.Errorf("%w: %w", errTrampolineSegmentFailed, err)
+		return kexec.Range{}, fmt.Errorf("%w: %w", errDTBSegmentFailed, err)
 	}
-	Debug("Added %d byte trampoline at %s", len(trampolineBuffer.Bytes()), trampolineRange)
-
-	/* Load it */
-	img.entry = trampolineRange.Start
-	img.segments = kmem.Segments
-	Debug("Entry: %#x", img.entry)
-	return img, nil
+	Debug("Added %d byte device tree at %s", len(dtbBuf), dtbRange)
+	return dtbRange, nil
 }
@@ -6,11 +6,15 @@ package linux
 
 import (
 	"bytes"
+	"compress/gzip"
+	"crypto/sha256"
 	"encoding/binary"
 	"errors"
+	"fmt"
 	"io"
 	"os"
 	"path/filepath"
+	"strings"
 	"testing"
 
 	"github.com/u-root/u-root/pkg/boot/kexec"
@@ -110,6 +114,7 @@ func trampoline(kernelEntry, dtbBase uint64) []byte {
 }
 
 func TestKexecLoadImage(t *testing.T) {
+	setImageLoaderArch(t, "arm64")
 	Debug = t.Logf
 
 	for _, tt := range []struct {
@@ -119,8 +124,13 @@ func TestKexecLoadImage(t *testing.T) {
 		kernel       *os.File
 		ramfs        *os.File
 		fdt          io.ReaderAt
+		dtbBytes     []byte
 		cmdline      string
 		reservations kexec.Ranges
+		noTrampoline bool
+		initrds      []InitrdSource
+		maxTotal     uint64
+		uefi         *UEFIInfo
 
 		// Results
 		segments kexec.Segments
@@ -156,6 +166,77 @@ func TestKexecLoadImage(t *testing.T) {
 				kexec.NewSegment(readFile(t, "../image/testdata/Image"), kexec.Range{Start: 0x200000, Size: 0xa00000}),
 			},
 		},
+		{
+			name:   "load-zboot",
+			kernel: createFile(t, zbootWrap("gzip", gzipBytes(t, readFile(t, "../image/testdata/Image")))),
+			entry:  0x101000, /* trampoline entry */
+			fdt: fdtReader(t, &dt.FDT{
+				RootNode: dt.NewNode("/", dt.WithChildren(
+					dt.NewNode("chosen", dt.WithProperty(
+						dt.PropertyU64("linux,initrd-start", 500),
+						dt.PropertyU64("linux,initrd-end", 500),
+						dt.PropertyString("bootargs", "ohno"),
+					)),
+					dt.NewNode("test memory", dt.WithProperty(
+						dt.PropertyString("device_type", "memory"),
+						dt.PropertyRegion("reg", 0x100000, 0xf00000),
+					)),
+				)),
+			}),
+			segments: kexec.Segments{
+				kexec.NewSegment(fdtBytes(t, &dt.FDT{RootNode: dt.NewNode("/", dt.WithChildren(
+					dt.NewNode("chosen"),
+					dt.NewNode("test memory", dt.WithProperty(
+						dt.PropertyString("device_type", "memory"),
+						dt.PropertyRegion("reg", 0x100000, 0xf00000),
+					)),
+				))}), kexec.Range{Start: 0x100000, Size: 0x1000}),
+				kexec.NewSegment(trampoline(0x200000, 0x100000), kexec.Range{Start: 0x101000, Size: 0x1000}),
+				kexec.NewSegment(readFile(t, "../image/testdata/Image"), kexec.Range{Start: 0x200000, Size: 0xa00000}),
+			},
+		},
+		{
+			name:   "load-uefi",
+			kernel: openFile(t, "../image/testdata/Image"),
+			entry:  0x101000, /* trampoline entry */
+			uefi: &UEFIInfo{
+				SystemTable:     0xbfe40018,
+				MMapStart:       0xbbf0a018,
+				MMapSize:        0x1e00,
+				MMapDescSize:    0x30,
+				MMapDescVersion: 1,
+			},
+			fdt: fdtReader(t, &dt.FDT{
+				RootNode: dt.NewNode("/", dt.WithChildren(
+					dt.NewNode("chosen", dt.WithProperty(
+						// Left by the EFI stub of the running kernel.
+						dt.PropertyU64("linux,uefi-system-table", 0xbfe50018),
+						dt.PropertyU64("linux,uefi-mmap-start", 0xbbf00018),
+					)),
+					dt.NewNode("test memory", dt.WithProperty(
+						dt.PropertyString("device_type", "memory"),
+						dt.PropertyRegion("reg", 0x100000, 0xf00000),
+					)),
+				)),
+			}),
+			segments: kexec.Segments{
+				kexec.NewSegment(fdtBytes(t, &dt.FDT{RootNode: dt.NewNode("/", dt.WithChildren(
+					dt.NewNode("chosen", dt.WithProperty(
+						dt.PropertyU64("linux,uefi-system-table", 0xbfe40018),
+						dt.PropertyU64("linux,uefi-mmap-start", 0xbbf0a018),
+						dt.PropertyU32("linux,uefi-mmap-size", 0x1e00),
+						dt.PropertyU32("linux,uefi-mmap-desc-size", 0x30),
+						dt.PropertyU32("linux,uefi-mmap-desc-ver", 1),
+					)),
+					dt.NewNode("test memory", dt.WithProperty(
+						dt.PropertyString("device_type", "memory"),
+						dt.PropertyRegion("reg", 0x100000, 0xf00000),
+					)),
+				))}), kexec.Range{Start: 0x100000, Size: 0x1000}),
+				kexec.NewSegment(trampoline(0x200000, 0x100000), kexec.Range{Start: 0x101000, Size: 0x1000}),
+				kexec.NewSegment(readFile(t, "../image/testdata/Image"), kexec.Range{Start: 0x200000, Size: 0xa00000}),
+			},
+		},
 		{
 			name:    "load-initramfs-and-cmdline",
 			kernel:  openFile(t, "../image/testdata/Image"),
@@ -189,6 +270,51 @@ func TestKexecLoadImage(t *testing.T) {
 				kexec.NewSegment(readFile(t, "../image/testdata/Image"), kexec.Range{Start: 0x200000, Size: 0xa00000}),
 			},
 		},
+		{
+			name:    "load-dtb-bytes",
+			kernel:  openFile(t, "../image/testdata/Image"),
+			ramfs:   createFile(t, []byte("ramfs")),
+			cmdline: "foobar",
+			dtbBytes: fdtBytes(t, &dt.FDT{
+				RootNode: dt.NewNode("/", dt.WithChildren(
+					dt.NewNode("chosen", dt.WithProperty(
+						dt.PropertyU64("linux,initrd-start", 500),
+						dt.PropertyU64("linux,initrd-end", 500),
+						dt.PropertyString("bootargs", "ohno"),
+					)),
+					dt.NewNode("test memory", dt.WithProperty(
+						dt.PropertyString("device_type", "memory"),
+						dt.PropertyRegion("reg", 0x100000, 0xf00000),
+					)),
+				)),
+			}),
+			entry: 0x102000,
+			segments: kexec.Segments{
+				kexec.NewSegment([]byte("ramfs"), kexec.Range{Start: 0x100000, Size: 0x1000}),
+				kexec.NewSegment(fdtBytes(t, &dt.FDT{RootNode: dt.NewNode("/", dt.WithChildren(
+					dt.NewNode("chosen", dt.WithProperty(
+						// bootargs is updated in place, the stale
+						// initrd properties are replaced.
+						dt.PropertyString("bootargs", "foobar"),
+						dt.PropertyU64("linux,initrd-start", 0x100000),
+						dt.PropertyU64("linux,initrd-end", 0x101000),
+					)),
+					dt.NewNode("test memory", dt.WithProperty(
+						dt.PropertyString("device_type", "memory"),
+						dt.PropertyRegion("reg", 0x100000, 0xf00000),
+					)),
+				))}), kexec.Range{Start: 0x101000, Size: 0x1000}),
+				kexec.NewSegment(trampoline(0x200000, 0x101000), kexec.Range{Start: 0x102000, Size: 0x1000}),
+				kexec.NewSegment(readFile(t, "../image/testdata/Image"), kexec.Range{Start: 0x200000, Size: 0xa00000}),
+			},
+		},
+		{
+			name:     "dtb-and-dtb-bytes",
+			kernel:   openFile(t, "../image/testdata/Image"),
+			fdt:      fdtReader(t, &dt.FDT{RootNode: dt.NewNode("/")}),
+			dtbBytes: fdtBytes(t, &dt.FDT{RootNode: dt.NewNode("/")}),
+			errs:     []error{errMultipleDTBs},
+		},
 		{
 			name:   "pipefile",
 			kernel: pipe(t, readFile(t, "../image/testdata/Image")),
@@ -372,9 +498,111 @@ func TestKexecLoadImage(t *testing.T) {
 				kexec.NewSegment(readFile(t, "../image/testdata/Image"), kexec.Range{Start: 0x400000, Size: 0xa00000}),
 			},
 		},
+		{
+			// kexec_load does not pass the DTB in x0 on arm64.
+			name:         "reject-no-trampoline",
+			kernel:       openFile(t, "../image/testdata/Image"),
+			noTrampoline: true,
+			fdt: fdtReader(t, &dt.FDT{
+				RootNode: dt.NewNode("/", dt.WithChildren(
+					dt.NewNode("chosen"),
+					dt.NewNode("test memory", dt.WithProperty(
+						dt.PropertyString("device_type", "memory"),
+						dt.PropertyRegion("reg", 0x100000, 0xf00000),
+					)),
+				)),
+			}),
+			errs: []error{errNoTrampolineUnsupported},
+		},
+		{
+			name:   "load-ordered-initrds",
+			kernel: openFile(t, "../image/testdata/Image"),
+			ramfs:  createFile(t, []byte("ramfs")),
+			initrds: []InitrdSource{
+				{Order: 1, File: createFile(t, []byte("overlay"))},
+				{Order: -1, File: createFile(t, []byte("fw"))},
+			},
+			fdt: fdtReader(t, &dt.FDT{
+				RootNode: dt.NewNode("/", dt.WithChildren(
+					dt.NewNode("chosen"),
+					dt.NewNode("test memory", dt.WithProperty(
+						dt.PropertyString("device_type", "memory"),
+						dt.PropertyRegion("reg", 0x100000, 0xf00000),
+					)),
+				)),
+			}),
+			entry: 0x102000,
+			segments: kexec.Segments{
+				// Firmware first, then ramfs (order 0), then the overlay,
+				// each padded to 4 bytes.
+				kexec.NewSegment([]byte("fw\x00\x00ramfs\x00\x00\x00overlay"), kexec.Range{Start: 0x100000, Size: 0x1000}),
+				kexec.NewSegment(fdtBytes(t, &dt.FDT{RootNode: dt.NewNode("/", dt.WithChildren(
+					dt.NewNode("chosen", dt.WithProperty(
+						dt.PropertyU64("linux,initrd-start", 0x100000),
+						dt.PropertyU64("linux,initrd-end", 0x101000),
+					)),
+					dt.NewNode("test memory", dt.WithProperty(
+						dt.PropertyString("device_type", "memory"),
+						dt.PropertyRegion("reg", 0x100000, 0xf00000),
+					)),
+				))}), kexec.Range{Start: 0x101000, Size: 0x1000}),
+				kexec.NewSegment(trampoline(0x200000, 0x101000), kexec.Range{Start: 0x102000, Size: 0x1000}),
+				kexec.NewSegment(readFile(t, "../image/testdata/Image"), kexec.Range{Start: 0x200000, Size: 0xa00000}),
+			},
+		},
+		{
+			name:     "exceeds-max-total-bytes",
+			kernel:   openFile(t, "../image/testdata/Image"),
+			ramfs:    createFile(t, []byte("ramfs")),
+			maxTotal: 0xa00000,
+			fdt: fdtReader(t, &dt.FDT{
+				RootNode: dt.NewNode("/", dt.WithChildren(
+					dt.NewNode("chosen"),
+					dt.NewNode("test memory", dt.WithProperty(
+						dt.PropertyString("device_type", "memory"),
+						dt.PropertyRegion("reg", 0x100000, 0xf00000),
+					)),
+				)),
+			}),
+			errs: []error{ErrImageTooLarge},
+		},
+		{
+			name:     "fits-max-total-bytes",
+			kernel:   openFile(t, "../image/testdata/Image"),
+			maxTotal: 0xa02000,
+			entry:    0x101000,
+			fdt: fdtReader(t, &dt.FDT{
+				RootNode: dt.NewNode("/", dt.WithChildren(
+					dt.NewNode("chosen"),
+					dt.NewNode("test memory", dt.WithProperty(
+						dt.PropertyString("device_type", "memory"),
+						dt.PropertyRegion("reg", 0x100000, 0xf00000),
+					)),
+				)),
+			}),
+			segments: kexec.Segments{
+				kexec.NewSegment(fdtBytes(t, &dt.FDT{RootNode: dt.NewNode("/", dt.WithChildren(
+					dt.NewNode("chosen"),
+					dt.NewNode("test memory", dt.WithProperty(
+						dt.PropertyString("device_type", "memory"),
+						dt.PropertyRegion("reg", 0x100000, 0xf00000),
+					)),
+				))}), kexec.Range{Start: 0x100000, Size: 0x1000}),
+				kexec.NewSegment(trampoline(0x200000, 0x100000), kexec.Range{Start: 0x101000, Size: 0x1000}),
+				kexec.NewSegment(readFile(t, "../image/testdata/Image"), kexec.Range{Start: 0x200000, Size: 0xa00000}),
+			},
+		},
 	} {
 		t.Run(tt.name, func(t *testing.T) {
-			got, err := kexecLoadImage(tt.kernel, tt.ramfs, tt.cmdline, tt.fdt, tt.reservations)
+			got, err := kexecLoadImage(tt.kernel, tt.ramfs, tt.cmdline, KexecOptions{
+				DTB:            tt.fdt,
+				DTBBytes:       tt.dtbBytes,
+				ReservedRanges: tt.reservations,
+				NoTrampoline:   tt.noTrampoline,
+				Initrds:        tt.initrds,
+				MaxTotalBytes:  tt.maxTotal,
+				UEFI:           tt.uefi,
+			})
 			for _, wantErr := range tt.errs {
 				if !errors.Is(err, wantErr) {
 					t.Errorf("kexecLoad Arm Image = %v, want %v", err, wantErr)
@@ -397,3 +625,608 @@ func TestKexecLoadImage(t *testing.T) {
 		})
 	}
 }
+
+func TestKexecLoadImageAlignment(t *testing.T) {
+	setImageLoaderArch(t, "arm64")
+	Debug = t.Logf
+
+	kernel := readFile(t, "../image/testdata/Image")
+	got, err := kexecLoadImage(createFile(t, kernel), createFile(t, []byte("ramfs")), "", KexecOptions{
+		DTB: fdtReader(t, &dt.FDT{
+			RootNode: dt.NewNode("/", dt.WithChildren(
+				dt.NewNode("chosen"),
+				dt.NewNode("test memory", dt.WithProperty(
+					dt.PropertyString("device_type", "memory"),
+					// Neither page- nor 2M-aligned.
+					dt.PropertyRegion("reg", 0x100a00, 0x1000000),
+				)),
+			)),
+		}),
+	})
+	if err != nil {
+		t.Fatalf("kexecLoad Arm Image = %v, want nil", err)
+	}
+	if len(got.segments) != 4 {
+		t.Fatalf("kexecLoad Arm Image = %v, want 4 segments", got.segments)
+	}
+
+	for _, s := range got.segments {
+		var name string
+		var alignSize uintptr
+		switch {
+		case bytes.Equal(s.Buf, kernel):
+			name, alignSize = "kernel", kernelAlignSize
+		case bytes.Equal(s.Buf, []byte("ramfs")):
+			name, alignSize = "initramfs", initrdAlignSize
+		case s.Phys.Start == got.entry:
+			name, alignSize = "trampoline", 4
+		default:
+			name, alignSize = "dtb", dtbAlignSize
+		}
+		if s.Phys.Start%alignSize != 0 {
+			t.Errorf("%s segment at %s, want %#x-aligned start", name, s.Phys, alignSize)
+		}
+		if s.Phys.Start < 0x100a00 {
+			t.Errorf("%s segment at %s, below start of RAM", name, s.Phys)
+		}
+	}
+}
+
+func TestKexecLoadImageFDTVersion(t *testing.T) {
+	setImageLoaderArch(t, "arm64")
+	for _, tt := range []struct {
+		name            string
+		lastCompVersion uint32
+		minVersion      uint32
+		wantWarning     bool
+	}{
+		{name: "no-check", lastCompVersion: 17},
+		{name: "compatible", lastCompVersion: 16, minVersion: 16},
+		{name: "older-compatible", lastCompVersion: 16, minVersion: 17},
+		{name: "too-new", lastCompVersion: 17, minVersion: 16, wantWarning: true},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			var logs []string
+			Debug = func(format string, v ...interface{}) {
+				logs = append(logs, fmt.Sprintf(format, v...))
+			}
+			defer func() { Debug = func(string, ...interface{}) {} }()
+
+			fdt := &dt.FDT{
+				Header: dt.Header{LastCompVersion: tt.lastCompVersion},
+				RootNode: dt.NewNode("/", dt.WithChildren(
+					dt.NewNode("chosen"),
+					dt.NewNode("test memory", dt.WithProperty(
+						dt.PropertyString("device_type", "memory"),
+						dt.PropertyRegion("reg", 0x100000, 0xf00000),
+					)),
+				)),
+			}
+			// The version check is a soft one: loading must succeed.
+			if _, err := kexecLoadImage(openFile(t, "../image/testdata/Image"), nil, "", KexecOptions{
+				DTBBytes:            fdtBytes(t, fdt),
+				MinFDTCompatVersion: tt.minVersion,
+			}); err != nil {
+				t.Fatalf("kexecLoad Arm Image = %v, want nil", err)
+			}
+
+			var warned bool
+			for _, l := range logs {
+				if strings.HasPrefix(l, "Warning: FDT version") {
+					warned = true
+				}
+			}
+			if warned != tt.wantWarning {
+				t.Errorf("kexecLoad Arm Image warned = %v, want %v; logs:\n%s", warned, tt.wantWarning, strings.Join(logs, "\n"))
+			}
+		})
+	}
+}
+
+func TestKexecLoadImageMeasureDTB(t *testing.T) {
+	setImageLoaderArch(t, "arm64")
+	Debug = t.Logf
+
+	for _, measure := range []bool{false, true} {
+		t.Run(fmt.Sprintf("measure=%t", measure), func(t *testing.T) {
+			fdt := &dt.FDT{
+				RootNode: dt.NewNode("/", dt.WithChildren(
+					dt.NewNode("chosen", dt.WithProperty(
+						// Left over from the previous boot.
+						dt.Property{Name: dtbHashProperty, Value: []byte("stale")},
+					)),
+					dt.NewNode("test memory", dt.WithProperty(
+						dt.PropertyString("device_type", "memory"),
+						dt.PropertyRegion("reg", 0x100000, 0xf00000),
+					)),
+				)),
+			}
+			got, err := kexecLoadImage(openFile(t, "../image/testdata/Image"), createFile(t, []byte("ramfs")), "console=ttyAMA0", KexecOptions{
+				DTBBytes:   fdtBytes(t, fdt),
+				MeasureDTB: measure,
+			})
+			if err != nil {
+				t.Fatalf("kexecLoad Arm Image = %v, want nil", err)
+			}
+
+			_, dtbBase, err := decodeArm64Trampoline(got.segments.GetPhys(kexec.Range{Start: got.entry, Size: uint(arm64TrampolineSize)}))
+			if err != nil {
+				t.Fatal(err)
+			}
+			var dtb []byte
+			for _, s := range got.segments {
+				if s.Phys.Start == dtbBase {
+					dtb = s.Buf
+				}
+			}
+			loaded, err := dt.ReadFDT(bytes.NewReader(dtb))
+			if err != nil {
+				t.Fatalf("reading loaded DTB: %v", err)
+			}
+			chosen, ok := loaded.NodeByName("chosen")
+			if !ok {
+				t.Fatalf("loaded DTB has no /chosen")
+			}
+			hash, ok := chosen.LookProperty(dtbHashProperty)
+			if !measure {
+				if ok {
+					t.Errorf("/chosen/%s = %x, want no property", dtbHashProperty, hash.Value)
+				}
+				return
+			}
+			if !ok {
+				t.Fatalf("/chosen/%s missing", dtbHashProperty)
+			}
+			if len(hash.Value) != sha256.Size {
+				t.Fatalf("/chosen/%s has %d bytes, want %d", dtbHashProperty, len(hash.Value), sha256.Size)
+			}
+
+			// Verify the hash the way an attestation verifier would.
+			want := append([]byte(nil), hash.Value...)
+			chosen.RemoveProperty(dtbHashProperty)
+			var b bytes.Buffer
+			if _, err := loaded.Write(&b); err != nil {
+				t.Fatal(err)
+			}
+			if sum := sha256.Sum256(b.Bytes()); !bytes.Equal(sum[:], want) {
+				t.Errorf("/chosen/%s = %x, want %x", dtbHashProperty, want, sum)
+			}
+		})
+	}
+}
+
+func TestKexecLoadImageKernelHeadroom(t *testing.T) {
+	setImageLoaderArch(t, "arm64")
+	for _, tt := range []struct {
+		name     string
+		headroom uint64
+		// ramSize is the size of RAM from 0x200000. The kernel takes
+		// [0x200000, 0xc00000).
+		ramSize   uint
+		wantStart uintptr
+		wantErr   error
+	}{
+		{
+			name:      "no headroom",
+			ramSize:   0xa03000,
+			wantStart: 0xc00000,
+		},
+		{
+			name:      "headroom",
+			headroom:  0x100000,
+			ramSize:   0xb03000,
+			wantStart: 0xd00000,
+		},
+		{
+			// Without the headroom, there would be room for the
+			// initrd right after the kernel.
+			name:     "no room after headroom",
+			headroom: 0x100000,
+			ramSize:  0xa03000,
+			wantErr:  errInitramfsSegmentFailed,
+		},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := kexecLoadImage(openFile(t, "../image/testdata/Image"), createFile(t, []byte("ramfs")), "", KexecOptions{
+				DTB: fdtReader(t, &dt.FDT{
+					RootNode: dt.NewNode("/", dt.WithChildren(
+						dt.NewNode("chosen"),
+						dt.NewNode("test memory", dt.WithProperty(
+							dt.PropertyString("device_type", "memory"),
+							dt.PropertyRegion("reg", 0x200000, uint64(tt.ramSize)),
+						)),
+					)),
+				}),
+				KernelHeadroom: tt.headroom,
+			})
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("kexecLoad Arm Image = %v, want %v", err, tt.wantErr)
+			}
+			if err != nil {
+				return
+			}
+			for _, s := range got.segments {
+				if bytes.Equal(s.Buf, []byte("ramfs")) && s.Phys.Start != tt.wantStart {
+					t.Errorf("initramfs segment at %s, want start %#x", s.Phys, tt.wantStart)
+				}
+			}
+		})
+	}
+}
+
+func TestKexecLoadImageVerifySignature(t *testing.T) {
+	setImageLoaderArch(t, "arm64")
+	kernel, err := os.ReadFile("../image/testdata/Image")
+	if err != nil {
+		t.Fatal(err)
+	}
+	errBadSignature := errors.New("bad signature")
+
+	for _, tt := range []struct {
+		name    string
+		verify  func([]byte) error
+		wantErr error
+	}{
+		{
+			name: "accept",
+			verify: func(k []byte) error {
+				if !bytes.Equal(k, kernel) {
+					return errBadSignature
+				}
+				return nil
+			},
+		},
+		{
+			name:    "reject",
+			verify:  func([]byte) error { return errBadSignature },
+			wantErr: errBadSignature,
+		},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := kexecLoadImage(openFile(t, "../image/testdata/Image"), nil, "", KexecOptions{
+				DTB: fdtReader(t, &dt.FDT{
+					RootNode: dt.NewNode("/", dt.WithChildren(
+						dt.NewNode("chosen"),
+						dt.NewNode("test memory", dt.WithProperty(
+							dt.PropertyString("device_type", "memory"),
+							dt.PropertyRegion("reg", 0x200000, 0x1000000),
+						)),
+					)),
+				}),
+				VerifySignature: tt.verify,
+			})
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("kexecLoad Arm Image = %v, want %v", err, tt.wantErr)
+			}
+			if err == nil && len(got.segments) == 0 {
+				t.Errorf("kexecLoad Arm Image loaded no segments")
+			}
+		})
+	}
+}
+
+func TestKexecLoadImageSeeds(t *testing.T) {
+	setImageLoaderArch(t, "arm64")
+	source := make([]byte, 8+rngSeedSize)
+	for i := range source {
+		source[i] = byte(i)
+	}
+
+	for _, tt := range []struct {
+		name      string
+		kaslrSeed bool
+		source    []byte
+		wantSeeds bool
+		wantErr   bool
+	}{
+		{name: "firmware passed seeds", kaslrSeed: true, source: source, wantSeeds: true},
+		{name: "no kaslr-seed", source: source},
+		{name: "short source", kaslrSeed: true, source: source[:8+rngSeedSize-1], wantErr: true},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			chosen := dt.NewNode("chosen")
+			if tt.kaslrSeed {
+				// The running kernel zeroed the seed it used.
+				chosen.UpdateProperty("kaslr-seed", make([]byte, 8))
+				chosen.UpdateProperty("rng-seed", []byte("stale"))
+			}
+			got, err := kexecLoadImage(openFile(t, "../image/testdata/Image"), nil, "", KexecOptions{
+				DTB: fdtReader(t, &dt.FDT{
+					RootNode: dt.NewNode("/", dt.WithChildren(
+						chosen,
+						dt.NewNode("test memory", dt.WithProperty(
+							dt.PropertyString("device_type", "memory"),
+							dt.PropertyRegion("reg", 0x100000, 0x1000000),
+						)),
+					)),
+				}),
+				RandSource: bytes.NewReader(tt.source),
+			})
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("kexecLoad Arm Image = %v, want error %t", err, tt.wantErr)
+			}
+			if err != nil {
+				return
+			}
+
+			var fdt *dt.FDT
+			for _, s := range got.segments {
+				if f, err := dt.ReadFDT(bytes.NewReader(s.Buf)); err == nil {
+					fdt = f
+				}
+			}
+			if fdt == nil {
+				t.Fatalf("no device tree segment in %v", got.segments)
+			}
+			c, _ := fdt.NodeByName("chosen")
+			for _, seed := range []struct {
+				name string
+				want []byte
+			}{
+				{"kaslr-seed", source[:8]},
+				{"rng-seed", source[8:]},
+			} {
+				p, ok := c.LookProperty(seed.name)
+				switch {
+				case ok != tt.wantSeeds:
+					t.Errorf("/chosen has %s: %t, want %t", seed.name, ok, tt.wantSeeds)
+				case ok && !bytes.Equal(p.Value, seed.want):
+					t.Errorf("/chosen/%s = %x, want %x", seed.name, p.Value, seed.want)
+				}
+			}
+		})
+	}
+}
+
+func TestKexecLoadImageOrderedInitrds(t *testing.T) {
+	setImageLoaderArch(t, "arm64")
+	// 37 bytes.
+	microcode := []byte("kernel/x86/microcode/GenuineIntel.bin")
+	var rootfs bytes.Buffer
+	w := gzip.NewWriter(&rootfs)
+	if _, err := w.Write([]byte("rootfs")); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := kexecLoadImage(openFile(t, "../image/testdata/Image"), nil, "", KexecOptions{
+		DTB: fdtReader(t, &dt.FDT{
+			RootNode: dt.NewNode("/", dt.WithChildren(
+				dt.NewNode("chosen"),
+				dt.NewNode("test memory", dt.WithProperty(
+					dt.PropertyString("device_type", "memory"),
+					dt.PropertyRegion("reg", 0x100000, 0x1000000),
+				)),
+			)),
+		}),
+		Initrds: OrderedInitrds(createFile(t, microcode), createFile(t, rootfs.Bytes())),
+	})
+	if err != nil {
+		t.Fatalf("kexecLoad Arm Image = %v, want nil", err)
+	}
+
+	// The microcode archive is padded to 4 bytes, the compressed rootfs
+	// follows as is.
+	want := append(append(microcode, make([]byte, 3)...), rootfs.Bytes()...)
+	var initrd *kexec.Segment
+	var fdt *dt.FDT
+	for i, s := range got.segments {
+		if bytes.HasPrefix(s.Buf, microcode) {
+			if initrd != nil {
+				t.Fatalf("more than one initrd segment in %v", got.segments)
+			}
+			initrd = &got.segments[i]
+		}
+		if f, err := dt.ReadFDT(bytes.NewReader(s.Buf)); err == nil {
+			fdt = f
+		}
+	}
+	if initrd == nil || fdt == nil {
+		t.Fatalf("no initrd or device tree segment in %v", got.segments)
+	}
+	if !bytes.Equal(initrd.Buf, want) {
+		t.Errorf("initrd = %q, want %q", initrd.Buf, want)
+	}
+
+	c, _ := fdt.NodeByName("chosen")
+	for _, prop := range []struct {
+		name string
+		want uint64
+	}{
+		{"linux,initrd-start", uint64(initrd.Phys.Start)},
+		{"linux,initrd-end", uint64(initrd.Phys.End())},
+	} {
+		p, ok := c.LookProperty(prop.name)
+		if !ok {
+			t.Errorf("/chosen has no %s", prop.name)
+			continue
+		}
+		if v, err := p.AsU64(); err != nil || v != prop.want {
+			t.Errorf("/chosen/%s = %#x, %v, want %#x", prop.name, v, err, prop.want)
+		}
+	}
+	if end := uint64(initrd.Phys.Start) + uint64(len(want)); uint64(initrd.Phys.End()) < end {
+		t.Errorf("initrd at %s does not span the %#x byte concatenation", initrd.Phys, len(want))
+	}
+}
+
+func TestKexecLoadImageDTBOverlays(t *testing.T) {
+	setImageLoaderArch(t, "arm64")
+	base := func() io.ReaderAt {
+		return fdtReader(t, &dt.FDT{
+			RootNode: dt.NewNode("/", dt.WithChildren(
+				dt.NewNode("chosen"),
+				dt.NewNode("test memory", dt.WithProperty(
+					dt.PropertyString("device_type", "memory"),
+					dt.PropertyRegion("reg", 0x100000, 0x1000000),
+				)),
+				dt.NewNode("uart@1000", dt.WithProperty(
+					dt.PropertyString("status", "disabled"),
+					dt.PropertyU32("phandle", 1),
+				)),
+				dt.NewNode("__symbols__", dt.WithProperty(
+					dt.PropertyString("uart0", "/uart@1000"),
+				)),
+			)),
+		})
+	}
+	// &uart0 { status = "okay"; };
+	overlay := &dt.FDT{RootNode: dt.NewNode("/", dt.WithChildren(
+		dt.NewNode("fragment@0",
+			dt.WithProperty(dt.PropertyU32("target", 0xffffffff)),
+			dt.WithChildren(dt.NewNode("__overlay__", dt.WithProperty(
+				dt.PropertyString("status", "okay"),
+			))),
+		),
+		dt.NewNode("__fixups__", dt.WithProperty(
+			dt.PropertyString("uart0", "/fragment@0:target:0"),
+		)),
+	))}
+
+	got, err := kexecLoadImage(openFile(t, "../image/testdata/Image"), nil, "", KexecOptions{
+		DTB:         base(),
+		DTBOverlays: []io.ReaderAt{fdtReader(t, overlay)},
+	})
+	if err != nil {
+		t.Fatalf("kexecLoad Arm Image = %v, want nil", err)
+	}
+	var fdt *dt.FDT
+	for _, s := range got.segments {
+		if f, err := dt.ReadFDT(bytes.NewReader(s.Buf)); err == nil {
+			fdt = f
+		}
+	}
+	if fdt == nil {
+		t.Fatalf("no device tree segment in %v", got.segments)
+	}
+	uart, _ := fdt.NodeByName("uart@1000")
+	if p, ok := uart.LookProperty("status"); !ok || !bytes.Equal(p.Value, []byte("okay\x00")) {
+		t.Errorf("/uart@1000/status = %v, want okay", p)
+	}
+
+	// The overlay targets a label the device tree does not have.
+	overlay.RootNode.Children[1].Properties[0] = dt.PropertyString("uart1", "/fragment@0:target:0")
+	_, err = kexecLoadImage(openFile(t, "../image/testdata/Image"), nil, "", KexecOptions{
+		DTB:         base(),
+		DTBOverlays: []io.ReaderAt{fdtReader(t, overlay)},
+	})
+	if !errors.Is(err, dt.ErrOverlayFixup) {
+		t.Errorf("kexecLoad Arm Image with unresolvable overlay = %v, want %v", err, dt.ErrOverlayFixup)
+	}
+}
+
+func TestKexecLoadImageFDTExtraSpace(t *testing.T) {
+	setImageLoaderArch(t, "arm64")
+	fdt := &dt.FDT{
+		RootNode: dt.NewNode("/", dt.WithChildren(
+			dt.NewNode("chosen"),
+			dt.NewNode("test memory", dt.WithProperty(
+				dt.PropertyString("device_type", "memory"),
+				dt.PropertyRegion("reg", 0x100000, 0x1000000),
+			)),
+		)),
+	}
+	want := fdtBytes(t, fdt)
+
+	for _, tt := range []struct {
+		name     string
+		extra    uint
+		wantSize uint
+		wantErr  error
+	}{
+		{name: "none", wantSize: 0x1000},
+		{name: "within page", extra: 0x100, wantSize: 0x1000},
+		{name: "beyond page", extra: 0x1800, wantSize: 0x2000},
+		{name: "too large", extra: maxDTBSize, wantErr: errDTBTooLarge},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := kexecLoadImage(openFile(t, "../image/testdata/Image"), nil, "", KexecOptions{
+				DTB:           fdtReader(t, fdt),
+				FDTExtraSpace: tt.extra,
+			})
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("kexecLoad Arm Image = %v, want %v", err, tt.wantErr)
+			}
+			if err != nil {
+				return
+			}
+
+			var seg *kexec.Segment
+			for i, s := range got.segments {
+				if bytes.HasPrefix(s.Buf, want[:4]) {
+					seg = &got.segments[i]
+				}
+			}
+			if seg == nil {
+				t.Fatalf("no device tree segment in %v", got.segments)
+			}
+			if seg.Phys.Size != tt.wantSize {
+				t.Errorf("device tree segment at %s, want size %#x", seg.Phys, tt.wantSize)
+			}
+			if len(seg.Buf) != len(want)+int(tt.extra) {
+				t.Errorf("device tree is %#x bytes, want %#x", len(seg.Buf), len(want)+int(tt.extra))
+			}
+			parsed, err := dt.ReadFDT(bytes.NewReader(seg.Buf))
+			if err != nil {
+				t.Fatalf("ReadFDT = %v, want nil", err)
+			}
+			if parsed.Header.TotalSize != uint32(len(seg.Buf)) {
+				t.Errorf("totalsize = %#x, want %#x", parsed.Header.TotalSize, len(seg.Buf))
+			}
+			if !bytes.Equal(seg.Buf[8:len(want)], want[8:]) {
+				t.Errorf("device tree changed beyond totalsize")
+			}
+		})
+	}
+}
+
+func TestKexecLoadImageReservedMemory(t *testing.T) {
+	setImageLoaderArch(t, "arm64")
+	// Without these reservations, the initrd would go to the start of RAM
+	// at 0x100000: one carveout in /reserved-memory and one /memreserve/.
+	reserved := []kexec.Range{
+		{Start: 0x100000, Size: 0x10000},
+		{Start: 0x110000, Size: 0x1000},
+	}
+	got, err := kexecLoadImage(openFile(t, "../image/testdata/Image"), createFile(t, []byte("ramfs")), "", KexecOptions{
+		DTB: fdtReader(t, &dt.FDT{
+			ReserveEntries: []dt.ReserveEntry{{Address: 0x110000, Size: 0x1000}},
+			RootNode: dt.NewNode("/", dt.WithChildren(
+				dt.NewNode("chosen"),
+				dt.NewNode("test memory", dt.WithProperty(
+					dt.PropertyString("device_type", "memory"),
+					dt.PropertyRegion("reg", 0x100000, 0x1000000),
+				)),
+				dt.NewNode("reserved-memory",
+					dt.WithProperty(dt.PropertyU32("#address-cells", 1), dt.PropertyU32("#size-cells", 1)),
+					dt.WithChildren(dt.NewNode("coprocessor@100000", dt.WithProperty(
+						dt.Property{Name: "reg", Value: []byte{0x00, 0x10, 0x00, 0x00, 0x00, 0x01, 0x00, 0x00}},
+					))),
+				),
+			)),
+		}),
+	})
+	if err != nil {
+		t.Fatalf("kexecLoad Arm Image = %v, want nil", err)
+	}
+
+	var initrd *kexec.Segment
+	for i, s := range got.segments {
+		for _, r := range reserved {
+			if s.Phys.Overlaps(r) {
+				t.Errorf("segment at %s overlaps reserved %s", s.Phys, r)
+			}
+		}
+		if bytes.Equal(s.Buf, []byte("ramfs")) {
+			initrd = &got.segments[i]
+		}
+	}
+	if initrd == nil {
+		t.Fatalf("no initrd segment in %v", got.segments)
+	}
+	if want := uintptr(0x111000); initrd.Phys.Start != want {
+		t.Errorf("initrd at %s, want it right after the reserved regions at %#x", initrd.Phys, want)
+	}
+}
@@ -0,0 +1,96 @@
+// Copyright 2024 the u-root Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package linux
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+
+	"github.com/u-root/u-root/pkg/boot/image"
+	"github.com/u-root/u-root/pkg/boot/kexec"
+	"github.com/u-root/u-root/pkg/dt"
+)
+
+// riscv64Loader loads riscv64 Images.
+type riscv64Loader struct{}
+
+func init() {
+	RegisterImageLoader(riscv64Loader{})
+}
+
+// Probe implements ImageLoader.Probe.
+func (riscv64Loader) Probe(kernel io.ReaderAt) bool {
+	if imageLoaderArch != "riscv64" {
+		return false
+	}
+	var hdr image.RISCVHeader
+	if err := binary.Read(io.NewSectionReader(kernel, 0, int64(binary.Size(hdr))), binary.LittleEndian, &hdr); err != nil {
+		return false
+	}
+	return hdr.Magic2 == image.RISCVMagic2
+}
+
+// Load implements ImageLoader.Load.
+func (riscv64Loader) Load(mm kexec.MemoryMap, kernelBuf, ramfsBuf []byte, fdt *dt.FDT, cmdline string, opts KexecOptions) (kexec.Segments, uintptr, error) {
+	kmem := &kexec.Memory{
+		Phys: mm,
+		Fit:  opts.Fit,
+	}
+
+	kImage, err := image.ParseRISCVFromBytes(kernelBuf)
+	if err != nil {
+		return nil, 0, fmt.Errorf("parse riscv64 Image from bytes: %w", err)
+	}
+	logKernelVersion(kernelBuf)
+	if err := verifyKernel(kernelBuf, opts); err != nil {
+		return nil, 0, err
+	}
+
+	// "The kernel image must be placed at a PMD_SIZE-aligned address"
+	// (riscv/boot.rst), 2MB on rv64.
+	//
+	// text_offset is the offset from the start of RAM that the kernel was
+	// linked for. The kernel relocates itself, so like its own
+	// kexec_file_load, place it right at the aligned base instead.
+	kernelRange, err := kmem.AddKexecSegmentExplicit(kernelBuf, uint(kImage.Header.ImageSize), 0, kernelAlignSize)
+	if err != nil {
+		return nil, 0, fmt.Errorf("%w: %w", errKernelSegmentFailed, err)
+	}
+
+	Debug("Added %#x byte (size %#x) kernel at %s with alignment %#x, ignoring text_offset %#x", len(kernelBuf), kImage.Header.ImageSize, kernelRange, kernelAlignSize, kImage.Header.TextOffset)
+	// The Image is not compressed, image_size includes all the memory
+	// it needs.
+	reserved := reserveKernelHeadroom(kmem, kernelRange, opts, 0)
+	Debug("Reserved %s for the kernel", reserved)
+
+	dtbRange, err := addInitrdAndDTB(kmem, ramfsBuf, fdt, cmdline, opts)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	if opts.NoTrampoline {
+		// The kexec core finds the DTB among the segments and enters
+		// the kernel with the hart ID in a0 and the DTB in a1.
+		return kmem.Segments, kernelRange.Start, nil
+	}
+
+	// Trampoline.
+	//
+	// The kexec core enters it with the hart ID in a0; it loads the DTB
+	// into a1 and jumps to the kernel.
+	trampoline := riscv64Trampoline(kernelRange.Start, dtbRange.Start)
+	Debug("trampoline bytes %x", trampoline)
+	trampolineRange, err := kmem.AddKexecSegment(trampoline)
+	if err != nil {
+		return nil, 0, fmt.Errorf("%w: %w", errTrampolineSegmentFailed, err)
+	}
+	Debug("Added %d byte trampoline at %s", len(trampoline), trampolineRange)
+
+	if err := verifyRISCV64Trampoline(kmem.Segments, trampolineRange.Start, kernelRange.Start, dtbRange.Start); err != nil {
+		return nil, 0, err
+	}
+	return kmem.Segments, trampolineRange.Start, nil
+}
@@ -0,0 +1,154 @@
+// Copyright 2024 the u-root Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package linux
+
+import (
+	"bytes"
+	"encoding/binary"
+	"os"
+	"testing"
+
+	"github.com/u-root/u-root/pkg/boot/image"
+	"github.com/u-root/u-root/pkg/boot/kexec"
+	"github.com/u-root/u-root/pkg/dt"
+)
+
+// riscvImage returns a small riscv64 Image: a header followed by code.
+func riscvImage(t *testing.T, textOffset, imageSize uint64) []byte {
+	t.Helper()
+	var b bytes.Buffer
+	if err := binary.Write(&b, binary.LittleEndian, image.RISCVHeader{
+		Code0:      0x0080006f, // j +8
+		TextOffset: textOffset,
+		ImageSize:  imageSize,
+		Version:    0x2,
+		Magic:      0x5643534952,
+		Magic2:     image.RISCVMagic2,
+	}); err != nil {
+		t.Fatal(err)
+	}
+	return append(b.Bytes(), bytes.Repeat([]byte{0x13, 0, 0, 0}, 16)...)
+}
+
+// riscvTrampoline is the riscv64 trampoline, encoded independently of
+// riscv64Trampoline.
+func riscvTrampoline(kernelEntry, dtbBase uint64) []byte {
+	t := []byte{
+		0x97, 0x02, 0x00, 0x00,
+		0x83, 0xb5, 0x82, 0x01,
+		0x03, 0xb3, 0x02, 0x01,
+		0x67, 0x00, 0x03, 0x00,
+		0x00, 0x00, 0x20, 0x00, 0x00, 0x00, 0x00, 0x00,
+		0x00, 0x00, 0x10, 0x00, 0x00, 0x00, 0x00, 0x00,
+	}
+	binary.LittleEndian.PutUint64(t[16:], kernelEntry)
+	binary.LittleEndian.PutUint64(t[24:], dtbBase)
+	return t
+}
+
+func TestKexecLoadImageRISCV64(t *testing.T) {
+	setImageLoaderArch(t, "riscv64")
+	Debug = t.Logf
+
+	kernel := riscvImage(t, 0x200000, 0x400000)
+	memory := func() *dt.Node {
+		return dt.NewNode("memory@80000000", dt.WithProperty(
+			dt.PropertyString("device_type", "memory"),
+			dt.PropertyRegion("reg", 0x100000, 0xf00000),
+		))
+	}
+
+	for _, tt := range []struct {
+		name         string
+		ramfs        *os.File
+		cmdline      string
+		noTrampoline bool
+
+		segments kexec.Segments
+		entry    uintptr
+	}{
+		{
+			name:  "load-no-initramfs",
+			entry: 0x101000, /* trampoline entry */
+			segments: kexec.Segments{
+				kexec.NewSegment(fdtBytes(t, &dt.FDT{RootNode: dt.NewNode("/", dt.WithChildren(
+					dt.NewNode("chosen"),
+					memory(),
+				))}), kexec.Range{Start: 0x100000, Size: 0x1000}),
+				kexec.NewSegment(riscvTrampoline(0x200000, 0x100000), kexec.Range{Start: 0x101000, Size: 0x1000}),
+				kexec.NewSegment(kernel, kexec.Range{Start: 0x200000, Size: 0x400000}),
+			},
+		},
+		{
+			name:    "load-initramfs-and-cmdline",
+			ramfs:   createFile(t, []byte("ramfs")),
+			cmdline: "console=ttyS0 initrd=/boot/initrd.img",
+			entry:   0x102000,
+			segments: kexec.Segments{
+				kexec.NewSegment([]byte("ramfs"), kexec.Range{Start: 0x100000, Size: 0x1000}),
+				kexec.NewSegment(fdtBytes(t, &dt.FDT{RootNode: dt.NewNode("/", dt.WithChildren(
+					dt.NewNode("chosen", dt.WithProperty(
+						// bootargs is updated in place.
+						dt.PropertyString("bootargs", "console=ttyS0"),
+						dt.PropertyU64("linux,initrd-start", 0x100000),
+						dt.PropertyU64("linux,initrd-end", 0x101000),
+					)),
+					memory(),
+				))}), kexec.Range{Start: 0x101000, Size: 0x1000}),
+				kexec.NewSegment(riscvTrampoline(0x200000, 0x101000), kexec.Range{Start: 0x102000, Size: 0x1000}),
+				kexec.NewSegment(kernel, kexec.Range{Start: 0x200000, Size: 0x400000}),
+			},
+		},
+		{
+			name:         "load-no-trampoline",
+			noTrampoline: true,
+			entry:        0x200000,
+			segments: kexec.Segments{
+				kexec.NewSegment(fdtBytes(t, &dt.FDT{RootNode: dt.NewNode("/", dt.WithChildren(
+					dt.NewNode("chosen"),
+					memory(),
+				))}), kexec.Range{Start: 0x100000, Size: 0x1000}),
+				kexec.NewSegment(kernel, kexec.Range{Start: 0x200000, Size: 0x400000}),
+			},
+		},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			fdt := fdtReader(t, &dt.FDT{RootNode: dt.NewNode("/", dt.WithChildren(
+				dt.NewNode("chosen", dt.WithProperty(dt.PropertyString("bootargs", "ohno"))),
+				memory(),
+			))})
+			got, err := kexecLoadImage(createFile(t, kernel), tt.ramfs, tt.cmdline, KexecOptions{
+				DTB:          fdt,
+				NoTrampoline: tt.noTrampoline,
+			})
+			if err != nil {
+				t.Fatalf("kexecLoad riscv64 Image = %v, want nil", err)
+			}
+			if got.entry != tt.entry {
+				t.Errorf("kexecLoad riscv64 Image entry = %#x, want %#x", got.entry, tt.entry)
+			}
+			if !kexec.SegmentsEqual(got.segments, tt.segments) {
+				t.Errorf("kexecLoad riscv64 Image =\n%v, want\n%v", got.segments, tt.segments)
+			}
+		})
+	}
+}
+
+func TestRISCV64LoaderProbe(t *testing.T) {
+	setImageLoaderArch(t, "riscv64")
+	for _, tt := range []struct {
+		name   string
+		kernel []byte
+		want   bool
+	}{
+		{name: "riscv64", kernel: riscvImage(t, 0x200000, 0x400000), want: true},
+		{name: "arm64", kernel: readFile(t, "../image/testdata/Image"), want: false},
+		{name: "short", kernel: riscvImage(t, 0x200000, 0x400000)[:0x38], want: false},
+	} {
+		if got := (riscv64Loader{}).Probe(bytes.NewReader(tt.kernel)); got != tt.want {
+			t.Errorf("%s: Probe = %t, want %t", tt.name, got, tt.want)
+		}
+	}
+}
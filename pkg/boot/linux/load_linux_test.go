@@ -0,0 +1,125 @@
+// Copyright 2024 the u-root Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package linux
+
+import (
+	"errors"
+	"os"
+	"testing"
+
+	"golang.org/x/sys/unix"
+)
+
+func TestKexecLoadMechanism(t *testing.T) {
+	errBadSignature := errors.New("bad signature")
+	verify := func(err error) func([]byte) error {
+		return func(kernel []byte) error {
+			if string(kernel) != "not a kernel" {
+				t.Errorf("VerifySignature(%q), want the kernel file", kernel)
+			}
+			return err
+		}
+	}
+
+	for _, tt := range []struct {
+		name     string
+		opts     KexecOptions
+		fileLoad error
+		// wantFileLoad is whether kexec_file_load is tried.
+		wantFileLoad bool
+		want         LoadMechanism
+		// kexec_load always fails on the bogus kernel.
+		wantErr bool
+		// wantErrIs is the error expected, if known.
+		wantErrIs error
+	}{
+		{
+			name:         "file load",
+			opts:         KexecOptions{FileLoad: true},
+			wantFileLoad: true,
+			want:         MechanismKexecFileLoad,
+		},
+		{
+			name:         "file load not implemented",
+			opts:         KexecOptions{FileLoad: true},
+			fileLoad:     unix.ENOSYS,
+			wantFileLoad: true,
+			want:         MechanismKexecLoad,
+			wantErr:      true,
+		},
+		{
+			name:         "file load not permitted",
+			opts:         KexecOptions{FileLoad: true},
+			fileLoad:     unix.EPERM,
+			wantFileLoad: true,
+			want:         MechanismKexecLoad,
+			wantErr:      true,
+		},
+		{
+			name:         "file load fails",
+			opts:         KexecOptions{FileLoad: true},
+			fileLoad:     unix.EINVAL,
+			wantFileLoad: true,
+			want:         MechanismKexecFileLoad,
+			wantErr:      true,
+			wantErrIs:    unix.EINVAL,
+		},
+		{
+			name:         "file load verified",
+			opts:         KexecOptions{FileLoad: true, VerifySignature: verify(nil)},
+			wantFileLoad: true,
+			want:         MechanismKexecFileLoad,
+		},
+		{
+			name:      "file load bad signature",
+			opts:      KexecOptions{FileLoad: true, VerifySignature: verify(errBadSignature)},
+			want:      MechanismKexecFileLoad,
+			wantErr:   true,
+			wantErrIs: errBadSignature,
+		},
+		{
+			name:      "file load with DTB",
+			opts:      KexecOptions{FileLoad: true, DTBBytes: []byte{}, MaxTotalBytes: 1 << 30},
+			want:      MechanismKexecFileLoad,
+			wantErr:   true,
+			wantErrIs: errFileLoadOption,
+		},
+		{
+			name:    "no file load",
+			want:    MechanismKexecLoad,
+			wantErr: true,
+		},
+		{
+			name:    "initrd sources",
+			opts:    KexecOptions{FileLoad: true, Initrds: []InitrdSource{{Order: 1}}},
+			want:    MechanismKexecLoad,
+			wantErr: true,
+		},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			var tried bool
+			old := kexecFileLoad
+			kexecFileLoad = func(kernel, ramfs *os.File, cmdline string) error {
+				tried = true
+				if ramfs != nil || cmdline != "console=ttyS0" {
+					t.Errorf("kexec_file_load(%v, %v, %q), want no initrd and console=ttyS0", kernel, ramfs, cmdline)
+				}
+				return tt.fileLoad
+			}
+			t.Cleanup(func() { kexecFileLoad = old })
+
+			got, err := KexecLoadMechanism(createFile(t, []byte("not a kernel")), nil, "console=ttyS0", tt.opts)
+			if got != tt.want {
+				t.Errorf("KexecLoadMechanism() = %v, want %v", got, tt.want)
+			}
+			if (err != nil) != tt.wantErr || (tt.wantErrIs != nil && !errors.Is(err, tt.wantErrIs)) {
+				t.Errorf("KexecLoadMechanism() = %v, want error %t (%v)", err, tt.wantErr, tt.wantErrIs)
+			}
+			if tried != tt.wantFileLoad {
+				t.Errorf("kexec_file_load tried: %t, want %t", tried, tt.wantFileLoad)
+			}
+		})
+	}
+}
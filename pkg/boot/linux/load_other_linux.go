@@ -2,20 +2,18 @@
 // Use of this source code is governed by a BSD-style
 // license that can be found in the LICENSE file.
 
-//go:build !amd64 && !arm64
-// +build !amd64,!arm64
+//go:build !amd64 && !arm64 && !riscv64
+// +build !amd64,!arm64,!riscv64
 
 package linux
 
 import (
-	"io"
 	"os"
 
-	"github.com/u-root/u-root/pkg/boot/kexec"
 	"golang.org/x/sys/unix"
 )
 
-// KexecLoad is not implemented for platforms other than amd64 and arm64.
-func KexecLoad(kernel, ramfs *os.File, cmdline string, dtb io.ReaderAt, reservations kexec.Ranges) error {
+// kexecLoad is not implemented for platforms other than amd64, arm64 and riscv64.
+func kexecLoad(kernel, ramfs *os.File, cmdline string, opts KexecOptions) error {
 	return unix.ENOSYS
 }
@@ -0,0 +1,56 @@
+// Copyright 2024 the u-root Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package linux
+
+import (
+	"errors"
+	"io"
+	"runtime"
+
+	"github.com/u-root/u-root/pkg/boot/kexec"
+	"github.com/u-root/u-root/pkg/dt"
+)
+
+// ImageLoader builds the kexec segments for one kind of kernel image.
+//
+// Loaders register themselves with RegisterImageLoader, typically from an
+// init function.
+type ImageLoader interface {
+	// Probe returns whether the loader recognizes the kernel image.
+	Probe(kernel io.ReaderAt) bool
+
+	// Load places kernel, initrd and the device tree in the free memory of
+	// mm and returns the resulting segments and the entry point. initrd
+	// is nil if there is none, fdt is nil for x86 bzImages.
+	Load(mm kexec.MemoryMap, kernel, initrd []byte, fdt *dt.FDT, cmdline string, opts KexecOptions) (kexec.Segments, uintptr, error)
+}
+
+// ErrNoImageLoader is returned when no registered ImageLoader recognizes the
+// kernel image.
+var ErrNoImageLoader = errors.New("no image loader recognizes the kernel")
+
+var imageLoaders []ImageLoader
+
+// imageLoaderArch is the GOARCH kernels are loaded for. Loaders only
+// recognize images of their architecture, as the callers only set up
+// what that architecture needs, e.g. there is no device tree on x86.
+// Tests replace it.
+var imageLoaderArch = runtime.GOARCH
+
+// RegisterImageLoader adds l to the loaders considered for kernel images.
+// Loaders are probed in registration order.
+func RegisterImageLoader(l ImageLoader) {
+	imageLoaders = append(imageLoaders, l)
+}
+
+// probeImageLoader returns the first registered loader recognizing kernel.
+func probeImageLoader(kernel io.ReaderAt) (ImageLoader, error) {
+	for _, l := range imageLoaders {
+		if l.Probe(kernel) {
+			return l, nil
+		}
+	}
+	return nil, ErrNoImageLoader
+}
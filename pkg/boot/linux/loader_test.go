@@ -0,0 +1,104 @@
+// Copyright 2024 the u-root Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package linux
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"os"
+	"testing"
+
+	"github.com/u-root/u-root/pkg/boot/kexec"
+	"github.com/u-root/u-root/pkg/dt"
+)
+
+var fakeMagic = []byte("FAKEKRNL")
+
+type fakeLoader struct {
+	loaded bool
+}
+
+func (f *fakeLoader) Probe(kernel io.ReaderAt) bool {
+	magic := make([]byte, len(fakeMagic))
+	if _, err := kernel.ReadAt(magic, 0); err != nil {
+		return false
+	}
+	return bytes.Equal(magic, fakeMagic)
+}
+
+func (f *fakeLoader) Load(mm kexec.MemoryMap, kernel, initrd []byte, fdt *dt.FDT, cmdline string, opts KexecOptions) (kexec.Segments, uintptr, error) {
+	f.loaded = true
+	return kexec.Segments{kexec.NewSegment(kernel, kexec.Range{Start: 0x100000, Size: 0x1000})}, 0x100000, nil
+}
+
+// setImageLoaderArch makes the loaders load kernels for arch for the rest
+// of the test.
+func setImageLoaderArch(t *testing.T, arch string) {
+	t.Helper()
+	old := imageLoaderArch
+	imageLoaderArch = arch
+	t.Cleanup(func() { imageLoaderArch = old })
+}
+
+func TestImageLoaderProbe(t *testing.T) {
+	setImageLoaderArch(t, "arm64")
+	old := imageLoaders
+	t.Cleanup(func() { imageLoaders = old })
+
+	fake := &fakeLoader{}
+	RegisterImageLoader(fake)
+
+	mm := kexec.MemoryMap{
+		{Range: kexec.Range{Start: 0x100000, Size: 0xf00000}, Type: kexec.RangeRAM},
+	}
+	fdt := &dt.FDT{RootNode: dt.NewNode("/", dt.WithChildren(dt.NewNode("chosen")))}
+
+	// The arm64 Image must still go to the arm64 loader.
+	if _, err := kexecLoadImageMM(mm, openFile(t, "../image/testdata/Image"), nil, fdt, "", KexecOptions{}); err != nil {
+		t.Fatalf("kexecLoadImageMM(Image) = %v, want nil", err)
+	}
+	if fake.loaded {
+		t.Errorf("fake loader used for arm64 Image")
+	}
+
+	kernel := append(append([]byte{}, fakeMagic...), []byte("kernel")...)
+	img, err := kexecLoadImageMM(mm, createFile(t, kernel), nil, fdt, "", KexecOptions{})
+	if err != nil {
+		t.Fatalf("kexecLoadImageMM(fake) = %v, want nil", err)
+	}
+	if !fake.loaded {
+		t.Errorf("fake loader not selected for fake kernel")
+	}
+	if img.entry != 0x100000 {
+		t.Errorf("kexecLoadImageMM(fake) entry = %#x, want %#x", img.entry, 0x100000)
+	}
+
+	if _, err := kexecLoadImageMM(mm, createFile(t, []byte("unknown kernel")), nil, fdt, "", KexecOptions{}); !errors.Is(err, ErrNoImageLoader) {
+		t.Errorf("kexecLoadImageMM(unknown) = %v, want %v", err, ErrNoImageLoader)
+	}
+}
+
+func TestImageLoaderOtherArch(t *testing.T) {
+	// x86 loads kernels without a device tree, so Images of other
+	// architectures must not get to their loaders.
+	setImageLoaderArch(t, "amd64")
+
+	mm := kexec.MemoryMap{
+		{Range: kexec.Range{Start: 0x100000, Size: 0xf00000}, Type: kexec.RangeRAM},
+	}
+	for name, kernel := range map[string]*os.File{
+		"arm64":   openFile(t, "../image/testdata/Image"),
+		"riscv64": createFile(t, riscvImage(t, 0x200000, 0x400000)),
+	} {
+		if _, err := kexecLoadImageMM(mm, kernel, nil, nil, "", KexecOptions{}); err == nil {
+			t.Errorf("kexecLoadImageMM(%s Image) = nil, want error", name)
+		}
+	}
+
+	if _, err := addInitrdAndDTB(&kexec.Memory{Phys: mm}, nil, nil, "", KexecOptions{}); !errors.Is(err, errNoFDT) {
+		t.Errorf("addInitrdAndDTB(nil FDT) = %v, want %v", err, errNoFDT)
+	}
+}
@@ -0,0 +1,130 @@
+// Copyright 2024 the u-root Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package linux
+
+import (
+	"bytes"
+	"compress/gzip"
+	"debug/pe"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+const (
+	// peMagic starts the DOS header of every PE executable.
+	peMagic = "MZ"
+
+	// zbootMagic marks EFI zboot images (CONFIG_EFI_ZBOOT).
+	zbootMagic = "zimg"
+
+	// ukiKernelSection is the PE section holding the kernel in a unified
+	// kernel image.
+	ukiKernelSection = ".linux"
+)
+
+var (
+	errNotPE              = errors.New("not a PE executable")
+	errNoEmbeddedImage    = errors.New("PE executable does not embed a kernel image")
+	errUnknownCompression = errors.New("unsupported EFI zboot compression")
+)
+
+// zbootHeader is the header of an EFI zboot image. It overlays the DOS header
+// of the PE executable, see drivers/firmware/efi/libstub/zboot-header.S.
+type zbootHeader struct {
+	MZ            [4]byte  `offset:"0x00"`
+	ImageType     [4]byte  `offset:"0x04"`
+	PayloadOffset uint32   `offset:"0x08"`
+	PayloadSize   uint32   `offset:"0x0c"`
+	_             [8]byte  `offset:"0x10"`
+	CompType      [32]byte `offset:"0x18"`
+}
+
+// ExtractImageFromPE returns a reader over the kernel Image embedded in the
+// PE executable r, and the size of the Image.
+//
+// Two wrappers are supported: EFI zboot images such as arm64 vmlinuz.efi,
+// whose gzip or zstd compressed payload is decompressed into memory, and
+// unified kernel images, whose .linux section is returned.
+//
+// Note that an arm64 Image is a PE executable by itself, because of the EFI
+// stub, but it does not embed another Image.
+func ExtractImageFromPE(r io.ReaderAt) (io.ReaderAt, int64, error) {
+	var zh zbootHeader
+	if err := binary.Read(io.NewSectionReader(r, 0, int64(binary.Size(zh))), binary.LittleEndian, &zh); err != nil {
+		return nil, 0, fmt.Errorf("%w: %w", errNotPE, err)
+	}
+	if string(zh.MZ[:2]) != peMagic {
+		return nil, 0, errNotPE
+	}
+	if string(zh.ImageType[:]) == zbootMagic {
+		return extractZboot(r, zh)
+	}
+
+	f, err := pe.NewFile(r)
+	if err != nil {
+		return nil, 0, fmt.Errorf("%w: %w", errNotPE, err)
+	}
+	s := f.Section(ukiKernelSection)
+	if s == nil {
+		return nil, 0, errNoEmbeddedImage
+	}
+	// The raw size is padded to the file alignment, the virtual size is
+	// the actual size of the kernel.
+	size := int64(s.Size)
+	if s.VirtualSize != 0 {
+		size = int64(min(s.VirtualSize, s.Size))
+	}
+	Debug("Found %#x byte kernel in PE section %s at %#x", size, s.Name, s.Offset)
+	return io.NewSectionReader(r, int64(s.Offset), size), size, nil
+}
+
+// unwrapPE returns the kernel Image embedded in the PE executable kernel.
+func unwrapPE(kernel []byte) ([]byte, error) {
+	r, size, err := ExtractImageFromPE(bytes.NewReader(kernel))
+	if err != nil {
+		return nil, fmt.Errorf("extracting kernel from PE: %w", err)
+	}
+	img, err := io.ReadAll(io.NewSectionReader(r, 0, size))
+	if err != nil {
+		return nil, fmt.Errorf("extracting kernel from PE: %w", err)
+	}
+	return img, nil
+}
+
+// extractZboot decompresses the payload of an EFI zboot image.
+func extractZboot(r io.ReaderAt, zh zbootHeader) (io.ReaderAt, int64, error) {
+	payload := io.NewSectionReader(r, int64(zh.PayloadOffset), int64(zh.PayloadSize))
+	comp := string(bytes.TrimRight(zh.CompType[:], "\x00"))
+
+	var dr io.Reader
+	switch comp {
+	case "gzip":
+		gr, err := gzip.NewReader(payload)
+		if err != nil {
+			return nil, 0, fmt.Errorf("zboot gzip payload: %w", err)
+		}
+		dr = gr
+	case "zstd":
+		zr, err := zstd.NewReader(payload)
+		if err != nil {
+			return nil, 0, fmt.Errorf("zboot zstd payload: %w", err)
+		}
+		defer zr.Close()
+		dr = zr
+	default:
+		return nil, 0, fmt.Errorf("%w: %q", errUnknownCompression, comp)
+	}
+
+	var img bytes.Buffer
+	if _, err := io.Copy(&img, dr); err != nil {
+		return nil, 0, fmt.Errorf("decompressing %s zboot payload: %w", comp, err)
+	}
+	Debug("Decompressed %#x byte %s zboot payload to %#x bytes", zh.PayloadSize, comp, img.Len())
+	return bytes.NewReader(img.Bytes()), int64(img.Len()), nil
+}
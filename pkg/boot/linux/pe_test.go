@@ -0,0 +1,171 @@
+// Copyright 2024 the u-root Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package linux
+
+import (
+	"bytes"
+	"compress/gzip"
+	"debug/pe"
+	"encoding/binary"
+	"errors"
+	"io"
+	"testing"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+// ukiWrap returns a minimal PE executable with the given sections, like a
+// unified kernel image. The raw section data is padded to 0x200 bytes.
+func ukiWrap(t *testing.T, sections map[string][]byte, order ...string) []byte {
+	t.Helper()
+	const (
+		peHeaderOffset = 0x40
+		fileAlign      = 0x200
+	)
+
+	var b bytes.Buffer
+	dos := make([]byte, peHeaderOffset)
+	copy(dos, peMagic)
+	binary.LittleEndian.PutUint32(dos[0x3c:], peHeaderOffset)
+	b.Write(dos)
+	b.WriteString("PE\x00\x00")
+	fh := pe.FileHeader{
+		Machine:          pe.IMAGE_FILE_MACHINE_ARM64,
+		NumberOfSections: uint16(len(order)),
+	}
+	if err := binary.Write(&b, binary.LittleEndian, fh); err != nil {
+		t.Fatal(err)
+	}
+
+	offset := uint32(fileAlign)
+	var data bytes.Buffer
+	for _, name := range order {
+		content := sections[name]
+		rawSize := (uint32(len(content)) + fileAlign - 1) &^ (fileAlign - 1)
+		sh := pe.SectionHeader32{
+			VirtualSize:      uint32(len(content)),
+			SizeOfRawData:    rawSize,
+			PointerToRawData: offset,
+		}
+		copy(sh.Name[:], name)
+		if err := binary.Write(&b, binary.LittleEndian, sh); err != nil {
+			t.Fatal(err)
+		}
+		data.Write(content)
+		data.Write(make([]byte, int(rawSize)-len(content)))
+		offset += rawSize
+	}
+	b.Write(make([]byte, fileAlign-b.Len()))
+	b.Write(data.Bytes())
+	return b.Bytes()
+}
+
+// zbootWrap returns a minimal EFI zboot image with the compressed payload.
+func zbootWrap(comp string, payload []byte) []byte {
+	const payloadOffset = 0x1000
+	zh := make([]byte, payloadOffset)
+	copy(zh, peMagic)
+	copy(zh[0x04:], zbootMagic)
+	binary.LittleEndian.PutUint32(zh[0x08:], payloadOffset)
+	binary.LittleEndian.PutUint32(zh[0x0c:], uint32(len(payload)))
+	copy(zh[0x18:], comp)
+	// The kernel appends the decompressed size to the payload.
+	return append(append(zh, payload...), 0xde, 0xad, 0xbe, 0xef)
+}
+
+func gzipBytes(t *testing.T, b []byte) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	w := gzip.NewWriter(&buf)
+	if _, err := w.Write(b); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func zstdBytes(t *testing.T, b []byte) []byte {
+	t.Helper()
+	w, err := zstd.NewWriter(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer w.Close()
+	return w.EncodeAll(b, nil)
+}
+
+func TestExtractImageFromPE(t *testing.T) {
+	kernel := readFile(t, "../image/testdata/Image")
+
+	for _, tt := range []struct {
+		name    string
+		pe      []byte
+		want    []byte
+		wantErr error
+	}{
+		{
+			name: "uki",
+			pe: ukiWrap(t, map[string][]byte{
+				".osrel":   []byte("ID=test\n"),
+				".cmdline": []byte("console=ttyAMA0"),
+				".linux":   kernel,
+			}, ".osrel", ".cmdline", ".linux"),
+			want: kernel,
+		},
+		{
+			name: "zboot-gzip",
+			pe:   zbootWrap("gzip", gzipBytes(t, kernel)),
+			want: kernel,
+		},
+		{
+			name: "zboot-zstd",
+			pe:   zbootWrap("zstd", zstdBytes(t, kernel)),
+			want: kernel,
+		},
+		{
+			name:    "zboot-unknown-compression",
+			pe:      zbootWrap("lz4", []byte("payload")),
+			wantErr: errUnknownCompression,
+		},
+		{
+			// The arm64 Image is a PE executable itself.
+			name:    "plain-image",
+			pe:      kernel,
+			wantErr: errNoEmbeddedImage,
+		},
+		{
+			name:    "not-pe",
+			pe:      []byte("\x7fELF and more bytes to fill a zboot header........"),
+			wantErr: errNotPE,
+		},
+		{
+			name:    "short",
+			pe:      []byte("MZ"),
+			wantErr: errNotPE,
+		},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			r, size, err := ExtractImageFromPE(bytes.NewReader(tt.pe))
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("ExtractImageFromPE() = %v, want %v", err, tt.wantErr)
+			}
+			if err != nil {
+				return
+			}
+			if size != int64(len(tt.want)) {
+				t.Errorf("ExtractImageFromPE() size = %#x, want %#x", size, len(tt.want))
+			}
+			got, err := io.ReadAll(io.NewSectionReader(r, 0, size))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(got, tt.want) {
+				t.Errorf("ExtractImageFromPE() returned %#x bytes differing from the raw Image", len(got))
+			}
+		})
+	}
+}
@@ -0,0 +1,110 @@
+// Copyright 2024 the u-root Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package linux
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"runtime"
+	"strings"
+
+	"github.com/u-root/u-root/pkg/boot/kexec"
+	"golang.org/x/sys/unix"
+)
+
+var (
+	errKexecAlreadyLoaded = errors.New("a kexec kernel is already loaded, not replacing it")
+	errKexecLoadedState   = errors.New("unexpected kexec_loaded state")
+	errNoKexec            = errors.New("kernel does not support kexec")
+	errNoSelfTestStub     = errors.New("no stub kernel for this architecture")
+)
+
+// Hooks for SelfTest. Tests replace them.
+var (
+	kexecLoadedPath     = "/sys/kernel/kexec_loaded"
+	selfTestArch        = runtime.GOARCH
+	selfTestMemoryMap   = kexec.MemoryMapFromIOMem
+	selfTestKexecLoad   = kexec.Load
+	selfTestKexecUnload = kexec.Unload
+)
+
+// selfTestStub is the code of the stub kernel SelfTest loads, a branch to
+// itself. It never runs, as the stub is unloaded right away.
+var selfTestStub = map[string][]byte{
+	"amd64":   {0xeb, 0xfe},             // jmp .
+	"arm64":   {0x00, 0x00, 0x00, 0x14}, // b .
+	"riscv64": {0x6f, 0x00, 0x00, 0x00}, // j .
+}
+
+func kexecLoaded() (bool, error) {
+	b, err := os.ReadFile(kexecLoadedPath)
+	if err != nil {
+		return false, err
+	}
+	return strings.TrimSpace(string(b)) == "1", nil
+}
+
+// SelfTest checks that kexec_load works on this machine without rebooting:
+// it loads a stub kernel into free RAM, checks that the kernel reports it as
+// loaded in /sys/kernel/kexec_loaded, and unloads it again.
+//
+// SelfTest requires CAP_SYS_BOOT and returns kexec.ErrNoSysBoot without it.
+// It refuses to run if a kernel is already loaded, as it would replace it.
+func SelfTest() error {
+	stub, ok := selfTestStub[selfTestArch]
+	if !ok {
+		return fmt.Errorf("%w: %s", errNoSelfTestStub, selfTestArch)
+	}
+
+	loaded, err := kexecLoaded()
+	if errors.Is(err, os.ErrNotExist) {
+		return fmt.Errorf("%w: %w", errNoKexec, err)
+	}
+	if err != nil {
+		return err
+	}
+	if loaded {
+		return errKexecAlreadyLoaded
+	}
+
+	mm, err := selfTestMemoryMap()
+	if err != nil {
+		return fmt.Errorf("reading memory map: %w", err)
+	}
+	kmem := &kexec.Memory{Phys: mm}
+	r, err := kmem.AddKexecSegment(append(stub, make([]byte, 0x1000-len(stub))...))
+	if err != nil {
+		return fmt.Errorf("placing stub kernel: %w", err)
+	}
+	Debug("Loading stub kernel at %s", r)
+
+	if err := selfTestKexecLoad(r.Start, kmem.Segments, 0); err != nil {
+		if errors.Is(err, unix.EPERM) {
+			return fmt.Errorf("%w: %w", kexec.ErrNoSysBoot, err)
+		}
+		return fmt.Errorf("loading stub kernel: %w", err)
+	}
+
+	loaded, err = kexecLoaded()
+	if uerr := selfTestKexecUnload(0); uerr != nil {
+		return fmt.Errorf("unloading stub kernel: %w", uerr)
+	}
+	if err != nil {
+		return err
+	}
+	if !loaded {
+		return fmt.Errorf("%w: kexec_load succeeded, but %s is not 1", errKexecLoadedState, kexecLoadedPath)
+	}
+
+	loaded, err = kexecLoaded()
+	if err != nil {
+		return err
+	}
+	if loaded {
+		return fmt.Errorf("%w: stub kernel unloaded, but %s is still 1", errKexecLoadedState, kexecLoadedPath)
+	}
+	return nil
+}
@@ -0,0 +1,34 @@
+// Copyright 2024 the u-root Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+//go:build integration
+
+package linux
+
+// TestIntegrationSelfTest issues real kexec_load calls. It needs
+// CAP_SYS_BOOT, so it only runs with the integration tag:
+//
+//	sudo go test -tags integration -run TestIntegration ./pkg/boot/linux
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/u-root/u-root/pkg/boot/kexec"
+	"golang.org/x/sys/unix"
+)
+
+func TestIntegrationSelfTest(t *testing.T) {
+	err := SelfTest()
+	switch {
+	case errors.Is(err, kexec.ErrNoSysBoot):
+		t.Skip("skipping, CAP_SYS_BOOT is required")
+	case errors.Is(err, errNoKexec), errors.Is(err, unix.ENOSYS):
+		t.Skip("skipping, kexec_load is not supported by this kernel")
+	case errors.Is(err, errKexecAlreadyLoaded):
+		t.Skip("skipping, a kexec kernel is already loaded")
+	case err != nil:
+		t.Errorf("SelfTest() = %v, want nil", err)
+	}
+}
@@ -0,0 +1,132 @@
+// Copyright 2024 the u-root Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package linux
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/u-root/u-root/pkg/boot/kexec"
+	"golang.org/x/sys/unix"
+)
+
+func TestSelfTest(t *testing.T) {
+	for _, tt := range []struct {
+		name string
+		// arch is the GOARCH SelfTest runs on, the host's if empty.
+		arch string
+		// initial is the content of kexec_loaded before SelfTest.
+		initial string
+		// loaded and unloaded are written to kexec_loaded by the fake
+		// kexec_load calls.
+		loaded   string
+		unloaded string
+		loadErr  error
+		want     []string
+		wantErr  error
+	}{
+		{
+			name:     "ok",
+			initial:  "0\n",
+			loaded:   "1\n",
+			unloaded: "0\n",
+			want:     []string{"load", "unload"},
+		},
+		{
+			name:    "no kexec",
+			wantErr: errNoKexec,
+		},
+		{
+			name:    "already loaded",
+			initial: "1\n",
+			wantErr: errKexecAlreadyLoaded,
+		},
+		{
+			name:    "no privilege",
+			initial: "0\n",
+			loadErr: kexec.ErrKexec{Errno: unix.EPERM},
+			want:    []string{"load"},
+			wantErr: kexec.ErrNoSysBoot,
+		},
+		{
+			name:     "not loaded",
+			initial:  "0\n",
+			loaded:   "0\n",
+			unloaded: "0\n",
+			want:     []string{"load", "unload"},
+			wantErr:  errKexecLoadedState,
+		},
+		{
+			name:     "not unloaded",
+			initial:  "0\n",
+			loaded:   "1\n",
+			unloaded: "1\n",
+			want:     []string{"load", "unload"},
+			wantErr:  errKexecLoadedState,
+		},
+		{
+			name:    "unsupported arch",
+			arch:    "mips",
+			initial: "0\n",
+			wantErr: errNoSelfTestStub,
+		},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			oldPath, oldArch, oldMM, oldLoad, oldUnload := kexecLoadedPath, selfTestArch, selfTestMemoryMap, selfTestKexecLoad, selfTestKexecUnload
+			t.Cleanup(func() {
+				kexecLoadedPath, selfTestArch, selfTestMemoryMap, selfTestKexecLoad, selfTestKexecUnload = oldPath, oldArch, oldMM, oldLoad, oldUnload
+			})
+			if tt.arch != "" {
+				selfTestArch = tt.arch
+			}
+
+			kexecLoadedPath = filepath.Join(t.TempDir(), "kexec_loaded")
+			write := func(s string) {
+				if err := os.WriteFile(kexecLoadedPath, []byte(s), 0o644); err != nil {
+					t.Fatal(err)
+				}
+			}
+			if tt.initial != "" {
+				write(tt.initial)
+			}
+
+			selfTestMemoryMap = func() (kexec.MemoryMap, error) {
+				return kexec.MemoryMap{
+					{Range: kexec.RangeFromInterval(0, 0x10000000), Type: kexec.RangeRAM},
+				}, nil
+			}
+			var calls []string
+			selfTestKexecLoad = func(entry uintptr, segs kexec.Segments, flags uint64) error {
+				calls = append(calls, "load")
+				if !segs.PhysContains(entry) {
+					t.Errorf("entry %#x is not in segments %v", entry, segs)
+				}
+				if entry < kexec.M1 {
+					t.Errorf("entry %#x is below 1M", entry)
+				}
+				if tt.loadErr != nil {
+					return tt.loadErr
+				}
+				write(tt.loaded)
+				return nil
+			}
+			selfTestKexecUnload = func(flags uint64) error {
+				calls = append(calls, "unload")
+				write(tt.unloaded)
+				return nil
+			}
+
+			if err := SelfTest(); !errors.Is(err, tt.wantErr) {
+				t.Errorf("SelfTest() = %v, want %v", err, tt.wantErr)
+			}
+			if !reflect.DeepEqual(calls, tt.want) {
+				t.Errorf("SelfTest() calls = %v, want %v", calls, tt.want)
+			}
+		})
+	}
+}
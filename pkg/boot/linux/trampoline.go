@@ -0,0 +1,150 @@
+// Copyright 2024 the u-root Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package linux
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+
+	"github.com/u-root/u-root/pkg/boot/kexec"
+)
+
+// arm64TrampolineCode loads the kernel entry and the DTB base that follow it
+// and branches to the kernel with the DTB base in x0.
+//
+// Instruction encoding per
+// "Arm Architecture Reference Manual Armv8, for Armv8-A architecture
+// profile" [ ARM DDI 0487E.a (ID070919) ]
+var arm64TrampolineCode = [6]uint32{
+	0x580000c4, // ldr x4, #0x18 (PC relative: kernel entry at byte 24)
+	0x580000e0, // ldr x0, #0x1c (PC relative: DTB base at byte 32)
+	// Zero out x1, x2, x3
+	0xaa1f03e1, // mov x1, xzr
+	0xaa1f03e2, // mov x2, xzr
+	0xaa1f03e3, // mov x3, xzr
+	// Branch register / Jump to instruction from x4.
+	0xd61f0080, // br  x4
+}
+
+// arm64TrampolineSize is the size of the trampoline code and its two
+// addresses.
+const arm64TrampolineSize = 4*len(arm64TrampolineCode) + 16
+
+// riscv64TrampolineCode loads the kernel entry and the DTB base that follow
+// it and jumps to the kernel with the DTB base in a1. The kexec core enters
+// the trampoline with the boot hart's ID in a0, which is left as is.
+//
+// Instruction encoding per "The RISC-V Instruction Set Manual, Volume I:
+// Unprivileged ISA".
+var riscv64TrampolineCode = [4]uint32{
+	0x00000297, // auipc t0, 0
+	0x0182b583, // ld a1, 24(t0) (DTB base at byte 24)
+	0x0102b303, // ld t1, 16(t0) (kernel entry at byte 16)
+	0x00030067, // jr t1
+}
+
+// riscv64TrampolineSize is the size of the trampoline code and its two
+// addresses.
+const riscv64TrampolineSize = 4*len(riscv64TrampolineCode) + 16
+
+var errBadTrampoline = errors.New("trampoline does not match the kernel and DTB placement")
+
+// arm64Trampoline returns a trampoline that jumps to kernelEntry with dtbBase
+// as the DTB address.
+//
+// TODO(10000TB): this assumes a little endian kernel, support
+// big endian if needed per flag.
+func arm64Trampoline(kernelEntry, dtbBase uintptr) []byte {
+	b := make([]byte, arm64TrampolineSize)
+	for i, insn := range arm64TrampolineCode {
+		binary.LittleEndian.PutUint32(b[4*i:], insn)
+	}
+	binary.LittleEndian.PutUint64(b[24:], uint64(kernelEntry))
+	binary.LittleEndian.PutUint64(b[32:], uint64(dtbBase))
+	return b
+}
+
+// decodeArm64Trampoline returns the kernel entry and DTB base embedded in the
+// trampoline b. It fails if the code is not the expected trampoline.
+func decodeArm64Trampoline(b []byte) (kernelEntry, dtbBase uintptr, err error) {
+	if len(b) < arm64TrampolineSize {
+		return 0, 0, fmt.Errorf("%w: %d bytes, want %d", errBadTrampoline, len(b), arm64TrampolineSize)
+	}
+	for i, want := range arm64TrampolineCode {
+		if got := binary.LittleEndian.Uint32(b[4*i:]); got != want {
+			return 0, 0, fmt.Errorf("%w: instruction %d is %#08x, want %#08x", errBadTrampoline, i, got, want)
+		}
+	}
+	return uintptr(binary.LittleEndian.Uint64(b[24:])), uintptr(binary.LittleEndian.Uint64(b[32:])), nil
+}
+
+// riscv64Trampoline returns a trampoline that jumps to kernelEntry with
+// dtbBase as the DTB address, per the RISC-V boot protocol: hart ID in a0,
+// DTB in a1.
+func riscv64Trampoline(kernelEntry, dtbBase uintptr) []byte {
+	b := make([]byte, riscv64TrampolineSize)
+	for i, insn := range riscv64TrampolineCode {
+		binary.LittleEndian.PutUint32(b[4*i:], insn)
+	}
+	binary.LittleEndian.PutUint64(b[16:], uint64(kernelEntry))
+	binary.LittleEndian.PutUint64(b[24:], uint64(dtbBase))
+	return b
+}
+
+// decodeRISCV64Trampoline returns the kernel entry and DTB base embedded in
+// the trampoline b. It fails if the code is not the expected trampoline.
+func decodeRISCV64Trampoline(b []byte) (kernelEntry, dtbBase uintptr, err error) {
+	if len(b) < riscv64TrampolineSize {
+		return 0, 0, fmt.Errorf("%w: %d bytes, want %d", errBadTrampoline, len(b), riscv64TrampolineSize)
+	}
+	for i, want := range riscv64TrampolineCode {
+		if got := binary.LittleEndian.Uint32(b[4*i:]); got != want {
+			return 0, 0, fmt.Errorf("%w: instruction %d is %#08x, want %#08x", errBadTrampoline, i, got, want)
+		}
+	}
+	return uintptr(binary.LittleEndian.Uint64(b[16:])), uintptr(binary.LittleEndian.Uint64(b[24:])), nil
+}
+
+// verifyArm64Trampoline checks that the trampoline placed at entry in segs
+// branches to kernelEntry and passes dtbBase, to catch the trampoline and
+// the segment placement disagreeing.
+func verifyArm64Trampoline(segs kexec.Segments, entry, kernelEntry, dtbBase uintptr) error {
+	return verifyTrampoline(segs, entry, kernelEntry, dtbBase, decodeArm64Trampoline)
+}
+
+// verifyRISCV64Trampoline is verifyArm64Trampoline for riscv64 trampolines.
+func verifyRISCV64Trampoline(segs kexec.Segments, entry, kernelEntry, dtbBase uintptr) error {
+	return verifyTrampoline(segs, entry, kernelEntry, dtbBase, decodeRISCV64Trampoline)
+}
+
+// verifyTrampoline checks the trampoline at entry in segs with decode.
+func verifyTrampoline(segs kexec.Segments, entry, kernelEntry, dtbBase uintptr, decode func([]byte) (uintptr, uintptr, error)) error {
+	var code []byte
+	for _, s := range segs {
+		if !s.Phys.Contains(entry) {
+			continue
+		}
+		if off := int(entry - s.Phys.Start); off < len(s.Buf) {
+			code = s.Buf[off:]
+		}
+		break
+	}
+	if code == nil {
+		return fmt.Errorf("%w: no trampoline at entry %#x", errBadTrampoline, entry)
+	}
+
+	gotEntry, gotDTB, err := decode(code)
+	if err != nil {
+		return err
+	}
+	if gotEntry != kernelEntry {
+		return fmt.Errorf("%w: branches to %#x, kernel is at %#x", errBadTrampoline, gotEntry, kernelEntry)
+	}
+	if gotDTB != dtbBase {
+		return fmt.Errorf("%w: passes DTB %#x, DTB is at %#x", errBadTrampoline, gotDTB, dtbBase)
+	}
+	return nil
+}
@@ -0,0 +1,128 @@
+// Copyright 2024 the u-root Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package linux
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+
+	"github.com/u-root/u-root/pkg/boot/kexec"
+)
+
+func TestArm64Trampoline(t *testing.T) {
+	// Compare against the independently encoded trampoline used by the
+	// loader tests.
+	if got, want := arm64Trampoline(0x200000, 0x100000), trampoline(0x200000, 0x100000); !bytes.Equal(got, want) {
+		t.Errorf("arm64Trampoline = %x, want %x", got, want)
+	}
+
+	entry, dtb, err := decodeArm64Trampoline(arm64Trampoline(0xc020_0000, 0x8000_1000))
+	if err != nil || entry != 0xc020_0000 || dtb != 0x8000_1000 {
+		t.Errorf("decodeArm64Trampoline = (%#x, %#x, %v), want (0xc0200000, 0x80001000, nil)", entry, dtb, err)
+	}
+}
+
+func TestVerifyArm64Trampoline(t *testing.T) {
+	const (
+		kernelBase = 0x200000
+		dtbBase    = 0x100000
+		entry      = 0x101000
+	)
+	segments := func(code []byte) kexec.Segments {
+		return kexec.Segments{
+			kexec.NewSegment([]byte("dtb"), kexec.Range{Start: dtbBase, Size: 0x1000}),
+			kexec.NewSegment(code, kexec.Range{Start: entry, Size: 0x1000}),
+			kexec.NewSegment([]byte("kernel"), kexec.Range{Start: kernelBase, Size: 0xa00000}),
+		}
+	}
+	tamper := func(off int, b byte) []byte {
+		code := arm64Trampoline(kernelBase, dtbBase)
+		code[off] ^= b
+		return code
+	}
+
+	for _, tt := range []struct {
+		name  string
+		segs  kexec.Segments
+		entry uintptr
+		want  error
+	}{
+		{
+			name:  "ok",
+			segs:  segments(arm64Trampoline(kernelBase, dtbBase)),
+			entry: entry,
+		},
+		{
+			name:  "tampered-entry",
+			segs:  segments(tamper(25, 0x10)),
+			entry: entry,
+			want:  errBadTrampoline,
+		},
+		{
+			name:  "tampered-dtb",
+			segs:  segments(tamper(32, 0x08)),
+			entry: entry,
+			want:  errBadTrampoline,
+		},
+		{
+			name:  "tampered-instruction",
+			segs:  segments(tamper(0, 0x01)),
+			entry: entry,
+			want:  errBadTrampoline,
+		},
+		{
+			name:  "swapped-addresses",
+			segs:  segments(arm64Trampoline(dtbBase, kernelBase)),
+			entry: entry,
+			want:  errBadTrampoline,
+		},
+		{
+			name:  "truncated",
+			segs:  segments(arm64Trampoline(kernelBase, dtbBase)[:24]),
+			entry: entry,
+			want:  errBadTrampoline,
+		},
+		{
+			name:  "entry-outside-segments",
+			segs:  segments(arm64Trampoline(kernelBase, dtbBase)),
+			entry: 0x2000000,
+			want:  errBadTrampoline,
+		},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := verifyArm64Trampoline(tt.segs, tt.entry, kernelBase, dtbBase); !errors.Is(err, tt.want) {
+				t.Errorf("verifyArm64Trampoline = %v, want %v", err, tt.want)
+			}
+		})
+	}
+}
+
+func TestRISCV64Trampoline(t *testing.T) {
+	if got, want := riscv64Trampoline(0x200000, 0x100000), riscvTrampoline(0x200000, 0x100000); !bytes.Equal(got, want) {
+		t.Errorf("riscv64Trampoline = %x, want %x", got, want)
+	}
+
+	entry, dtb, err := decodeRISCV64Trampoline(riscv64Trampoline(0x8040_0000, 0x8220_0000))
+	if err != nil || entry != 0x8040_0000 || dtb != 0x8220_0000 {
+		t.Errorf("decodeRISCV64Trampoline = (%#x, %#x, %v), want (0x80400000, 0x82200000, nil)", entry, dtb, err)
+	}
+
+	segs := kexec.Segments{
+		kexec.NewSegment(riscv64Trampoline(0x200000, 0x100000), kexec.Range{Start: 0x101000, Size: 0x1000}),
+	}
+	if err := verifyRISCV64Trampoline(segs, 0x101000, 0x200000, 0x100000); err != nil {
+		t.Errorf("verifyRISCV64Trampoline = %v, want nil", err)
+	}
+	if err := verifyRISCV64Trampoline(segs, 0x101000, 0x100000, 0x200000); !errors.Is(err, errBadTrampoline) {
+		t.Errorf("verifyRISCV64Trampoline(swapped) = %v, want %v", err, errBadTrampoline)
+	}
+	arm64 := kexec.Segments{
+		kexec.NewSegment(arm64Trampoline(0x200000, 0x100000), kexec.Range{Start: 0x101000, Size: 0x1000}),
+	}
+	if err := verifyRISCV64Trampoline(arm64, 0x101000, 0x200000, 0x100000); !errors.Is(err, errBadTrampoline) {
+		t.Errorf("verifyRISCV64Trampoline(arm64 trampoline) = %v, want %v", err, errBadTrampoline)
+	}
+}
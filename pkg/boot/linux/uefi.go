@@ -0,0 +1,41 @@
+// Copyright 2024 the u-root Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package linux
+
+import "github.com/u-root/u-root/pkg/dt"
+
+// UEFIInfo is what a kernel booted on UEFI needs to find the firmware: the
+// system table and the UEFI memory map. The EFI stub passes them in /chosen,
+// see Documentation/arch/arm/uefi.rst.
+//
+// The memory map must stay where it is, so it is typically the one the
+// running kernel got, read from its /chosen node.
+type UEFIInfo struct {
+	// SystemTable is the physical address of the UEFI system table.
+	SystemTable uint64
+
+	// MMapStart is the physical address of the UEFI memory map.
+	MMapStart uint64
+
+	// MMapSize is the size of the UEFI memory map in bytes.
+	MMapSize uint32
+
+	// MMapDescSize is the size of one memory map descriptor in bytes.
+	MMapDescSize uint32
+
+	// MMapDescVersion is the version of the memory map descriptors.
+	MMapDescVersion uint32
+}
+
+// properties returns the /chosen properties describing u.
+func (u UEFIInfo) properties() []dt.Property {
+	return []dt.Property{
+		dt.PropertyU64("linux,uefi-system-table", u.SystemTable),
+		dt.PropertyU64("linux,uefi-mmap-start", u.MMapStart),
+		dt.PropertyU32("linux,uefi-mmap-size", u.MMapSize),
+		dt.PropertyU32("linux,uefi-mmap-desc-size", u.MMapDescSize),
+		dt.PropertyU32("linux,uefi-mmap-desc-ver", u.MMapDescVersion),
+	}
+}
@@ -0,0 +1,35 @@
+// Copyright 2024 the u-root Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package linux
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/u-root/u-root/pkg/dt"
+)
+
+func TestUEFIInfoProperties(t *testing.T) {
+	u := UEFIInfo{
+		SystemTable:     0x1_bfe4_0018,
+		MMapStart:       0xbbf0_a018,
+		MMapSize:        0x1e00,
+		MMapDescSize:    0x30,
+		MMapDescVersion: 1,
+	}
+
+	// The addresses are 64-bit, two cells; the sizes and the version are
+	// 32-bit, one cell. Cells are big endian.
+	want := []dt.Property{
+		{Name: "linux,uefi-system-table", Value: []byte{0, 0, 0, 0x01, 0xbf, 0xe4, 0x00, 0x18}},
+		{Name: "linux,uefi-mmap-start", Value: []byte{0, 0, 0, 0, 0xbb, 0xf0, 0xa0, 0x18}},
+		{Name: "linux,uefi-mmap-size", Value: []byte{0, 0, 0x1e, 0x00}},
+		{Name: "linux,uefi-mmap-desc-size", Value: []byte{0, 0, 0, 0x30}},
+		{Name: "linux,uefi-mmap-desc-ver", Value: []byte{0, 0, 0, 0x01}},
+	}
+	if got := u.properties(); !reflect.DeepEqual(got, want) {
+		t.Errorf("properties() = %v, want %v", got, want)
+	}
+}
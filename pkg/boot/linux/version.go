@@ -0,0 +1,135 @@
+// Copyright 2024 the u-root Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package linux
+
+import (
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+// linuxBanner starts the kernel's version banner, linux_banner in
+// init/version.c, e.g. "Linux version 6.1.0 (user@host) (gcc ...) #1 SMP ...".
+var linuxBanner = []byte("Linux version ")
+
+// maxDecompressedKernel caps the size of a decompressed kernel searched for
+// the banner.
+const maxDecompressedKernel = 256 << 20
+
+var errNoKernelVersion = errors.New("no kernel version banner found")
+
+// kernelStream is a compressed stream format a kernel may be packed in.
+type kernelStream struct {
+	magic      []byte
+	decompress func(io.Reader) ([]byte, error)
+}
+
+var kernelStreams = []kernelStream{
+	{magic: []byte{0x1f, 0x8b, 0x08}, decompress: gunzip},
+	{magic: []byte{0x28, 0xb5, 0x2f, 0xfd}, decompress: unzstd},
+}
+
+// KernelVersion returns the version banner embedded in the kernel image r,
+// without the trailing newline.
+//
+// The banner is searched in the image as is, which finds it in e.g. an arm64
+// Image. Otherwise the first gzip or zstd stream in the image that
+// decompresses is searched, which covers bzImages, Image.gz and EFI zboot
+// images using these compressions.
+func KernelVersion(r io.ReaderAt) (string, error) {
+	buf, err := io.ReadAll(io.NewSectionReader(r, 0, math.MaxInt64))
+	if err != nil {
+		return "", fmt.Errorf("reading kernel: %w", err)
+	}
+	return kernelVersion(buf)
+}
+
+// kernelVersion is KernelVersion for a kernel image already in memory.
+func kernelVersion(buf []byte) (string, error) {
+	if v, ok := findBanner(buf); ok {
+		return v, nil
+	}
+
+	// A compressed kernel is preceded by the decompressor, so the stream
+	// does not start at a known offset. Magic bytes in the decompressor
+	// do not decompress to anything, skip them, but stop at the first
+	// real stream: it is the kernel, and decompressing it is costly.
+	for _, s := range kernelStreams {
+		for off := bytes.Index(buf, s.magic); off >= 0; {
+			// Data after the stream may make the decompressor fail,
+			// so search whatever it returned.
+			if img, _ := s.decompress(bytes.NewReader(buf[off:])); len(img) > 0 {
+				if v, ok := findBanner(img); ok {
+					return v, nil
+				}
+				return "", errNoKernelVersion
+			}
+			next := bytes.Index(buf[off+1:], s.magic)
+			if next < 0 {
+				break
+			}
+			off += 1 + next
+		}
+	}
+	return "", errNoKernelVersion
+}
+
+// logKernelVersion logs the version banner of kernel, if there is one.
+func logKernelVersion(kernel []byte) {
+	v, err := kernelVersion(kernel)
+	if err != nil {
+		Debug("Kernel version: %v", err)
+		return
+	}
+	Debug("Kernel version: %s", v)
+}
+
+// findBanner returns the version banner in the uncompressed kernel b.
+func findBanner(b []byte) (string, bool) {
+	for off := 0; ; {
+		i := bytes.Index(b[off:], linuxBanner)
+		if i < 0 {
+			return "", false
+		}
+		start := off + i
+		end := start + len(linuxBanner)
+		// Skip format strings like "Linux version %s", the version
+		// number follows the banner immediately.
+		if end < len(b) && b[end] >= '0' && b[end] <= '9' {
+			if n := bytes.IndexAny(b[end:], "\n\x00"); n >= 0 {
+				end += n
+			} else {
+				end = len(b)
+			}
+			return string(b[start:end]), true
+		}
+		off = end
+	}
+}
+
+// gunzip decompresses a single gzip stream, ignoring data after it.
+func gunzip(r io.Reader) ([]byte, error) {
+	gr, err := gzip.NewReader(r)
+	if err != nil {
+		return nil, err
+	}
+	gr.Multistream(false)
+	return io.ReadAll(io.LimitReader(gr, maxDecompressedKernel))
+}
+
+// unzstd decompresses a zstd stream.
+func unzstd(r io.Reader) ([]byte, error) {
+	zr, err := zstd.NewReader(r)
+	if err != nil {
+		return nil, err
+	}
+	defer zr.Close()
+	return io.ReadAll(io.LimitReader(zr, maxDecompressedKernel))
+}
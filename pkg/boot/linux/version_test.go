@@ -0,0 +1,93 @@
+// Copyright 2024 the u-root Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package linux
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+)
+
+func TestKernelVersion(t *testing.T) {
+	const banner = "Linux version 6.1.0-test (user@host) (gcc 12.2.0) #1 SMP PREEMPT"
+	// An arm64 Image with the banner in its rodata, after a format string
+	// that must not match.
+	image := append(readFile(t, "../image/testdata/Image"), "Linux version %s\x00"+banner+"\n\x00"...)
+
+	for _, tt := range []struct {
+		name   string
+		kernel []byte
+		want   string
+	}{
+		{
+			name:   "Image",
+			kernel: image,
+			want:   banner,
+		},
+		{
+			name:   "Image.gz",
+			kernel: gzipBytes(t, image),
+			want:   banner,
+		},
+		{
+			name:   "zboot gzip",
+			kernel: zbootWrap("gzip", gzipBytes(t, image)),
+			want:   banner,
+		},
+		{
+			name:   "zboot zstd",
+			kernel: zbootWrap("zstd", zstdBytes(t, image)),
+			want:   banner,
+		},
+		{
+			name:   "bzImage gzip",
+			kernel: readFile(t, "../bzimage/testdata/bzImage-linux5.10-x86_64-gzip"),
+			want:   "Linux version 5.10.0-dirty (abrender@abrender.svl.corp.google.com) (gcc (Debian 11.3.0-5) 11.3.0, GNU ld (GNU Binutils for Debian) 2.38.90.20220713) #1 Fri Aug 19 13:28:06 PDT 2022",
+		},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := KernelVersion(bytes.NewReader(tt.kernel))
+			if err != nil {
+				t.Fatalf("KernelVersion() = %v, want nil", err)
+			}
+			if got != tt.want {
+				t.Errorf("KernelVersion() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestKernelVersionNotFound(t *testing.T) {
+	// A kernel with a banner, padded so that gzip compresses it rather than
+	// storing it, which would leave the banner in the raw bytes.
+	pad := bytes.Repeat([]byte("vmlinux "), 4096)
+	banner := gzipBytes(t, append(append(pad, "Linux version 6.1.0\n"...), pad...))
+	if bytes.Contains(banner, linuxBanner) {
+		t.Fatalf("gzip stream contains %q uncompressed", linuxBanner)
+	}
+	if _, err := KernelVersion(bytes.NewReader(banner)); err != nil {
+		t.Fatalf("KernelVersion(gzip stream) = %v, want nil", err)
+	}
+
+	for _, tt := range []struct {
+		name   string
+		kernel []byte
+	}{
+		{name: "empty"},
+		{name: "Image", kernel: readFile(t, "../image/testdata/Image")},
+		{name: "bad gzip", kernel: []byte("\x1f\x8b\x08garbage")},
+		{
+			// Only the first stream that decompresses is searched.
+			name:   "second stream",
+			kernel: append(gzipBytes(t, []byte("vmlinux")), banner...),
+		},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := KernelVersion(bytes.NewReader(tt.kernel)); !errors.Is(err, errNoKernelVersion) {
+				t.Errorf("KernelVersion() = %v, want %v", err, errNoKernelVersion)
+			}
+		})
+	}
+}
@@ -0,0 +1,130 @@
+// Copyright 2024 the u-root Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package brctl
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"golang.org/x/sys/unix"
+)
+
+// IfaceError is the error of one interface of AddIfs.
+type IfaceError struct {
+	Iface string
+	Err   error
+}
+
+func (e IfaceError) Error() string {
+	return fmt.Sprintf("%s: %v", e.Iface, e.Err)
+}
+
+func (e IfaceError) Unwrap() error {
+	return e.Err
+}
+
+// AddIfsError is returned by AddIfs if some interfaces were not attached.
+type AddIfsError struct {
+	Bridge string
+	// Attached are the interfaces that were attached nevertheless, in
+	// the order they were given. Detach them to roll back.
+	Attached []string
+	// Failed are the interfaces that were not attached, with the reason,
+	// in the order they were given.
+	Failed []IfaceError
+}
+
+func (e *AddIfsError) Error() string {
+	failed := make([]string, 0, len(e.Failed))
+	for _, f := range e.Failed {
+		failed = append(failed, f.Error())
+	}
+	return fmt.Sprintf("attaching to %s failed for %s; attached: [%s]", e.Bridge, strings.Join(failed, ", "), strings.Join(e.Attached, " "))
+}
+
+// Unwrap returns the errors of the failed interfaces.
+func (e *AddIfsError) Unwrap() []error {
+	errs := make([]error, 0, len(e.Failed))
+	for _, f := range e.Failed {
+		errs = append(errs, f)
+	}
+	return errs
+}
+
+// AddIfs attaches the interfaces ifaces to bridge, using one control socket
+// for all of them.
+//
+// All interface names are resolved first. If any of them cannot be, nothing
+// is attached. Otherwise every interface is tried, and if some fail, an
+// *AddIfsError names them along with the interfaces that were attached.
+func AddIfs(bridge string, ifaces ...string) error {
+	b, err := New()
+	if err != nil {
+		return err
+	}
+	defer b.Close()
+
+	return b.AddIfs(bridge, ifaces...)
+}
+
+// AddIfs is the handle version of the package level AddIfs.
+func (b *Brctl) AddIfs(bridge string, ifaces ...string) error {
+	indexes := make([]int, len(ifaces))
+	var failed []IfaceError
+	for i, iface := range ifaces {
+		index, err := b.index(iface)
+		if err != nil {
+			failed = append(failed, IfaceError{Iface: iface, Err: err})
+		}
+		indexes[i] = index
+	}
+	if failed != nil {
+		return &AddIfsError{Bridge: bridge, Failed: failed}
+	}
+
+	var attached []string
+	for i, iface := range ifaces {
+		err := func() (err error) {
+			defer observe("Addif", iface)(&err)
+			return b.addif(bridge, iface, indexes[i])
+		}()
+		// addif fails after attaching if the port defaults cannot be
+		// applied.
+		if err == nil || isPortOf(bridge, iface) {
+			attached = append(attached, iface)
+		}
+		if err != nil {
+			failed = append(failed, IfaceError{Iface: iface, Err: err})
+		}
+	}
+	if failed != nil {
+		return &AddIfsError{Bridge: bridge, Attached: attached, Failed: failed}
+	}
+	return nil
+}
+
+var errNoInterface = errors.New("no such interface")
+
+// index returns the ifindex of iface, using the handle's control socket.
+func (b *Brctl) index(iface string) (int, error) {
+	ifr, err := unix.NewIfreq(iface)
+	if err != nil {
+		return 0, fmt.Errorf("unix.NewIfreq: %w", err)
+	}
+
+	err = b.withSocket(func(fd int) error {
+		return ioctlIfreq(fd, unix.SIOCGIFINDEX, ifr)
+	})
+	if err != nil {
+		return 0, fmt.Errorf("unix.IoctlIfreq: %w", err)
+	}
+
+	index := ifr.Uint32()
+	if index == 0 {
+		return 0, errNoInterface
+	}
+	return int(index), nil
+}
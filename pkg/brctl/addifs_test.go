@@ -0,0 +1,100 @@
+// Copyright 2024 the u-root Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package brctl
+
+import (
+	"errors"
+	"reflect"
+	"strings"
+	"syscall"
+	"testing"
+
+	"golang.org/x/sys/unix"
+)
+
+// fakeAddif resolves interface names to indexes and records the indexes
+// attached with SIOCBRADDIF, failing those in fail.
+func fakeAddif(t *testing.T, indexes map[string]uint32, fail map[uint32]error) *[]uint32 {
+	t.Helper()
+	var attached []uint32
+	old := ioctlIfreq
+	ioctlIfreq = func(_ int, req uint, ifr *unix.Ifreq) error {
+		switch req {
+		case unix.SIOCGIFINDEX:
+			index, ok := indexes[ifr.Name()]
+			if !ok {
+				return syscall.ENODEV
+			}
+			ifr.SetUint32(index)
+			return nil
+		case unix.SIOCBRADDIF:
+			if err := fail[ifr.Uint32()]; err != nil {
+				return err
+			}
+			attached = append(attached, ifr.Uint32())
+			return nil
+		}
+		t.Fatalf("unexpected ioctl %#x", req)
+		return nil
+	}
+	t.Cleanup(func() { ioctlIfreq = old })
+	return &attached
+}
+
+func TestAddIfs(t *testing.T) {
+	fakeSysfs(t, map[string]string{})
+	attached := fakeAddif(t, map[string]uint32{"eth0": 1, "eth1": 2, "eth2": 3}, nil)
+
+	if err := AddIfs("br0", "eth0", "eth1", "eth2"); err != nil {
+		t.Fatalf("AddIfs(br0, eth0, eth1, eth2) = %v, want nil", err)
+	}
+	if want := []uint32{1, 2, 3}; !reflect.DeepEqual(*attached, want) {
+		t.Errorf("attached %v, want %v", *attached, want)
+	}
+}
+
+func TestAddIfsUnknownInterface(t *testing.T) {
+	fakeSysfs(t, map[string]string{})
+	attached := fakeAddif(t, map[string]uint32{"eth0": 1, "eth2": 3}, nil)
+
+	err := AddIfs("br0", "eth0", "eth1", "eth2", "eth3")
+	var aerr *AddIfsError
+	if !errors.As(err, &aerr) {
+		t.Fatalf("AddIfs() = %v, want *AddIfsError", err)
+	}
+	if len(aerr.Attached) != 0 || len(aerr.Failed) != 2 || aerr.Failed[0].Iface != "eth1" || aerr.Failed[1].Iface != "eth3" {
+		t.Errorf("AddIfs() = %+v, want eth1 and eth3 failed, nothing attached", aerr)
+	}
+	if !errors.Is(err, syscall.ENODEV) {
+		t.Errorf("AddIfs() = %v, want %v", err, syscall.ENODEV)
+	}
+	if len(*attached) != 0 {
+		t.Errorf("attached %v, want nothing", *attached)
+	}
+}
+
+func TestAddIfsPartialFailure(t *testing.T) {
+	fakeSysfs(t, map[string]string{})
+	indexes := map[string]uint32{"eth0": 1, "eth1": 2, "eth2": 3, "eth3": 4, "eth4": 5}
+	attached := fakeAddif(t, indexes, map[uint32]error{3: syscall.EBUSY})
+
+	err := AddIfs("br0", "eth0", "eth1", "eth2", "eth3", "eth4")
+	var aerr *AddIfsError
+	if !errors.As(err, &aerr) {
+		t.Fatalf("AddIfs() = %v, want *AddIfsError", err)
+	}
+	if want := []string{"eth0", "eth1", "eth3", "eth4"}; !reflect.DeepEqual(aerr.Attached, want) {
+		t.Errorf("AddIfs() attached %q, want %q", aerr.Attached, want)
+	}
+	if len(aerr.Failed) != 1 || aerr.Failed[0].Iface != "eth2" || !errors.Is(aerr.Failed[0].Err, syscall.EBUSY) {
+		t.Errorf("AddIfs() failed %v, want eth2 busy", aerr.Failed)
+	}
+	if !strings.Contains(err.Error(), "eth2") || !errors.Is(err, syscall.EBUSY) {
+		t.Errorf("AddIfs() = %v, want eth2 named and %v", err, syscall.EBUSY)
+	}
+	if want := []uint32{1, 2, 4, 5}; !reflect.DeepEqual(*attached, want) {
+		t.Errorf("attached %v, want %v", *attached, want)
+	}
+}
@@ -0,0 +1,66 @@
+// Copyright 2024 the u-root Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package brctl
+
+import (
+	"fmt"
+
+	"github.com/vishvananda/netlink/nl"
+	"golang.org/x/sys/unix"
+)
+
+// netlinkExecute sends a request on a NETLINK_ROUTE socket and waits for the
+// acknowledgement. Tests replace it to observe the request.
+var netlinkExecute = func(req *nl.NetlinkRequest) error {
+	_, err := req.Execute(unix.NETLINK_ROUTE, 0)
+	return err
+}
+
+// SetBackupPort makes backup the backup port of port. When port loses
+// carrier, the bridge redirects its traffic to backup. Both must be ports of
+// the same bridge.
+func SetBackupPort(port, backup string) error {
+	backupIndex, err := getIndexFromInterfaceName(backup)
+	if err != nil {
+		return fmt.Errorf("getIndexFromInterfaceName: %w", err)
+	}
+
+	return setBackupPort(port, uint32(backupIndex))
+}
+
+// ClearBackupPort removes the backup port of port.
+func ClearBackupPort(port string) error {
+	return setBackupPort(port, 0)
+}
+
+// setBackupPort sets IFLA_BRPORT_BACKUP_PORT of port to the ifindex of the
+// backup port. The kernel clears the backup port for ifindex 0.
+func setBackupPort(port string, backupIndex uint32) error {
+	portIndex, err := getIndexFromInterfaceName(port)
+	if err != nil {
+		return fmt.Errorf("getIndexFromInterfaceName: %w", err)
+	}
+
+	if err := netlinkExecute(backupPortRequest(portIndex, backupIndex)); err != nil {
+		return fmt.Errorf("set backup port of %s: %w", port, err)
+	}
+	return nil
+}
+
+// backupPortRequest builds the RTM_SETLINK request setting the backup port
+// of the bridge port with the given ifindex.
+func backupPortRequest(portIndex int, backupIndex uint32) *nl.NetlinkRequest {
+	req := nl.NewNetlinkRequest(unix.RTM_SETLINK, unix.NLM_F_ACK)
+
+	msg := nl.NewIfInfomsg(unix.AF_BRIDGE)
+	msg.Index = int32(portIndex)
+	req.AddData(msg)
+
+	protinfo := nl.NewRtAttr(unix.IFLA_PROTINFO|unix.NLA_F_NESTED, nil)
+	protinfo.AddRtAttr(unix.IFLA_BRPORT_BACKUP_PORT, nl.Uint32Attr(backupIndex))
+	req.AddData(protinfo)
+
+	return req
+}
@@ -0,0 +1,180 @@
+// Copyright 2024 the u-root Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package brctl
+
+import (
+	"errors"
+	"os"
+	"syscall"
+	"testing"
+
+	"github.com/vishvananda/netlink/nl"
+	"golang.org/x/sys/unix"
+)
+
+// fakeIndexes resolves interface names to the given ifindexes.
+func fakeIndexes(t *testing.T, indexes map[string]uint32) {
+	t.Helper()
+	old := ioctlIfreq
+	ioctlIfreq = func(_ int, req uint, ifr *unix.Ifreq) error {
+		if req != unix.SIOCGIFINDEX {
+			t.Fatalf("unexpected ioctl %#x", req)
+		}
+		ifr.SetUint32(indexes[ifr.Name()])
+		return nil
+	}
+	t.Cleanup(func() { ioctlIfreq = old })
+}
+
+// recordNetlink records the requests sent via netlink, failing each with err.
+func recordNetlink(t *testing.T, err error) *[][]byte {
+	t.Helper()
+	var reqs [][]byte
+	old := netlinkExecute
+	netlinkExecute = func(req *nl.NetlinkRequest) error {
+		reqs = append(reqs, req.Serialize())
+		return err
+	}
+	t.Cleanup(func() { netlinkExecute = old })
+	return &reqs
+}
+
+// parseBackupPort decodes a request built by backupPortRequest into the port
+// and backup port ifindexes.
+func parseBackupPort(t *testing.T, b []byte) (int32, uint32) {
+	t.Helper()
+	msgs, err := syscall.ParseNetlinkMessage(b)
+	if err != nil || len(msgs) != 1 {
+		t.Fatalf("ParseNetlinkMessage = %v, %v, want 1 message", msgs, err)
+	}
+	m := msgs[0]
+	if m.Header.Type != unix.RTM_SETLINK {
+		t.Errorf("message type = %d, want RTM_SETLINK", m.Header.Type)
+	}
+	if m.Header.Flags&unix.NLM_F_ACK == 0 {
+		t.Errorf("message flags = %#x, want NLM_F_ACK", m.Header.Flags)
+	}
+	ifi := nl.DeserializeIfInfomsg(m.Data)
+	if ifi.Family != unix.AF_BRIDGE {
+		t.Errorf("ifinfomsg family = %d, want AF_BRIDGE", ifi.Family)
+	}
+
+	attrs, err := nl.ParseRouteAttr(m.Data[unix.SizeofIfInfomsg:])
+	if err != nil || len(attrs) != 1 {
+		t.Fatalf("ParseRouteAttr = %v, %v, want 1 attribute", attrs, err)
+	}
+	if attrs[0].Attr.Type != unix.IFLA_PROTINFO|unix.NLA_F_NESTED {
+		t.Fatalf("attribute type = %#x, want nested IFLA_PROTINFO", attrs[0].Attr.Type)
+	}
+	nested, err := nl.ParseRouteAttr(attrs[0].Value)
+	if err != nil || len(nested) != 1 {
+		t.Fatalf("ParseRouteAttr(IFLA_PROTINFO) = %v, %v, want 1 attribute", nested, err)
+	}
+	if nested[0].Attr.Type != unix.IFLA_BRPORT_BACKUP_PORT {
+		t.Fatalf("nested attribute type = %d, want IFLA_BRPORT_BACKUP_PORT", nested[0].Attr.Type)
+	}
+	return ifi.Index, nl.NativeEndian().Uint32(nested[0].Value)
+}
+
+func TestBackupPort(t *testing.T) {
+	for _, tt := range []struct {
+		name       string
+		set        func() error
+		wantPort   int32
+		wantBackup uint32
+	}{
+		{
+			name:       "set",
+			set:        func() error { return SetBackupPort("eth0", "eth1") },
+			wantPort:   3,
+			wantBackup: 4,
+		},
+		{
+			name:       "clear",
+			set:        func() error { return ClearBackupPort("eth1") },
+			wantPort:   4,
+			wantBackup: 0,
+		},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			fakeIndexes(t, map[string]uint32{"eth0": 3, "eth1": 4})
+			reqs := recordNetlink(t, nil)
+
+			if err := tt.set(); err != nil {
+				t.Fatalf("got %v, want nil", err)
+			}
+			if len(*reqs) != 1 {
+				t.Fatalf("sent %d requests, want 1", len(*reqs))
+			}
+			port, backup := parseBackupPort(t, (*reqs)[0])
+			if port != tt.wantPort || backup != tt.wantBackup {
+				t.Errorf("request for port %d with backup %d, want port %d with backup %d", port, backup, tt.wantPort, tt.wantBackup)
+			}
+		})
+	}
+}
+
+func TestSetBackupPortErrors(t *testing.T) {
+	for _, tt := range []struct {
+		name    string
+		backup  string
+		nlErr   error
+		wantErr error
+		wantReq int
+	}{
+		{
+			name:    "unknown backup",
+			backup:  "eth9",
+			wantErr: nil,
+		},
+		{
+			name:    "kernel error",
+			backup:  "eth1",
+			nlErr:   unix.EINVAL,
+			wantErr: unix.EINVAL,
+			wantReq: 1,
+		},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			fakeIndexes(t, map[string]uint32{"eth0": 3, "eth1": 4})
+			reqs := recordNetlink(t, tt.nlErr)
+
+			err := SetBackupPort("eth0", tt.backup)
+			if err == nil {
+				t.Fatalf("SetBackupPort(eth0, %s) = nil, want error", tt.backup)
+			}
+			if tt.wantErr != nil && !errors.Is(err, tt.wantErr) {
+				t.Errorf("SetBackupPort(eth0, %s) = %v, want %v", tt.backup, err, tt.wantErr)
+			}
+			if len(*reqs) != tt.wantReq {
+				t.Errorf("sent %d requests, want %d", len(*reqs), tt.wantReq)
+			}
+		})
+	}
+}
+
+func TestGetIndexFromInterfaceNameClosesSocket(t *testing.T) {
+	fakeIndexes(t, map[string]uint32{"eth0": 3})
+
+	before, err := os.ReadDir("/proc/self/fd")
+	if err != nil {
+		t.Skipf("counting open files: %v", err)
+	}
+	for i := 0; i < 64; i++ {
+		if _, err := getIndexFromInterfaceName("eth0"); err != nil {
+			t.Fatalf("getIndexFromInterfaceName(eth0) = %v, want nil", err)
+		}
+		if _, err := getIndexFromInterfaceName("eth1"); err == nil {
+			t.Fatalf("getIndexFromInterfaceName(eth1) = nil, want error")
+		}
+	}
+	after, err := os.ReadDir("/proc/self/fd")
+	if err != nil {
+		t.Fatalf("counting open files: %v", err)
+	}
+	if len(after) > len(before) {
+		t.Errorf("open files went from %d to %d, want no sockets leaked", len(before), len(after))
+	}
+}
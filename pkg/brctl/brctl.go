@@ -5,10 +5,11 @@
 package brctl
 
 import (
-	"encoding/binary"
+	"errors"
 	"fmt"
 	"io"
 	"log"
+	"net"
 	"os"
 	"path"
 	"strconv"
@@ -19,13 +20,50 @@ import (
 
 // Addbr adds a bridge with the provided name.
 func Addbr(name string) error {
-	brctlSocket, err := unix.Socket(unix.AF_INET, unix.SOCK_STREAM, 0)
+	b, err := New()
 	if err != nil {
-		return fmt.Errorf("unix.Socket: %w", err)
+		return err
+	}
+	defer b.Close()
+
+	return b.Addbr(name)
+}
+
+// Addbr is the handle version of the package level Addbr.
+func (b *Brctl) Addbr(name string) (err error) {
+	defer observe("Addbr", name)(&err)
+
+	return b.withSocket(func(fd int) error {
+		if _, err := ioctlStr(fd, unix.SIOCBRADDBR, name); err != nil {
+			return fmt.Errorf("executeIoctlStr: %w", err)
+		}
+		return nil
+	})
+}
+
+// AddbrUp adds a bridge with the provided name and brings it up. If the
+// bridge cannot be brought up, it is deleted again.
+func AddbrUp(name string) error {
+	b, err := New()
+	if err != nil {
+		return err
+	}
+	defer b.Close()
+
+	return b.AddbrUp(name)
+}
+
+// AddbrUp is the handle version of the package level AddbrUp.
+func (b *Brctl) AddbrUp(name string) error {
+	if err := b.Addbr(name); err != nil {
+		return err
 	}
 
-	if _, err := executeIoctlStr(brctlSocket, unix.SIOCBRADDBR, name); err != nil {
-		return fmt.Errorf("executeIoctlStr: %w", err)
+	if err := b.SetLinkUp(name); err != nil {
+		if derr := b.Delbr(name); derr != nil {
+			return fmt.Errorf("%w; deleting bridge: %v", err, derr)
+		}
+		return err
 	}
 
 	return nil
@@ -33,49 +71,87 @@ func Addbr(name string) error {
 
 // Delbr deletes a bridge with the name provided.
 func Delbr(name string) error {
-	brctlSocket, err := unix.Socket(unix.AF_INET, unix.SOCK_STREAM, 0)
+	b, err := New()
 	if err != nil {
-		return fmt.Errorf("unix.Socket: %w", err)
+		return err
 	}
+	defer b.Close()
 
-	if _, err := executeIoctlStr(brctlSocket, unix.SIOCBRDELBR, name); err != nil {
-		return fmt.Errorf("executeIoctlStr: %w", err)
-	}
+	return b.Delbr(name)
+}
 
-	return nil
+// Delbr is the handle version of the package level Delbr.
+func (b *Brctl) Delbr(name string) (err error) {
+	defer observe("Delbr", name)(&err)
+
+	return b.withSocket(func(fd int) error {
+		if _, err := ioctlStr(fd, unix.SIOCBRDELBR, name); err != nil {
+			return fmt.Errorf("executeIoctlStr: %w", err)
+		}
+		return nil
+	})
 }
 
-// Addif adds an interface to the bridge provided
+// Addif adds an interface to the bridge provided and applies the bridge's
+// PortDefaults to it.
 func Addif(bridge string, iface string) error {
-	brctlSocket, err := unix.Socket(unix.AF_INET, unix.SOCK_STREAM, 0)
+	b, err := New()
 	if err != nil {
-		return fmt.Errorf("unix.Socket: %w", err)
+		return err
 	}
+	defer b.Close()
 
-	ifr, err := unix.NewIfreq(bridge)
-	if err != nil {
-		return fmt.Errorf("unix.NewIfreq: %w", err)
-	}
+	return b.Addif(bridge, iface)
+}
+
+// Addif is the handle version of the package level Addif.
+func (b *Brctl) Addif(bridge string, iface string) (err error) {
+	defer observe("Addif", iface)(&err)
 
 	ifIndex, err := getIndexFromInterfaceName(iface)
 	if err != nil {
 		return fmt.Errorf("getIndexFromInterfaceName: %w", err)
 	}
+
+	return b.addif(bridge, iface, ifIndex)
+}
+
+// addif attaches the interface iface with the given ifindex to bridge.
+func (b *Brctl) addif(bridge string, iface string, ifIndex int) error {
+	ifr, err := unix.NewIfreq(bridge)
+	if err != nil {
+		return fmt.Errorf("unix.NewIfreq: %w", err)
+	}
 	ifr.SetUint32(uint32(ifIndex))
 
-	if err := unix.IoctlIfreq(brctlSocket, unix.SIOCBRADDIF, ifr); err != nil {
-		return fmt.Errorf("unix.IoctlIfreq: %w", err)
+	err = b.withSocket(func(fd int) error {
+		if err := ioctlIfreq(fd, unix.SIOCBRADDIF, ifr); err != nil {
+			return fmt.Errorf("unix.IoctlIfreq: %w", err)
+		}
+		return nil
+	})
+	if err != nil {
+		return err
 	}
 
-	return nil
+	// The port stays attached if the policy cannot be applied.
+	return b.applyPortDefaults(bridge, iface)
 }
 
 // Delif deleted a given interface from the bridge
 func Delif(bridge string, iface string) error {
-	brctlSocket, err := unix.Socket(unix.AF_INET, unix.SOCK_STREAM, 0)
+	b, err := New()
 	if err != nil {
-		return fmt.Errorf("unix.Socket: %w", err)
+		return err
 	}
+	defer b.Close()
+
+	return b.Delif(bridge, iface)
+}
+
+// Delif is the handle version of the package level Delif.
+func (b *Brctl) Delif(bridge string, iface string) (err error) {
+	defer observe("Delif", iface)(&err)
 
 	ifr, err := unix.NewIfreq(bridge)
 	if err != nil {
@@ -88,34 +164,77 @@ func Delif(bridge string, iface string) error {
 	}
 	ifr.SetUint32(uint32(ifIndex))
 
-	if err := unix.IoctlIfreq(brctlSocket, unix.SIOCBRDELIF, ifr); err != nil {
-		return fmt.Errorf("unix.IoctlIfreq: %w", err)
-	}
-
-	return nil
+	return b.withSocket(func(fd int) error {
+		if err := ioctlIfreq(fd, unix.SIOCBRDELIF, ifr); err != nil {
+			return fmt.Errorf("unix.IoctlIfreq: %w", err)
+		}
+		return nil
+	})
 }
 
 // All bridges are in the virtfs under /sys/class/net/<name>/bridge/<item>, read info from there
 // Update this function if BridgeInfo struct changes
 func getBridgeInfo(name string) (BridgeInfo, error) {
-	basePath := path.Join(BRCTL_SYS_NET, name, "bridge")
-	bridgeID, err := os.ReadFile(path.Join(basePath, BRCTL_BRIDGEID))
+	basePath := path.Join(sysfsPath, name, "bridge")
+	bridgeID, err := readFile(path.Join(basePath, BRCTL_BRIDGEID))
 	if err != nil {
 		return BridgeInfo{}, fmt.Errorf("os.ReadFile: %w", err)
 	}
 
-	stpEnabledRaw, err := os.ReadFile(path.Join(basePath, BRCTL_STP_STATE))
+	stpModeRaw, err := readFile(path.Join(basePath, BRCTL_STP_STATE))
 	if err != nil {
 		return BridgeInfo{}, fmt.Errorf("os.ReadFile: %w", err)
 	}
 
-	stpEnabled, err := strconv.ParseBool(strings.TrimSuffix(string(stpEnabledRaw), "\n"))
+	stpMode, err := strconv.Atoi(strings.TrimSuffix(string(stpModeRaw), "\n"))
 	if err != nil {
-		return BridgeInfo{}, fmt.Errorf("strconv.ParseBool: %w", err)
+		return BridgeInfo{}, fmt.Errorf("strconv.Atoi: %w", err)
+	}
+
+	// Kernels without VLAN filtering support lack the attribute.
+	vlanFiltering, err := getBridgeBool(name, BRCTL_VLAN_FILTERING)
+	if err != nil && !errors.Is(err, ErrNotSupported) {
+		return BridgeInfo{}, fmt.Errorf("getBridgeBool: %w", err)
+	}
+
+	// group_fwd_mask appeared in Linux 3.2.
+	groupFwdMask, err := getGroupForwardMask(name)
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return BridgeInfo{}, fmt.Errorf("getGroupForwardMask: %w", err)
+	}
+
+	// Kernels without IGMP snooping support lack the attribute.
+	multicastSnooping, err := getBridgeBool(name, BRCTL_MULTICAST_SNOOPING)
+	if err != nil && !errors.Is(err, ErrNotSupported) {
+		return BridgeInfo{}, fmt.Errorf("getBridgeBool: %w", err)
+	}
+
+	rootID, err := getBridgeValue(name, BRCTL_ROOT_ID)
+	if err != nil {
+		return BridgeInfo{}, fmt.Errorf("getBridgeValue: %w", err)
+	}
+	// Without STP, or while the bridge is the root, root_id is the
+	// bridge's own ID and root_port 0.
+	rootPortRaw, err := getBridgeValue(name, BRCTL_ROOT_PORT)
+	if err != nil {
+		return BridgeInfo{}, fmt.Errorf("getBridgeValue: %w", err)
+	}
+	rootPort, err := strconv.Atoi(rootPortRaw)
+	if err != nil {
+		return BridgeInfo{}, fmt.Errorf("strconv.Atoi: %w", err)
+	}
+
+	address, err := getDeviceValue(name, BRCTL_ADDRESS)
+	if err != nil {
+		return BridgeInfo{}, fmt.Errorf("getDeviceValue: %w", err)
+	}
+	mac, err := net.ParseMAC(address)
+	if err != nil {
+		return BridgeInfo{}, fmt.Errorf("net.ParseMAC: %w", err)
 	}
 
 	// get interfaceDir from sysfs
-	interfaceDir, err := os.ReadDir(path.Join(BRCTL_SYS_NET, name, BRCTL_BRIDGE_INTERFACE))
+	interfaceDir, err := os.ReadDir(path.Join(sysfsPath, name, BRCTL_BRIDGE_INTERFACE))
 	if err != nil {
 		return BridgeInfo{}, fmt.Errorf("os.ReadDir: %w", err)
 	}
@@ -126,10 +245,17 @@ func getBridgeInfo(name string) (BridgeInfo, error) {
 	}
 
 	return BridgeInfo{
-		Name:       name,
-		BridgeID:   strings.TrimSuffix(string(bridgeID), "\n"),
-		StpState:   stpEnabled,
-		Interfaces: interfaces,
+		Name:              name,
+		BridgeID:          strings.TrimSuffix(string(bridgeID), "\n"),
+		MACAddress:        mac,
+		RootID:            rootID,
+		RootPort:          rootPort,
+		StpMode:           StpMode(stpMode),
+		StpState:          stpMode != int(StpOff),
+		VLANFiltering:     vlanFiltering,
+		GroupFwdMask:      groupFwdMask,
+		MulticastSnooping: multicastSnooping,
+		Interfaces:        interfaces,
 	}, nil
 
 }
@@ -150,28 +276,19 @@ func showBridge(name string, out io.Writer) {
 }
 
 // Showmacs shows a list of learned MAC addresses for this bridge.
-// The mac addresses are stored in the first 6 bytes of /sys/class/net/<name>/brforward,
-// The following format applies:
-// 00-05: MAC address
-// 06-08: port number
-// 09-10: is_local
-// 11-15: timeval (ignored for now)
 func Showmacs(bridge string, out io.Writer) error {
-	// parse sysf into 0x10 byte chunks
-	brforward, err := os.ReadFile(path.Join(BRCTL_SYS_NET, bridge, BRCTL_BRFORWARD))
+	entries, err := ShowMACs(bridge)
 	if err != nil {
-		return fmt.Errorf("Readfile(%q): %w", path.Join(BRCTL_SYS_NET, bridge, BRCTL_BRFORWARD), err)
+		return err
 	}
 
-	fmt.Fprintf(out, "port no\tmac addr\t\tis_local?\n")
-
-	for i := 0; i < len(brforward); i += 0x10 {
-		chunk := brforward[i : i+0x10]
-		mac := chunk[0:6]
-		portNo := uint16(binary.BigEndian.Uint16(chunk[6:8]))
-		isLocal := uint8(chunk[9]) != 0
-
-		fmt.Fprintf(out, "%3d\t%2x:%2x:%2x:%2x:%2x:%2x\t%v\n", portNo, mac[0], mac[1], mac[2], mac[3], mac[4], mac[5], isLocal)
+	fmt.Fprintf(out, "port no\tmac addr\t\tis local?\tageing timer\n")
+	for _, e := range entries {
+		isLocal := "no"
+		if e.IsLocal {
+			isLocal = "yes"
+		}
+		fmt.Fprintf(out, "%3d\t%s\t%s\t\t%s\n", e.PortNo, e.MAC, isLocal, formatTimer(e.AgeingTimer))
 	}
 
 	return nil
@@ -179,17 +296,16 @@ func Showmacs(bridge string, out io.Writer) error {
 
 // Show will show some information on the bridge and its attached ports.
 func Show(out io.Writer, names ...string) error {
-	fmt.Fprint(out, "bridge name\tbridge id\tSTP enabled\t\tinterfaces")
+	fmt.Fprintln(out, "bridge name\tbridge id\tSTP enabled\t\tinterfaces")
 	if len(names) == 0 {
-		devices, err := os.ReadDir(BRCTL_SYS_NET)
+		devices, err := os.ReadDir(sysfsPath)
 		if err != nil {
-			return fmt.Errorf("ReadDir(%q)= %w", BRCTL_SYS_NET, err)
+			return fmt.Errorf("ReadDir(%q)= %w", sysfsPath, err)
 		}
 
 		for _, bridge := range devices {
 			// check if device is bridge, aka if it has a bridge directory
-			_, err := os.Stat(BRCTL_SYS_NET + bridge.Name() + "/bridge/")
-			if err == nil {
+			if bridgeExists(bridge.Name()) {
 				showBridge(bridge.Name(), out)
 			}
 		}
@@ -205,12 +321,19 @@ func Show(out io.Writer, names ...string) error {
 // After <time> seconds of not having seen a frame coming from a certain address,
 // the bridge will time out (delete) that address from the Forwarding DataBase (fdb).
 func Setageingtime(name string, time string) error {
+	return sysfsHandle().Setageingtime(name, time)
+}
+
+// Setageingtime is the handle version of the package level Setageingtime.
+func (b *Brctl) Setageingtime(name string, time string) (err error) {
+	defer observe("Setageingtime", name)(&err)
+
 	ageingTime, err := stringToJiffies(time)
 	if err != nil {
 		return fmt.Errorf("stringToJiffies(%q) = %w", time, err)
 	}
 
-	if err = setBridgeValue(name, BRCTL_AGEING_TIME, []byte(strconv.Itoa(ageingTime)), uint64(BRCTL_SET_AEGING_TIME)); err != nil {
+	if err = b.setBridgeValue(name, BRCTL_AGEING_TIME, []byte(strconv.Itoa(ageingTime))); err != nil {
 		return fmt.Errorf("setBridgeValue: %w", err)
 	}
 	return nil
@@ -222,6 +345,13 @@ func Setageingtime(name string, time string) error {
 // > If <state> is "on" or "yes"  the STP  will  be turned on, otherwise it will be turned off
 // So this is actually the described behavior, not checking for "off" and "no"
 func Stp(bridge string, state string) error {
+	return sysfsHandle().Stp(bridge, state)
+}
+
+// Stp is the handle version of the package level Stp.
+func (b *Brctl) Stp(bridge string, state string) (err error) {
+	defer observe("Stp", bridge)(&err)
+
 	var stpState int
 	if state == "on" || state == "yes" {
 		stpState = 1
@@ -229,7 +359,7 @@ func Stp(bridge string, state string) error {
 		stpState = 0
 	}
 
-	if err := setBridgeValue(bridge, BRCTL_STP_STATE, []byte(strconv.Itoa(stpState)), uint64(BRCTL_SET_BRIDGE_PRIORITY)); err != nil {
+	if err := b.setBridgeValue(bridge, BRCTL_STP_STATE, []byte(strconv.Itoa(stpState))); err != nil {
 		return fmt.Errorf("setBridgeValue: %w", err)
 	}
 
@@ -240,13 +370,20 @@ func Stp(bridge string, state string) error {
 // The priority value is an unsigned 8-bit quantity (a number between 0 and 255),
 // and has no dimension. This metric is used in the designated port and root port selection algorithms.
 func Setbridgeprio(bridge string, bridgePriority string) error {
+	return sysfsHandle().Setbridgeprio(bridge, bridgePriority)
+}
+
+// Setbridgeprio is the handle version of the package level Setbridgeprio.
+func (b *Brctl) Setbridgeprio(bridge string, bridgePriority string) (err error) {
+	defer observe("Setbridgeprio", bridge)(&err)
+
 	// parse bridgePriority to int
 	prio, err := strconv.Atoi(bridgePriority)
 	if err != nil {
 		return err
 	}
 
-	if err := setBridgeValue(bridge, BRCTL_BRIDGE_PRIO, []byte(strconv.Itoa(prio)), 0); err != nil {
+	if err := b.setBridgeValue(bridge, BRCTL_BRIDGE_PRIO, []byte(strconv.Itoa(prio))); err != nil {
 		return fmt.Errorf("setBridgeValue %w", err)
 	}
 
@@ -255,12 +392,19 @@ func Setbridgeprio(bridge string, bridgePriority string) error {
 
 // Setfd sets the bridge's 'bridge forward delay' to <time> seconds.
 func Setfd(bridge string, time string) error {
+	return sysfsHandle().Setfd(bridge, time)
+}
+
+// Setfd is the handle version of the package level Setfd.
+func (b *Brctl) Setfd(bridge string, time string) (err error) {
+	defer observe("Setfd", bridge)(&err)
+
 	forwardDelay, err := stringToJiffies(time)
 	if err != nil {
 		return fmt.Errorf("stringToJiffies(%q) = %w", time, err)
 	}
 
-	if err := setBridgeValue(bridge, BRCTL_FORWARD_DELAY, []byte(strconv.Itoa(forwardDelay)), 0); err != nil {
+	if err := b.setBridgeValue(bridge, BRCTL_FORWARD_DELAY, []byte(strconv.Itoa(forwardDelay))); err != nil {
 		return fmt.Errorf("setBridgeValue: %w", err)
 	}
 
@@ -269,12 +413,19 @@ func Setfd(bridge string, time string) error {
 
 // Sethello sets the bridge's 'bridge hello time' to <time> seconds.
 func Sethello(bridge string, time string) error {
+	return sysfsHandle().Sethello(bridge, time)
+}
+
+// Sethello is the handle version of the package level Sethello.
+func (b *Brctl) Sethello(bridge string, time string) (err error) {
+	defer observe("Sethello", bridge)(&err)
+
 	helloTime, err := stringToJiffies(time)
 	if err != nil {
 		return fmt.Errorf("stringToJiffies(%q) = %w", time, err)
 	}
 
-	if err := setBridgeValue(bridge, BRCTL_HELLO_TIME, []byte(strconv.Itoa(helloTime)), 0); err != nil {
+	if err := b.setBridgeValue(bridge, BRCTL_HELLO_TIME, []byte(strconv.Itoa(helloTime))); err != nil {
 		return fmt.Errorf("setBridgeValue: %w", err)
 	}
 
@@ -283,12 +434,19 @@ func Sethello(bridge string, time string) error {
 
 // Setmaxage sets the bridge's 'maximum message age' to <time> seconds.
 func Setmaxage(bridge string, time string) error {
+	return sysfsHandle().Setmaxage(bridge, time)
+}
+
+// Setmaxage is the handle version of the package level Setmaxage.
+func (b *Brctl) Setmaxage(bridge string, time string) (err error) {
+	defer observe("Setmaxage", bridge)(&err)
+
 	maxAge, err := stringToJiffies(time)
 	if err != nil {
 		return fmt.Errorf("stringToJiffies(%q) = %w", time, err)
 	}
 
-	if err := setBridgeValue(bridge, BRCTL_MAX_AGE, []byte(strconv.Itoa(maxAge)), 0); err != nil {
+	if err := b.setBridgeValue(bridge, BRCTL_MAX_AGE, []byte(strconv.Itoa(maxAge))); err != nil {
 		return fmt.Errorf("setBridgeValue: %w", err)
 	}
 
@@ -297,12 +455,19 @@ func Setmaxage(bridge string, time string) error {
 
 // Setpathcost sets the port cost of the port <port> to <cost>. This is a dimensionless metric.
 func Setpathcost(bridge string, port string, cost string) error {
+	return sysfsHandle().Setpathcost(bridge, port, cost)
+}
+
+// Setpathcost is the handle version of the package level Setpathcost.
+func (b *Brctl) Setpathcost(bridge string, port string, cost string) (err error) {
+	defer observe("Setpathcost", port)(&err)
+
 	pathCost, err := strconv.ParseUint(cost, 10, 64)
 	if err != nil {
 		return err
 	}
 
-	err = setPortBrportValue(port, BRCTL_PATH_COST, append([]byte(strconv.FormatUint(pathCost, 10)), BRCTL_SYS_SUFFIX))
+	err = b.setPortBrportValue(port, BRCTL_PATH_COST, append([]byte(strconv.FormatUint(pathCost, 10)), BRCTL_SYS_SUFFIX))
 	if err != nil {
 		return fmt.Errorf("setPortBrportValue: %w", err)
 	}
@@ -314,16 +479,30 @@ func Setpathcost(bridge string, port string, cost string) error {
 // The priority value is an unsigned 8-bit quantity (a number between 0 and 255),
 // and has no dimension. This metric is used in the designated port and root port selection algorithms.
 func Setportprio(bridge string, port string, prio string) error {
+	return sysfsHandle().Setportprio(bridge, port, prio)
+}
+
+// Setportprio is the handle version of the package level Setportprio.
+func (b *Brctl) Setportprio(bridge string, port string, prio string) (err error) {
+	defer observe("Setportprio", port)(&err)
+
 	portPriority, err := strconv.Atoi(prio)
 	if err != nil {
 		return err
 	}
 
-	return setPortBrportValue(port, BRCTL_PRIORITY, []byte(strconv.Itoa(portPriority)))
+	return b.setPortBrportValue(port, BRCTL_PRIORITY, []byte(strconv.Itoa(portPriority)))
 }
 
 // Hairpin sets the hairpin mode of the <port> attached to <bridge>
 func Hairpin(bridge string, port string, hairpinmode string) error {
+	return sysfsHandle().Hairpin(bridge, port, hairpinmode)
+}
+
+// Hairpin is the handle version of the package level Hairpin.
+func (b *Brctl) Hairpin(bridge string, port string, hairpinmode string) (err error) {
+	defer observe("Hairpin", port)(&err)
+
 	var hairpinMode string
 	if hairpinmode == "on" {
 		hairpinMode = "1"
@@ -331,7 +510,33 @@ func Hairpin(bridge string, port string, hairpinmode string) error {
 		hairpinMode = "0"
 	}
 
-	if err := setPortBrportValue(port, BRCTL_HAIRPIN, []byte(hairpinMode)); err != nil {
+	if err := b.setPortBrportValue(port, BRCTL_HAIRPIN, []byte(hairpinMode)); err != nil {
+		return fmt.Errorf("setPortBrportValue: %w", err)
+	}
+
+	return nil
+}
+
+// SetHairpin turns hairpin mode, also called reflective relay, of iface on or
+// off. In hairpin mode the bridge may send frames back out of the port they
+// arrived on. Unlike Hairpin, it fails if iface is not a port of bridge.
+func SetHairpin(bridge, iface string, enabled bool) error {
+	return sysfsHandle().SetHairpin(bridge, iface, enabled)
+}
+
+// SetHairpin is the handle version of the package level SetHairpin.
+func (b *Brctl) SetHairpin(bridge, iface string, enabled bool) (err error) {
+	defer observe("SetHairpin", iface)(&err)
+
+	if _, err := os.Stat(path.Join(b.sysfs, bridge, BRCTL_BRIDGE_INTERFACE, iface)); err != nil {
+		return fmt.Errorf("%s: %w %s", iface, errNotPort, bridge)
+	}
+
+	value := "0"
+	if enabled {
+		value = "1"
+	}
+	if err := b.setPortBrportValue(iface, BRCTL_HAIRPIN, []byte(value)); err != nil {
 		return fmt.Errorf("setPortBrportValue: %w", err)
 	}
 
@@ -6,13 +6,16 @@ package brctl
 
 import (
 	"bytes"
+	"errors"
 	"fmt"
+	"math"
 	"net"
 	"os"
 	"path"
 	"strconv"
 	"strings"
 	"testing"
+	"time"
 
 	"github.com/hugelgupf/vmtest/guest"
 )
@@ -90,16 +93,56 @@ var test_str_to_jiffies = []struct {
 		true,
 		fmt.Errorf("ParseDuration(\"1.5\") = time: missing unit in duration \"1.5\""),
 	},
+	{"5ms at 100 Hz rounds up", "5ms", 100, 1, false, nil},
+	{"5ms at 250 Hz rounds down", "5ms", 250, 1, false, nil},
+	{"3ms at 250 Hz", "3ms", 250, 1, false, nil},
+	{"2s at 100 Hz", "2s", 100, 200, false, nil},
+	{"2s at 250 Hz", "2s", 250, 500, false, nil},
+	{"1h at 100 Hz", "1h", 100, 360000, false, nil},
+	{"1h at 250 Hz", "1h", 250, 900000, false, nil},
+	{"zero", "0s", 250, 0, false, nil},
+	{
+		"below half a jiffy",
+		"4ms",
+		100,
+		0,
+		true,
+		fmt.Errorf("4ms: %w", errBelowJiffy),
+	},
+	{
+		"overflow at 100 Hz",
+		"6000h",
+		100,
+		0,
+		true,
+		fmt.Errorf("6000h0m0s: %w", errJiffiesOverflow),
+	},
+	{
+		"overflow at 250 Hz",
+		"2500h",
+		250,
+		0,
+		true,
+		fmt.Errorf("2500h0m0s: %w", errJiffiesOverflow),
+	},
 }
 
 func TestStringToJiffies(t *testing.T) {
+	old := sysconfhz
+	defer func() { sysconfhz = old }()
+
 	for _, tt := range test_str_to_jiffies {
 		t.Run(tt.name, func(t *testing.T) {
+			sysconfhz = func() (int, error) { return tt.hz, nil }
 			jiffies, err := stringToJiffies(tt.duration)
 			if err != nil && !tt.wanterr {
 				t.Fatalf("stringToJiffies(%q, %d) = '%v', want nil", tt.duration, tt.hz, err)
 			}
 
+			if err == nil && tt.wanterr {
+				t.Fatalf("stringToJiffies(%q, %d) = nil, want '%v'", tt.duration, tt.hz, tt.err)
+			}
+
 			if err != nil && tt.wanterr {
 				if err.Error() != tt.err.Error() {
 					t.Fatalf("stringToJiffies(%q, %d) = '%v', want '%v'", tt.duration, tt.hz, err, tt.err)
@@ -606,3 +649,63 @@ func TestHairpin(t *testing.T) {
 		t.Fatalf("br_get_val(%q, \"hairpin_mode\") = %q, want %q", TEST_BRIDGE, hairpin, TEST_VALUE)
 	}
 }
+
+func TestSetHairpin(t *testing.T) {
+	root := fakeSysfs(t, map[string]string{
+		"br0/brif/eth0/.keep":      "",
+		"eth0/brport/hairpin_mode": "0\n",
+		"eth1/brport/hairpin_mode": "0\n",
+	})
+
+	for _, enabled := range []bool{true, false} {
+		if err := SetHairpin("br0", "eth0", enabled); err != nil {
+			t.Fatalf("SetHairpin(br0, eth0, %t) = %v, want nil", enabled, err)
+		}
+		want := "0"
+		if enabled {
+			want = "1"
+		}
+		if got := readSysfs(t, root, "eth0/brport/hairpin_mode"); got != want {
+			t.Errorf("SetHairpin(br0, eth0, %t) wrote %q, want %q", enabled, got, want)
+		}
+	}
+
+	// eth1 is a port, but not of br0.
+	if err := SetHairpin("br0", "eth1", true); !errors.Is(err, errNotPort) {
+		t.Errorf("SetHairpin(br0, eth1, true) = %v, want %v", err, errNotPort)
+	}
+	if got := readSysfs(t, root, "eth1/brport/hairpin_mode"); got != "0" {
+		t.Errorf("SetHairpin(br0, eth1, true) wrote %q, want no write", got)
+	}
+}
+
+func TestJiffiesToDuration(t *testing.T) {
+	old := sysconfhz
+	defer func() { sysconfhz = old }()
+
+	for _, tt := range []struct {
+		hz      int
+		jiffies int
+		want    time.Duration
+	}{
+		{hz: 100, jiffies: 1, want: 10 * time.Millisecond},
+		{hz: 100, jiffies: 200, want: 2 * time.Second},
+		{hz: 250, jiffies: 1, want: 4 * time.Millisecond},
+		{hz: 250, jiffies: 900000, want: time.Hour},
+		{hz: 250, jiffies: math.MaxInt32, want: 8589934588 * time.Millisecond},
+	} {
+		sysconfhz = func() (int, error) { return tt.hz, nil }
+		got, err := jiffiesToDuration(tt.jiffies)
+		if err != nil {
+			t.Fatalf("jiffiesToDuration(%d) at %d Hz = %v, want nil", tt.jiffies, tt.hz, err)
+		}
+		if got != tt.want {
+			t.Errorf("jiffiesToDuration(%d) at %d Hz = %v, want %v", tt.jiffies, tt.hz, got, tt.want)
+		}
+
+		// Converting back yields the same jiffies.
+		if j, err := durationToJiffies(got); err != nil || j != tt.jiffies {
+			t.Errorf("durationToJiffies(%v) at %d Hz = %d, %v, want %d", got, tt.hz, j, err, tt.jiffies)
+		}
+	}
+}
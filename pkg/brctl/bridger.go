@@ -0,0 +1,166 @@
+// Copyright 2024 the u-root Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package brctl
+
+import (
+	"errors"
+	"fmt"
+	"net"
+
+	"github.com/vishvananda/netlink/nl"
+	"golang.org/x/sys/unix"
+)
+
+// Bridger creates and deletes bridges and attaches and detaches their ports.
+// NewIoctlBridger does so with the bridge ioctls, like Addbr and friends,
+// NewNetlinkBridger with rtnetlink.
+type Bridger interface {
+	// AddBridge creates the bridge name.
+	AddBridge(name string) error
+	// DeleteBridge deletes the bridge name.
+	DeleteBridge(name string) error
+	// AddIf attaches iface to bridge and applies the bridge's
+	// PortDefaults to it.
+	AddIf(bridge, iface string) error
+	// DeleteIf detaches iface from bridge.
+	DeleteIf(bridge, iface string) error
+}
+
+var errNotBridge = errors.New("not a bridge")
+
+// ioctlBridger implements Bridger with the ioctls of a handle.
+type ioctlBridger struct {
+	b *Brctl
+}
+
+// NewIoctlBridger returns a Bridger issuing ioctls on the control socket of
+// b. Closing b closes the Bridger, too.
+func NewIoctlBridger(b *Brctl) Bridger {
+	return ioctlBridger{b: b}
+}
+
+func (i ioctlBridger) AddBridge(name string) error {
+	return i.b.Addbr(name)
+}
+
+func (i ioctlBridger) DeleteBridge(name string) error {
+	return i.b.Delbr(name)
+}
+
+func (i ioctlBridger) AddIf(bridge, iface string) error {
+	return i.b.Addif(bridge, iface)
+}
+
+func (i ioctlBridger) DeleteIf(bridge, iface string) error {
+	return i.b.Delif(bridge, iface)
+}
+
+// linkIndex returns the ifindex of the named device. Go resolves it with
+// rtnetlink, so no ioctl socket is needed. Tests replace it.
+var linkIndex = func(name string) (int, error) {
+	ifi, err := net.InterfaceByName(name)
+	if err != nil {
+		return 0, err
+	}
+	return ifi.Index, nil
+}
+
+// linkName returns the name of the device with the given ifindex. Tests
+// replace it.
+var linkName = func(index int) (string, error) {
+	ifi, err := net.InterfaceByIndex(index)
+	if err != nil {
+		return "", err
+	}
+	return ifi.Name, nil
+}
+
+// netlinkBridger implements Bridger with rtnetlink requests.
+type netlinkBridger struct{}
+
+// NewNetlinkBridger returns a Bridger using rtnetlink instead of the bridge
+// ioctls. Each operation uses a netlink socket of its own, so there is nothing
+// to close.
+func NewNetlinkBridger() Bridger {
+	return netlinkBridger{}
+}
+
+// AddBridge creates the bridge with an RTM_NEWLINK request of kind "bridge".
+func (netlinkBridger) AddBridge(name string) (err error) {
+	defer observe("AddBridge", name)(&err)
+
+	req := nl.NewNetlinkRequest(unix.RTM_NEWLINK, unix.NLM_F_CREATE|unix.NLM_F_EXCL|unix.NLM_F_ACK)
+	req.AddData(nl.NewIfInfomsg(unix.AF_UNSPEC))
+	req.AddData(nl.NewRtAttr(unix.IFLA_IFNAME, nl.ZeroTerminated(name)))
+	linkInfo := nl.NewRtAttr(unix.IFLA_LINKINFO, nil)
+	linkInfo.AddRtAttr(nl.IFLA_INFO_KIND, nl.NonZeroTerminated("bridge"))
+	req.AddData(linkInfo)
+
+	if err := netlinkExecute(req); err != nil {
+		return fmt.Errorf("creating bridge %s: %w", name, err)
+	}
+	return nil
+}
+
+// DeleteBridge deletes the bridge with an RTM_DELLINK request. Unlike
+// SIOCBRDELBR, RTM_DELLINK deletes devices of any kind, so other devices are
+// refused first.
+func (netlinkBridger) DeleteBridge(name string) (err error) {
+	defer observe("DeleteBridge", name)(&err)
+
+	if !bridgeExists(name) {
+		return fmt.Errorf("%s: %w", name, errNotBridge)
+	}
+
+	req := nl.NewNetlinkRequest(unix.RTM_DELLINK, unix.NLM_F_ACK)
+	req.AddData(nl.NewIfInfomsg(unix.AF_UNSPEC))
+	req.AddData(nl.NewRtAttr(unix.IFLA_IFNAME, nl.ZeroTerminated(name)))
+
+	if err := netlinkExecute(req); err != nil {
+		return fmt.Errorf("deleting bridge %s: %w", name, err)
+	}
+	return nil
+}
+
+// AddIf attaches iface by setting its master to the bridge.
+func (netlinkBridger) AddIf(bridge, iface string) (err error) {
+	defer observe("AddIf", iface)(&err)
+
+	index, err := linkIndex(bridge)
+	if err != nil {
+		return fmt.Errorf("linkIndex: %w", err)
+	}
+	if err := netlinkExecute(masterRequest(iface, uint32(index))); err != nil {
+		return fmt.Errorf("attaching %s to %s: %w", iface, bridge, err)
+	}
+
+	// The port stays attached if the policy cannot be applied.
+	return sysfsHandle().applyPortDefaults(bridge, iface)
+}
+
+// DeleteIf detaches iface by clearing its master. Clearing the master
+// detaches iface from any bridge or bond, so ports of other masters are
+// refused first.
+func (netlinkBridger) DeleteIf(bridge, iface string) (err error) {
+	defer observe("DeleteIf", iface)(&err)
+
+	if !isPortOf(bridge, iface) {
+		return fmt.Errorf("%s: %w %s", iface, errNotPort, bridge)
+	}
+	if err := netlinkExecute(masterRequest(iface, 0)); err != nil {
+		return fmt.Errorf("detaching %s from %s: %w", iface, bridge, err)
+	}
+	return nil
+}
+
+// masterRequest builds the RTM_SETLINK request setting the master of iface to
+// the device with the given ifindex, or clearing it for 0.
+func masterRequest(iface string, master uint32) *nl.NetlinkRequest {
+	req := nl.NewNetlinkRequest(unix.RTM_SETLINK, unix.NLM_F_ACK)
+	req.AddData(nl.NewIfInfomsg(unix.AF_UNSPEC))
+	req.AddData(nl.NewRtAttr(unix.IFLA_IFNAME, nl.ZeroTerminated(iface)))
+	req.AddData(nl.NewRtAttr(unix.IFLA_MASTER, nl.Uint32Attr(master)))
+	return req
+}
@@ -0,0 +1,174 @@
+// Copyright 2024 the u-root Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package brctl
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+	"syscall"
+	"testing"
+
+	"github.com/vishvananda/netlink/nl"
+	"golang.org/x/sys/unix"
+)
+
+// linkRequest is a decoded rtnetlink link request.
+type linkRequest struct {
+	typ    uint16
+	flags  uint16
+	ifname string
+	kind   string
+	master *uint32
+}
+
+func parseLinkRequest(t *testing.T, b []byte) linkRequest {
+	t.Helper()
+	msgs, err := syscall.ParseNetlinkMessage(b)
+	if err != nil || len(msgs) != 1 {
+		t.Fatalf("ParseNetlinkMessage = %v, %v, want 1 message", msgs, err)
+	}
+	attrs, err := nl.ParseRouteAttr(msgs[0].Data[unix.SizeofIfInfomsg:])
+	if err != nil {
+		t.Fatalf("ParseRouteAttr = %v, want nil", err)
+	}
+
+	req := linkRequest{typ: msgs[0].Header.Type, flags: msgs[0].Header.Flags}
+	for _, a := range attrs {
+		switch a.Attr.Type {
+		case unix.IFLA_IFNAME:
+			req.ifname = string(a.Value[:len(a.Value)-1])
+		case unix.IFLA_MASTER:
+			master := nl.NativeEndian().Uint32(a.Value)
+			req.master = &master
+		case unix.IFLA_LINKINFO:
+			info, err := nl.ParseRouteAttr(a.Value)
+			if err != nil {
+				t.Fatalf("ParseRouteAttr = %v, want nil", err)
+			}
+			for _, i := range info {
+				if i.Attr.Type == nl.IFLA_INFO_KIND {
+					req.kind = string(i.Value)
+				}
+			}
+		default:
+			t.Errorf("unexpected attribute %d", a.Attr.Type)
+		}
+	}
+	return req
+}
+
+func TestNetlinkBridger(t *testing.T) {
+	fakeSysfs(t, map[string]string{
+		"br0/bridge/bridge_id": "8000.020000000000\n",
+		"br0/brif/eth0/.keep":  "",
+		"eth1/operstate":       "up\n",
+	})
+	old := linkIndex
+	linkIndex = func(name string) (int, error) {
+		if name != "br0" {
+			return 0, fmt.Errorf("no index for %s", name)
+		}
+		return 7, nil
+	}
+	t.Cleanup(func() { linkIndex = old })
+	reqs := recordNetlink(t, nil)
+
+	b := NewNetlinkBridger()
+	for _, op := range []struct {
+		name string
+		f    func() error
+	}{
+		{"AddBridge(br1)", func() error { return b.AddBridge("br1") }},
+		{"AddIf(br0, eth1)", func() error { return b.AddIf("br0", "eth1") }},
+		{"DeleteIf(br0, eth0)", func() error { return b.DeleteIf("br0", "eth0") }},
+		{"DeleteBridge(br0)", func() error { return b.DeleteBridge("br0") }},
+	} {
+		if err := op.f(); err != nil {
+			t.Fatalf("%s = %v, want nil", op.name, err)
+		}
+	}
+
+	var got []linkRequest
+	for _, req := range *reqs {
+		got = append(got, parseLinkRequest(t, req))
+	}
+	seven, zero := uint32(7), uint32(0)
+	want := []linkRequest{
+		{typ: unix.RTM_NEWLINK, flags: unix.NLM_F_REQUEST | unix.NLM_F_CREATE | unix.NLM_F_EXCL | unix.NLM_F_ACK, ifname: "br1", kind: "bridge"},
+		{typ: unix.RTM_SETLINK, flags: unix.NLM_F_REQUEST | unix.NLM_F_ACK, ifname: "eth1", master: &seven},
+		{typ: unix.RTM_SETLINK, flags: unix.NLM_F_REQUEST | unix.NLM_F_ACK, ifname: "eth0", master: &zero},
+		{typ: unix.RTM_DELLINK, flags: unix.NLM_F_REQUEST | unix.NLM_F_ACK, ifname: "br0"},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("netlink requests = %+v, want %+v", got, want)
+	}
+}
+
+func TestNetlinkBridgerRefuses(t *testing.T) {
+	fakeSysfs(t, map[string]string{
+		"br0/bridge/bridge_id": "8000.020000000000\n",
+		"eth0/operstate":       "up\n",
+	})
+	reqs := recordNetlink(t, nil)
+
+	b := NewNetlinkBridger()
+	if err := b.DeleteBridge("eth0"); !errors.Is(err, errNotBridge) {
+		t.Errorf("DeleteBridge(eth0) = %v, want %v", err, errNotBridge)
+	}
+	if err := b.DeleteIf("br0", "eth0"); !errors.Is(err, errNotPort) {
+		t.Errorf("DeleteIf(br0, eth0) = %v, want %v", err, errNotPort)
+	}
+	if len(*reqs) != 0 {
+		t.Errorf("sent %d netlink requests, want none", len(*reqs))
+	}
+}
+
+func TestNetlinkBridgerError(t *testing.T) {
+	reqs := recordNetlink(t, syscall.EEXIST)
+
+	if err := NewNetlinkBridger().AddBridge("br0"); !errors.Is(err, syscall.EEXIST) {
+		t.Errorf("AddBridge(br0) = %v, want %v", err, syscall.EEXIST)
+	}
+	if len(*reqs) != 1 {
+		t.Errorf("sent %d netlink requests, want 1", len(*reqs))
+	}
+}
+
+func TestIoctlBridger(t *testing.T) {
+	calls := fakeIoctls(t, 0, nil)
+
+	h, err := New()
+	if err != nil {
+		t.Fatalf("New() = %v, want nil", err)
+	}
+	defer h.Close()
+
+	b := NewIoctlBridger(h)
+	if err := b.AddBridge("br0"); err != nil {
+		t.Fatalf("AddBridge(br0) = %v, want nil", err)
+	}
+	if err := b.AddIf("br0", "eth0"); err != nil {
+		t.Fatalf("AddIf(br0, eth0) = %v, want nil", err)
+	}
+	if err := b.DeleteIf("br0", "eth0"); err != nil {
+		t.Fatalf("DeleteIf(br0, eth0) = %v, want nil", err)
+	}
+	if err := b.DeleteBridge("br0"); err != nil {
+		t.Fatalf("DeleteBridge(br0) = %v, want nil", err)
+	}
+
+	want := []string{
+		fmt.Sprintf("%#x br0", unix.SIOCBRADDBR),
+		fmt.Sprintf("%#x eth0", unix.SIOCGIFINDEX),
+		fmt.Sprintf("%#x br0 %#x", unix.SIOCBRADDIF, 1),
+		fmt.Sprintf("%#x eth0", unix.SIOCGIFINDEX),
+		fmt.Sprintf("%#x br0 %#x", unix.SIOCBRDELIF, 1),
+		fmt.Sprintf("%#x br0", unix.SIOCBRDELBR),
+	}
+	if !reflect.DeepEqual(*calls, want) {
+		t.Errorf("ioctls = %q, want %q", *calls, want)
+	}
+}
@@ -33,4 +33,50 @@ const (
 	BRCTL_BRFORWARD        = "brforward"
 	BRCTL_BRIDGEID         = "bridge_id"
 	BRCTL_BRIDGE_INTERFACE = "brif"
+	BRCTL_GC_TIMER         = "gc_timer"
+	BRCTL_CARRIER          = "carrier"
+	BRCTL_OPERSTATE        = "operstate"
+	BRCTL_ADDRESS          = "address"
+	BRCTL_SPEED            = "speed"
+	BRCTL_DUPLEX           = "duplex"
+	BRCTL_FLAGS            = "flags"
+	BRCTL_TX_QUEUE_LEN     = "tx_queue_len"
+	BRCTL_GROUP_FWD_MASK   = "group_fwd_mask"
+
+	BRCTL_VLAN_FILTERING      = "vlan_filtering"
+	BRCTL_VLAN_STATS_ENABLED  = "vlan_stats_enabled"
+	BRCTL_VLAN_STATS_PER_PORT = "vlan_stats_per_port"
+	BRCTL_VLAN_TUNNEL         = "vlan_tunnel"
+	BRCTL_VLAN_PROTOCOL       = "vlan_protocol"
+	BRCTL_DEFAULT_PVID        = "default_pvid"
+
+	BRCTL_LEARNING        = "learning"
+	BRCTL_UNICAST_FLOOD   = "unicast_flood"
+	BRCTL_MULTICAST_FLOOD = "multicast_flood"
+	BRCTL_BROADCAST_FLOOD = "broadcast_flood"
+
+	BRCTL_MULTICAST_SNOOPING         = "multicast_snooping"
+	BRCTL_MULTICAST_QUERIER          = "multicast_querier"
+	BRCTL_MULTICAST_QUERY_USE_IFADDR = "multicast_query_use_ifaddr"
+	BRCTL_MULTICAST_ROUTER           = "multicast_router"
+
+	BRCTL_PORT_STATE          = "state"
+	BRCTL_FORWARD_DELAY_TIMER = "forward_delay_timer"
+	BRCTL_PORT_NO             = "port_no"
+	BRCTL_PORT_ID             = "port_id"
+	BRCTL_DESIGNATED_BRIDGE   = "designated_bridge"
+	BRCTL_DESIGNATED_PORT     = "designated_port"
+
+	BRCTL_ROOT_ID        = "root_id"
+	BRCTL_ROOT_PORT      = "root_port"
+	BRCTL_ROOT_PATH_COST = "root_path_cost"
+)
+
+// STP port states as shown in brport/state.
+const (
+	BR_STATE_DISABLED   = 0
+	BR_STATE_LISTENING  = 1
+	BR_STATE_LEARNING   = 2
+	BR_STATE_FORWARDING = 3
+	BR_STATE_BLOCKING   = 4
 )
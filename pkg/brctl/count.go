@@ -0,0 +1,45 @@
+// Copyright 2024 the u-root Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package brctl
+
+import (
+	"fmt"
+	"os"
+	"path"
+)
+
+// PortCount returns the number of ports attached to the bridge.
+func PortCount(bridge string) (int, error) {
+	ports, err := os.ReadDir(path.Join(sysfsPath, bridge, BRCTL_BRIDGE_INTERFACE))
+	if err != nil {
+		return 0, fmt.Errorf("os.ReadDir: %w", err)
+	}
+	return len(ports), nil
+}
+
+// FDBCount returns the number of entries in the bridge's forwarding database,
+// without decoding them like ShowMACs does.
+func FDBCount(bridge string) (int, error) {
+	p := path.Join(sysfsPath, bridge, BRCTL_BRFORWARD)
+	fi, err := os.Stat(p)
+	if err != nil {
+		return 0, fmt.Errorf("os.Stat: %w", err)
+	}
+
+	size := fi.Size()
+	// The kernel does not know the size of brforward in advance and
+	// reports 0, so it has to be read.
+	if size == 0 {
+		brforward, err := readFile(p)
+		if err != nil {
+			return 0, fmt.Errorf("Readfile(%q): %w", p, err)
+		}
+		size = int64(len(brforward))
+	}
+	if size%fdbEntrySize != 0 {
+		return 0, fmt.Errorf("brforward size %d is not a multiple of %d", size, fdbEntrySize)
+	}
+	return int(size / fdbEntrySize), nil
+}
@@ -0,0 +1,82 @@
+// Copyright 2024 the u-root Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package brctl
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestPortCount(t *testing.T) {
+	fakeSysfs(t, map[string]string{
+		"br0/brif/eth0/.keep": "",
+		"br0/brif/eth1/.keep": "",
+		"br0/brif/eth2/.keep": "",
+		"br1/bridge/priority": "32768\n",
+	})
+	if err := os.MkdirAll(filepath.Join(sysfsPath, "br1", "brif"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, tt := range []struct {
+		bridge  string
+		want    int
+		wantErr error
+	}{
+		{bridge: "br0", want: 3},
+		{bridge: "br1", want: 0},
+		{bridge: "br2", wantErr: os.ErrNotExist},
+	} {
+		got, err := PortCount(tt.bridge)
+		if !errors.Is(err, tt.wantErr) || got != tt.want {
+			t.Errorf("PortCount(%q) = (%d, %v), want (%d, %v)", tt.bridge, got, err, tt.want, tt.wantErr)
+		}
+	}
+}
+
+func TestFDBCount(t *testing.T) {
+	brforward := bytes.Join([][]byte{
+		fdbRecord("00:11:22:33:44:55", 1, true, 0),
+		fdbRecord("66:77:88:99:aa:bb", 2, false, 100),
+		fdbRecord("66:77:88:99:aa:cc", 2, false, 200),
+	}, nil)
+	fakeSysfs(t, map[string]string{
+		"br0/brforward": string(brforward),
+		"br1/brforward": "",
+		"br2/brforward": "short",
+	})
+
+	for _, tt := range []struct {
+		bridge  string
+		want    int
+		wantErr bool
+	}{
+		{bridge: "br0", want: 3},
+		{bridge: "br1", want: 0},
+		{bridge: "br2", wantErr: true},
+		{bridge: "br3", wantErr: true},
+	} {
+		got, err := FDBCount(tt.bridge)
+		if (err != nil) != tt.wantErr || got != tt.want {
+			t.Errorf("FDBCount(%q) = (%d, %v), want (%d, error %t)", tt.bridge, got, err, tt.want, tt.wantErr)
+		}
+	}
+}
+
+func TestFDBCountZeroSize(t *testing.T) {
+	// sysfs reports a size of 0 for brforward regardless of its content.
+	brforward := append(fdbRecord("00:11:22:33:44:55", 1, true, 0), fdbRecord("66:77:88:99:aa:bb", 2, false, 100)...)
+	fakeSysfs(t, map[string]string{"br0/brforward": ""})
+	old := readFile
+	readFile = func(string) ([]byte, error) { return brforward, nil }
+	t.Cleanup(func() { readFile = old })
+
+	if got, err := FDBCount("br0"); err != nil || got != 2 {
+		t.Errorf("FDBCount(%q) = (%d, %v), want (2, nil)", "br0", got, err)
+	}
+}
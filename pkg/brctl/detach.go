@@ -0,0 +1,31 @@
+// Copyright 2024 the u-root Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package brctl
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path"
+)
+
+// DetachAllPorts detaches all ports from the bridge. Ports that are detached
+// concurrently, e.g. because they disappear, are not an error. The errors of
+// the remaining ports are joined, so one failing port does not keep the others
+// attached.
+func DetachAllPorts(bridge string) error {
+	ports, err := os.ReadDir(path.Join(sysfsPath, bridge, BRCTL_BRIDGE_INTERFACE))
+	if err != nil {
+		return fmt.Errorf("os.ReadDir: %w", err)
+	}
+
+	var errs []error
+	for _, port := range ports {
+		if err := Delif(bridge, port.Name()); err != nil && isPortOf(bridge, port.Name()) {
+			errs = append(errs, fmt.Errorf("Delif(%q, %q): %w", bridge, port.Name(), err))
+		}
+	}
+	return errors.Join(errs...)
+}
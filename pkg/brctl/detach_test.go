@@ -0,0 +1,108 @@
+// Copyright 2024 the u-root Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package brctl
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"golang.org/x/sys/unix"
+)
+
+func TestDetachAllPorts(t *testing.T) {
+	errBusy := errors.New("device busy")
+	for _, tt := range []struct {
+		name string
+		// detached ports vanish from brif just before their Delif, which
+		// then fails like the kernel's does.
+		detached []string
+		fail     map[string]error
+		wantErr  []error
+	}{
+		{
+			name: "two ports",
+		},
+		{
+			name:     "already detached",
+			detached: []string{"eth0"},
+		},
+		{
+			name:    "one port fails",
+			fail:    map[string]error{"eth0": errBusy},
+			wantErr: []error{errBusy},
+		},
+		{
+			name:    "both ports fail",
+			fail:    map[string]error{"eth0": errBusy, "eth1": unix.EPERM},
+			wantErr: []error{errBusy, unix.EPERM},
+		},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			root := fakeSysfs(t, map[string]string{
+				"br0/brif/eth0/.keep": "",
+				"br0/brif/eth1/.keep": "",
+			})
+			calls := fakeIoctls(t, 0, nil)
+
+			// The ioctls cannot tell the ports apart, so track them
+			// by the SIOCGIFINDEX preceding each SIOCBRDELIF.
+			var port string
+			fakeIfreq := ioctlIfreq
+			ioctlIfreq = func(fd int, req uint, ifr *unix.Ifreq) error {
+				if err := fakeIfreq(fd, req, ifr); err != nil {
+					return err
+				}
+				switch req {
+				case unix.SIOCGIFINDEX:
+					port = ifr.Name()
+				case unix.SIOCBRDELIF:
+					for _, d := range tt.detached {
+						if d == port {
+							if err := os.RemoveAll(filepath.Join(root, "br0/brif", port)); err != nil {
+								t.Fatal(err)
+							}
+							return unix.EINVAL
+						}
+					}
+					return tt.fail[port]
+				}
+				return nil
+			}
+
+			err := DetachAllPorts("br0")
+			for _, want := range tt.wantErr {
+				if !errors.Is(err, want) {
+					t.Errorf("DetachAllPorts() = %v, want %v", err, want)
+				}
+			}
+			if len(tt.wantErr) == 0 && err != nil {
+				t.Errorf("DetachAllPorts() = %v, want nil", err)
+			}
+
+			want := []string{
+				fmt.Sprintf("%#x eth0", unix.SIOCGIFINDEX),
+				fmt.Sprintf("%#x br0 %#x", unix.SIOCBRDELIF, 1),
+				fmt.Sprintf("%#x eth1", unix.SIOCGIFINDEX),
+				fmt.Sprintf("%#x br0 %#x", unix.SIOCBRDELIF, 1),
+			}
+			if !reflect.DeepEqual(*calls, want) {
+				t.Errorf("DetachAllPorts() ioctls = %v, want %v", *calls, want)
+			}
+		})
+	}
+}
+
+func TestDetachAllPortsNoBridge(t *testing.T) {
+	fakeSysfs(t, map[string]string{})
+	fakeIoctls(t, 0, nil)
+
+	if err := DetachAllPorts("br0"); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("DetachAllPorts() = %v, want %v", err, os.ErrNotExist)
+	}
+}
@@ -0,0 +1,198 @@
+// Copyright 2024 the u-root Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package brctl
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"path"
+	"syscall"
+	"time"
+
+	"github.com/vishvananda/netlink"
+	"github.com/vishvananda/netlink/nl"
+	"golang.org/x/sys/unix"
+)
+
+// fdbEntrySize is the size of a struct __fdb_entry record in brforward.
+const fdbEntrySize = 0x10
+
+// neighList dumps the neighbour tables of the given family. Tests replace it
+// to provide the bridge's forwarding database.
+var neighList = netlink.NeighList
+
+var errInvalidMAC = errors.New("not an Ethernet address")
+
+// FDBEntry is an entry of the bridge's forwarding database.
+type FDBEntry struct {
+	PortNo      uint16
+	Port        string // name of port PortNo, empty if the port is gone
+	MAC         net.HardwareAddr
+	IsLocal     bool
+	AgeingTimer time.Duration
+}
+
+// ShowMACs returns the forwarding database of the bridge.
+//
+// The entries are read from /sys/class/net/<name>/brforward, which holds an
+// array of struct __fdb_entry in host byte order:
+// 00-05: MAC address
+// 06:    port number, low byte
+// 07:    is_local
+// 08-11: ageing timer in clock ticks (hundredths of a second)
+// 12:    port number, high byte
+// 13-15: padding
+//
+// Port numbers are resolved to port names via the port_no of the ports in
+// /sys/class/net/<name>/brif. Local entries are the addresses of the bridge
+// and its ports; all others are learned or static.
+func ShowMACs(bridge string) ([]FDBEntry, error) {
+	brforward, err := readFile(path.Join(sysfsPath, bridge, BRCTL_BRFORWARD))
+	if err != nil {
+		return nil, fmt.Errorf("Readfile(%q): %w", path.Join(sysfsPath, bridge, BRCTL_BRFORWARD), err)
+	}
+
+	entries, err := parseFDB(brforward)
+	if err != nil {
+		return nil, err
+	}
+
+	ports, err := portNames(bridge)
+	if err != nil {
+		return nil, err
+	}
+	for i := range entries {
+		entries[i].Port = ports[entries[i].PortNo]
+	}
+	return entries, nil
+}
+
+// portNames maps the port numbers of the bridge's ports to their names.
+func portNames(bridge string) (map[uint16]string, error) {
+	brif := path.Join(sysfsPath, bridge, BRCTL_BRIDGE_INTERFACE)
+	dir, err := os.ReadDir(brif)
+	if err != nil {
+		return nil, fmt.Errorf("os.ReadDir: %w", err)
+	}
+
+	ports := make(map[uint16]string, len(dir))
+	for _, port := range dir {
+		raw, err := readFile(path.Join(brif, port.Name(), BRCTL_PORT_NO))
+		if errors.Is(err, os.ErrNotExist) {
+			// The port was removed meanwhile.
+			continue
+		}
+		if err != nil {
+			return nil, fmt.Errorf("os.ReadFile: %w", err)
+		}
+		n, err := parsePortNumber(string(raw))
+		if err != nil {
+			return nil, err
+		}
+		ports[n] = port.Name()
+	}
+	return ports, nil
+}
+
+// DelFDBByMAC removes the entries for mac from the forwarding database of
+// bridge, on all ports and VLANs, e.g. to forget an address learned on the
+// wrong port after a host moved. The local entries holding the addresses of
+// the bridge and its ports are kept. It is not an error if there are no
+// entries for mac.
+func DelFDBByMAC(bridge string, mac net.HardwareAddr) error {
+	if len(mac) != 6 {
+		return fmt.Errorf("%v: %w", mac, errInvalidMAC)
+	}
+
+	bridgeIndex, err := getIndexFromInterfaceName(bridge)
+	if err != nil {
+		return fmt.Errorf("getIndexFromInterfaceName: %w", err)
+	}
+
+	neighs, err := neighList(0, unix.AF_BRIDGE)
+	if err != nil {
+		return fmt.Errorf("listing FDB of %s: %w", bridge, err)
+	}
+
+	for _, n := range neighs {
+		// Entries of the ports' own tables, NTF_SELF, are not the
+		// bridge's.
+		if n.MasterIndex != bridgeIndex || n.Flags&unix.NTF_SELF != 0 {
+			continue
+		}
+		if n.State&unix.NUD_PERMANENT != 0 || !bytes.Equal(n.HardwareAddr, mac) {
+			continue
+		}
+		err := netlinkExecute(fdbDelRequest(n.LinkIndex, n.LinkIndex == bridgeIndex, mac, uint16(n.Vlan)))
+		// The entry may have aged out meanwhile.
+		if err != nil && !errors.Is(err, syscall.ENOENT) {
+			return fmt.Errorf("deleting FDB entry %v on ifindex %d: %w", mac, n.LinkIndex, err)
+		}
+	}
+	return nil
+}
+
+// fdbDelRequest builds the RTM_DELNEIGH request removing the FDB entry for
+// mac in VLAN vid, 0 for none, on the device with the given ifindex. Entries
+// on ports are removed via their master, entries on the bridge device itself
+// by the bridge.
+func fdbDelRequest(index int, self bool, mac net.HardwareAddr, vid uint16) *nl.NetlinkRequest {
+	req := nl.NewNetlinkRequest(unix.RTM_DELNEIGH, unix.NLM_F_ACK)
+
+	msg := &netlink.Ndmsg{
+		Family: unix.AF_BRIDGE,
+		Index:  uint32(index),
+		Flags:  unix.NTF_MASTER,
+	}
+	if self {
+		msg.Flags = unix.NTF_SELF
+	}
+	req.AddData(msg)
+
+	req.AddData(nl.NewRtAttr(unix.NDA_LLADDR, mac))
+	if vid != 0 {
+		req.AddData(nl.NewRtAttr(unix.NDA_VLAN, nl.Uint16Attr(vid)))
+	}
+
+	return req
+}
+
+func parseFDB(brforward []byte) ([]FDBEntry, error) {
+	if len(brforward)%fdbEntrySize != 0 {
+		return nil, fmt.Errorf("brforward size %d is not a multiple of %d", len(brforward), fdbEntrySize)
+	}
+
+	entries := make([]FDBEntry, 0, len(brforward)/fdbEntrySize)
+	for i := 0; i < len(brforward); i += fdbEntrySize {
+		chunk := brforward[i : i+fdbEntrySize]
+
+		e := FDBEntry{
+			PortNo:  uint16(chunk[6]) | uint16(chunk[12])<<8,
+			MAC:     net.HardwareAddr(append([]byte{}, chunk[0:6]...)),
+			IsLocal: chunk[7] != 0,
+		}
+		// Local entries never age.
+		if !e.IsLocal {
+			ageing, err := jiffiesToDuration(int(binary.NativeEndian.Uint32(chunk[8:12])))
+			if err != nil {
+				return nil, err
+			}
+			e.AgeingTimer = ageing
+		}
+		entries = append(entries, e)
+	}
+
+	return entries, nil
+}
+
+// formatTimer formats d as seconds with two decimals, like brctl does.
+func formatTimer(d time.Duration) string {
+	cs := d.Milliseconds() / 10
+	return fmt.Sprintf("%4d.%.2d", cs/100, cs%100)
+}
@@ -0,0 +1,237 @@
+// Copyright 2024 the u-root Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package brctl
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"net"
+	"reflect"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/vishvananda/netlink"
+	"github.com/vishvananda/netlink/nl"
+	"golang.org/x/sys/unix"
+)
+
+// fdbRecord encodes a struct __fdb_entry.
+func fdbRecord(mac string, port uint16, isLocal bool, ageing uint32) []byte {
+	b := make([]byte, fdbEntrySize)
+	hw, _ := net.ParseMAC(mac)
+	copy(b, hw)
+	b[6] = byte(port)
+	if isLocal {
+		b[7] = 1
+	}
+	binary.NativeEndian.PutUint32(b[8:], ageing)
+	b[12] = byte(port >> 8)
+	return b
+}
+
+func TestShowMACs(t *testing.T) {
+	hz, err := sysconfhz()
+	if err != nil {
+		t.Fatalf("sysconfhz() = %v, want nil", err)
+	}
+
+	brforward := append(fdbRecord("00:11:22:33:44:55", 1, true, 0),
+		fdbRecord("66:77:88:99:aa:bb", 0x102, false, uint32(hz)*12+uint32(hz)*34/100)...)
+	fakeSysfs(t, map[string]string{
+		"br0/brforward":         string(brforward),
+		"br0/brif/eth0/port_no": "0x1\n",
+		"br0/brif/eth1/port_no": "0x102\n",
+		"br0/brif/eth2/port_no": "0x3\n",
+	})
+
+	got, err := ShowMACs("br0")
+	if err != nil {
+		t.Fatalf("ShowMACs(%q) = %v, want nil", "br0", err)
+	}
+
+	want := []FDBEntry{
+		{
+			PortNo:  1,
+			Port:    "eth0",
+			MAC:     net.HardwareAddr{0x00, 0x11, 0x22, 0x33, 0x44, 0x55},
+			IsLocal: true,
+		},
+		{
+			PortNo:      0x102,
+			Port:        "eth1",
+			MAC:         net.HardwareAddr{0x66, 0x77, 0x88, 0x99, 0xaa, 0xbb},
+			AgeingTimer: 12340 * time.Millisecond,
+		},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("ShowMACs(%q) = %v, want %v", "br0", got, want)
+	}
+
+	var out bytes.Buffer
+	if err := Showmacs("br0", &out); err != nil {
+		t.Fatalf("Showmacs(%q) = %v, want nil", "br0", err)
+	}
+	wantOut := "port no\tmac addr\t\tis local?\tageing timer\n" +
+		"  1\t00:11:22:33:44:55\tyes\t\t   0.00\n" +
+		"258\t66:77:88:99:aa:bb\tno\t\t  12.34\n"
+	if out.String() != wantOut {
+		t.Errorf("Showmacs(%q) = %q, want %q", "br0", out.String(), wantOut)
+	}
+}
+
+func TestShowMACsRemovedPort(t *testing.T) {
+	fakeSysfs(t, map[string]string{
+		"br0/brforward":         string(fdbRecord("66:77:88:99:aa:bb", 2, false, 0)),
+		"br0/brif/eth0/port_no": "0x1\n",
+	})
+
+	got, err := ShowMACs("br0")
+	if err != nil {
+		t.Fatalf("ShowMACs(%q) = %v, want nil", "br0", err)
+	}
+	if len(got) != 1 || got[0].PortNo != 2 || got[0].Port != "" {
+		t.Errorf("ShowMACs(%q) = %v, want one entry of unnamed port 2", "br0", got)
+	}
+}
+
+func TestShowMACsTruncated(t *testing.T) {
+	fakeSysfs(t, map[string]string{"br0/brforward": "short"})
+
+	if _, err := ShowMACs("br0"); err == nil {
+		t.Errorf("ShowMACs(%q) = nil, want error", "br0")
+	}
+}
+
+// fakeNeighs makes neighList return neighs.
+func fakeNeighs(t *testing.T, neighs []netlink.Neigh) {
+	t.Helper()
+	old := neighList
+	neighList = func(linkIndex, family int) ([]netlink.Neigh, error) {
+		if linkIndex != 0 || family != unix.AF_BRIDGE {
+			t.Errorf("neighList(%d, %d), want neighList(0, AF_BRIDGE)", linkIndex, family)
+		}
+		return neighs, nil
+	}
+	t.Cleanup(func() { neighList = old })
+}
+
+// fdbDel is a decoded request built by fdbDelRequest.
+type fdbDel struct {
+	index uint32
+	flags uint8
+	mac   string
+	vid   uint16
+}
+
+func parseFDBDel(t *testing.T, b []byte) fdbDel {
+	t.Helper()
+	msgs, err := syscall.ParseNetlinkMessage(b)
+	if err != nil || len(msgs) != 1 {
+		t.Fatalf("ParseNetlinkMessage = %v, %v, want 1 message", msgs, err)
+	}
+	m := msgs[0]
+	if m.Header.Type != unix.RTM_DELNEIGH {
+		t.Errorf("message type = %d, want RTM_DELNEIGH", m.Header.Type)
+	}
+	if m.Header.Flags&unix.NLM_F_ACK == 0 {
+		t.Errorf("message flags = %#x, want NLM_F_ACK", m.Header.Flags)
+	}
+	if m.Data[0] != unix.AF_BRIDGE {
+		t.Errorf("ndmsg family = %d, want AF_BRIDGE", m.Data[0])
+	}
+	del := fdbDel{
+		index: binary.NativeEndian.Uint32(m.Data[4:8]),
+		flags: m.Data[10],
+	}
+	attrs, err := nl.ParseRouteAttr(m.Data[unix.SizeofNdMsg:])
+	if err != nil {
+		t.Fatalf("ParseRouteAttr = %v, want nil", err)
+	}
+	for _, a := range attrs {
+		switch a.Attr.Type {
+		case unix.NDA_LLADDR:
+			del.mac = net.HardwareAddr(a.Value).String()
+		case unix.NDA_VLAN:
+			del.vid = binary.NativeEndian.Uint16(a.Value)
+		default:
+			t.Errorf("unexpected attribute %d", a.Attr.Type)
+		}
+	}
+	return del
+}
+
+func TestDelFDBByMAC(t *testing.T) {
+	mac, _ := net.ParseMAC("66:77:88:99:aa:bb")
+	other, _ := net.ParseMAC("00:11:22:33:44:55")
+	fakeIndexes(t, map[string]uint32{"br0": 10})
+	fakeNeighs(t, []netlink.Neigh{
+		{LinkIndex: 3, MasterIndex: 10, HardwareAddr: mac, State: unix.NUD_REACHABLE},
+		{LinkIndex: 4, MasterIndex: 10, HardwareAddr: mac, State: unix.NUD_REACHABLE, Vlan: 20},
+		{LinkIndex: 10, MasterIndex: 10, HardwareAddr: mac, State: unix.NUD_NOARP, Vlan: 20},
+		// Not the bridge's entries for mac.
+		{LinkIndex: 3, MasterIndex: 10, HardwareAddr: other, State: unix.NUD_REACHABLE},
+		{LinkIndex: 5, MasterIndex: 11, HardwareAddr: mac, State: unix.NUD_REACHABLE},
+		{LinkIndex: 3, HardwareAddr: mac, State: unix.NUD_PERMANENT, Flags: unix.NTF_SELF},
+		{LinkIndex: 4, MasterIndex: 10, HardwareAddr: mac, State: unix.NUD_PERMANENT},
+	})
+	reqs := recordNetlink(t, nil)
+
+	if err := DelFDBByMAC("br0", mac); err != nil {
+		t.Fatalf("DelFDBByMAC(br0, %v) = %v, want nil", mac, err)
+	}
+
+	var got []fdbDel
+	for _, req := range *reqs {
+		got = append(got, parseFDBDel(t, req))
+	}
+	want := []fdbDel{
+		{index: 3, flags: unix.NTF_MASTER, mac: mac.String()},
+		{index: 4, flags: unix.NTF_MASTER, mac: mac.String(), vid: 20},
+		{index: 10, flags: unix.NTF_SELF, mac: mac.String(), vid: 20},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("DelFDBByMAC(br0, %v) sent %+v, want %+v", mac, got, want)
+	}
+}
+
+func TestDelFDBByMACNotFound(t *testing.T) {
+	mac, _ := net.ParseMAC("66:77:88:99:aa:bb")
+	fakeIndexes(t, map[string]uint32{"br0": 10})
+
+	// No entry at all.
+	fakeNeighs(t, nil)
+	reqs := recordNetlink(t, nil)
+	if err := DelFDBByMAC("br0", mac); err != nil {
+		t.Errorf("DelFDBByMAC(br0, %v) = %v, want nil", mac, err)
+	}
+	if len(*reqs) != 0 {
+		t.Errorf("DelFDBByMAC(br0, %v) sent %d requests, want none", mac, len(*reqs))
+	}
+
+	// The entry ages out before it is deleted.
+	fakeNeighs(t, []netlink.Neigh{{LinkIndex: 3, MasterIndex: 10, HardwareAddr: mac}})
+	recordNetlink(t, syscall.ENOENT)
+	if err := DelFDBByMAC("br0", mac); err != nil {
+		t.Errorf("DelFDBByMAC(br0, %v) = %v, want nil", mac, err)
+	}
+}
+
+func TestDelFDBByMACErrors(t *testing.T) {
+	mac, _ := net.ParseMAC("66:77:88:99:aa:bb")
+	fakeIndexes(t, map[string]uint32{"br0": 10})
+	fakeNeighs(t, []netlink.Neigh{{LinkIndex: 3, MasterIndex: 10, HardwareAddr: mac}})
+
+	recordNetlink(t, syscall.EPERM)
+	if err := DelFDBByMAC("br0", mac); !errors.Is(err, syscall.EPERM) {
+		t.Errorf("DelFDBByMAC(br0, %v) = %v, want %v", mac, err, syscall.EPERM)
+	}
+
+	short := net.HardwareAddr{1, 2, 3}
+	if err := DelFDBByMAC("br0", short); !errors.Is(err, errInvalidMAC) {
+		t.Errorf("DelFDBByMAC(br0, %v) = %v, want %v", short, err, errInvalidMAC)
+	}
+}
@@ -0,0 +1,148 @@
+// Copyright 2024 the u-root Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package brctl
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"syscall"
+)
+
+var (
+	errUnknownProtocol      = errors.New("unknown link-local protocol")
+	errRestrictedProtocol   = errors.New("the bridge never forwards this protocol")
+	errGroupForwardRejected = errors.New("the kernel rejected group_fwd_mask")
+)
+
+// groupForwardBits maps link-local protocols to their bit in group_fwd_mask.
+// Bit n stands for the group address 01:80:c2:00:00:0n.
+var groupForwardBits = map[string]uint{
+	"stp":    0x0,
+	"pause":  0x1,
+	"lacp":   0x2,
+	"802.1x": 0x3,
+	"mvrp":   0xd,
+	"lldp":   0xe,
+}
+
+// groupForwardRestricted are the bits the kernel refuses to set, because
+// forwarding the protocols would break the bridge or the link
+// (BR_GROUPFWD_RESTRICTED): STP, PAUSE frames and LACP, i.e. the group
+// addresses 01:80:c2:00:00:00 to 01:80:c2:00:00:02.
+const groupForwardRestricted = 1<<0 | 1<<1 | 1<<2
+
+// groupForwardMask returns the group_fwd_mask bits of the protocols.
+func groupForwardMask(protocols []string) (uint16, error) {
+	var mask uint16
+	for _, p := range protocols {
+		bit, ok := groupForwardBits[p]
+		if !ok {
+			return 0, fmt.Errorf("%w: %q", errUnknownProtocol, p)
+		}
+		if groupForwardRestricted&(1<<bit) != 0 {
+			return 0, fmt.Errorf("%w: %q", errRestrictedProtocol, p)
+		}
+		mask |= 1 << bit
+	}
+	return mask, nil
+}
+
+func getGroupForwardMask(bridge string) (uint16, error) {
+	raw, err := getBridgeValue(bridge, BRCTL_GROUP_FWD_MASK)
+	if err != nil {
+		return 0, fmt.Errorf("getBridgeValue: %w", err)
+	}
+	mask, err := strconv.ParseUint(raw, 0, 16)
+	if err != nil {
+		return 0, fmt.Errorf("strconv.ParseUint(%q) = %w", raw, err)
+	}
+	return uint16(mask), nil
+}
+
+func setGroupForwardMask(bridge string, mask uint16) error {
+	err := setBridgeValue(bridge, BRCTL_GROUP_FWD_MASK, []byte(strconv.FormatUint(uint64(mask), 10)), 0)
+	if errors.Is(err, syscall.EINVAL) {
+		return fmt.Errorf("%#x: %w: %w", mask, errGroupForwardRejected, err)
+	}
+	if err != nil {
+		return fmt.Errorf("setBridgeValue: %w", err)
+	}
+	return nil
+}
+
+// SetGroupForwardMask sets the group_fwd_mask of the bridge to mask. Bit n
+// makes the bridge forward frames to the group address 01:80:c2:00:00:0n,
+// which it drops by default.
+//
+// The kernel refuses bits 0 to 2, STP, PAUSE and LACP, so masks with any of
+// them are rejected without writing. If the kernel rejects the mask
+// nevertheless, the error says so rather than just "invalid argument".
+func SetGroupForwardMask(bridge string, mask uint16) error {
+	if restricted := mask & groupForwardRestricted; restricted != 0 {
+		return fmt.Errorf("%w: group_fwd_mask %#x has the bits %#x", errRestrictedProtocol, mask, restricted)
+	}
+	return setGroupForwardMask(bridge, mask)
+}
+
+// EnableGroupForward makes the bridge forward the link-local protocols, e.g.
+// "lldp" or "802.1x", which it drops by default. Other protocols that are
+// forwarded are left alone.
+//
+// The known protocols are stp, pause, lacp, 802.1x, mvrp and lldp. The bridge
+// never forwards stp, pause and lacp, so they are rejected.
+func EnableGroupForward(bridge string, protocols ...string) error {
+	bits, err := groupForwardMask(protocols)
+	if err != nil {
+		return err
+	}
+	mask, err := getGroupForwardMask(bridge)
+	if err != nil {
+		return err
+	}
+	return setGroupForwardMask(bridge, mask|bits)
+}
+
+// DisableGroupForward makes the bridge drop the link-local protocols again.
+// It takes the same protocols as EnableGroupForward.
+func DisableGroupForward(bridge string, protocols ...string) error {
+	bits, err := groupForwardMask(protocols)
+	if err != nil {
+		return err
+	}
+	mask, err := getGroupForwardMask(bridge)
+	if err != nil {
+		return err
+	}
+	return setGroupForwardMask(bridge, mask&^bits)
+}
+
+// GroupForward returns the link-local protocols the bridge forwards, sorted by
+// their group address. Forwarded group addresses without a known protocol
+// are returned as the address, e.g. "01:80:c2:00:00:0b".
+func GroupForward(bridge string) ([]string, error) {
+	mask, err := getGroupForwardMask(bridge)
+	if err != nil {
+		return nil, err
+	}
+
+	names := make(map[uint]string, len(groupForwardBits))
+	for name, bit := range groupForwardBits {
+		names[bit] = name
+	}
+
+	protocols := []string{}
+	for bit := uint(0); bit < 16; bit++ {
+		if mask&(1<<bit) == 0 {
+			continue
+		}
+		if name, ok := names[bit]; ok {
+			protocols = append(protocols, name)
+		} else {
+			protocols = append(protocols, fmt.Sprintf("01:80:c2:00:00:%02x", bit))
+		}
+	}
+	return protocols, nil
+}
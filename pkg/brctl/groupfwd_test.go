@@ -0,0 +1,146 @@
+// Copyright 2024 the u-root Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package brctl
+
+import (
+	"errors"
+	"reflect"
+	"syscall"
+	"testing"
+)
+
+func TestGroupForward(t *testing.T) {
+	for _, tt := range []struct {
+		name     string
+		mask     string
+		enable   []string
+		disable  []string
+		wantMask string
+		want     []string
+		wantErr  error
+	}{
+		{
+			name:     "lldp",
+			mask:     "0x0",
+			enable:   []string{"lldp"},
+			wantMask: "16384",
+			want:     []string{"lldp"},
+		},
+		{
+			name:     "several",
+			mask:     "0x0",
+			enable:   []string{"802.1x", "lldp", "mvrp"},
+			wantMask: "24584",
+			want:     []string{"802.1x", "mvrp", "lldp"},
+		},
+		{
+			name:     "keeps other bits",
+			mask:     "0x800",
+			enable:   []string{"lldp"},
+			wantMask: "18432",
+			want:     []string{"01:80:c2:00:00:0b", "lldp"},
+		},
+		{
+			name:     "disable",
+			mask:     "0x4808",
+			disable:  []string{"lldp", "802.1x"},
+			wantMask: "2048",
+			want:     []string{"01:80:c2:00:00:0b"},
+		},
+		{
+			name:     "disable not enabled",
+			mask:     "0x0",
+			disable:  []string{"lldp"},
+			wantMask: "0",
+			want:     []string{},
+		},
+		{
+			name:     "restricted lacp",
+			mask:     "0x0",
+			enable:   []string{"lldp", "lacp"},
+			wantMask: "0x0",
+			want:     []string{},
+			wantErr:  errRestrictedProtocol,
+		},
+		{
+			name:     "restricted stp",
+			mask:     "0x0",
+			enable:   []string{"stp"},
+			wantMask: "0x0",
+			want:     []string{},
+			wantErr:  errRestrictedProtocol,
+		},
+		{
+			name:     "unknown",
+			mask:     "0x4000",
+			disable:  []string{"cdp"},
+			wantMask: "0x4000",
+			want:     []string{"lldp"},
+			wantErr:  errUnknownProtocol,
+		},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			root := fakeSysfs(t, map[string]string{"br0/bridge/group_fwd_mask": tt.mask + "\n"})
+
+			var err error
+			if tt.enable != nil {
+				err = EnableGroupForward("br0", tt.enable...)
+			} else {
+				err = DisableGroupForward("br0", tt.disable...)
+			}
+			if !errors.Is(err, tt.wantErr) {
+				t.Errorf("group forward(%q) = %v, want %v", "br0", err, tt.wantErr)
+			}
+			if got := readSysfs(t, root, "br0/bridge/group_fwd_mask"); got != tt.wantMask {
+				t.Errorf("group_fwd_mask = %q, want %q", got, tt.wantMask)
+			}
+
+			got, err := GroupForward("br0")
+			if err != nil {
+				t.Fatalf("GroupForward(%q) = %v, want nil", "br0", err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("GroupForward(%q) = %q, want %q", "br0", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSetGroupForwardMask(t *testing.T) {
+	for _, tt := range []struct {
+		name     string
+		mask     uint16
+		kernel   error
+		wantMask string
+		wantErr  error
+	}{
+		{name: "lldp and mvrp", mask: 0x6000, wantMask: "24576"},
+		{name: "clear", mask: 0, wantMask: "0"},
+		{name: "lacp", mask: 0x4004, wantMask: "0x0", wantErr: errRestrictedProtocol},
+		{name: "stp", mask: 0x1, wantMask: "0x0", wantErr: errRestrictedProtocol},
+		{name: "kernel rejects", mask: 0x8, kernel: syscall.EINVAL, wantMask: "0x0", wantErr: errGroupForwardRejected},
+		{name: "kernel error", mask: 0x8, kernel: syscall.EPERM, wantMask: "0x0", wantErr: syscall.EPERM},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			root := fakeSysfs(t, map[string]string{"br0/bridge/group_fwd_mask": "0x0\n"})
+			if tt.kernel != nil {
+				old := writeFile
+				writeFile = func(string, []byte) error { return tt.kernel }
+				t.Cleanup(func() { writeFile = old })
+			}
+
+			err := SetGroupForwardMask("br0", tt.mask)
+			if !errors.Is(err, tt.wantErr) {
+				t.Errorf("SetGroupForwardMask(br0, %#x) = %v, want %v", tt.mask, err, tt.wantErr)
+			}
+			if tt.kernel != nil && !errors.Is(err, tt.kernel) {
+				t.Errorf("SetGroupForwardMask(br0, %#x) = %v, want %v", tt.mask, err, tt.kernel)
+			}
+			if got := readSysfs(t, root, "br0/bridge/group_fwd_mask"); got != tt.wantMask {
+				t.Errorf("group_fwd_mask = %q, want %q", got, tt.wantMask)
+			}
+		})
+	}
+}
@@ -0,0 +1,84 @@
+// Copyright 2024 the u-root Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package brctl
+
+import (
+	"fmt"
+	"os"
+	"path"
+	"strings"
+	"sync"
+
+	"golang.org/x/sys/unix"
+)
+
+// Brctl is a handle for issuing many bridge operations. It keeps one control
+// socket open for the ioctl based operations and resolves the sysfs root
+// once, instead of each package level function doing so on every call.
+//
+// A Brctl is safe for concurrent use. Its methods behave like the package
+// level functions of the same name. Its ioctls apply to the network
+// namespace New was called in, also when used inside WithNetns.
+type Brctl struct {
+	mu    sync.Mutex
+	fd    int
+	sysfs string
+}
+
+// New returns a handle with an open control socket. Call Close to release it.
+func New() (*Brctl, error) {
+	fd, err := unix.Socket(unix.AF_INET, unix.SOCK_STREAM, 0)
+	if err != nil {
+		return nil, fmt.Errorf("unix.Socket: %w", err)
+	}
+
+	return &Brctl{fd: fd, sysfs: sysfsPath}, nil
+}
+
+// sysfsHandle returns a handle without a control socket, for the package
+// level functions that only access sysfs.
+func sysfsHandle() *Brctl {
+	return &Brctl{fd: -1, sysfs: sysfsPath}
+}
+
+// Close releases the handle's control socket.
+func (b *Brctl) Close() error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.fd < 0 {
+		return nil
+	}
+	err := unix.Close(b.fd)
+	b.fd = -1
+	return err
+}
+
+// withSocket calls fn with the handle's control socket.
+func (b *Brctl) withSocket(fn func(fd int) error) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.fd < 0 {
+		return fmt.Errorf("brctl handle: %w", os.ErrClosed)
+	}
+	return fn(b.fd)
+}
+
+func (b *Brctl) setBridgeValue(bridge string, name string, value []byte) error {
+	return writeFile(path.Join(b.sysfs, bridge, "bridge", name), append(value, BRCTL_SYS_SUFFIX))
+}
+
+func (b *Brctl) getBridgeValue(bridge string, name string) (string, error) {
+	out, err := readFile(path.Join(b.sysfs, bridge, "bridge", name))
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSuffix(string(out), "\n"), nil
+}
+
+func (b *Brctl) setPortBrportValue(port string, name string, value []byte) error {
+	return writeFile(path.Join(b.sysfs, port, "brport", name), append(value, BRCTL_SYS_SUFFIX))
+}
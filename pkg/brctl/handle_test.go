@@ -0,0 +1,140 @@
+// Copyright 2024 the u-root Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package brctl
+
+import (
+	"errors"
+	"net"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/hugelgupf/vmtest/guest"
+)
+
+func TestBrctlSysfs(t *testing.T) {
+	root := fakeSysfs(t, map[string]string{
+		"br0/bridge/forward_delay": "1500\n",
+		"br0/bridge/stp_state":     "0\n",
+		"eth0/brport/priority":     "32\n",
+	})
+
+	b, err := New()
+	if err != nil {
+		t.Fatalf("New() = %v, want nil", err)
+	}
+	defer b.Close()
+
+	// The handle keeps using the sysfs root it was created with.
+	fakeSysfs(t, map[string]string{})
+
+	if err := b.Setfd("br0", "4s"); err != nil {
+		t.Fatalf("Setfd(%q, %q) = %v, want nil", "br0", "4s", err)
+	}
+	if err := b.Stp("br0", "on"); err != nil {
+		t.Fatalf("Stp(%q, %q) = %v, want nil", "br0", "on", err)
+	}
+	if err := b.Setportprio("br0", "eth0", "16"); err != nil {
+		t.Fatalf("Setportprio(%q, %q, %q) = %v, want nil", "br0", "eth0", "16", err)
+	}
+
+	for file, want := range map[string]string{
+		"br0/bridge/forward_delay": strings.TrimSuffix(jiffies(t, 4*time.Second), "\n"),
+		"br0/bridge/stp_state":     "1",
+		"eth0/brport/priority":     "16",
+	} {
+		if got := readSysfs(t, root, file); got != want {
+			t.Errorf("%s = %q, want %q", file, got, want)
+		}
+	}
+}
+
+func TestBrctlClosed(t *testing.T) {
+	b, err := New()
+	if err != nil {
+		t.Fatalf("New() = %v, want nil", err)
+	}
+	if err := b.Close(); err != nil {
+		t.Fatalf("Close() = %v, want nil", err)
+	}
+	if err := b.Close(); err != nil {
+		t.Errorf("second Close() = %v, want nil", err)
+	}
+
+	if err := b.Addbr(BRCTL_TEST_BR_0); !errors.Is(err, os.ErrClosed) {
+		t.Errorf("Addbr(%q) on closed handle = %v, want %v", BRCTL_TEST_BR_0, err, os.ErrClosed)
+	}
+}
+
+func TestBrctlAddbrDelbr(t *testing.T) {
+	guest.SkipIfNotInVM(t)
+
+	if err := clearEnv(); err != nil {
+		t.Skip(err)
+	}
+
+	b, err := New()
+	if err != nil {
+		t.Fatalf("New() = %v, want nil", err)
+	}
+	defer b.Close()
+
+	for _, bridge := range BRCTL_TEST_BRIDGES {
+		if err := b.Addbr(bridge); err != nil {
+			t.Fatalf("Addbr(%q) = %v, want nil", bridge, err)
+		}
+	}
+	if err := interfacesExist(BRCTL_TEST_BRIDGES); err != nil {
+		t.Fatalf("interfacesExist(%v) = %v, want nil", BRCTL_TEST_BRIDGES, err)
+	}
+
+	for _, bridge := range BRCTL_TEST_BRIDGES {
+		if err := b.Delbr(bridge); err != nil {
+			t.Fatalf("Delbr(%q) = %v, want nil", bridge, err)
+		}
+	}
+	for _, iface := range BRCTL_TEST_BRIDGES {
+		if _, err := net.InterfaceByName(iface); err == nil {
+			t.Fatalf("net.InterfaceByName(%q) = nil, want an error", iface)
+		}
+	}
+}
+
+func BenchmarkAddbrDelbr(b *testing.B) {
+	guest.SkipIfNotInVM(b)
+
+	if err := clearEnv(); err != nil {
+		b.Skip(err)
+	}
+
+	b.Run("package", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			if err := Addbr(BRCTL_TEST_BR_0); err != nil {
+				b.Fatalf("Addbr(%q) = %v, want nil", BRCTL_TEST_BR_0, err)
+			}
+			if err := Delbr(BRCTL_TEST_BR_0); err != nil {
+				b.Fatalf("Delbr(%q) = %v, want nil", BRCTL_TEST_BR_0, err)
+			}
+		}
+	})
+
+	b.Run("handle", func(b *testing.B) {
+		h, err := New()
+		if err != nil {
+			b.Fatalf("New() = %v, want nil", err)
+		}
+		defer h.Close()
+
+		for i := 0; i < b.N; i++ {
+			if err := h.Addbr(BRCTL_TEST_BR_0); err != nil {
+				b.Fatalf("Addbr(%q) = %v, want nil", BRCTL_TEST_BR_0, err)
+			}
+			if err := h.Delbr(BRCTL_TEST_BR_0); err != nil {
+				b.Fatalf("Delbr(%q) = %v, want nil", BRCTL_TEST_BR_0, err)
+			}
+		}
+	})
+}
@@ -0,0 +1,183 @@
+// Copyright 2024 the u-root Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package brctl
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"sync"
+)
+
+// maxInfoWorkers caps the number of bridges BridgeInfos reads at once, so
+// that many bridges do not exhaust file descriptors.
+const maxInfoWorkers = 8
+
+// BridgeInfos returns the information on the bridges, in the order of names.
+// The bridges are read concurrently, which speeds up systems with many
+// bridges.
+//
+// If bridges cannot be read, the error of the first of them in names is
+// returned.
+func BridgeInfos(names ...string) ([]BridgeInfo, error) {
+	infos := make([]BridgeInfo, len(names))
+	errs := make([]error, len(names))
+
+	work := make(chan int)
+	var wg sync.WaitGroup
+	for w := 0; w < min(maxInfoWorkers, len(names)); w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range work {
+				infos[i], errs[i] = getBridgeInfo(names[i])
+			}
+		}()
+	}
+	for i := range names {
+		work <- i
+	}
+	close(work)
+	wg.Wait()
+
+	for i, err := range errs {
+		if err != nil {
+			return nil, fmt.Errorf("getBridgeInfo(%q): %w", names[i], err)
+		}
+	}
+	return infos, nil
+}
+
+// List returns the information on all bridges, sorted by name.
+//
+// Bridges are network devices with a bridge directory in sysfs; other
+// devices are skipped.
+func List() ([]BridgeInfo, error) {
+	names, err := bridgeNames()
+	if err != nil {
+		return nil, err
+	}
+	return BridgeInfos(names...)
+}
+
+// ListBridgesFunc returns the names of the bridges whose information pred
+// accepts, sorted by name.
+//
+// The information is read one bridge at a time and only kept for the call
+// to pred. Bridges removed while they are read are skipped.
+func ListBridgesFunc(pred func(BridgeInfo) bool) ([]string, error) {
+	names, err := bridgeNames()
+	if err != nil {
+		return nil, err
+	}
+
+	var match []string
+	for _, name := range names {
+		info, err := getBridgeInfo(name)
+		if errors.Is(err, os.ErrNotExist) {
+			continue
+		}
+		if err != nil {
+			return nil, fmt.Errorf("getBridgeInfo(%q): %w", name, err)
+		}
+		if pred(info) {
+			match = append(match, name)
+		}
+	}
+	return match, nil
+}
+
+// bridgeNames returns the names of all bridges, sorted.
+func bridgeNames() ([]string, error) {
+	devices, err := os.ReadDir(sysfsPath)
+	if err != nil {
+		return nil, fmt.Errorf("os.ReadDir: %w", err)
+	}
+
+	var names []string
+	for _, dev := range devices {
+		// The devices are symlinks to their sysfs directories, which
+		// bridgeExists follows.
+		if bridgeExists(dev.Name()) {
+			names = append(names, dev.Name())
+		}
+	}
+	return names, nil
+}
+
+// ShowJSON writes the information on all bridges as a JSON array, see List.
+func ShowJSON(w io.Writer) error {
+	infos, err := List()
+	if err != nil {
+		return err
+	}
+	if infos == nil {
+		infos = []BridgeInfo{}
+	}
+
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(infos)
+}
+
+// PortInfo is the STP information on a bridge port, as shown by brctl
+// showstp.
+type PortInfo struct {
+	Name     string
+	State    PortState
+	Priority int
+	PathCost int
+	Hairpin  bool
+}
+
+// PortInfos returns the STP information on the ports of bridge, sorted by
+// name. Ports removed while they are read are skipped.
+func PortInfos(bridge string) ([]PortInfo, error) {
+	ports, err := os.ReadDir(path.Join(sysfsPath, bridge, BRCTL_BRIDGE_INTERFACE))
+	if err != nil {
+		return nil, fmt.Errorf("os.ReadDir: %w", err)
+	}
+
+	infos := make([]PortInfo, 0, len(ports))
+	for _, port := range ports {
+		info, err := getPortInfo(port.Name())
+		if errors.Is(err, os.ErrNotExist) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		infos = append(infos, info)
+	}
+	return infos, nil
+}
+
+func getPortInfo(port string) (PortInfo, error) {
+	info := PortInfo{Name: port}
+	for _, attr := range []struct {
+		name string
+		dst  *int
+	}{
+		{BRCTL_PORT_STATE, (*int)(&info.State)},
+		{BRCTL_PRIORITY, &info.Priority},
+		{BRCTL_PATH_COST, &info.PathCost},
+	} {
+		n, err := getPortInt(port, attr.name)
+		if err != nil {
+			return PortInfo{}, err
+		}
+		*attr.dst = n
+	}
+
+	hairpin, err := getPortBool(port, BRCTL_HAIRPIN)
+	if err != nil {
+		return PortInfo{}, err
+	}
+	info.Hairpin = hairpin
+	return info, nil
+}
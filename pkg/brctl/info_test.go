@@ -0,0 +1,497 @@
+// Copyright 2024 the u-root Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package brctl
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeBridges returns a fake sysfs tree with n bridges br0..br<n-1>, each
+// with one port.
+func fakeBridges(n int) map[string]string {
+	files := map[string]string{}
+	for i := 0; i < n; i++ {
+		br := fmt.Sprintf("br%d", i)
+		files[br+"/bridge/bridge_id"] = fmt.Sprintf("8000.0200000000%02x\n", i)
+		files[br+"/bridge/root_id"] = fmt.Sprintf("8000.0200000000%02x\n", i)
+		files[br+"/bridge/root_port"] = "0\n"
+		files[br+"/bridge/stp_state"] = fmt.Sprintf("%d\n", i%2)
+		files[br+"/address"] = fmt.Sprintf("02:00:00:00:00:%02x\n", i)
+		files[fmt.Sprintf("%s/brif/eth%d/.keep", br, i)] = ""
+	}
+	return files
+}
+
+func TestBridgeInfos(t *testing.T) {
+	const n = 20
+	root := fakeSysfs(t, fakeBridges(n))
+
+	// Make the first bridges the slowest to read, so they finish last.
+	var mu sync.Mutex
+	var running, maxRunning int
+	old := readFile
+	readFile = func(name string) ([]byte, error) {
+		mu.Lock()
+		running++
+		maxRunning = max(maxRunning, running)
+		mu.Unlock()
+		defer func() {
+			mu.Lock()
+			running--
+			mu.Unlock()
+		}()
+
+		var i int
+		if _, err := fmt.Sscanf(strings.TrimPrefix(name, root+"/"), "br%d/", &i); err == nil {
+			time.Sleep(time.Duration(n-i) * 100 * time.Microsecond)
+		}
+		return old(name)
+	}
+	t.Cleanup(func() { readFile = old })
+
+	// Not sorted, to check that the order of names is kept.
+	names := []string{"br3", "br1", "br2", "br0"}
+	for i := 4; i < n; i++ {
+		names = append(names, fmt.Sprintf("br%d", i))
+	}
+	got, err := BridgeInfos(names...)
+	if err != nil {
+		t.Fatalf("BridgeInfos() = %v, want nil", err)
+	}
+
+	var want []BridgeInfo
+	for _, name := range names {
+		i, err := strconv.Atoi(strings.TrimPrefix(name, "br"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		want = append(want, BridgeInfo{
+			Name:       name,
+			BridgeID:   fmt.Sprintf("8000.0200000000%02x", i),
+			MACAddress: net.HardwareAddr{2, 0, 0, 0, 0, byte(i)},
+			RootID:     fmt.Sprintf("8000.0200000000%02x", i),
+			StpMode:    StpMode(i % 2),
+			StpState:   i%2 == 1,
+			Interfaces: []string{fmt.Sprintf("eth%d", i)},
+		})
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("BridgeInfos() = %v, want %v", got, want)
+	}
+	if maxRunning > maxInfoWorkers {
+		t.Errorf("BridgeInfos() read %d files at once, want at most %d", maxRunning, maxInfoWorkers)
+	}
+}
+
+func TestBridgeInfosError(t *testing.T) {
+	root := fakeSysfs(t, fakeBridges(3))
+	if err := os.Remove(filepath.Join(root, "br1/bridge/stp_state")); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := BridgeInfos("br0", "br1", "br2", "br3"); !errors.Is(err, os.ErrNotExist) || !strings.Contains(err.Error(), `"br1"`) {
+		t.Errorf("BridgeInfos() = %v, want %v for br1", err, os.ErrNotExist)
+	}
+}
+
+func TestBridgeInfosEmpty(t *testing.T) {
+	got, err := BridgeInfos()
+	if err != nil || len(got) != 0 {
+		t.Errorf("BridgeInfos() = %v, %v, want no bridges", got, err)
+	}
+}
+
+func BenchmarkBridgeInfos(b *testing.B) {
+	root := b.TempDir()
+	for name, content := range fakeBridges(64) {
+		p := filepath.Join(root, name)
+		if err := os.MkdirAll(filepath.Dir(p), 0o755); err != nil {
+			b.Fatal(err)
+		}
+		if err := os.WriteFile(p, []byte(content), 0o644); err != nil {
+			b.Fatal(err)
+		}
+	}
+	old := sysfsPath
+	sysfsPath = root
+	b.Cleanup(func() { sysfsPath = old })
+
+	var names []string
+	for i := 0; i < 64; i++ {
+		names = append(names, fmt.Sprintf("br%d", i))
+	}
+
+	b.Run("serial", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			for _, name := range names {
+				if _, err := getBridgeInfo(name); err != nil {
+					b.Fatal(err)
+				}
+			}
+		}
+	})
+	b.Run("concurrent", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			if _, err := BridgeInfos(names...); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+}
+
+func TestList(t *testing.T) {
+	files := fakeBridges(2)
+	// A port and a device that merely looks like a bridge by name.
+	files["eth0/brport/state"] = "3\n"
+	files["br-lookalike/carrier"] = "1\n"
+	root := fakeSysfs(t, files)
+
+	// /sys/class/net holds symlinks to the devices.
+	devices := t.TempDir()
+	if err := os.Mkdir(filepath.Join(root, "virtual"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	for _, dev := range []string{"br0", "br1", "eth0", "br-lookalike"} {
+		if err := os.Rename(filepath.Join(root, dev), filepath.Join(root, "virtual", dev)); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.Symlink(filepath.Join(root, "virtual", dev), filepath.Join(devices, dev)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// A dangling link.
+	if err := os.Symlink(filepath.Join(root, "virtual", "gone"), filepath.Join(devices, "gone")); err != nil {
+		t.Fatal(err)
+	}
+	sysfsPath = devices
+
+	got, err := List()
+	if err != nil {
+		t.Fatalf("List() = %v, want nil", err)
+	}
+	want := []BridgeInfo{
+		{Name: "br0", BridgeID: "8000.020000000000", MACAddress: net.HardwareAddr{2, 0, 0, 0, 0, 0}, RootID: "8000.020000000000", StpMode: StpOff, StpState: false, Interfaces: []string{"eth0"}},
+		{Name: "br1", BridgeID: "8000.020000000001", MACAddress: net.HardwareAddr{2, 0, 0, 0, 0, 1}, RootID: "8000.020000000001", StpMode: StpKernel, StpState: true, Interfaces: []string{"eth1"}},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("List() = %v, want %v", got, want)
+	}
+}
+
+func TestListBridgesFunc(t *testing.T) {
+	files := fakeBridges(5)
+	files["br3/bridge/vlan_filtering"] = "1\n"
+	root := fakeSysfs(t, files)
+
+	// br2 is removed after the listing.
+	old := readFile
+	readFile = func(name string) ([]byte, error) {
+		if strings.HasPrefix(name, filepath.Join(root, "br2")) {
+			return nil, os.ErrNotExist
+		}
+		return old(name)
+	}
+	t.Cleanup(func() { readFile = old })
+
+	for _, tt := range []struct {
+		name string
+		pred func(BridgeInfo) bool
+		want []string
+	}{
+		{"stp on", func(b BridgeInfo) bool { return b.StpState }, []string{"br1", "br3"}},
+		{"stp off", func(b BridgeInfo) bool { return !b.StpState }, []string{"br0", "br4"}},
+		{"vlan filtering", func(b BridgeInfo) bool { return b.VLANFiltering }, []string{"br3"}},
+		{"none", func(BridgeInfo) bool { return false }, nil},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := ListBridgesFunc(tt.pred)
+			if err != nil {
+				t.Fatalf("ListBridgesFunc() = %v, want nil", err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("ListBridgesFunc() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestListNoBridges(t *testing.T) {
+	fakeSysfs(t, map[string]string{"eth0/carrier": "1\n"})
+
+	got, err := List()
+	if err != nil || len(got) != 0 {
+		t.Errorf("List() = %v, %v, want no bridges", got, err)
+	}
+}
+
+func TestBridgeInfoVLANFiltering(t *testing.T) {
+	files := fakeBridges(2)
+	files["br0/bridge/vlan_filtering"] = "1\n"
+	// br1 has no vlan_filtering, as on kernels without VLAN filtering.
+	fakeSysfs(t, files)
+
+	for _, tt := range []struct {
+		bridge string
+		want   bool
+	}{
+		{bridge: "br0", want: true},
+		{bridge: "br1", want: false},
+	} {
+		info, err := getBridgeInfo(tt.bridge)
+		if err != nil {
+			t.Fatalf("getBridgeInfo(%q) = %v, want nil", tt.bridge, err)
+		}
+		if info.VLANFiltering != tt.want {
+			t.Errorf("getBridgeInfo(%q).VLANFiltering = %v, want %v", tt.bridge, info.VLANFiltering, tt.want)
+		}
+	}
+}
+
+func TestBridgeInfoGroupFwdMask(t *testing.T) {
+	files := fakeBridges(2)
+	files["br0/bridge/group_fwd_mask"] = "0x4008\n"
+	// br1 has no group_fwd_mask, as on kernels before 3.2.
+	fakeSysfs(t, files)
+
+	for _, tt := range []struct {
+		bridge string
+		want   uint16
+	}{
+		{bridge: "br0", want: 0x4008},
+		{bridge: "br1", want: 0},
+	} {
+		info, err := getBridgeInfo(tt.bridge)
+		if err != nil {
+			t.Fatalf("getBridgeInfo(%q) = %v, want nil", tt.bridge, err)
+		}
+		if info.GroupFwdMask != tt.want {
+			t.Errorf("getBridgeInfo(%q).GroupFwdMask = %#x, want %#x", tt.bridge, info.GroupFwdMask, tt.want)
+		}
+	}
+}
+
+func TestBridgeInfoRoot(t *testing.T) {
+	files := fakeBridges(2)
+	// br1 runs STP and reaches the root bridge via port 2.
+	files["br1/bridge/root_id"] = "1000.0a0000000001\n"
+	files["br1/bridge/root_port"] = "2\n"
+	fakeSysfs(t, files)
+
+	for _, tt := range []struct {
+		bridge   string
+		mac      string
+		rootID   string
+		rootPort int
+	}{
+		// Without STP, the bridge is its own root.
+		{bridge: "br0", mac: "02:00:00:00:00:00", rootID: "8000.020000000000", rootPort: 0},
+		{bridge: "br1", mac: "02:00:00:00:00:01", rootID: "1000.0a0000000001", rootPort: 2},
+	} {
+		info, err := getBridgeInfo(tt.bridge)
+		if err != nil {
+			t.Fatalf("getBridgeInfo(%q) = %v, want nil", tt.bridge, err)
+		}
+		if info.MACAddress.String() != tt.mac || info.RootID != tt.rootID || info.RootPort != tt.rootPort {
+			t.Errorf("getBridgeInfo(%q) = MAC %v, root %s via %d, want MAC %s, root %s via %d", tt.bridge, info.MACAddress, info.RootID, info.RootPort, tt.mac, tt.rootID, tt.rootPort)
+		}
+	}
+
+	files["br0/address"] = "not a mac\n"
+	fakeSysfs(t, files)
+	if _, err := getBridgeInfo("br0"); err == nil {
+		t.Errorf("getBridgeInfo(br0) with a bad address = nil, want error")
+	}
+}
+
+func TestBridgeInfoStpMode(t *testing.T) {
+	files := fakeBridges(1)
+	for mode, want := range map[string]StpMode{"0": StpOff, "1": StpKernel, "2": StpUser} {
+		files["br0/bridge/stp_state"] = mode + "\n"
+		fakeSysfs(t, files)
+
+		got, err := getBridgeInfo("br0")
+		if err != nil {
+			t.Fatalf("getBridgeInfo(br0) = %v, want nil", err)
+		}
+		if got.StpMode != want || got.StpState != (want != StpOff) {
+			t.Errorf("getBridgeInfo(br0) with stp_state %s = mode %v, state %t, want mode %v, state %t", mode, got.StpMode, got.StpState, want, want != StpOff)
+		}
+	}
+
+	for mode, want := range map[StpMode]string{StpOff: "off", StpKernel: "kernel", StpUser: "user", 3: "stp mode 3"} {
+		if got := mode.String(); got != want {
+			t.Errorf("StpMode(%d).String() = %q, want %q", int(mode), got, want)
+		}
+	}
+}
+
+func TestBridgeInfoJSON(t *testing.T) {
+	for _, tt := range []struct {
+		name string
+		info BridgeInfo
+		want string
+	}{
+		{
+			name: "interfaces",
+			info: BridgeInfo{Name: "br0", BridgeID: "8000.020000000000", MACAddress: net.HardwareAddr{2, 0, 0, 0, 0, 0}, RootID: "8000.020000000001", RootPort: 1, StpMode: StpUser, StpState: true, Interfaces: []string{"eth0", "eth1"}},
+			want: `{"name":"br0","bridge_id":"8000.020000000000","root_id":"8000.020000000001","root_port":1,"stp_mode":2,"stp_state":true,"vlan_filtering":false,"multicast_snooping":false,"interfaces":["eth0","eth1"],"mac_address":"02:00:00:00:00:00"}`,
+		},
+		{
+			name: "no interfaces",
+			info: BridgeInfo{Name: "br0", BridgeID: "8000.020000000000"},
+			want: `{"name":"br0","bridge_id":"8000.020000000000","root_id":"","root_port":0,"stp_mode":0,"stp_state":false,"vlan_filtering":false,"multicast_snooping":false,"interfaces":[],"mac_address":""}`,
+		},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := json.Marshal(tt.info)
+			if err != nil {
+				t.Fatalf("json.Marshal(%+v) = %v, want nil", tt.info, err)
+			}
+			if string(got) != tt.want {
+				t.Errorf("json.Marshal(%+v) = %s, want %s", tt.info, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestShowJSON(t *testing.T) {
+	files := fakeBridges(1)
+	// A bridge without ports.
+	files["br1/bridge/bridge_id"] = "8000.020000000001\n"
+	files["br1/bridge/stp_state"] = "0\n"
+	files["br1/bridge/vlan_filtering"] = "1\n"
+	files["br1/bridge/multicast_snooping"] = "1\n"
+	files["br1/bridge/root_id"] = "8000.020000000001\n"
+	files["br1/bridge/root_port"] = "0\n"
+	files["br1/address"] = "02:00:00:00:00:01\n"
+	root := fakeSysfs(t, files)
+	if err := os.Mkdir(filepath.Join(root, "br1/brif"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+
+	var b strings.Builder
+	if err := ShowJSON(&b); err != nil {
+		t.Fatalf("ShowJSON() = %v, want nil", err)
+	}
+	want := `[
+  {
+    "name": "br0",
+    "bridge_id": "8000.020000000000",
+    "root_id": "8000.020000000000",
+    "root_port": 0,
+    "stp_mode": 0,
+    "stp_state": false,
+    "vlan_filtering": false,
+    "multicast_snooping": false,
+    "interfaces": [
+      "eth0"
+    ],
+    "mac_address": "02:00:00:00:00:00"
+  },
+  {
+    "name": "br1",
+    "bridge_id": "8000.020000000001",
+    "root_id": "8000.020000000001",
+    "root_port": 0,
+    "stp_mode": 0,
+    "stp_state": false,
+    "vlan_filtering": true,
+    "multicast_snooping": true,
+    "interfaces": [],
+    "mac_address": "02:00:00:00:00:01"
+  }
+]
+`
+	if b.String() != want {
+		t.Errorf("ShowJSON() = %s, want %s", b.String(), want)
+	}
+}
+
+func TestShowJSONNoBridges(t *testing.T) {
+	fakeSysfs(t, map[string]string{"eth0/carrier": "1\n"})
+
+	var b strings.Builder
+	if err := ShowJSON(&b); err != nil {
+		t.Fatalf("ShowJSON() = %v, want nil", err)
+	}
+	if want := "[]\n"; b.String() != want {
+		t.Errorf("ShowJSON() = %q, want %q", b.String(), want)
+	}
+}
+
+func TestPortInfos(t *testing.T) {
+	fakeSysfs(t, map[string]string{
+		"br0/brif/eth0/.keep":      "",
+		"br0/brif/eth1/.keep":      "",
+		"br0/brif/eth2/.keep":      "",
+		"eth0/brport/state":        "3\n",
+		"eth0/brport/priority":     "32\n",
+		"eth0/brport/path_cost":    "100\n",
+		"eth0/brport/hairpin_mode": "1\n",
+		"eth1/brport/state":        "4\n",
+		"eth1/brport/priority":     "8\n",
+		"eth1/brport/path_cost":    "2\n",
+		"eth1/brport/hairpin_mode": "0\n",
+		// eth2 was removed from the bridge after the listing.
+	})
+
+	got, err := PortInfos("br0")
+	if err != nil {
+		t.Fatalf("PortInfos(br0) = %v, want nil", err)
+	}
+	want := []PortInfo{
+		{Name: "eth0", State: BR_STATE_FORWARDING, Priority: 32, PathCost: 100, Hairpin: true},
+		{Name: "eth1", State: BR_STATE_BLOCKING, Priority: 8, PathCost: 2},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("PortInfos(br0) = %+v, want %+v", got, want)
+	}
+	if s := got[1].State.String(); s != "blocking" {
+		t.Errorf("State.String() = %q, want %q", s, "blocking")
+	}
+}
+
+func TestPortInfosErrors(t *testing.T) {
+	fakeSysfs(t, map[string]string{
+		"br0/brif/eth0/.keep":   "",
+		"eth0/brport/state":     "forwarding\n",
+		"eth0/brport/priority":  "32\n",
+		"eth0/brport/path_cost": "100\n",
+	})
+	if _, err := PortInfos("br0"); err == nil {
+		t.Errorf("PortInfos(br0) = nil, want error")
+	}
+	if _, err := PortInfos("br1"); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("PortInfos(br1) = %v, want %v", err, os.ErrNotExist)
+	}
+}
+
+func TestShowAll(t *testing.T) {
+	files := fakeBridges(2)
+	files["eth0/operstate"] = "up\n"
+	fakeSysfs(t, files)
+
+	var out strings.Builder
+	if err := Show(&out); err != nil {
+		t.Fatalf("Show() = %v, want nil", err)
+	}
+	want := "bridge name\tbridge id\tSTP enabled\t\tinterfaces\n" +
+		"br0\t\t8000.020000000000\t\tfalse\t\teth0 \n" +
+		"br1\t\t8000.020000000001\t\ttrue\t\teth1 \n"
+	if out.String() != want {
+		t.Errorf("Show() = %q, want %q", out.String(), want)
+	}
+}
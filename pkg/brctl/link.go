@@ -0,0 +1,229 @@
+// Copyright 2024 the u-root Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package brctl
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+
+	"golang.org/x/sys/unix"
+)
+
+// CarrierUp reports whether the bridge has carrier, i.e. at least one of its
+// ports is up. A bridge that is administratively down reports false.
+func CarrierUp(bridge string) (bool, error) {
+	carrier, err := getDeviceValue(bridge, BRCTL_CARRIER)
+	if err != nil {
+		// The kernel refuses to read carrier of a device that is down.
+		if errors.Is(err, unix.EINVAL) {
+			return false, nil
+		}
+		return false, fmt.Errorf("getDeviceValue: %w", err)
+	}
+
+	return carrier == "1", nil
+}
+
+// OperState returns the RFC 2863 operational state of the bridge, e.g. "up",
+// "down" or "lowerlayerdown".
+func OperState(bridge string) (string, error) {
+	state, err := getDeviceValue(bridge, BRCTL_OPERSTATE)
+	if err != nil {
+		return "", fmt.Errorf("getDeviceValue: %w", err)
+	}
+
+	return state, nil
+}
+
+// Duplex is the duplex mode of a link.
+type Duplex int
+
+// Duplex modes.
+const (
+	DuplexUnknown Duplex = iota
+	DuplexHalf
+	DuplexFull
+)
+
+var duplexNames = map[Duplex]string{
+	DuplexUnknown: "unknown",
+	DuplexHalf:    "half",
+	DuplexFull:    "full",
+}
+
+func (d Duplex) String() string {
+	if name, ok := duplexNames[d]; ok {
+		return name
+	}
+	return fmt.Sprintf("duplex %d", int(d))
+}
+
+// LinkInfo describes the physical link of a network device.
+type LinkInfo struct {
+	// SpeedMbps is the link speed in Mbit/s, 0 if unknown.
+	SpeedMbps int
+	Duplex    Duplex
+}
+
+// PortLinkInfo returns the speed and duplex mode of the port's link, e.g. a
+// bridge member.
+//
+// Devices that are down or have no link report an unknown speed and duplex
+// mode, as do virtual devices without a notion of speed.
+func PortLinkInfo(port string) (LinkInfo, error) {
+	var info LinkInfo
+
+	speed, err := getLinkValue(port, BRCTL_SPEED)
+	if err != nil {
+		return LinkInfo{}, err
+	}
+	if speed != "" {
+		n, err := strconv.Atoi(speed)
+		if err != nil {
+			return LinkInfo{}, fmt.Errorf("strconv.Atoi(%q) = %w", speed, err)
+		}
+		// The kernel shows SPEED_UNKNOWN as -1.
+		if n > 0 {
+			info.SpeedMbps = n
+		}
+	}
+
+	duplex, err := getLinkValue(port, BRCTL_DUPLEX)
+	if err != nil {
+		return LinkInfo{}, err
+	}
+	switch duplex {
+	case "half":
+		info.Duplex = DuplexHalf
+	case "full":
+		info.Duplex = DuplexFull
+	}
+
+	return info, nil
+}
+
+// getLinkValue reads a link attribute of the device. Like carrier, the kernel
+// refuses to read them for a device that is down or whose driver cannot tell,
+// which is reported as an empty value.
+func getLinkValue(dev string, name string) (string, error) {
+	value, err := getDeviceValue(dev, name)
+	if errors.Is(err, unix.EINVAL) {
+		return "", nil
+	}
+	if err != nil {
+		return "", fmt.Errorf("getDeviceValue: %w", err)
+	}
+	return value, nil
+}
+
+// SetLinkUp sets the IFF_UP flag of the device, e.g. a bridge.
+func SetLinkUp(dev string) error {
+	b, err := New()
+	if err != nil {
+		return err
+	}
+	defer b.Close()
+
+	return b.SetLinkUp(dev)
+}
+
+// SetLinkUp is the handle version of the package level SetLinkUp.
+func (b *Brctl) SetLinkUp(dev string) (err error) {
+	defer observe("SetLinkUp", dev)(&err)
+
+	return b.setLinkFlag(dev, unix.IFF_UP, true)
+}
+
+// SetPromisc enables or disables promiscuous mode (IFF_PROMISC) on the
+// bridge device, e.g. for bridges used as mirror targets.
+func SetPromisc(bridge string, on bool) error {
+	b, err := New()
+	if err != nil {
+		return err
+	}
+	defer b.Close()
+
+	return b.SetPromisc(bridge, on)
+}
+
+// SetPromisc is the handle version of the package level SetPromisc.
+func (b *Brctl) SetPromisc(bridge string, on bool) (err error) {
+	defer observe("SetPromisc", bridge)(&err)
+
+	return b.setLinkFlag(bridge, unix.IFF_PROMISC, on)
+}
+
+// SetAllMulti enables or disables the reception of all multicast packets
+// (IFF_ALLMULTI) on the bridge device.
+func SetAllMulti(bridge string, on bool) error {
+	b, err := New()
+	if err != nil {
+		return err
+	}
+	defer b.Close()
+
+	return b.SetAllMulti(bridge, on)
+}
+
+// SetAllMulti is the handle version of the package level SetAllMulti.
+func (b *Brctl) SetAllMulti(bridge string, on bool) (err error) {
+	defer observe("SetAllMulti", bridge)(&err)
+
+	return b.setLinkFlag(bridge, unix.IFF_ALLMULTI, on)
+}
+
+// setLinkFlag sets or clears flag in the interface flags of dev, leaving the
+// other flags alone.
+func (b *Brctl) setLinkFlag(dev string, flag uint16, on bool) error {
+	ifr, err := unix.NewIfreq(dev)
+	if err != nil {
+		return fmt.Errorf("unix.NewIfreq: %w", err)
+	}
+
+	return b.withSocket(func(fd int) error {
+		if err := ioctlIfreq(fd, unix.SIOCGIFFLAGS, ifr); err != nil {
+			return fmt.Errorf("unix.IoctlIfreq(SIOCGIFFLAGS): %w", err)
+		}
+		ifr.SetUint16(withFlag(ifr.Uint16(), flag, on))
+		if err := ioctlIfreq(fd, unix.SIOCSIFFLAGS, ifr); err != nil {
+			return fmt.Errorf("unix.IoctlIfreq(SIOCSIFFLAGS): %w", err)
+		}
+		return nil
+	})
+}
+
+// withFlag returns flags with flag set if on, or cleared otherwise.
+func withFlag(flags uint16, flag uint16, on bool) uint16 {
+	if on {
+		return flags | flag
+	}
+	return flags &^ flag
+}
+
+// Promisc reports whether the bridge device is in promiscuous mode.
+func Promisc(bridge string) (bool, error) {
+	return hasLinkFlag(bridge, unix.IFF_PROMISC)
+}
+
+// AllMulti reports whether the bridge device receives all multicast packets.
+func AllMulti(bridge string) (bool, error) {
+	return hasLinkFlag(bridge, unix.IFF_ALLMULTI)
+}
+
+// hasLinkFlag reports whether flag is set in the interface flags of dev, as
+// shown in /sys/class/net/<dev>/flags.
+func hasLinkFlag(dev string, flag uint16) (bool, error) {
+	raw, err := getDeviceValue(dev, BRCTL_FLAGS)
+	if err != nil {
+		return false, fmt.Errorf("getDeviceValue: %w", err)
+	}
+	flags, err := strconv.ParseUint(raw, 0, 32)
+	if err != nil {
+		return false, fmt.Errorf("strconv.ParseUint(%q) = %w", raw, err)
+	}
+
+	return flags&uint64(flag) != 0, nil
+}
@@ -0,0 +1,288 @@
+// Copyright 2024 the u-root Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package brctl
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"reflect"
+	"testing"
+
+	"golang.org/x/sys/unix"
+)
+
+func TestCarrierUp(t *testing.T) {
+	for _, tt := range []struct {
+		name    string
+		files   map[string]string
+		readErr error
+		want    bool
+		wantErr bool
+	}{
+		{
+			name:  "up",
+			files: map[string]string{"br0/carrier": "1\n"},
+			want:  true,
+		},
+		{
+			name:  "no carrier",
+			files: map[string]string{"br0/carrier": "0\n"},
+			want:  false,
+		},
+		{
+			name:    "device down",
+			files:   map[string]string{"br0/carrier": ""},
+			readErr: &os.PathError{Op: "read", Path: "br0/carrier", Err: unix.EINVAL},
+			want:    false,
+		},
+		{
+			name:    "no device",
+			files:   map[string]string{},
+			wantErr: true,
+		},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			fakeSysfs(t, tt.files)
+			if tt.readErr != nil {
+				old := readFile
+				readFile = func(string) ([]byte, error) { return nil, tt.readErr }
+				t.Cleanup(func() { readFile = old })
+			}
+
+			got, err := CarrierUp("br0")
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("CarrierUp(%q) = %v, want error %v", "br0", err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("CarrierUp(%q) = %v, want %v", "br0", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestPortLinkInfo(t *testing.T) {
+	for _, tt := range []struct {
+		name    string
+		files   map[string]string
+		readErr error
+		want    LinkInfo
+		wantErr bool
+	}{
+		{
+			name:  "up",
+			files: map[string]string{"eth0/speed": "1000\n", "eth0/duplex": "full\n"},
+			want:  LinkInfo{SpeedMbps: 1000, Duplex: DuplexFull},
+		},
+		{
+			name:  "half duplex",
+			files: map[string]string{"eth0/speed": "10\n", "eth0/duplex": "half\n"},
+			want:  LinkInfo{SpeedMbps: 10, Duplex: DuplexHalf},
+		},
+		{
+			name:    "down",
+			files:   map[string]string{"eth0/speed": "", "eth0/duplex": ""},
+			readErr: &os.PathError{Op: "read", Path: "eth0/speed", Err: unix.EINVAL},
+			want:    LinkInfo{Duplex: DuplexUnknown},
+		},
+		{
+			// Like virtio_net without a configured speed.
+			name:  "virtual",
+			files: map[string]string{"eth0/speed": "-1\n", "eth0/duplex": "unknown\n"},
+			want:  LinkInfo{Duplex: DuplexUnknown},
+		},
+		{
+			name:    "garbage speed",
+			files:   map[string]string{"eth0/speed": "fast\n", "eth0/duplex": "full\n"},
+			wantErr: true,
+		},
+		{
+			name:    "no device",
+			files:   map[string]string{},
+			wantErr: true,
+		},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			fakeSysfs(t, tt.files)
+			if tt.readErr != nil {
+				old := readFile
+				readFile = func(string) ([]byte, error) { return nil, tt.readErr }
+				t.Cleanup(func() { readFile = old })
+			}
+
+			got, err := PortLinkInfo("eth0")
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("PortLinkInfo(%q) = %v, want error %v", "eth0", err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("PortLinkInfo(%q) = %+v, want %+v", "eth0", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestOperState(t *testing.T) {
+	for _, state := range []string{"up", "down", "lowerlayerdown"} {
+		t.Run(state, func(t *testing.T) {
+			fakeSysfs(t, map[string]string{"br0/operstate": state + "\n"})
+
+			got, err := OperState("br0")
+			if err != nil {
+				t.Fatalf("OperState(%q) = %v, want nil", "br0", err)
+			}
+			if got != state {
+				t.Errorf("OperState(%q) = %q, want %q", "br0", got, state)
+			}
+		})
+	}
+}
+
+// fakeIoctls records the control socket ioctls as "<request> <name> [flags]".
+// SIOCGIFFLAGS reports flags, SIOCGIFINDEX reports index 1, and requests in
+// fail return an error.
+func fakeIoctls(t *testing.T, flags uint16, fail map[uint]error) *[]string {
+	t.Helper()
+	var calls []string
+	oldStr, oldIfreq := ioctlStr, ioctlIfreq
+	ioctlStr = func(_ int, req uint, name string) (int, error) {
+		calls = append(calls, fmt.Sprintf("%#x %s", req, name))
+		return 0, fail[req]
+	}
+	ioctlIfreq = func(_ int, req uint, ifr *unix.Ifreq) error {
+		switch req {
+		case unix.SIOCGIFFLAGS:
+			ifr.SetUint16(flags)
+			calls = append(calls, fmt.Sprintf("%#x %s", req, ifr.Name()))
+		case unix.SIOCGIFINDEX:
+			ifr.SetUint32(1)
+			calls = append(calls, fmt.Sprintf("%#x %s", req, ifr.Name()))
+		default:
+			calls = append(calls, fmt.Sprintf("%#x %s %#x", req, ifr.Name(), ifr.Uint16()))
+		}
+		return fail[req]
+	}
+	t.Cleanup(func() { ioctlStr, ioctlIfreq = oldStr, oldIfreq })
+	return &calls
+}
+
+func TestAddbrUp(t *testing.T) {
+	errDown := errors.New("cannot set flags")
+	for _, tt := range []struct {
+		name    string
+		fail    map[uint]error
+		want    []string
+		wantErr error
+	}{
+		{
+			name: "up",
+			want: []string{
+				fmt.Sprintf("%#x br0", unix.SIOCBRADDBR),
+				fmt.Sprintf("%#x br0", unix.SIOCGIFFLAGS),
+				fmt.Sprintf("%#x br0 %#x", unix.SIOCSIFFLAGS, unix.IFF_BROADCAST|unix.IFF_MULTICAST|unix.IFF_UP),
+			},
+		},
+		{
+			name: "set flags fails",
+			fail: map[uint]error{unix.SIOCSIFFLAGS: errDown},
+			want: []string{
+				fmt.Sprintf("%#x br0", unix.SIOCBRADDBR),
+				fmt.Sprintf("%#x br0", unix.SIOCGIFFLAGS),
+				fmt.Sprintf("%#x br0 %#x", unix.SIOCSIFFLAGS, unix.IFF_BROADCAST|unix.IFF_MULTICAST|unix.IFF_UP),
+				fmt.Sprintf("%#x br0", unix.SIOCBRDELBR),
+			},
+			wantErr: errDown,
+		},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			calls := fakeIoctls(t, unix.IFF_BROADCAST|unix.IFF_MULTICAST, tt.fail)
+
+			if err := AddbrUp("br0"); !errors.Is(err, tt.wantErr) {
+				t.Errorf("AddbrUp(%q) = %v, want %v", "br0", err, tt.wantErr)
+			}
+			if !reflect.DeepEqual(*calls, tt.want) {
+				t.Errorf("AddbrUp(%q) ioctls = %q, want %q", "br0", *calls, tt.want)
+			}
+		})
+	}
+}
+
+func TestWithFlag(t *testing.T) {
+	const flags = unix.IFF_UP | unix.IFF_BROADCAST | unix.IFF_MULTICAST
+	for _, tt := range []struct {
+		flags uint16
+		flag  uint16
+		on    bool
+		want  uint16
+	}{
+		{flags: flags, flag: unix.IFF_PROMISC, on: true, want: flags | unix.IFF_PROMISC},
+		{flags: flags | unix.IFF_PROMISC, flag: unix.IFF_PROMISC, on: true, want: flags | unix.IFF_PROMISC},
+		{flags: flags | unix.IFF_PROMISC, flag: unix.IFF_PROMISC, on: false, want: flags},
+		{flags: flags, flag: unix.IFF_PROMISC, on: false, want: flags},
+		{flags: flags | unix.IFF_PROMISC, flag: unix.IFF_ALLMULTI, on: true, want: flags | unix.IFF_PROMISC | unix.IFF_ALLMULTI},
+		{flags: flags | unix.IFF_PROMISC | unix.IFF_ALLMULTI, flag: unix.IFF_ALLMULTI, on: false, want: flags | unix.IFF_PROMISC},
+	} {
+		if got := withFlag(tt.flags, tt.flag, tt.on); got != tt.want {
+			t.Errorf("withFlag(%#x, %#x, %v) = %#x, want %#x", tt.flags, tt.flag, tt.on, got, tt.want)
+		}
+	}
+}
+
+func TestSetPromiscAllMulti(t *testing.T) {
+	const flags = unix.IFF_UP | unix.IFF_BROADCAST | unix.IFF_MULTICAST
+	for _, tt := range []struct {
+		name  string
+		set   func(string, bool) error
+		flags uint16
+		on    bool
+		want  uint16
+	}{
+		{name: "promisc on", set: SetPromisc, flags: flags, on: true, want: flags | unix.IFF_PROMISC},
+		{name: "promisc off", set: SetPromisc, flags: flags | unix.IFF_PROMISC | unix.IFF_ALLMULTI, on: false, want: flags | unix.IFF_ALLMULTI},
+		{name: "allmulti on", set: SetAllMulti, flags: flags | unix.IFF_PROMISC, on: true, want: flags | unix.IFF_PROMISC | unix.IFF_ALLMULTI},
+		{name: "allmulti off", set: SetAllMulti, flags: flags | unix.IFF_ALLMULTI, on: false, want: flags},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			calls := fakeIoctls(t, tt.flags, nil)
+
+			if err := tt.set("br0", tt.on); err != nil {
+				t.Fatalf("%s(%q, %v) = %v, want nil", tt.name, "br0", tt.on, err)
+			}
+			want := []string{
+				fmt.Sprintf("%#x br0", unix.SIOCGIFFLAGS),
+				fmt.Sprintf("%#x br0 %#x", unix.SIOCSIFFLAGS, tt.want),
+			}
+			if !reflect.DeepEqual(*calls, want) {
+				t.Errorf("%s(%q, %v) ioctls = %q, want %q", tt.name, "br0", tt.on, *calls, want)
+			}
+		})
+	}
+}
+
+func TestPromiscAllMulti(t *testing.T) {
+	for _, tt := range []struct {
+		flags        string
+		wantPromisc  bool
+		wantAllMulti bool
+	}{
+		{flags: "0x1003\n"},
+		{flags: "0x1103\n", wantPromisc: true},
+		{flags: "0x1203\n", wantAllMulti: true},
+		{flags: "0x1303\n", wantPromisc: true, wantAllMulti: true},
+	} {
+		fakeSysfs(t, map[string]string{"br0/flags": tt.flags})
+
+		if got, err := Promisc("br0"); err != nil || got != tt.wantPromisc {
+			t.Errorf("Promisc(%q) with flags %q = (%v, %v), want (%v, nil)", "br0", tt.flags, got, err, tt.wantPromisc)
+		}
+		if got, err := AllMulti("br0"); err != nil || got != tt.wantAllMulti {
+			t.Errorf("AllMulti(%q) with flags %q = (%v, %v), want (%v, nil)", "br0", tt.flags, got, err, tt.wantAllMulti)
+		}
+	}
+
+	fakeSysfs(t, map[string]string{"br0/flags": "garbage\n"})
+	if _, err := Promisc("br0"); err == nil {
+		t.Errorf("Promisc(%q) with bad flags = nil, want error", "br0")
+	}
+}
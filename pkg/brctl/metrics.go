@@ -0,0 +1,35 @@
+// Copyright 2024 the u-root Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package brctl
+
+import "time"
+
+// OnOp, if set, is called after each operation that changes a bridge or port,
+// e.g. to record latencies and error rates. op is the name of the operation,
+// such as "Addbr" or "Setfd", and iface is the device it changed: the bridge,
+// or the port for port operations. err is the error the operation returns.
+//
+// OnOp is called for the package level functions and the Brctl methods
+// alike. It must be set before any operation runs and must be safe for
+// concurrent use.
+var OnOp func(op string, iface string, dur time.Duration, err error)
+
+// noObserve is returned by observe when OnOp is not set.
+func noObserve(*error) {}
+
+// observe starts timing op. The returned function reports the operation to
+// OnOp, and is meant to be deferred with the operation's named error result:
+//
+//	defer observe("Addbr", name)(&err)
+func observe(op string, iface string) func(*error) {
+	hook := OnOp
+	if hook == nil {
+		return noObserve
+	}
+	start := time.Now()
+	return func(err *error) {
+		hook(op, iface, time.Since(start), *err)
+	}
+}
@@ -0,0 +1,75 @@
+// Copyright 2024 the u-root Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package brctl
+
+import (
+	"errors"
+	"os"
+	"reflect"
+	"testing"
+	"time"
+
+	"golang.org/x/sys/unix"
+)
+
+type opRecord struct {
+	op    string
+	iface string
+	err   error
+}
+
+// recordOps sets OnOp to record the reported operations.
+func recordOps(t *testing.T) *[]opRecord {
+	t.Helper()
+	var ops []opRecord
+	old := OnOp
+	OnOp = func(op string, iface string, dur time.Duration, err error) {
+		if dur < 0 {
+			t.Errorf("OnOp(%q) duration = %v, want >= 0", op, dur)
+		}
+		ops = append(ops, opRecord{op: op, iface: iface, err: err})
+	}
+	t.Cleanup(func() { OnOp = old })
+	return &ops
+}
+
+func TestOnOp(t *testing.T) {
+	fakeSysfs(t, defaultBridgeFiles(t))
+	errAdd := errors.New("cannot add bridge")
+	fakeIoctls(t, 0, map[uint]error{unix.SIOCBRADDBR: errAdd})
+	ops := recordOps(t)
+
+	if err := Setfd("br0", "4s"); err != nil {
+		t.Errorf("Setfd(%q) = %v, want nil", "br0", err)
+	}
+	errPathCost := Setpathcost("br0", "eth1", "4")
+	if !errors.Is(errPathCost, os.ErrNotExist) {
+		t.Errorf("Setpathcost(%q) = %v, want %v", "eth1", errPathCost, os.ErrNotExist)
+	}
+	errAddbr := Addbr("br1")
+	if !errors.Is(errAddbr, errAdd) {
+		t.Errorf("Addbr(%q) = %v, want %v", "br1", errAddbr, errAdd)
+	}
+
+	want := []opRecord{
+		{op: "Setfd", iface: "br0"},
+		{op: "Setpathcost", iface: "eth1", err: errPathCost},
+		{op: "Addbr", iface: "br1", err: errAddbr},
+	}
+	if !reflect.DeepEqual(*ops, want) {
+		t.Errorf("OnOp calls = %v, want %v", *ops, want)
+	}
+}
+
+func TestOnOpUnset(t *testing.T) {
+	fakeSysfs(t, defaultBridgeFiles(t))
+	old := OnOp
+	OnOp = nil
+	t.Cleanup(func() { OnOp = old })
+
+	if err := Setfd("br0", "4s"); err != nil {
+		t.Errorf("Setfd(%q) = %v, want nil", "br0", err)
+	}
+}
@@ -0,0 +1,209 @@
+// Copyright 2024 the u-root Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package brctl
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"syscall"
+
+	"github.com/vishvananda/netlink/nl"
+	"golang.org/x/sys/unix"
+)
+
+// ErrBridgeUp is returned if the kernel refuses to change a setting while the
+// bridge is up. Bring the bridge down and retry.
+var ErrBridgeUp = errors.New("bridge must be down")
+
+// SetMulticastSnooping enables or disables IGMP/MLD snooping on the bridge.
+// Without snooping, the bridge floods multicast to all ports. Some kernels
+// refuse to toggle snooping while the bridge is up, which is reported as
+// ErrBridgeUp. ErrNotSupported is returned if the kernel lacks the feature.
+func SetMulticastSnooping(bridge string, enabled bool) error {
+	err := setBridgeBool(bridge, BRCTL_MULTICAST_SNOOPING, enabled)
+	if errors.Is(err, syscall.EBUSY) {
+		return fmt.Errorf("%s: %w: %w", BRCTL_MULTICAST_SNOOPING, ErrBridgeUp, err)
+	}
+	if err != nil {
+		return fmt.Errorf("setBridgeBool: %w", err)
+	}
+	return nil
+}
+
+// SetMulticastQuerier enables or disables the bridge's IGMP/MLD querier.
+// ErrNotSupported is returned if the kernel lacks the feature.
+func SetMulticastQuerier(bridge string, on bool) error {
+	if err := setBridgeBool(bridge, BRCTL_MULTICAST_QUERIER, on); err != nil {
+		return fmt.Errorf("setBridgeBool: %w", err)
+	}
+	return nil
+}
+
+// MulticastQuerier reports whether the bridge's IGMP/MLD querier is enabled.
+func MulticastQuerier(bridge string) (bool, error) {
+	on, err := getBridgeBool(bridge, BRCTL_MULTICAST_QUERIER)
+	if err != nil {
+		return false, fmt.Errorf("getBridgeBool: %w", err)
+	}
+	return on, nil
+}
+
+// SetQueryUseIfAddr selects the source address of the querier's queries.
+// If on, the bridge's own IPv4 address or IPv6 link-local address is used,
+// which MLD requires; otherwise queries are sent from the unspecified
+// address. ErrNotSupported is returned if the kernel lacks the feature.
+func SetQueryUseIfAddr(bridge string, on bool) error {
+	if err := setBridgeBool(bridge, BRCTL_MULTICAST_QUERY_USE_IFADDR, on); err != nil {
+		return fmt.Errorf("setBridgeBool: %w", err)
+	}
+	return nil
+}
+
+// QueryUseIfAddr reports whether the querier sends queries from the bridge's
+// own address.
+func QueryUseIfAddr(bridge string) (bool, error) {
+	on, err := getBridgeBool(bridge, BRCTL_MULTICAST_QUERY_USE_IFADDR)
+	if err != nil {
+		return false, fmt.Errorf("getBridgeBool: %w", err)
+	}
+	return on, nil
+}
+
+// Multicast router modes of a port, from the brport/multicast_router
+// attribute.
+const (
+	mrouterDisabled  = 0
+	mrouterTempQuery = 1
+	mrouterPerm      = 2
+	mrouterTemp      = 3
+)
+
+// Attributes of RTM_GETMDB dumps, from linux/if_bridge.h.
+const (
+	mdbaRouter     = 2
+	mdbaRouterPort = 1
+)
+
+var (
+	errMRouterMode = errors.New("unknown multicast router mode")
+	errMDBDump     = errors.New("malformed MDB dump")
+)
+
+// IsMRouter reports whether the bridge forwards multicast to port as a
+// multicast router port. That is the case if port is configured as a router
+// permanently, with multicast_router 2, or if the kernel learned it as one
+// from queries or router advertisements, with multicast_router 1 or 3.
+func IsMRouter(port string) (bool, error) {
+	value, err := getPortBrportValue(port, BRCTL_MULTICAST_ROUTER)
+	if errors.Is(err, os.ErrNotExist) {
+		return false, fmt.Errorf("%s: %w", BRCTL_MULTICAST_ROUTER, ErrNotSupported)
+	}
+	if err != nil {
+		return false, fmt.Errorf("getPortBrportValue: %w", err)
+	}
+	mode, err := strconv.Atoi(strings.TrimSuffix(value, "\n"))
+	if err != nil {
+		return false, fmt.Errorf("%s: %w", BRCTL_MULTICAST_ROUTER, err)
+	}
+
+	switch mode {
+	case mrouterDisabled:
+		return false, nil
+	case mrouterPerm:
+		return true, nil
+	case mrouterTempQuery, mrouterTemp:
+	default:
+		return false, fmt.Errorf("%w %d of %s", errMRouterMode, mode, port)
+	}
+
+	index, err := linkIndex(port)
+	if err != nil {
+		return false, fmt.Errorf("linkIndex: %w", err)
+	}
+	// The port is attached to one bridge only, so it is enough to look
+	// for it among the router ports of all bridges.
+	routers, err := mrouterIndexes(0)
+	if err != nil {
+		return false, err
+	}
+	for _, r := range routers {
+		if r == index {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// MRouterPorts returns the multicast router ports of the bridge, configured
+// or learned. sysfs only shows the bridge's own router mode in
+// bridge/multicast_router, so they are read from the kernel's multicast
+// database over rtnetlink.
+func MRouterPorts(bridge string) ([]string, error) {
+	index, err := linkIndex(bridge)
+	if err != nil {
+		return nil, fmt.Errorf("linkIndex: %w", err)
+	}
+	routers, err := mrouterIndexes(index)
+	if err != nil {
+		return nil, err
+	}
+
+	ports := make([]string, 0, len(routers))
+	for _, r := range routers {
+		name, err := linkName(r)
+		if err != nil {
+			// The port was removed meanwhile.
+			continue
+		}
+		ports = append(ports, name)
+	}
+	return ports, nil
+}
+
+// mrouterIndexes returns the ifindexes of the multicast router ports of the
+// bridge with ifindex bridgeIndex, or of all bridges if it is 0.
+func mrouterIndexes(bridgeIndex int) ([]int, error) {
+	req := nl.NewNetlinkRequest(unix.RTM_GETMDB, unix.NLM_F_DUMP)
+	req.AddData(bridgeMsg{})
+	msgs, err := netlinkDump(req, unix.RTM_NEWMDB)
+	if err != nil {
+		return nil, fmt.Errorf("dump multicast database: %w", err)
+	}
+
+	var routers []int
+	for _, msg := range msgs {
+		if len(msg) < sizeofBridgeMsg {
+			return nil, fmt.Errorf("%w: %d byte message", errMDBDump, len(msg))
+		}
+		if bridgeIndex != 0 && int(nl.NativeEndian().Uint32(msg[4:])) != bridgeIndex {
+			continue
+		}
+		attrs, err := nl.ParseRouteAttr(msg[sizeofBridgeMsg:])
+		if err != nil {
+			return nil, fmt.Errorf("%w: %w", errMDBDump, err)
+		}
+		for _, a := range attrs {
+			if a.Attr.Type&nl.NLA_TYPE_MASK != mdbaRouter {
+				continue
+			}
+			ports, err := nl.ParseRouteAttr(a.Value)
+			if err != nil {
+				return nil, fmt.Errorf("%w: %w", errMDBDump, err)
+			}
+			for _, p := range ports {
+				// Newer kernels follow the ifindex with nested
+				// attributes such as the router timer.
+				if p.Attr.Type&nl.NLA_TYPE_MASK != mdbaRouterPort || len(p.Value) < 4 {
+					continue
+				}
+				routers = append(routers, int(nl.NativeEndian().Uint32(p.Value)))
+			}
+		}
+	}
+	return routers, nil
+}
@@ -0,0 +1,243 @@
+// Copyright 2024 the u-root Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package brctl
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+	"syscall"
+	"testing"
+
+	"github.com/vishvananda/netlink/nl"
+	"golang.org/x/sys/unix"
+)
+
+func TestMulticastQuerier(t *testing.T) {
+	for _, tt := range []struct {
+		name string
+		file string
+		set  func(string, bool) error
+		get  func(string) (bool, error)
+	}{
+		{
+			name: "multicast_querier",
+			file: "br0/bridge/multicast_querier",
+			set:  SetMulticastQuerier,
+			get:  MulticastQuerier,
+		},
+		{
+			name: "multicast_query_use_ifaddr",
+			file: "br0/bridge/multicast_query_use_ifaddr",
+			set:  SetQueryUseIfAddr,
+			get:  QueryUseIfAddr,
+		},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			root := fakeSysfs(t, map[string]string{tt.file: "0\n"})
+
+			for _, on := range []bool{true, false} {
+				if err := tt.set("br0", on); err != nil {
+					t.Fatalf("set(%q, %v) = %v, want nil", "br0", on, err)
+				}
+
+				want := "0"
+				if on {
+					want = "1"
+				}
+				if got := readSysfs(t, root, tt.file); got != want {
+					t.Errorf("%s = %q, want %q", tt.file, got, want)
+				}
+
+				got, err := tt.get("br0")
+				if err != nil {
+					t.Fatalf("get(%q) = %v, want nil", "br0", err)
+				}
+				if got != on {
+					t.Errorf("get(%q) = %v, want %v", "br0", got, on)
+				}
+			}
+		})
+	}
+}
+
+func TestMulticastQuerierNotSupported(t *testing.T) {
+	fakeSysfs(t, map[string]string{"br0/bridge/stp_state": "0\n"})
+
+	if err := SetQueryUseIfAddr("br0", true); !errors.Is(err, ErrNotSupported) {
+		t.Errorf("SetQueryUseIfAddr(%q, true) = %v, want %v", "br0", err, ErrNotSupported)
+	}
+	if _, err := QueryUseIfAddr("br0"); !errors.Is(err, ErrNotSupported) {
+		t.Errorf("QueryUseIfAddr(%q) = %v, want %v", "br0", err, ErrNotSupported)
+	}
+	if err := SetMulticastQuerier("br0", true); !errors.Is(err, ErrNotSupported) {
+		t.Errorf("SetMulticastQuerier(%q, true) = %v, want %v", "br0", err, ErrNotSupported)
+	}
+}
+
+func TestSetMulticastSnooping(t *testing.T) {
+	root := fakeSysfs(t, map[string]string{
+		"br0/bridge/bridge_id":          "8000.020000000000\n",
+		"br0/bridge/root_id":            "8000.020000000000\n",
+		"br0/bridge/root_port":          "0\n",
+		"br0/bridge/stp_state":          "0\n",
+		"br0/bridge/multicast_snooping": "1\n",
+		"br0/address":                   "02:00:00:00:00:00\n",
+		"br0/brif/.keep":                "",
+	})
+
+	for _, on := range []bool{false, true} {
+		if err := SetMulticastSnooping("br0", on); err != nil {
+			t.Fatalf("SetMulticastSnooping(br0, %t) = %v, want nil", on, err)
+		}
+		want := "0"
+		if on {
+			want = "1"
+		}
+		if got := readSysfs(t, root, "br0/bridge/multicast_snooping"); got != want {
+			t.Errorf("multicast_snooping = %q, want %q", got, want)
+		}
+
+		info, err := getBridgeInfo("br0")
+		if err != nil {
+			t.Fatalf("getBridgeInfo(br0) = %v, want nil", err)
+		}
+		if info.MulticastSnooping != on {
+			t.Errorf("getBridgeInfo(br0).MulticastSnooping = %t, want %t", info.MulticastSnooping, on)
+		}
+	}
+}
+
+func TestSetMulticastSnoopingErrors(t *testing.T) {
+	fakeSysfs(t, map[string]string{"br0/bridge/stp_state": "0\n"})
+	if err := SetMulticastSnooping("br0", false); !errors.Is(err, ErrNotSupported) {
+		t.Errorf("SetMulticastSnooping(br0, false) = %v, want %v", err, ErrNotSupported)
+	}
+
+	fakeSysfs(t, map[string]string{"br0/bridge/multicast_snooping": "1\n"})
+	old := writeFile
+	writeFile = func(string, []byte) error { return syscall.EBUSY }
+	t.Cleanup(func() { writeFile = old })
+
+	err := SetMulticastSnooping("br0", false)
+	if !errors.Is(err, ErrBridgeUp) || !errors.Is(err, syscall.EBUSY) {
+		t.Errorf("SetMulticastSnooping(br0, false) = %v, want %v and %v", err, ErrBridgeUp, syscall.EBUSY)
+	}
+}
+
+// mdbRouters returns an RTM_NEWMDB payload listing the router ports of the
+// bridge, with a router timer after each ifindex as newer kernels send.
+func mdbRouters(bridge uint32, ports ...uint32) []byte {
+	msg := bridgeMsg{index: bridge}.Serialize()
+	router := nl.NewRtAttr(mdbaRouter, nil)
+	for _, p := range ports {
+		port := router.AddRtAttr(mdbaRouterPort, nl.Uint32Attr(p))
+		port.AddRtAttr(1, nl.Uint32Attr(0))
+	}
+	return append(msg, router.Serialize()...)
+}
+
+// fakeLinkNames resolves ifindexes to names.
+func fakeLinkNames(t *testing.T, names map[int]string) {
+	t.Helper()
+	oldIndex, oldName := linkIndex, linkName
+	linkIndex = func(name string) (int, error) {
+		for index, n := range names {
+			if n == name {
+				return index, nil
+			}
+		}
+		return 0, fmt.Errorf("no index for %s", name)
+	}
+	linkName = func(index int) (string, error) {
+		if name, ok := names[index]; ok {
+			return name, nil
+		}
+		return "", fmt.Errorf("no name for %d", index)
+	}
+	t.Cleanup(func() { linkIndex, linkName = oldIndex, oldName })
+}
+
+func TestIsMRouter(t *testing.T) {
+	fakeLinkNames(t, map[int]string{1: "br0", 2: "eth0", 3: "eth1", 4: "br1", 5: "eth2"})
+
+	for _, tt := range []struct {
+		mode    string
+		learned bool
+		want    bool
+		dump    bool
+	}{
+		{mode: "0", learned: true, want: false},
+		{mode: "1", learned: false, want: false, dump: true},
+		{mode: "1", learned: true, want: true, dump: true},
+		{mode: "2", learned: false, want: true},
+		{mode: "3", learned: false, want: false, dump: true},
+		{mode: "3", learned: true, want: true, dump: true},
+	} {
+		t.Run(fmt.Sprintf("mode %s learned %t", tt.mode, tt.learned), func(t *testing.T) {
+			fakeSysfs(t, map[string]string{"eth0/brport/multicast_router": tt.mode + "\n"})
+			routers := []uint32{3}
+			if tt.learned {
+				routers = append(routers, 2)
+			}
+			reqs := fakeNetlinkDump(t, unix.RTM_NEWMDB, [][]byte{mdbRouters(1, routers...), mdbRouters(4, 5)}, nil)
+
+			got, err := IsMRouter("eth0")
+			if err != nil {
+				t.Fatalf("IsMRouter(eth0) = %v, want nil", err)
+			}
+			if got != tt.want {
+				t.Errorf("IsMRouter(eth0) = %t, want %t", got, tt.want)
+			}
+			if dumped := len(*reqs) > 0; dumped != tt.dump {
+				t.Errorf("IsMRouter(eth0) dumped the multicast database: %t, want %t", dumped, tt.dump)
+			}
+		})
+	}
+}
+
+func TestIsMRouterErrors(t *testing.T) {
+	fakeSysfs(t, map[string]string{"eth0/brport/state": "3\n"})
+	if _, err := IsMRouter("eth0"); !errors.Is(err, ErrNotSupported) {
+		t.Errorf("IsMRouter(eth0) = %v, want %v", err, ErrNotSupported)
+	}
+
+	fakeSysfs(t, map[string]string{"eth0/brport/multicast_router": "4\n"})
+	if _, err := IsMRouter("eth0"); !errors.Is(err, errMRouterMode) {
+		t.Errorf("IsMRouter(eth0) = %v, want %v", err, errMRouterMode)
+	}
+
+	fakeSysfs(t, map[string]string{"eth0/brport/multicast_router": "1\n"})
+	fakeLinkNames(t, map[int]string{2: "eth0"})
+	fakeNetlinkDump(t, unix.RTM_NEWMDB, [][]byte{{7, 0, 0}}, nil)
+	if _, err := IsMRouter("eth0"); !errors.Is(err, errMDBDump) {
+		t.Errorf("IsMRouter(eth0) = %v, want %v", err, errMDBDump)
+	}
+}
+
+func TestMRouterPorts(t *testing.T) {
+	// Port 6 was removed after the dump.
+	fakeLinkNames(t, map[int]string{1: "br0", 2: "eth0", 3: "eth1", 4: "br1", 5: "eth2"})
+	reqs := fakeNetlinkDump(t, unix.RTM_NEWMDB, [][]byte{mdbRouters(1, 2, 3, 6), mdbRouters(4, 5)}, nil)
+
+	got, err := MRouterPorts("br0")
+	if err != nil {
+		t.Fatalf("MRouterPorts(br0) = %v, want nil", err)
+	}
+	if want := []string{"eth0", "eth1"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("MRouterPorts(br0) = %q, want %q", got, want)
+	}
+
+	msgs, err := syscall.ParseNetlinkMessage((*reqs)[0])
+	if err != nil || len(msgs) != 1 {
+		t.Fatalf("ParseNetlinkMessage = %v, %v, want 1 message", msgs, err)
+	}
+	if h := msgs[0].Header; h.Type != unix.RTM_GETMDB || h.Flags&unix.NLM_F_DUMP != unix.NLM_F_DUMP {
+		t.Errorf("request type %d flags %#x, want RTM_GETMDB dump", h.Type, h.Flags)
+	}
+	if data := msgs[0].Data; len(data) != sizeofBridgeMsg || data[0] != unix.AF_BRIDGE {
+		t.Errorf("request = %x, want an AF_BRIDGE br_port_msg", data)
+	}
+}
@@ -0,0 +1,59 @@
+// Copyright 2024 the u-root Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package brctl
+
+import (
+	"fmt"
+	"os"
+	"runtime"
+
+	"golang.org/x/sys/unix"
+)
+
+// setns switches the calling thread's namespace. Tests replace it to avoid
+// actually leaving the test's network namespace.
+var setns = unix.Setns
+
+// WithNetns runs fn with the calling goroutine in the network namespace
+// referred to by fd, e.g. an open /proc/<pid>/ns/net or /run/netns/<name>.
+// The original namespace is restored afterwards.
+//
+// Only socket based operations (ioctls) are affected. Attributes are still
+// read from the sysfs at sysfsPath, which shows the network namespace of
+// the process that mounted it; use a sysfs mounted inside the target
+// namespace to see its devices.
+//
+// A Brctl handle keeps the control socket it was created with, which stays
+// in the namespace New ran in. Create handles inside fn to operate on the
+// target namespace, and do not use handles from outside of it.
+//
+// fn must not start goroutines that call brctl functions, as they would run
+// in the original namespace.
+func WithNetns(fd int, fn func() error) error {
+	runtime.LockOSThread()
+
+	orig, err := os.Open(fmt.Sprintf("/proc/%d/task/%d/ns/net", os.Getpid(), unix.Gettid()))
+	if err != nil {
+		runtime.UnlockOSThread()
+		return fmt.Errorf("os.Open: %w", err)
+	}
+	defer orig.Close()
+
+	if err := setns(fd, unix.CLONE_NEWNET); err != nil {
+		runtime.UnlockOSThread()
+		return fmt.Errorf("setns(%d): %w", fd, err)
+	}
+
+	fnErr := fn()
+
+	if err := setns(int(orig.Fd()), unix.CLONE_NEWNET); err != nil {
+		// Leave the thread locked so that the runtime terminates it
+		// instead of reusing it in the wrong namespace.
+		return fmt.Errorf("restoring network namespace: %w", err)
+	}
+	runtime.UnlockOSThread()
+
+	return fnErr
+}
@@ -0,0 +1,73 @@
+// Copyright 2024 the u-root Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package brctl
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+
+	"golang.org/x/sys/unix"
+)
+
+// fakeSetns records setns calls instead of switching namespaces. Calls fail
+// with the errors from errs in turn.
+func fakeSetns(t *testing.T, errs ...error) *[]int {
+	t.Helper()
+	var fds []int
+	old := setns
+	setns = func(fd int, nstype int) error {
+		if nstype != unix.CLONE_NEWNET {
+			t.Errorf("setns(%d, %#x), want nstype CLONE_NEWNET", fd, nstype)
+		}
+		fds = append(fds, fd)
+		if len(errs) == 0 {
+			return nil
+		}
+		err := errs[0]
+		errs = errs[1:]
+		return err
+	}
+	t.Cleanup(func() { setns = old })
+	return &fds
+}
+
+func TestWithNetns(t *testing.T) {
+	fds := fakeSetns(t)
+	errFn := errors.New("fn failed")
+
+	var ran bool
+	err := WithNetns(42, func() error {
+		ran = true
+		if !reflect.DeepEqual(*fds, []int{42}) {
+			t.Errorf("setns calls inside fn = %v, want [42]", *fds)
+		}
+		return errFn
+	})
+	if !errors.Is(err, errFn) {
+		t.Errorf("WithNetns(42) = %v, want %v", err, errFn)
+	}
+	if !ran {
+		t.Errorf("WithNetns(42) did not run fn")
+	}
+	if len(*fds) != 2 || (*fds)[1] == 42 {
+		t.Errorf("setns calls = %v, want [42 <original>]", *fds)
+	}
+}
+
+func TestWithNetnsSetnsFails(t *testing.T) {
+	fds := fakeSetns(t, unix.EBADF)
+
+	err := WithNetns(42, func() error {
+		t.Errorf("WithNetns(42) ran fn despite setns failing")
+		return nil
+	})
+	if !errors.Is(err, unix.EBADF) {
+		t.Errorf("WithNetns(42) = %v, want %v", err, unix.EBADF)
+	}
+	if !reflect.DeepEqual(*fds, []int{42}) {
+		t.Errorf("setns calls = %v, want [42]", *fds)
+	}
+}
@@ -0,0 +1,184 @@
+// Copyright 2024 the u-root Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package brctl
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path"
+	"strings"
+)
+
+// ChangeOp is the kind of operation of a Change.
+type ChangeOp int
+
+// The operations Plan returns.
+const (
+	// AddBridge creates the bridge.
+	AddBridge ChangeOp = iota
+	// SetBridgeAttr sets an attribute of the bridge.
+	SetBridgeAttr
+	// PrunePort detaches a port that is not in the spec.
+	PrunePort
+	// AddPort attaches a port.
+	AddPort
+	// SetPortAttr sets an attribute of a port.
+	SetPortAttr
+)
+
+var changeOpNames = map[ChangeOp]string{
+	AddBridge:     "add bridge",
+	SetBridgeAttr: "set bridge attribute",
+	PrunePort:     "prune port",
+	AddPort:       "add port",
+	SetPortAttr:   "set port attribute",
+}
+
+func (op ChangeOp) String() string {
+	if name, ok := changeOpNames[op]; ok {
+		return name
+	}
+	return fmt.Sprintf("op %d", int(op))
+}
+
+// Change is one operation that brings a bridge in line with its spec.
+type Change struct {
+	Op     ChangeOp
+	Bridge string
+
+	// Port is the port of PrunePort, AddPort and SetPortAttr.
+	Port string
+
+	// Attr is the sysfs attribute of SetBridgeAttr and SetPortAttr,
+	// From its current raw value and To the value to write. From is
+	// empty if the attribute cannot be read yet, e.g. because the bridge
+	// does not exist.
+	Attr string
+	From string
+	To   string
+}
+
+func (c Change) String() string {
+	switch c.Op {
+	case AddBridge:
+		return fmt.Sprintf("%s: add bridge", c.Bridge)
+	case SetBridgeAttr:
+		return fmt.Sprintf("%s: set %s %q -> %q", c.Bridge, c.Attr, c.From, c.To)
+	case PrunePort:
+		return fmt.Sprintf("%s: prune port %s", c.Bridge, c.Port)
+	case AddPort:
+		return fmt.Sprintf("%s: add port %s", c.Bridge, c.Port)
+	case SetPortAttr:
+		return fmt.Sprintf("%s: set %s %q -> %q", c.Port, c.Attr, c.From, c.To)
+	}
+	return fmt.Sprintf("%s: %s", c.Bridge, c.Op)
+}
+
+// Plan compares the live bridge with spec and returns the changes that
+// EnsureBridgeConfig would make, in order, without making them.
+//
+// Attributes of a bridge or port that does not exist yet are all planned to
+// be set, as they cannot be compared. Apply skips those that the kernel's
+// defaults already satisfy.
+func Plan(spec BridgeSpec) ([]Change, error) {
+	var changes []Change
+	exists := bridgeExists(spec.Name)
+	if !exists {
+		changes = append(changes, Change{Op: AddBridge, Bridge: spec.Name})
+	}
+
+	attrs, err := spec.attrs()
+	if err != nil {
+		return nil, err
+	}
+	for _, a := range attrs {
+		cur, err := getBridgeValue(spec.Name, a.name)
+		if err == nil && a.matches(cur) {
+			continue
+		}
+		if a.optional && a.value == "0" && errors.Is(err, os.ErrNotExist) {
+			continue
+		}
+		changes = append(changes, Change{Op: SetBridgeAttr, Bridge: spec.Name, Attr: a.name, From: cur, To: a.value})
+	}
+
+	if spec.Prune && exists {
+		attached, err := os.ReadDir(path.Join(sysfsPath, spec.Name, BRCTL_BRIDGE_INTERFACE))
+		if err != nil {
+			return nil, fmt.Errorf("os.ReadDir: %w", err)
+		}
+
+		wanted := make(map[string]bool, len(spec.Ports))
+		for _, port := range spec.Ports {
+			wanted[port.Name] = true
+		}
+		for _, port := range attached {
+			if !wanted[port.Name()] {
+				changes = append(changes, Change{Op: PrunePort, Bridge: spec.Name, Port: port.Name()})
+			}
+		}
+	}
+
+	for _, port := range spec.Ports {
+		if !isPortOf(spec.Name, port.Name) {
+			changes = append(changes, Change{Op: AddPort, Bridge: spec.Name, Port: port.Name})
+		}
+
+		for _, a := range port.attrs() {
+			cur, err := getPortBrportValue(port.Name, a.name)
+			cur = strings.TrimSuffix(cur, "\n")
+			if err == nil && a.matches(cur) {
+				continue
+			}
+			changes = append(changes, Change{Op: SetPortAttr, Bridge: spec.Name, Port: port.Name, Attr: a.name, From: cur, To: a.value})
+		}
+	}
+
+	return changes, nil
+}
+
+// Apply makes the changes returned by Plan.
+//
+// Attributes are read again before they are set, and left alone if they
+// already have the planned value, e.g. because the kernel's default for a new
+// bridge matches.
+func Apply(changes []Change) error {
+	for _, c := range changes {
+		switch c.Op {
+		case AddBridge:
+			if err := Addbr(c.Bridge); err != nil {
+				return fmt.Errorf("Addbr(%q): %w", c.Bridge, err)
+			}
+		case SetBridgeAttr:
+			a := sysfsAttr{name: c.Attr, value: c.To}
+			if cur, err := getBridgeValue(c.Bridge, a.name); err == nil && a.matches(cur) {
+				continue
+			}
+			if err := setBridgeValue(c.Bridge, a.name, []byte(a.value), 0); err != nil {
+				return fmt.Errorf("setBridgeValue(%q, %q): %w", c.Bridge, a.name, err)
+			}
+		case PrunePort:
+			if err := Delif(c.Bridge, c.Port); err != nil {
+				return fmt.Errorf("Delif(%q, %q): %w", c.Bridge, c.Port, err)
+			}
+		case AddPort:
+			if err := Addif(c.Bridge, c.Port); err != nil {
+				return fmt.Errorf("Addif(%q, %q): %w", c.Bridge, c.Port, err)
+			}
+		case SetPortAttr:
+			a := sysfsAttr{name: c.Attr, value: c.To}
+			if cur, err := getPortBrportValue(c.Port, a.name); err == nil && a.matches(strings.TrimSuffix(cur, "\n")) {
+				continue
+			}
+			if err := setPortBrportValue(c.Port, a.name, []byte(a.value)); err != nil {
+				return fmt.Errorf("setPortBrportValue(%q, %q): %w", c.Port, a.name, err)
+			}
+		default:
+			return fmt.Errorf("unknown change %v", c)
+		}
+	}
+	return nil
+}
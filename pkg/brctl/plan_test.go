@@ -0,0 +1,143 @@
+// Copyright 2024 the u-root Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package brctl
+
+import (
+	"fmt"
+	"reflect"
+	"strconv"
+	"testing"
+	"time"
+
+	"golang.org/x/sys/unix"
+)
+
+func TestPlan(t *testing.T) {
+	for _, tt := range []struct {
+		name string
+		spec func() BridgeSpec
+		want func() []Change
+	}{
+		{
+			name: "unchanged",
+			spec: func() BridgeSpec {
+				spec := DefaultBridgeSpec("br0")
+				spec.Ports = []PortSpec{DefaultPortSpec("eth0")}
+				return spec
+			},
+			want: func() []Change { return nil },
+		},
+		{
+			name: "diff",
+			spec: func() BridgeSpec {
+				spec := DefaultBridgeSpec("br0")
+				spec.STP = true
+				spec.ForwardDelay = 4 * time.Second
+				port := DefaultPortSpec("eth0")
+				port.PathCost = 4
+				spec.Ports = []PortSpec{port, DefaultPortSpec("eth1")}
+				spec.Prune = true
+				return spec
+			},
+			want: func() []Change {
+				return []Change{
+					{Op: SetBridgeAttr, Bridge: "br0", Attr: "forward_delay", From: jiffiesValue(t, 15*time.Second), To: jiffiesValue(t, 4*time.Second)},
+					{Op: SetBridgeAttr, Bridge: "br0", Attr: "stp_state", From: "0", To: "1"},
+					{Op: PrunePort, Bridge: "br0", Port: "eth2"},
+					{Op: SetPortAttr, Bridge: "br0", Port: "eth0", Attr: "path_cost", From: "100", To: "4"},
+					{Op: AddPort, Bridge: "br0", Port: "eth1"},
+					{Op: SetPortAttr, Bridge: "br0", Port: "eth1", Attr: "path_cost", To: "100"},
+					{Op: SetPortAttr, Bridge: "br0", Port: "eth1", Attr: "priority", To: "32"},
+				}
+			},
+		},
+		{
+			name: "new bridge",
+			spec: func() BridgeSpec {
+				spec := DefaultBridgeSpec("br1")
+				spec.Prune = true
+				return spec
+			},
+			want: func() []Change {
+				return []Change{
+					{Op: AddBridge, Bridge: "br1"},
+					{Op: SetBridgeAttr, Bridge: "br1", Attr: "ageing_time", To: jiffiesValue(t, 300*time.Second)},
+					{Op: SetBridgeAttr, Bridge: "br1", Attr: "forward_delay", To: jiffiesValue(t, 15*time.Second)},
+					{Op: SetBridgeAttr, Bridge: "br1", Attr: "hello_time", To: jiffiesValue(t, 2*time.Second)},
+					{Op: SetBridgeAttr, Bridge: "br1", Attr: "max_age", To: jiffiesValue(t, 20*time.Second)},
+					{Op: SetBridgeAttr, Bridge: "br1", Attr: "priority", To: "32768"},
+					{Op: SetBridgeAttr, Bridge: "br1", Attr: "stp_state", To: "0"},
+				}
+			},
+		},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			// eth0 and eth2 are attached, eth1 is not.
+			files := defaultBridgeFiles(t)
+			files["br0/brif/eth2/.keep"] = ""
+			files["eth2/brport/path_cost"] = "100\n"
+			files["eth2/brport/priority"] = "32\n"
+			root := fakeSysfs(t, files)
+			writes := recordWrites(t, root)
+			calls := fakeIoctls(t, 0, nil)
+
+			got, err := Plan(tt.spec())
+			if err != nil {
+				t.Fatalf("Plan() = %v, want nil", err)
+			}
+			if want := tt.want(); !reflect.DeepEqual(got, want) {
+				t.Errorf("Plan() = %v, want %v", got, want)
+			}
+			if len(*writes) != 0 || len(*calls) != 0 {
+				t.Errorf("Plan() wrote %v and called ioctls %v, want nothing", *writes, *calls)
+			}
+		})
+	}
+}
+
+// jiffiesValue returns d as the raw jiffies value of a sysfs attribute.
+func jiffiesValue(t *testing.T, d time.Duration) string {
+	t.Helper()
+	j, err := durationToJiffies(d)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return strconv.Itoa(j)
+}
+
+func TestApplyPlan(t *testing.T) {
+	files := defaultBridgeFiles(t)
+	files["br0/brif/eth2/.keep"] = ""
+	files["eth1/brport/path_cost"] = "100\n"
+	files["eth1/brport/priority"] = "32\n"
+	root := fakeSysfs(t, files)
+
+	spec := DefaultBridgeSpec("br0")
+	spec.STP = true
+	spec.Ports = []PortSpec{DefaultPortSpec("eth0"), DefaultPortSpec("eth1")}
+	spec.Prune = true
+	changes, err := Plan(spec)
+	if err != nil {
+		t.Fatalf("Plan() = %v, want nil", err)
+	}
+
+	writes := recordWrites(t, root)
+	calls := fakeIoctls(t, 0, nil)
+	if err := Apply(changes); err != nil {
+		t.Fatalf("Apply() = %v, want nil", err)
+	}
+	if want := []string{"br0/bridge/stp_state"}; !reflect.DeepEqual(*writes, want) {
+		t.Errorf("Apply() wrote %v, want %v", *writes, want)
+	}
+	wantCalls := []string{
+		fmt.Sprintf("%#x eth2", unix.SIOCGIFINDEX),
+		fmt.Sprintf("%#x br0 %#x", unix.SIOCBRDELIF, 1),
+		fmt.Sprintf("%#x eth1", unix.SIOCGIFINDEX),
+		fmt.Sprintf("%#x br0 %#x", unix.SIOCBRADDIF, 1),
+	}
+	if !reflect.DeepEqual(*calls, wantCalls) {
+		t.Errorf("Apply() ioctls = %v, want %v", *calls, wantCalls)
+	}
+}
@@ -0,0 +1,95 @@
+// Copyright 2024 the u-root Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package brctl
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"sync"
+)
+
+// PortDefaults is the learning and flooding policy of a bridge's new ports.
+//
+// Linux has no bridge level defaults for these: every port starts out
+// learning and flooding, and brport only has per port attributes. So the
+// policy is kept in this package instead, and Addif applies it to each port
+// it attaches. Ports attached otherwise, e.g. by another process, keep the
+// kernel's defaults.
+type PortDefaults struct {
+	// Learning makes the bridge learn source addresses on the port.
+	Learning bool
+	// UnicastFlood floods unknown unicast frames to the port.
+	UnicastFlood bool
+	// MulticastFlood floods unknown multicast frames to the port.
+	MulticastFlood bool
+	// BroadcastFlood floods broadcast frames to the port.
+	BroadcastFlood bool
+}
+
+// KernelPortDefaults is the policy the kernel gives new ports.
+var KernelPortDefaults = PortDefaults{
+	Learning:       true,
+	UnicastFlood:   true,
+	MulticastFlood: true,
+	BroadcastFlood: true,
+}
+
+var (
+	portDefaultsMu sync.Mutex
+	portDefaults   = map[string]PortDefaults{}
+)
+
+// SetPortDefaults sets the policy Addif applies to the ports it attaches to
+// bridge. Ports already attached are not changed.
+func SetPortDefaults(bridge string, d PortDefaults) {
+	portDefaultsMu.Lock()
+	defer portDefaultsMu.Unlock()
+
+	if d == KernelPortDefaults {
+		delete(portDefaults, bridge)
+		return
+	}
+	portDefaults[bridge] = d
+}
+
+// BridgePortDefaults returns the policy Addif applies to the ports it attaches
+// to bridge, KernelPortDefaults unless SetPortDefaults changed it.
+func BridgePortDefaults(bridge string) PortDefaults {
+	portDefaultsMu.Lock()
+	defer portDefaultsMu.Unlock()
+
+	if d, ok := portDefaults[bridge]; ok {
+		return d
+	}
+	return KernelPortDefaults
+}
+
+// applyPortDefaults applies the policy of bridge to its new port. Only
+// attributes differing from the kernel's defaults are written, so kernels
+// lacking some of them work as long as the policy does not need them.
+func (b *Brctl) applyPortDefaults(bridge string, port string) error {
+	d := BridgePortDefaults(bridge)
+	for _, attr := range []struct {
+		name string
+		on   bool
+	}{
+		{BRCTL_LEARNING, d.Learning},
+		{BRCTL_UNICAST_FLOOD, d.UnicastFlood},
+		{BRCTL_MULTICAST_FLOOD, d.MulticastFlood},
+		{BRCTL_BROADCAST_FLOOD, d.BroadcastFlood},
+	} {
+		if attr.on {
+			continue
+		}
+		if err := b.setPortBrportValue(port, attr.name, []byte("0")); err != nil {
+			if errors.Is(err, os.ErrNotExist) {
+				return fmt.Errorf("%s: %w", attr.name, ErrNotSupported)
+			}
+			return fmt.Errorf("setPortBrportValue: %w", err)
+		}
+	}
+	return nil
+}
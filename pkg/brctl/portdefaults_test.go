@@ -0,0 +1,74 @@
+// Copyright 2024 the u-root Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package brctl
+
+import (
+	"errors"
+	"testing"
+)
+
+// portFlagFiles adds the brport attributes of port with the kernel's defaults
+// to files.
+func portFlagFiles(files map[string]string, port string) map[string]string {
+	for _, name := range []string{BRCTL_LEARNING, BRCTL_UNICAST_FLOOD, BRCTL_MULTICAST_FLOOD, BRCTL_BROADCAST_FLOOD} {
+		files[port+"/brport/"+name] = "1\n"
+	}
+	return files
+}
+
+func TestAddifPortDefaults(t *testing.T) {
+	root := fakeSysfs(t, portFlagFiles(portFlagFiles(map[string]string{}, "eth0"), "eth1"))
+	fakeIoctls(t, 0, nil)
+
+	SetPortDefaults("br0", PortDefaults{Learning: false, UnicastFlood: false, MulticastFlood: true, BroadcastFlood: true})
+	t.Cleanup(func() { SetPortDefaults("br0", KernelPortDefaults) })
+
+	if got := BridgePortDefaults("br1"); got != KernelPortDefaults {
+		t.Errorf("BridgePortDefaults(br1) = %+v, want %+v", got, KernelPortDefaults)
+	}
+
+	if err := Addif("br0", "eth0"); err != nil {
+		t.Fatalf("Addif(br0, eth0) = %v, want nil", err)
+	}
+	if err := Addif("br1", "eth1"); err != nil {
+		t.Fatalf("Addif(br1, eth1) = %v, want nil", err)
+	}
+
+	for _, tt := range []struct {
+		port, name, want string
+	}{
+		{"eth0", BRCTL_LEARNING, "0"},
+		{"eth0", BRCTL_UNICAST_FLOOD, "0"},
+		{"eth0", BRCTL_MULTICAST_FLOOD, "1"},
+		{"eth0", BRCTL_BROADCAST_FLOOD, "1"},
+		// br1 has the kernel's defaults.
+		{"eth1", BRCTL_LEARNING, "1"},
+		{"eth1", BRCTL_UNICAST_FLOOD, "1"},
+	} {
+		if got := readSysfs(t, root, tt.port+"/brport/"+tt.name); got != tt.want {
+			t.Errorf("%s %s = %q, want %q", tt.port, tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestAddifPortDefaultsNotSupported(t *testing.T) {
+	// An old kernel without broadcast_flood.
+	fakeSysfs(t, map[string]string{
+		"eth0/brport/learning":      "1\n",
+		"eth0/brport/unicast_flood": "1\n",
+	})
+	fakeIoctls(t, 0, nil)
+
+	SetPortDefaults("br0", PortDefaults{Learning: false, UnicastFlood: true, MulticastFlood: true, BroadcastFlood: true})
+	t.Cleanup(func() { SetPortDefaults("br0", KernelPortDefaults) })
+	if err := Addif("br0", "eth0"); err != nil {
+		t.Errorf("Addif(br0, eth0) = %v, want nil", err)
+	}
+
+	SetPortDefaults("br0", PortDefaults{Learning: true, UnicastFlood: true, MulticastFlood: true, BroadcastFlood: false})
+	if err := Addif("br0", "eth0"); !errors.Is(err, ErrNotSupported) {
+		t.Errorf("Addif(br0, eth0) = %v, want %v", err, ErrNotSupported)
+	}
+}
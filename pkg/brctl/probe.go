@@ -0,0 +1,173 @@
+// Copyright 2024 the u-root Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package brctl
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net"
+	"time"
+
+	"golang.org/x/sys/unix"
+)
+
+const (
+	// probeEtherType is the IEEE 802 local experimental EtherType 1.
+	probeEtherType = 0x88b5
+
+	// probeMinFrame is the minimum Ethernet frame size without the FCS.
+	probeMinFrame = 60
+)
+
+// probeMagic starts the payload of probe frames.
+var probeMagic = []byte("u-root brctl probe")
+
+var broadcastMAC = net.HardwareAddr{0xff, 0xff, 0xff, 0xff, 0xff, 0xff}
+
+// probeTimeout is how long ProbeL2 waits for the probe frame.
+var probeTimeout = time.Second
+
+var (
+	errProbeSamePort = errors.New("probe source and destination are the same")
+	errProbeEndpoint = errors.New("probe endpoint is a port of the bridge")
+	errProbeTimeout  = errors.New("probe frame not received")
+)
+
+// ProbeL2 checks that bridge forwards frames between srcPort and dstPort. It
+// sends a broadcast frame with a unique payload out of srcPort and waits for
+// it on dstPort, using AF_PACKET sockets.
+//
+// srcPort and dstPort are the link partners of two different ports of the
+// bridge, e.g. the peers of veth pairs whose other ends are attached to the
+// bridge. They must not be ports of the bridge themselves: a frame sent on a
+// port leaves the bridge instead of entering it.
+//
+// The frame is broadcast, so it is also flooded to the bridge's other ports.
+func ProbeL2(bridge, srcPort, dstPort string) error {
+	if srcPort == dstPort {
+		return fmt.Errorf("%s: %w", srcPort, errProbeSamePort)
+	}
+	for _, port := range []string{srcPort, dstPort} {
+		if isPortOf(bridge, port) {
+			return fmt.Errorf("%s: %w %s", port, errProbeEndpoint, bridge)
+		}
+	}
+
+	src, err := net.InterfaceByName(srcPort)
+	if err != nil {
+		return fmt.Errorf("net.InterfaceByName: %w", err)
+	}
+	dst, err := net.InterfaceByName(dstPort)
+	if err != nil {
+		return fmt.Errorf("net.InterfaceByName: %w", err)
+	}
+
+	nonce := make([]byte, 16)
+	if _, err := rand.Read(nonce); err != nil {
+		return fmt.Errorf("rand.Read: %w", err)
+	}
+
+	// Listen first, so the frame cannot be missed.
+	rfd, err := packetSocket(dst.Index)
+	if err != nil {
+		return fmt.Errorf("socket on %s: %w", dstPort, err)
+	}
+	defer unix.Close(rfd)
+
+	// Protocol 0 only sends, it receives no frames.
+	sfd, err := unix.Socket(unix.AF_PACKET, unix.SOCK_RAW|unix.SOCK_CLOEXEC, 0)
+	if err != nil {
+		return fmt.Errorf("socket on %s: %w", srcPort, err)
+	}
+	defer unix.Close(sfd)
+
+	to := &unix.SockaddrLinklayer{
+		Protocol: htons(probeEtherType),
+		Ifindex:  src.Index,
+		Halen:    6,
+	}
+	copy(to.Addr[:], broadcastMAC)
+	if err := unix.Sendto(sfd, probeFrame(src.HardwareAddr, nonce), 0, to); err != nil {
+		return fmt.Errorf("sending probe on %s: %w", srcPort, err)
+	}
+
+	return waitProbe(rfd, nonce, time.Now().Add(probeTimeout))
+}
+
+// packetSocket opens a raw AF_PACKET socket receiving the probe frames of the
+// interface with the given index.
+func packetSocket(index int) (int, error) {
+	fd, err := unix.Socket(unix.AF_PACKET, unix.SOCK_RAW|unix.SOCK_CLOEXEC, int(htons(probeEtherType)))
+	if err != nil {
+		return -1, err
+	}
+	if err := unix.Bind(fd, &unix.SockaddrLinklayer{Protocol: htons(probeEtherType), Ifindex: index}); err != nil {
+		unix.Close(fd)
+		return -1, err
+	}
+	return fd, nil
+}
+
+// waitProbe reads frames from fd until the probe frame with nonce arrives or
+// the deadline passes.
+func waitProbe(fd int, nonce []byte, deadline time.Time) error {
+	buf := make([]byte, 1518)
+	for {
+		left := time.Until(deadline)
+		if left <= 0 {
+			return errProbeTimeout
+		}
+		tv := unix.NsecToTimeval(left.Nanoseconds())
+		if err := unix.SetsockoptTimeval(fd, unix.SOL_SOCKET, unix.SO_RCVTIMEO, &tv); err != nil {
+			return fmt.Errorf("setsockopt: %w", err)
+		}
+		n, from, err := unix.Recvfrom(fd, buf, 0)
+		switch {
+		case errors.Is(err, unix.EAGAIN), errors.Is(err, unix.EINTR):
+			continue
+		case err != nil:
+			return fmt.Errorf("recvfrom: %w", err)
+		}
+		if ll, ok := from.(*unix.SockaddrLinklayer); ok && ll.Pkttype == unix.PACKET_OUTGOING {
+			continue
+		}
+		if isProbe(buf[:n], nonce) {
+			return nil
+		}
+	}
+}
+
+// probeFrame builds the broadcast probe frame sent from src.
+func probeFrame(src net.HardwareAddr, nonce []byte) []byte {
+	frame := make([]byte, 14, probeMinFrame)
+	copy(frame[0:6], broadcastMAC)
+	copy(frame[6:12], src)
+	binary.BigEndian.PutUint16(frame[12:14], probeEtherType)
+	frame = append(frame, probeMagic...)
+	frame = append(frame, nonce...)
+	if len(frame) < probeMinFrame {
+		frame = append(frame, make([]byte, probeMinFrame-len(frame))...)
+	}
+	return frame
+}
+
+// isProbe reports whether frame is the probe frame with nonce.
+func isProbe(frame []byte, nonce []byte) bool {
+	if len(frame) < 14 || binary.BigEndian.Uint16(frame[12:14]) != probeEtherType {
+		return false
+	}
+	payload := frame[14:]
+	return bytes.HasPrefix(payload, probeMagic) && bytes.HasPrefix(payload[len(probeMagic):], nonce)
+}
+
+// htons converts a short to network byte order.
+func htons(v uint16) uint16 {
+	var b [2]byte
+	binary.BigEndian.PutUint16(b[:], v)
+	return binary.NativeEndian.Uint16(b[:])
+}
@@ -0,0 +1,103 @@
+// Copyright 2024 the u-root Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+//go:build integration
+
+package brctl
+
+// This test creates a bridge and veth pairs in a new network namespace, so it
+// needs CAP_NET_ADMIN and CAP_SYS_ADMIN and only runs with the integration
+// tag:
+//
+//	sudo go test -tags integration -run TestIntegrationProbeL2 ./pkg/brctl
+
+import (
+	"errors"
+	"os"
+	"runtime"
+	"testing"
+
+	"github.com/vishvananda/netlink"
+	"golang.org/x/sys/unix"
+)
+
+// inNetns runs fn in a new network and mount namespace with sysfs mounted at
+// sysfsPath. The namespaces vanish with the thread running fn.
+func inNetns(t *testing.T, fn func() error) error {
+	t.Helper()
+	if os.Geteuid() != 0 {
+		t.Skip("skipping, root is required")
+	}
+	dir := t.TempDir()
+
+	errc := make(chan error, 1)
+	go func() {
+		// Never unlocked: the runtime terminates the thread with the
+		// goroutine instead of reusing it in the new namespaces.
+		runtime.LockOSThread()
+		if err := unix.Unshare(unix.CLONE_NEWNET | unix.CLONE_NEWNS); err != nil {
+			errc <- err
+			return
+		}
+		if err := unix.Mount("", "/", "", unix.MS_REC|unix.MS_PRIVATE, ""); err != nil {
+			errc <- err
+			return
+		}
+		if err := unix.Mount("sysfs", dir, "sysfs", 0, ""); err != nil {
+			errc <- err
+			return
+		}
+		errc <- fn()
+	}()
+
+	old := sysfsPath
+	sysfsPath = dir
+	t.Cleanup(func() { sysfsPath = old })
+	return <-errc
+}
+
+// addVeth adds a veth pair and sets both ends up.
+func addVeth(name, peer string) error {
+	veth := &netlink.Veth{LinkAttrs: netlink.LinkAttrs{Name: name}, PeerName: peer}
+	if err := netlink.LinkAdd(veth); err != nil {
+		return err
+	}
+	for _, n := range []string{name, peer} {
+		if err := SetLinkUp(n); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func TestIntegrationProbeL2(t *testing.T) {
+	err := inNetns(t, func() error {
+		if err := AddbrUp("br0"); err != nil {
+			return err
+		}
+		// port0 and port1 are ports of br0, their peers host0 and
+		// host1 are the probe endpoints. host2's pair is not attached.
+		for _, p := range [][2]string{{"port0", "host0"}, {"port1", "host1"}, {"port2", "host2"}} {
+			if err := addVeth(p[0], p[1]); err != nil {
+				return err
+			}
+		}
+		for _, port := range []string{"port0", "port1"} {
+			if err := Addif("br0", port); err != nil {
+				return err
+			}
+		}
+
+		if err := ProbeL2("br0", "host0", "host1"); err != nil {
+			return err
+		}
+		if err := ProbeL2("br0", "host0", "host2"); !errors.Is(err, errProbeTimeout) {
+			t.Errorf("ProbeL2(br0, host0, host2) = %v, want %v", err, errProbeTimeout)
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+}
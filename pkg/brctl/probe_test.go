@@ -0,0 +1,63 @@
+// Copyright 2024 the u-root Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package brctl
+
+import (
+	"bytes"
+	"errors"
+	"net"
+	"testing"
+)
+
+func TestProbeFrame(t *testing.T) {
+	src := net.HardwareAddr{0x02, 0, 0, 0, 0, 1}
+	nonce := []byte("0123456789abcdef")
+	frame := probeFrame(src, nonce)
+
+	if len(frame) != probeMinFrame {
+		t.Errorf("len(probeFrame()) = %d, want %d", len(frame), probeMinFrame)
+	}
+	if !bytes.Equal(frame[0:6], broadcastMAC) || !bytes.Equal(frame[6:12], src) {
+		t.Errorf("probeFrame() addresses = %x, want %x %x", frame[0:12], broadcastMAC, src)
+	}
+
+	for _, tt := range []struct {
+		name  string
+		frame []byte
+		want  bool
+	}{
+		{name: "probe", frame: frame, want: true},
+		{name: "other nonce", frame: probeFrame(src, []byte("fedcba9876543210")), want: false},
+		{name: "other EtherType", frame: append(append(append([]byte{}, frame[:12]...), 0x08, 0x00), frame[14:]...), want: false},
+		{name: "short", frame: frame[:10], want: false},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := isProbe(tt.frame, nonce); got != tt.want {
+				t.Errorf("isProbe() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestProbeL2Endpoints(t *testing.T) {
+	fakeSysfs(t, map[string]string{"br0/brif/eth0/.keep": ""})
+
+	for _, tt := range []struct {
+		name    string
+		src     string
+		dst     string
+		wantErr error
+	}{
+		{name: "same", src: "veth0", dst: "veth0", wantErr: errProbeSamePort},
+		{name: "src is port", src: "eth0", dst: "veth0", wantErr: errProbeEndpoint},
+		{name: "dst is port", src: "veth0", dst: "eth0", wantErr: errProbeEndpoint},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := ProbeL2("br0", tt.src, tt.dst); !errors.Is(err, tt.wantErr) {
+				t.Errorf("ProbeL2(br0, %s, %s) = %v, want %v", tt.src, tt.dst, err, tt.wantErr)
+			}
+		})
+	}
+}
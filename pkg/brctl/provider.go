@@ -0,0 +1,67 @@
+// Copyright 2024 the u-root Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package brctl
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+
+	"golang.org/x/sys/unix"
+)
+
+// ConfigureProviderBridge creates the bridge name as an 802.1ad provider
+// bridge for QinQ: it filters VLANs by their S-tag, and untagged frames and
+// frames of customers, C-tagged only, are assigned to the service VLAN
+// svlan.
+//
+// The attributes are written in the order the kernel accepts them: the VLAN
+// protocol and default PVID before VLAN filtering, since older kernels
+// refuse to change the default PVID while filtering. If any of them cannot
+// be written, the bridge is deleted again. ErrNotSupported is returned if
+// the kernel lacks VLAN filtering.
+func ConfigureProviderBridge(name string, svlan uint16) error {
+	b, err := New()
+	if err != nil {
+		return err
+	}
+	defer b.Close()
+
+	return b.ConfigureProviderBridge(name, svlan)
+}
+
+// ConfigureProviderBridge is the handle version of the package level
+// ConfigureProviderBridge.
+func (b *Brctl) ConfigureProviderBridge(name string, svlan uint16) (err error) {
+	defer observe("ConfigureProviderBridge", name)(&err)
+
+	if svlan < minVID || svlan > maxVID {
+		return fmt.Errorf("%w: %d", errInvalidVID, svlan)
+	}
+	if err := b.Addbr(name); err != nil {
+		return err
+	}
+
+	values := []bridgeValue{
+		{name: BRCTL_VLAN_PROTOCOL, value: fmt.Sprintf("%#04x", unix.ETH_P_8021AD)},
+		{name: BRCTL_DEFAULT_PVID, value: strconv.Itoa(int(svlan))},
+		{name: BRCTL_VLAN_FILTERING, value: "1"},
+	}
+	for _, v := range values {
+		err := b.setBridgeValue(name, v.name, []byte(v.value))
+		if errors.Is(err, os.ErrNotExist) {
+			err = fmt.Errorf("%s: %w", v.name, ErrNotSupported)
+		}
+		if err != nil {
+			err = fmt.Errorf("setBridgeValue: %w", err)
+			if derr := b.Delbr(name); derr != nil {
+				return fmt.Errorf("%w; deleting bridge: %v", err, derr)
+			}
+			return err
+		}
+	}
+	return nil
+}
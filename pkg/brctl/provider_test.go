@@ -0,0 +1,83 @@
+// Copyright 2024 the u-root Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package brctl
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+	"testing"
+
+	"golang.org/x/sys/unix"
+)
+
+func TestConfigureProviderBridge(t *testing.T) {
+	root := fakeSysfs(t, map[string]string{
+		"br0/bridge/vlan_protocol":  "0x8100\n",
+		"br0/bridge/default_pvid":   "1\n",
+		"br0/bridge/vlan_filtering": "0\n",
+	})
+	writes := recordWrites(t, root)
+	calls := fakeIoctls(t, 0, nil)
+
+	if err := ConfigureProviderBridge("br0", 100); err != nil {
+		t.Fatalf("ConfigureProviderBridge(br0, 100) = %v, want nil", err)
+	}
+
+	if want := []string{fmt.Sprintf("%#x br0", unix.SIOCBRADDBR)}; !reflect.DeepEqual(*calls, want) {
+		t.Errorf("ioctls = %q, want %q", *calls, want)
+	}
+	want := []string{"br0/bridge/vlan_protocol", "br0/bridge/default_pvid", "br0/bridge/vlan_filtering"}
+	if !reflect.DeepEqual(*writes, want) {
+		t.Errorf("writes = %q, want %q", *writes, want)
+	}
+	for file, value := range map[string]string{
+		"br0/bridge/vlan_protocol":  "0x88a8",
+		"br0/bridge/default_pvid":   "100",
+		"br0/bridge/vlan_filtering": "1",
+	} {
+		if got := readSysfs(t, root, file); got != value {
+			t.Errorf("%s = %q, want %q", file, got, value)
+		}
+	}
+}
+
+func TestConfigureProviderBridgeErrors(t *testing.T) {
+	for _, tt := range []struct {
+		name    string
+		svlan   uint16
+		files   map[string]string
+		want    []string
+		wantErr error
+	}{
+		{
+			name:    "invalid VLAN",
+			svlan:   4095,
+			wantErr: errInvalidVID,
+		},
+		{
+			name:  "no VLAN filtering",
+			svlan: 100,
+			files: map[string]string{"br0/bridge/stp_state": "0\n"},
+			want: []string{
+				fmt.Sprintf("%#x br0", unix.SIOCBRADDBR),
+				fmt.Sprintf("%#x br0", unix.SIOCBRDELBR),
+			},
+			wantErr: ErrNotSupported,
+		},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			fakeSysfs(t, tt.files)
+			calls := fakeIoctls(t, 0, nil)
+
+			if err := ConfigureProviderBridge("br0", tt.svlan); !errors.Is(err, tt.wantErr) {
+				t.Errorf("ConfigureProviderBridge(br0, %d) = %v, want %v", tt.svlan, err, tt.wantErr)
+			}
+			if !reflect.DeepEqual(*calls, tt.want) {
+				t.Errorf("ioctls = %q, want %q", *calls, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,138 @@
+// Copyright 2024 the u-root Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package brctl
+
+import (
+	"fmt"
+	"path"
+	"strconv"
+	"strings"
+)
+
+// Role is the STP role of a bridge port.
+type Role int
+
+// STP port roles.
+const (
+	// RoleDisabled ports do not take part in STP.
+	RoleDisabled Role = iota
+	// The RoleRoot port has the best path towards the root bridge.
+	RoleRoot
+	// RoleDesignated ports connect their segment to the root bridge.
+	RoleDesignated
+	// RoleAlternate ports are blocked, because another bridge is
+	// designated for their segment.
+	RoleAlternate
+	// RoleBackup ports are blocked, because another port of the same
+	// bridge is designated for their segment.
+	RoleBackup
+)
+
+var roleNames = map[Role]string{
+	RoleDisabled:   "disabled",
+	RoleRoot:       "root",
+	RoleDesignated: "designated",
+	RoleAlternate:  "alternate",
+	RoleBackup:     "backup",
+}
+
+func (r Role) String() string {
+	if name, ok := roleNames[r]; ok {
+		return name
+	}
+	return fmt.Sprintf("role %d", int(r))
+}
+
+// getPortBridgeValue reads an attribute of the bridge the port is attached to,
+// following the brport/bridge link.
+func getPortBridgeValue(port string, name string) (string, error) {
+	out, err := readFile(path.Join(sysfsPath, port, "brport", "bridge", "bridge", name))
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSuffix(string(out), "\n"), nil
+}
+
+// parsePortNumber parses a port number or id, which sysfs shows in hex or
+// decimal depending on the attribute.
+func parsePortNumber(raw string) (uint16, error) {
+	n, err := strconv.ParseUint(strings.TrimSuffix(raw, "\n"), 0, 16)
+	if err != nil {
+		return 0, fmt.Errorf("strconv.ParseUint(%q) = %w", raw, err)
+	}
+	return uint16(n), nil
+}
+
+// PortRole returns the STP role of the port, derived like the kernel's STP
+// implementation does from the port state, the designated bridge and port of
+// the port's segment, and the root port of the bridge.
+func PortRole(port string) (Role, error) {
+	state, err := getPortInt(port, BRCTL_PORT_STATE)
+	if err != nil {
+		return 0, err
+	}
+	if state == BR_STATE_DISABLED {
+		return RoleDisabled, nil
+	}
+
+	raw := map[string]string{}
+	for _, name := range []string{BRCTL_PORT_NO, BRCTL_PORT_ID, BRCTL_DESIGNATED_BRIDGE, BRCTL_DESIGNATED_PORT} {
+		v, err := getPortBrportValue(port, name)
+		if err != nil {
+			return 0, fmt.Errorf("getPortBrportValue: %w", err)
+		}
+		raw[name] = strings.TrimSuffix(v, "\n")
+	}
+	bridgeID, err := getPortBridgeValue(port, BRCTL_BRIDGEID)
+	if err != nil {
+		return 0, fmt.Errorf("getPortBridgeValue: %w", err)
+	}
+	rootPort, err := getPortBridgeValue(port, BRCTL_ROOT_PORT)
+	if err != nil {
+		return 0, fmt.Errorf("getPortBridgeValue: %w", err)
+	}
+
+	portNo, err := parsePortNumber(raw[BRCTL_PORT_NO])
+	if err != nil {
+		return 0, err
+	}
+	rootPortNo, err := parsePortNumber(rootPort)
+	if err != nil {
+		return 0, err
+	}
+	if rootPortNo != 0 && rootPortNo == portNo {
+		return RoleRoot, nil
+	}
+
+	if raw[BRCTL_DESIGNATED_BRIDGE] != bridgeID {
+		return RoleAlternate, nil
+	}
+	portID, err := parsePortNumber(raw[BRCTL_PORT_ID])
+	if err != nil {
+		return 0, err
+	}
+	designatedPort, err := parsePortNumber(raw[BRCTL_DESIGNATED_PORT])
+	if err != nil {
+		return 0, err
+	}
+	if designatedPort == portID {
+		return RoleDesignated, nil
+	}
+	return RoleBackup, nil
+}
+
+// RootPathCost returns the bridge's cost of the path to the root bridge. It
+// is 0 on the root bridge itself.
+func RootPathCost(bridge string) (int, error) {
+	raw, err := getBridgeValue(bridge, BRCTL_ROOT_PATH_COST)
+	if err != nil {
+		return 0, fmt.Errorf("getBridgeValue: %w", err)
+	}
+	cost, err := strconv.Atoi(raw)
+	if err != nil {
+		return 0, fmt.Errorf("strconv.Atoi(%q) = %w", raw, err)
+	}
+	return cost, nil
+}
@@ -0,0 +1,116 @@
+// Copyright 2024 the u-root Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package brctl
+
+import (
+	"errors"
+	"os"
+	"testing"
+)
+
+func TestPortRole(t *testing.T) {
+	const (
+		self  = "8000.020000000001"
+		other = "1000.020000000002"
+	)
+	for _, tt := range []struct {
+		name string
+		// brport attributes of eth0, port 2 of the bridge.
+		state, designatedBridge, designatedPort string
+		// rootPort is the root port number of the bridge.
+		rootPort string
+		want     Role
+	}{
+		{
+			name:             "disabled",
+			state:            "0",
+			designatedBridge: self,
+			designatedPort:   "32770",
+			rootPort:         "0",
+			want:             RoleDisabled,
+		},
+		{
+			name:             "root",
+			state:            "3",
+			designatedBridge: other,
+			designatedPort:   "32769",
+			rootPort:         "2",
+			want:             RoleRoot,
+		},
+		{
+			name:             "designated",
+			state:            "3",
+			designatedBridge: self,
+			designatedPort:   "32770",
+			rootPort:         "1",
+			want:             RoleDesignated,
+		},
+		{
+			// With STP off, the bridge is its own root.
+			name:             "designated on root bridge",
+			state:            "3",
+			designatedBridge: self,
+			designatedPort:   "32770",
+			rootPort:         "0",
+			want:             RoleDesignated,
+		},
+		{
+			name:             "alternate",
+			state:            "4",
+			designatedBridge: other,
+			designatedPort:   "32771",
+			rootPort:         "1",
+			want:             RoleAlternate,
+		},
+		{
+			name:             "backup",
+			state:            "4",
+			designatedBridge: self,
+			designatedPort:   "32769",
+			rootPort:         "3",
+			want:             RoleBackup,
+		},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			fakeSysfs(t, map[string]string{
+				"eth0/brport/state":                   tt.state + "\n",
+				"eth0/brport/port_no":                 "0x2\n",
+				"eth0/brport/port_id":                 "0x8002\n",
+				"eth0/brport/designated_bridge":       tt.designatedBridge + "\n",
+				"eth0/brport/designated_port":         tt.designatedPort + "\n",
+				"eth0/brport/bridge/bridge/bridge_id": self + "\n",
+				"eth0/brport/bridge/bridge/root_port": tt.rootPort + "\n",
+			})
+
+			got, err := PortRole("eth0")
+			if err != nil {
+				t.Fatalf("PortRole(%q) = %v, want nil", "eth0", err)
+			}
+			if got != tt.want {
+				t.Errorf("PortRole(%q) = %v, want %v", "eth0", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestPortRoleNotAPort(t *testing.T) {
+	fakeSysfs(t, map[string]string{"eth0/carrier": "1\n"})
+
+	if _, err := PortRole("eth0"); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("PortRole(%q) = %v, want %v", "eth0", err, os.ErrNotExist)
+	}
+}
+
+func TestRootPathCost(t *testing.T) {
+	fakeSysfs(t, map[string]string{"br0/bridge/root_path_cost": "104\n"})
+
+	got, err := RootPathCost("br0")
+	if err != nil {
+		t.Fatalf("RootPathCost(%q) = %v, want nil", "br0", err)
+	}
+	if got != 104 {
+		t.Errorf("RootPathCost(%q) = %d, want %d", "br0", got, 104)
+	}
+}
@@ -0,0 +1,291 @@
+// Copyright 2024 the u-root Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package brctl
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// BridgeSpec describes the complete configuration of a bridge.
+// Start from DefaultBridgeSpec to get the kernel's defaults.
+type BridgeSpec struct {
+	Name         string
+	STP          bool
+	ForwardDelay time.Duration
+	HelloTime    time.Duration
+	MaxAge       time.Duration
+	AgeingTime   time.Duration
+	Priority     uint16
+
+	// The VLAN attributes are missing on older kernels. Leaving them
+	// disabled works everywhere.
+	VLANFiltering    bool
+	VLANStats        bool
+	VLANStatsPerPort bool
+
+	Ports []PortSpec
+
+	// Prune detaches ports that are attached to the bridge but missing
+	// from Ports, so that the bridge ends up with exactly Ports.
+	Prune bool
+}
+
+// PortSpec describes the configuration of a bridge port.
+// Start from DefaultPortSpec to get the kernel's defaults.
+type PortSpec struct {
+	Name     string
+	PathCost uint32
+	Priority uint8
+}
+
+// DefaultBridgeSpec returns the configuration the kernel gives a new bridge.
+func DefaultBridgeSpec(name string) BridgeSpec {
+	return BridgeSpec{
+		Name:         name,
+		ForwardDelay: 15 * time.Second,
+		HelloTime:    2 * time.Second,
+		MaxAge:       20 * time.Second,
+		AgeingTime:   300 * time.Second,
+		Priority:     0x8000,
+	}
+}
+
+// DefaultPortSpec returns the configuration the kernel gives a new port.
+func DefaultPortSpec(name string) PortSpec {
+	return PortSpec{
+		Name:     name,
+		PathCost: 100,
+		Priority: 0x20,
+	}
+}
+
+// sysfsAttr is the raw value of a sysfs attribute.
+type sysfsAttr struct {
+	name  string
+	value string
+
+	// optional attributes may be missing from older kernels. They are
+	// only required to exist if value differs from the default "0".
+	optional bool
+}
+
+// matches reports whether cur, as read from sysfs, already satisfies a.
+func (a sysfsAttr) matches(cur string) bool {
+	// Enabling STP shows as 2 if user space runs the protocol.
+	if a.name == BRCTL_STP_STATE && a.value == "1" {
+		return cur != "0"
+	}
+	return cur == a.value
+}
+
+// boolAttr returns the sysfs representation of on.
+func boolAttr(on bool) string {
+	if on {
+		return "1"
+	}
+	return "0"
+}
+
+// attrs returns the bridge attributes described by spec in the order they
+// have to be written: the STP parameters before STP itself is switched.
+func (spec BridgeSpec) attrs() ([]sysfsAttr, error) {
+	var attrs []sysfsAttr
+	for _, timer := range []struct {
+		name string
+		d    time.Duration
+	}{
+		{BRCTL_AGEING_TIME, spec.AgeingTime},
+		{BRCTL_FORWARD_DELAY, spec.ForwardDelay},
+		{BRCTL_HELLO_TIME, spec.HelloTime},
+		{BRCTL_MAX_AGE, spec.MaxAge},
+	} {
+		jiffies, err := durationToJiffies(timer.d)
+		if err != nil {
+			return nil, fmt.Errorf("durationToJiffies(%v) = %w", timer.d, err)
+		}
+		attrs = append(attrs, sysfsAttr{name: timer.name, value: strconv.Itoa(jiffies)})
+	}
+
+	return append(attrs,
+		sysfsAttr{name: BRCTL_BRIDGE_PRIO, value: strconv.FormatUint(uint64(spec.Priority), 10)},
+		sysfsAttr{name: BRCTL_VLAN_FILTERING, value: boolAttr(spec.VLANFiltering), optional: true},
+		sysfsAttr{name: BRCTL_VLAN_STATS_ENABLED, value: boolAttr(spec.VLANStats), optional: true},
+		sysfsAttr{name: BRCTL_VLAN_STATS_PER_PORT, value: boolAttr(spec.VLANStatsPerPort), optional: true},
+		sysfsAttr{name: BRCTL_STP_STATE, value: boolAttr(spec.STP)},
+	), nil
+}
+
+// attrs returns the port attributes described by spec.
+func (spec PortSpec) attrs() []sysfsAttr {
+	return []sysfsAttr{
+		{name: BRCTL_PATH_COST, value: strconv.FormatUint(uint64(spec.PathCost), 10)},
+		{name: BRCTL_PRIORITY, value: strconv.FormatUint(uint64(spec.Priority), 10)},
+	}
+}
+
+// bridgeExists returns whether name is an existing bridge device.
+func bridgeExists(name string) bool {
+	_, err := os.Stat(path.Join(sysfsPath, name, "bridge"))
+	return err == nil
+}
+
+// isPortOf returns whether port is attached to bridge.
+func isPortOf(bridge string, port string) bool {
+	_, err := os.Stat(path.Join(sysfsPath, bridge, BRCTL_BRIDGE_INTERFACE, port))
+	return err == nil
+}
+
+// EnsureBridgeConfig brings the bridge in line with spec. The bridge is created
+// and the ports are attached if necessary. With spec.Prune, other ports are
+// detached.
+//
+// Only attributes whose current value differs from spec are written, so
+// re-applying an unchanged spec does not disturb e.g. live STP state. Plan
+// shows the changes without making them.
+func EnsureBridgeConfig(spec BridgeSpec) error {
+	changes, err := Plan(spec)
+	if err != nil {
+		return err
+	}
+	return Apply(changes)
+}
+
+// DumpConfig reads the configuration of an existing bridge and its ports.
+// Passing the result to EnsureBridgeConfig reproduces the bridge.
+func DumpConfig(bridge string) (BridgeSpec, error) {
+	spec := BridgeSpec{Name: bridge}
+
+	stp, err := getBridgeValue(bridge, BRCTL_STP_STATE)
+	if err != nil {
+		return BridgeSpec{}, fmt.Errorf("getBridgeValue: %w", err)
+	}
+	spec.STP = stp != "0"
+
+	for _, timer := range []struct {
+		name string
+		d    *time.Duration
+	}{
+		{BRCTL_AGEING_TIME, &spec.AgeingTime},
+		{BRCTL_FORWARD_DELAY, &spec.ForwardDelay},
+		{BRCTL_HELLO_TIME, &spec.HelloTime},
+		{BRCTL_MAX_AGE, &spec.MaxAge},
+	} {
+		raw, err := getBridgeValue(bridge, timer.name)
+		if err != nil {
+			return BridgeSpec{}, fmt.Errorf("getBridgeValue: %w", err)
+		}
+		jiffies, err := strconv.Atoi(raw)
+		if err != nil {
+			return BridgeSpec{}, fmt.Errorf("strconv.Atoi(%q) = %w", raw, err)
+		}
+		if *timer.d, err = jiffiesToDuration(jiffies); err != nil {
+			return BridgeSpec{}, err
+		}
+	}
+
+	prio, err := getBridgeValue(bridge, BRCTL_BRIDGE_PRIO)
+	if err != nil {
+		return BridgeSpec{}, fmt.Errorf("getBridgeValue: %w", err)
+	}
+	p, err := strconv.ParseUint(prio, 10, 16)
+	if err != nil {
+		return BridgeSpec{}, fmt.Errorf("strconv.ParseUint(%q) = %w", prio, err)
+	}
+	spec.Priority = uint16(p)
+
+	for _, vlan := range []struct {
+		name string
+		on   *bool
+	}{
+		{BRCTL_VLAN_FILTERING, &spec.VLANFiltering},
+		{BRCTL_VLAN_STATS_ENABLED, &spec.VLANStats},
+		{BRCTL_VLAN_STATS_PER_PORT, &spec.VLANStatsPerPort},
+	} {
+		on, err := getBridgeBool(bridge, vlan.name)
+		if err != nil && !errors.Is(err, ErrNotSupported) {
+			return BridgeSpec{}, fmt.Errorf("getBridgeBool: %w", err)
+		}
+		*vlan.on = on
+	}
+
+	ports, err := os.ReadDir(path.Join(sysfsPath, bridge, BRCTL_BRIDGE_INTERFACE))
+	if err != nil {
+		return BridgeSpec{}, fmt.Errorf("os.ReadDir: %w", err)
+	}
+	for _, port := range ports {
+		ps, err := dumpPortConfig(port.Name())
+		if err != nil {
+			return BridgeSpec{}, err
+		}
+		spec.Ports = append(spec.Ports, ps)
+	}
+
+	return spec, nil
+}
+
+// dumpPortConfig reads the configuration of a bridge port.
+func dumpPortConfig(port string) (PortSpec, error) {
+	values := make(map[string]uint64)
+	for _, attr := range []struct {
+		name string
+		bits int
+	}{
+		{BRCTL_PATH_COST, 32},
+		{BRCTL_PRIORITY, 8},
+	} {
+		raw, err := getPortBrportValue(port, attr.name)
+		if err != nil {
+			return PortSpec{}, fmt.Errorf("getPortBrportValue: %w", err)
+		}
+		raw = strings.TrimSuffix(raw, "\n")
+		if values[attr.name], err = strconv.ParseUint(raw, 10, attr.bits); err != nil {
+			return PortSpec{}, fmt.Errorf("strconv.ParseUint(%q) = %w", raw, err)
+		}
+	}
+
+	return PortSpec{
+		Name:     port,
+		PathCost: uint32(values[BRCTL_PATH_COST]),
+		Priority: uint8(values[BRCTL_PRIORITY]),
+	}, nil
+}
+
+// DumpPortAttrs reads every attribute in /sys/class/net/<port>/brport into
+// a map from attribute name to value, without the trailing newline.
+// Entries that are not regular files, such as the bridge link, and
+// write-only attributes, such as flush, are skipped.
+// This covers attributes that have no typed accessor in this package.
+func DumpPortAttrs(port string) (map[string]string, error) {
+	entries, err := os.ReadDir(path.Join(sysfsPath, port, "brport"))
+	if err != nil {
+		return nil, fmt.Errorf("os.ReadDir: %w", err)
+	}
+
+	attrs := make(map[string]string, len(entries))
+	for _, entry := range entries {
+		if !entry.Type().IsRegular() {
+			continue
+		}
+		info, err := entry.Info()
+		if err != nil {
+			return nil, fmt.Errorf("entry.Info: %w", err)
+		}
+		if info.Mode().Perm()&0o444 == 0 {
+			continue
+		}
+		value, err := getPortBrportValue(port, entry.Name())
+		if err != nil {
+			return nil, fmt.Errorf("getPortBrportValue: %w", err)
+		}
+		attrs[entry.Name()] = strings.TrimSuffix(value, "\n")
+	}
+	return attrs, nil
+}
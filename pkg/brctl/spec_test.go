@@ -0,0 +1,288 @@
+// Copyright 2024 the u-root Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package brctl
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"testing"
+	"time"
+
+	"golang.org/x/sys/unix"
+)
+
+// recordWrites records the sysfs files written, relative to root.
+func recordWrites(t *testing.T, root string) *[]string {
+	t.Helper()
+	var writes []string
+	old := writeFile
+	writeFile = func(name string, value []byte) error {
+		rel, err := filepath.Rel(root, name)
+		if err != nil {
+			return err
+		}
+		writes = append(writes, rel)
+		return old(name, value)
+	}
+	t.Cleanup(func() { writeFile = old })
+	return &writes
+}
+
+// jiffies returns d as the jiffies string the kernel shows in sysfs.
+func jiffies(t *testing.T, d time.Duration) string {
+	t.Helper()
+	j, err := durationToJiffies(d)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return strconv.Itoa(j) + "\n"
+}
+
+// defaultBridgeFiles returns a fake sysfs bridge as the kernel creates it,
+// with port eth0 attached.
+func defaultBridgeFiles(t *testing.T) map[string]string {
+	return map[string]string{
+		"br0/bridge/ageing_time":   jiffies(t, 300*time.Second),
+		"br0/bridge/forward_delay": jiffies(t, 15*time.Second),
+		"br0/bridge/hello_time":    jiffies(t, 2*time.Second),
+		"br0/bridge/max_age":       jiffies(t, 20*time.Second),
+		"br0/bridge/priority":      "32768\n",
+		"br0/bridge/stp_state":     "0\n",
+		"br0/brif/eth0/.keep":      "",
+		"eth0/brport/path_cost":    "100\n",
+		"eth0/brport/priority":     "32\n",
+	}
+}
+
+func TestEnsureBridgeConfig(t *testing.T) {
+	for _, tt := range []struct {
+		name   string
+		spec   func() BridgeSpec
+		writes []string
+	}{
+		{
+			name: "unchanged",
+			spec: func() BridgeSpec {
+				spec := DefaultBridgeSpec("br0")
+				spec.Ports = []PortSpec{DefaultPortSpec("eth0")}
+				return spec
+			},
+		},
+		{
+			name: "stp and port cost",
+			spec: func() BridgeSpec {
+				spec := DefaultBridgeSpec("br0")
+				spec.STP = true
+				spec.ForwardDelay = 4 * time.Second
+				port := DefaultPortSpec("eth0")
+				port.PathCost = 4
+				spec.Ports = []PortSpec{port}
+				return spec
+			},
+			writes: []string{
+				"br0/bridge/forward_delay",
+				"br0/bridge/stp_state",
+				"eth0/brport/path_cost",
+			},
+		},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			root := fakeSysfs(t, defaultBridgeFiles(t))
+			writes := recordWrites(t, root)
+
+			if err := EnsureBridgeConfig(tt.spec()); err != nil {
+				t.Fatalf("EnsureBridgeConfig() = %v, want nil", err)
+			}
+			if !reflect.DeepEqual(*writes, tt.writes) {
+				t.Errorf("EnsureBridgeConfig() wrote %v, want %v", *writes, tt.writes)
+			}
+
+			// Re-applying the spec must not write anything.
+			*writes = nil
+			if err := EnsureBridgeConfig(tt.spec()); err != nil {
+				t.Fatalf("EnsureBridgeConfig() = %v, want nil", err)
+			}
+			if len(*writes) != 0 {
+				t.Errorf("EnsureBridgeConfig() again wrote %v, want nothing", *writes)
+			}
+		})
+	}
+}
+
+func TestDumpConfig(t *testing.T) {
+	// A fully configured bridge with user space STP and two ports.
+	configured := map[string]string{
+		"br0/bridge/ageing_time":         jiffies(t, 30*time.Second),
+		"br0/bridge/forward_delay":       jiffies(t, 4*time.Second),
+		"br0/bridge/hello_time":          jiffies(t, 1*time.Second),
+		"br0/bridge/max_age":             jiffies(t, 6*time.Second),
+		"br0/bridge/priority":            "4096\n",
+		"br0/bridge/stp_state":           "2\n",
+		"br0/bridge/vlan_filtering":      "1\n",
+		"br0/bridge/vlan_stats_enabled":  "1\n",
+		"br0/bridge/vlan_stats_per_port": "0\n",
+		"br0/brif/eth0/.keep":            "",
+		"br0/brif/eth1/.keep":            "",
+		"eth0/brport/path_cost":          "4\n",
+		"eth0/brport/priority":           "16\n",
+		"eth1/brport/path_cost":          "100\n",
+		"eth1/brport/priority":           "32\n",
+	}
+	want := BridgeSpec{
+		Name:          "br0",
+		STP:           true,
+		ForwardDelay:  4 * time.Second,
+		HelloTime:     1 * time.Second,
+		MaxAge:        6 * time.Second,
+		AgeingTime:    30 * time.Second,
+		Priority:      4096,
+		VLANFiltering: true,
+		VLANStats:     true,
+		Ports: []PortSpec{
+			{Name: "eth0", PathCost: 4, Priority: 16},
+			{Name: "eth1", PathCost: 100, Priority: 32},
+		},
+	}
+
+	fakeSysfs(t, configured)
+	got, err := DumpConfig("br0")
+	if err != nil {
+		t.Fatalf("DumpConfig(%q) = %v, want nil", "br0", err)
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("DumpConfig(%q) = %+v, want %+v", "br0", got, want)
+	}
+
+	// Restore the dump onto a bridge with kernel defaults.
+	defaults := defaultBridgeFiles(t)
+	defaults["br0/bridge/vlan_filtering"] = "0\n"
+	defaults["br0/bridge/vlan_stats_enabled"] = "0\n"
+	defaults["br0/bridge/vlan_stats_per_port"] = "0\n"
+	defaults["br0/brif/eth1/.keep"] = ""
+	defaults["eth1/brport/path_cost"] = "100\n"
+	defaults["eth1/brport/priority"] = "32\n"
+	fakeSysfs(t, defaults)
+
+	if err := EnsureBridgeConfig(got); err != nil {
+		t.Fatalf("EnsureBridgeConfig() = %v, want nil", err)
+	}
+	restored, err := DumpConfig("br0")
+	if err != nil {
+		t.Fatalf("DumpConfig(%q) = %v, want nil", "br0", err)
+	}
+	if !reflect.DeepEqual(restored, want) {
+		t.Errorf("DumpConfig(%q) after restore = %+v, want %+v", "br0", restored, want)
+	}
+}
+
+func TestDumpConfigOldKernel(t *testing.T) {
+	// Kernels without VLAN support lack the vlan attributes.
+	fakeSysfs(t, defaultBridgeFiles(t))
+
+	got, err := DumpConfig("br0")
+	if err != nil {
+		t.Fatalf("DumpConfig(%q) = %v, want nil", "br0", err)
+	}
+	want := DefaultBridgeSpec("br0")
+	want.Ports = []PortSpec{DefaultPortSpec("eth0")}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("DumpConfig(%q) = %+v, want %+v", "br0", got, want)
+	}
+}
+
+func TestDumpPortAttrs(t *testing.T) {
+	root := fakeSysfs(t, map[string]string{
+		"br0/bridge/forward_delay":     "1500\n",
+		"eth0/brport/path_cost":        "100\n",
+		"eth0/brport/priority":         "32\n",
+		"eth0/brport/state":            "3\n",
+		"eth0/brport/multicast_router": "1\n",
+		"eth0/brport/group_fwd_mask":   "0x0\n",
+		"eth0/brport/no_newline":       "7",
+		"eth0/brport/empty":            "",
+		"eth0/brport/subdir/ignored":   "1\n",
+		"eth1/brport/path_cost":        "200\n",
+	})
+	if err := os.Symlink(filepath.Join(root, "br0"), filepath.Join(root, "eth0", "brport", "bridge")); err != nil {
+		t.Fatal(err)
+	}
+	// flush is write-only, reading it fails even as root.
+	if err := os.WriteFile(filepath.Join(root, "eth0", "brport", "flush"), nil, 0o200); err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := DumpPortAttrs("eth0")
+	if err != nil {
+		t.Fatalf("DumpPortAttrs(%q) = %v, want nil", "eth0", err)
+	}
+	want := map[string]string{
+		"path_cost":        "100",
+		"priority":         "32",
+		"state":            "3",
+		"multicast_router": "1",
+		"group_fwd_mask":   "0x0",
+		"no_newline":       "7",
+		"empty":            "",
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("DumpPortAttrs(%q) = %v, want %v", "eth0", got, want)
+	}
+
+	if _, err := DumpPortAttrs("eth2"); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("DumpPortAttrs(%q) = %v, want %v", "eth2", err, os.ErrNotExist)
+	}
+}
+
+func TestEnsureBridgeConfigPrune(t *testing.T) {
+	for _, tt := range []struct {
+		name  string
+		prune bool
+		want  []string
+	}{
+		{
+			name: "keep extra port",
+			want: []string{
+				fmt.Sprintf("%#x eth1", unix.SIOCGIFINDEX),
+				fmt.Sprintf("%#x br0 %#x", unix.SIOCBRADDIF, 1),
+			},
+		},
+		{
+			name:  "prune extra port",
+			prune: true,
+			want: []string{
+				fmt.Sprintf("%#x eth2", unix.SIOCGIFINDEX),
+				fmt.Sprintf("%#x br0 %#x", unix.SIOCBRDELIF, 1),
+				fmt.Sprintf("%#x eth1", unix.SIOCGIFINDEX),
+				fmt.Sprintf("%#x br0 %#x", unix.SIOCBRADDIF, 1),
+			},
+		},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			// eth0 and eth2 are attached, eth1 is not.
+			files := defaultBridgeFiles(t)
+			files["br0/brif/eth2/.keep"] = ""
+			files["eth1/brport/path_cost"] = "100\n"
+			files["eth1/brport/priority"] = "32\n"
+			files["eth2/brport/path_cost"] = "100\n"
+			files["eth2/brport/priority"] = "32\n"
+			fakeSysfs(t, files)
+			calls := fakeIoctls(t, 0, nil)
+
+			spec := DefaultBridgeSpec("br0")
+			spec.Ports = []PortSpec{DefaultPortSpec("eth0"), DefaultPortSpec("eth1")}
+			spec.Prune = tt.prune
+			if err := EnsureBridgeConfig(spec); err != nil {
+				t.Fatalf("EnsureBridgeConfig() = %v, want nil", err)
+			}
+			if !reflect.DeepEqual(*calls, tt.want) {
+				t.Errorf("EnsureBridgeConfig() ioctls = %v, want %v", *calls, tt.want)
+			}
+		})
+	}
+}
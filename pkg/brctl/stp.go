@@ -0,0 +1,63 @@
+// Copyright 2024 the u-root Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package brctl
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+)
+
+var errNotApplied = errors.New("bridge attribute does not read back as written")
+
+// EnableSTP configures the bridge's STP timers and priority and only then
+// turns STP on. Turning STP on first would run it with the old timers until
+// they are changed, which may open transient loops or elect the wrong root.
+//
+// Every value is read back after writing it; timers may be one clock tick
+// off, as the kernel rounds them to its HZ. The first one that does not
+// read back as written stops EnableSTP, with STP still off unless it was on
+// already. STP counts as on in kernel and user space mode, see StpMode.
+func EnableSTP(bridge string, timers Timers, priority uint16) error {
+	return sysfsHandle().EnableSTP(bridge, timers, priority)
+}
+
+// EnableSTP is the handle version of the package level EnableSTP.
+func (b *Brctl) EnableSTP(bridge string, timers Timers, priority uint16) (err error) {
+	defer observe("EnableSTP", bridge)(&err)
+
+	values, err := timers.values()
+	if err != nil {
+		return err
+	}
+	values = append(values, bridgeValue{name: BRCTL_BRIDGE_PRIO, value: strconv.Itoa(int(priority))})
+
+	for _, v := range values {
+		if err := b.setBridgeValue(bridge, v.name, []byte(v.value)); err != nil {
+			return fmt.Errorf("setBridgeValue: %w", err)
+		}
+		got, err := b.getBridgeValue(bridge, v.name)
+		if err != nil {
+			return fmt.Errorf("getBridgeValue: %w", err)
+		}
+		if !v.readsBack(got) {
+			return fmt.Errorf("%s is %s, want %s: %w", v.name, got, v.value, errNotApplied)
+		}
+	}
+
+	if err := b.setBridgeValue(bridge, BRCTL_STP_STATE, []byte(strconv.Itoa(int(StpKernel)))); err != nil {
+		return fmt.Errorf("setBridgeValue: %w", err)
+	}
+	// The kernel hands STP to user space if /sbin/bridge-stp claims the
+	// bridge, so any mode but off will do.
+	got, err := b.getBridgeValue(bridge, BRCTL_STP_STATE)
+	if err != nil {
+		return fmt.Errorf("getBridgeValue: %w", err)
+	}
+	if mode, err := strconv.Atoi(got); err != nil || StpMode(mode) == StpOff {
+		return fmt.Errorf("%s is %s, want STP on: %w", BRCTL_STP_STATE, got, errNotApplied)
+	}
+	return nil
+}
@@ -0,0 +1,119 @@
+// Copyright 2024 the u-root Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package brctl
+
+import (
+	"errors"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestEnableSTP(t *testing.T) {
+	root := fakeSysfs(t, defaultBridgeFiles(t))
+	writes := recordWrites(t, root)
+
+	timers := Timers{ForwardDelay: 4 * time.Second, HelloTime: time.Second, MaxAge: 6 * time.Second}
+	if err := EnableSTP("br0", timers, 4096); err != nil {
+		t.Fatalf("EnableSTP(br0, %+v, 4096) = %v, want nil", timers, err)
+	}
+
+	wantWrites := []string{
+		"br0/bridge/forward_delay",
+		"br0/bridge/hello_time",
+		"br0/bridge/max_age",
+		"br0/bridge/priority",
+		"br0/bridge/stp_state",
+	}
+	if !reflect.DeepEqual(*writes, wantWrites) {
+		t.Errorf("EnableSTP wrote %q, want %q", *writes, wantWrites)
+	}
+	for file, want := range map[string]string{
+		"br0/bridge/forward_delay": jiffies(t, timers.ForwardDelay),
+		"br0/bridge/hello_time":    jiffies(t, timers.HelloTime),
+		"br0/bridge/max_age":       jiffies(t, timers.MaxAge),
+		"br0/bridge/priority":      "4096",
+		"br0/bridge/stp_state":     "1",
+	} {
+		if got := readSysfs(t, root, file); got != strings.TrimSuffix(want, "\n") {
+			t.Errorf("%s = %q, want %q", file, got, strings.TrimSuffix(want, "\n"))
+		}
+	}
+}
+
+func TestEnableSTPNotApplied(t *testing.T) {
+	for _, tt := range []struct {
+		name string
+		// file reads back as value, whatever was written.
+		file       string
+		value      string
+		wantWrites int
+	}{
+		{name: "forward_delay", file: "br0/bridge/forward_delay", value: "398\n", wantWrites: 1},
+		{name: "priority", file: "br0/bridge/priority", value: "32768\n", wantWrites: 4},
+		{name: "stp_state", file: "br0/bridge/stp_state", value: "0\n", wantWrites: 5},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			root := fakeSysfs(t, defaultBridgeFiles(t))
+			writes := recordWrites(t, root)
+			old := readFile
+			readFile = func(name string) ([]byte, error) {
+				if name == filepath.Join(root, tt.file) {
+					return []byte(tt.value), nil
+				}
+				return old(name)
+			}
+			t.Cleanup(func() { readFile = old })
+
+			timers := Timers{ForwardDelay: 4 * time.Second, HelloTime: time.Second, MaxAge: 6 * time.Second}
+			if err := EnableSTP("br0", timers, 4096); !errors.Is(err, errNotApplied) {
+				t.Errorf("EnableSTP(br0, %+v, 4096) = %v, want %v", timers, err, errNotApplied)
+			}
+			if len(*writes) != tt.wantWrites {
+				t.Errorf("EnableSTP wrote %q, want %d writes", *writes, tt.wantWrites)
+			}
+		})
+	}
+}
+
+func TestEnableSTPTimerRounding(t *testing.T) {
+	root := fakeSysfs(t, defaultBridgeFiles(t))
+	// With HZ=250, 205 clock ticks are stored as 512 jiffies and shown
+	// as 204.
+	old := readFile
+	readFile = func(name string) ([]byte, error) {
+		if name == filepath.Join(root, "br0/bridge/hello_time") {
+			return []byte("204\n"), nil
+		}
+		return old(name)
+	}
+	t.Cleanup(func() { readFile = old })
+
+	timers := Timers{ForwardDelay: 5 * time.Second, HelloTime: 2050 * time.Millisecond, MaxAge: 8 * time.Second}
+	if err := EnableSTP("br0", timers, 4096); err != nil {
+		t.Errorf("EnableSTP(br0, %+v, 4096) = %v, want nil", timers, err)
+	}
+	if got := readSysfs(t, root, "br0/bridge/stp_state"); got != "1" {
+		t.Errorf("stp_state = %q, want %q", got, "1")
+	}
+}
+
+func TestEnableSTPInvalidTimers(t *testing.T) {
+	root := fakeSysfs(t, defaultBridgeFiles(t))
+	writes := recordWrites(t, root)
+
+	timers := Timers{ForwardDelay: 4 * time.Second, HelloTime: time.Second, MaxAge: 7 * time.Second}
+	if err := EnableSTP("br0", timers, 4096); !errors.Is(err, errTimerConstraint) {
+		t.Errorf("EnableSTP(br0, %+v, 4096) = %v, want %v", timers, err, errTimerConstraint)
+	}
+	if len(*writes) != 0 {
+		t.Errorf("EnableSTP wrote %q, want nothing", *writes)
+	}
+	if got := readSysfs(t, root, "br0/bridge/stp_state"); got != "0" {
+		t.Errorf("stp_state = %q, want %q", got, "0")
+	}
+}
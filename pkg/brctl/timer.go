@@ -0,0 +1,252 @@
+// Copyright 2024 the u-root Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package brctl
+
+import (
+	"errors"
+	"fmt"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var (
+	errNegativeDuration = errors.New("negative duration")
+	errTimerConstraint  = errors.New("STP timers violate 2*(ForwardDelay-1s) >= MaxAge >= 2*(HelloTime+1s)")
+)
+
+// Timers are the STP timers of a bridge.
+type Timers struct {
+	ForwardDelay time.Duration
+	HelloTime    time.Duration
+	MaxAge       time.Duration
+}
+
+// validate checks the relation IEEE 802.1D requires between the timers.
+func (t Timers) validate() error {
+	if 2*(t.ForwardDelay-time.Second) < t.MaxAge || t.MaxAge < 2*(t.HelloTime+time.Second) {
+		return fmt.Errorf("forward delay %v, hello time %v, max age %v: %w", t.ForwardDelay, t.HelloTime, t.MaxAge, errTimerConstraint)
+	}
+	return nil
+}
+
+// SetHelloTime sets the bridge's hello time, the interval between STP hello
+// packets.
+func SetHelloTime(bridge string, d time.Duration) error {
+	return sysfsHandle().SetHelloTime(bridge, d)
+}
+
+// SetHelloTime is the handle version of the package level SetHelloTime.
+func (b *Brctl) SetHelloTime(bridge string, d time.Duration) (err error) {
+	defer observe("SetHelloTime", bridge)(&err)
+
+	return b.setBridgeTimer(bridge, BRCTL_HELLO_TIME, d)
+}
+
+// SetForwardDelay sets the bridge's forward delay, the time a port spends in
+// each of the listening and learning states.
+func SetForwardDelay(bridge string, d time.Duration) error {
+	return sysfsHandle().SetForwardDelay(bridge, d)
+}
+
+// SetForwardDelay is the handle version of the package level SetForwardDelay.
+func (b *Brctl) SetForwardDelay(bridge string, d time.Duration) (err error) {
+	defer observe("SetForwardDelay", bridge)(&err)
+
+	return b.setBridgeTimer(bridge, BRCTL_FORWARD_DELAY, d)
+}
+
+// SetMaxAge sets the bridge's maximum message age, after which STP
+// information received on a port is discarded.
+func SetMaxAge(bridge string, d time.Duration) error {
+	return sysfsHandle().SetMaxAge(bridge, d)
+}
+
+// SetMaxAge is the handle version of the package level SetMaxAge.
+func (b *Brctl) SetMaxAge(bridge string, d time.Duration) (err error) {
+	defer observe("SetMaxAge", bridge)(&err)
+
+	return b.setBridgeTimer(bridge, BRCTL_MAX_AGE, d)
+}
+
+// SetTimers sets the bridge's forward delay, hello time and maximum message
+// age together. The timers are checked against each other and converted to
+// jiffies before any of them is written, so invalid timers leave the bridge
+// unchanged.
+func SetTimers(bridge string, t Timers) error {
+	return sysfsHandle().SetTimers(bridge, t)
+}
+
+// SetTimers is the handle version of the package level SetTimers.
+func (b *Brctl) SetTimers(bridge string, t Timers) (err error) {
+	defer observe("SetTimers", bridge)(&err)
+
+	values, err := t.values()
+	if err != nil {
+		return err
+	}
+	for _, v := range values {
+		if err := b.setBridgeValue(bridge, v.name, []byte(v.value)); err != nil {
+			return fmt.Errorf("setBridgeValue: %w", err)
+		}
+	}
+	return nil
+}
+
+// bridgeValue is the value of a bridge attribute, as written to sysfs.
+type bridgeValue struct {
+	name  string
+	value string
+	// timer marks values in USER_HZ clock ticks. The kernel stores them
+	// in its own jiffies, so they may read back one tick off.
+	timer bool
+}
+
+// readsBack reports whether got, read from sysfs, shows that v was applied.
+func (v bridgeValue) readsBack(got string) bool {
+	if got == v.value {
+		return true
+	}
+	if !v.timer {
+		return false
+	}
+	want, err := strconv.Atoi(v.value)
+	if err != nil {
+		return false
+	}
+	n, err := strconv.Atoi(got)
+	return err == nil && n >= want-1 && n <= want+1
+}
+
+// values validates the timers and returns the bridge attributes to write,
+// in jiffies.
+func (t Timers) values() ([]bridgeValue, error) {
+	if err := t.validate(); err != nil {
+		return nil, err
+	}
+
+	timers := []struct {
+		name string
+		d    time.Duration
+	}{
+		{name: BRCTL_FORWARD_DELAY, d: t.ForwardDelay},
+		{name: BRCTL_HELLO_TIME, d: t.HelloTime},
+		{name: BRCTL_MAX_AGE, d: t.MaxAge},
+	}
+	values := make([]bridgeValue, 0, len(timers))
+	for _, timer := range timers {
+		if timer.d < 0 {
+			return nil, fmt.Errorf("%s %v: %w", timer.name, timer.d, errNegativeDuration)
+		}
+		j, err := durationToJiffies(timer.d)
+		if err != nil {
+			return nil, fmt.Errorf("durationToJiffies(%v) = %w", timer.d, err)
+		}
+		values = append(values, bridgeValue{name: timer.name, value: strconv.Itoa(j), timer: true})
+	}
+	return values, nil
+}
+
+// SetAgeingTime sets the time after which the bridge deletes a learned MAC
+// address it has not seen a frame from.
+func SetAgeingTime(bridge string, d time.Duration) error {
+	return sysfsHandle().SetAgeingTime(bridge, d)
+}
+
+// SetAgeingTime is the handle version of the package level SetAgeingTime.
+func (b *Brctl) SetAgeingTime(bridge string, d time.Duration) (err error) {
+	defer observe("SetAgeingTime", bridge)(&err)
+
+	return b.setBridgeTimer(bridge, BRCTL_AGEING_TIME, d)
+}
+
+// setBridgeTimer writes d in jiffies to the bridge attribute name.
+func (b *Brctl) setBridgeTimer(bridge string, name string, d time.Duration) error {
+	if d < 0 {
+		return fmt.Errorf("%s %v: %w", name, d, errNegativeDuration)
+	}
+
+	jiffies, err := durationToJiffies(d)
+	if err != nil {
+		return fmt.Errorf("durationToJiffies(%v) = %w", d, err)
+	}
+
+	if err := b.setBridgeValue(bridge, name, []byte(strconv.Itoa(jiffies))); err != nil {
+		return fmt.Errorf("setBridgeValue: %w", err)
+	}
+	return nil
+}
+
+// GetGCTimer returns the time remaining until the next garbage collection
+// run over the bridge's forwarding database.
+//
+// There is no separate knob for the GC interval: the kernel reschedules the
+// collection for the earliest FDB entry expiry, which is never more than the
+// ageing time away. Use Setageingtime to influence how often GC runs.
+func GetGCTimer(bridge string) (time.Duration, error) {
+	raw, err := getBridgeValue(bridge, BRCTL_GC_TIMER)
+	if err != nil {
+		return 0, fmt.Errorf("getBridgeValue: %w", err)
+	}
+
+	jiffies, err := strconv.Atoi(raw)
+	if err != nil {
+		return 0, fmt.Errorf("strconv.Atoi(%q) = %w", raw, err)
+	}
+
+	return jiffiesToDuration(jiffies)
+}
+
+// ForwardingDelayRemaining returns the time until the port starts forwarding
+// frames. It is zero if the port is forwarding already.
+//
+// A listening port has to pass through the learning state first, which takes
+// another forward delay of the bridge.
+func ForwardingDelayRemaining(port string) (time.Duration, error) {
+	state, err := getPortInt(port, BRCTL_PORT_STATE)
+	if err != nil {
+		return 0, err
+	}
+
+	switch state {
+	case BR_STATE_FORWARDING:
+		return 0, nil
+	case BR_STATE_LISTENING, BR_STATE_LEARNING:
+	default:
+		return 0, fmt.Errorf("port %s in STP state %d will not start forwarding", port, state)
+	}
+
+	remaining, err := getPortInt(port, BRCTL_FORWARD_DELAY_TIMER)
+	if err != nil {
+		return 0, err
+	}
+	if state == BR_STATE_LISTENING {
+		// brport/bridge links to the bridge the port is attached to.
+		fd, err := getPortInt(port, path.Join("bridge", "bridge", BRCTL_FORWARD_DELAY))
+		if err != nil {
+			return 0, err
+		}
+		remaining += fd
+	}
+
+	return jiffiesToDuration(remaining)
+}
+
+// getPortInt reads an integer brport attribute, e.g. a state or a timer in
+// jiffies.
+func getPortInt(port string, name string) (int, error) {
+	raw, err := getPortBrportValue(port, name)
+	if err != nil {
+		return 0, fmt.Errorf("getPortBrportValue: %w", err)
+	}
+	raw = strings.TrimSuffix(raw, "\n")
+
+	n, err := strconv.Atoi(raw)
+	if err != nil {
+		return 0, fmt.Errorf("strconv.Atoi(%q) = %w", raw, err)
+	}
+	return n, nil
+}
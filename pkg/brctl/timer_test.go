@@ -0,0 +1,234 @@
+// Copyright 2024 the u-root Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package brctl
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestGetGCTimer(t *testing.T) {
+	hz, err := sysconfhz()
+	if err != nil {
+		t.Fatalf("sysconfhz() = %v, want nil", err)
+	}
+
+	for _, tt := range []struct {
+		name    string
+		value   string
+		want    time.Duration
+		wantErr bool
+	}{
+		{
+			name:  "zero",
+			value: "0\n",
+			want:  0,
+		},
+		{
+			name:  "two and a half seconds",
+			value: strconv.Itoa(hz*5/2) + "\n",
+			want:  2500 * time.Millisecond,
+		},
+		{
+			name:    "garbage",
+			value:   "abc\n",
+			wantErr: true,
+		},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			fakeSysfs(t, map[string]string{"br0/bridge/gc_timer": tt.value})
+
+			got, err := GetGCTimer("br0")
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("GetGCTimer(%q) = %v, want error %v", "br0", err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("GetGCTimer(%q) = %v, want %v", "br0", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestForwardingDelayRemaining(t *testing.T) {
+	hz, err := sysconfhz()
+	if err != nil {
+		t.Fatalf("sysconfhz() = %v, want nil", err)
+	}
+
+	for _, tt := range []struct {
+		name    string
+		state   string
+		timer   string
+		want    time.Duration
+		wantErr bool
+	}{
+		{
+			name:  "forwarding",
+			state: "3\n",
+			timer: "0\n",
+			want:  0,
+		},
+		{
+			name:  "learning",
+			state: "2\n",
+			timer: strconv.Itoa(hz*5/2) + "\n",
+			want:  2500 * time.Millisecond,
+		},
+		{
+			name:  "listening",
+			state: "1\n",
+			timer: strconv.Itoa(hz) + "\n",
+			// Plus the bridge's forward delay for learning.
+			want: 16 * time.Second,
+		},
+		{
+			name:    "blocking",
+			state:   "4\n",
+			timer:   "0\n",
+			wantErr: true,
+		},
+		{
+			name:    "garbage",
+			state:   "2\n",
+			timer:   "abc\n",
+			wantErr: true,
+		},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			fakeSysfs(t, map[string]string{
+				"eth0/brport/state":                       tt.state,
+				"eth0/brport/forward_delay_timer":         tt.timer,
+				"eth0/brport/bridge/bridge/forward_delay": strconv.Itoa(hz*15) + "\n",
+			})
+
+			got, err := ForwardingDelayRemaining("eth0")
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("ForwardingDelayRemaining(%q) = %v, want error %v", "eth0", err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("ForwardingDelayRemaining(%q) = %v, want %v", "eth0", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSetBridgeTimers(t *testing.T) {
+	for _, tt := range []struct {
+		name string
+		file string
+		set  func(string, time.Duration) error
+		d    time.Duration
+	}{
+		{name: "hello time", file: "br0/bridge/hello_time", set: SetHelloTime, d: 2 * time.Second},
+		{name: "forward delay", file: "br0/bridge/forward_delay", set: SetForwardDelay, d: 4 * time.Second},
+		{name: "max age", file: "br0/bridge/max_age", set: SetMaxAge, d: 20 * time.Second},
+		{name: "ageing time", file: "br0/bridge/ageing_time", set: SetAgeingTime, d: 300 * time.Second},
+		{name: "fraction", file: "br0/bridge/hello_time", set: SetHelloTime, d: 1500 * time.Millisecond},
+		{name: "zero", file: "br0/bridge/ageing_time", set: SetAgeingTime, d: 0},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			root := fakeSysfs(t, map[string]string{tt.file: "0\n"})
+
+			if err := tt.set("br0", tt.d); err != nil {
+				t.Fatalf("set(br0, %v) = %v, want nil", tt.d, err)
+			}
+			// Check the raw file, including the newline.
+			got, err := os.ReadFile(filepath.Join(root, tt.file))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if want := jiffies(t, tt.d); string(got) != want {
+				t.Errorf("%s = %q, want %q", tt.file, got, want)
+			}
+		})
+	}
+}
+
+func TestSetBridgeTimersNegative(t *testing.T) {
+	root := fakeSysfs(t, defaultBridgeFiles(t))
+	writes := recordWrites(t, root)
+
+	for name, set := range map[string]func(string, time.Duration) error{
+		"SetHelloTime":    SetHelloTime,
+		"SetForwardDelay": SetForwardDelay,
+		"SetMaxAge":       SetMaxAge,
+		"SetAgeingTime":   SetAgeingTime,
+	} {
+		if err := set("br0", -time.Second); !errors.Is(err, errNegativeDuration) {
+			t.Errorf("%s(br0, -1s) = %v, want %v", name, err, errNegativeDuration)
+		}
+	}
+	if len(*writes) != 0 {
+		t.Errorf("wrote %v, want nothing", *writes)
+	}
+}
+
+func TestSetTimers(t *testing.T) {
+	root := fakeSysfs(t, defaultBridgeFiles(t))
+	writes := recordWrites(t, root)
+
+	timers := Timers{ForwardDelay: 4 * time.Second, HelloTime: time.Second, MaxAge: 6 * time.Second}
+	if err := SetTimers("br0", timers); err != nil {
+		t.Fatalf("SetTimers(br0, %+v) = %v, want nil", timers, err)
+	}
+	for file, d := range map[string]time.Duration{
+		"br0/bridge/forward_delay": timers.ForwardDelay,
+		"br0/bridge/hello_time":    timers.HelloTime,
+		"br0/bridge/max_age":       timers.MaxAge,
+	} {
+		if got, want := readSysfs(t, root, file), strings.TrimSuffix(jiffies(t, d), "\n"); got != want {
+			t.Errorf("%s = %q, want %q", file, got, want)
+		}
+	}
+	if len(*writes) != 3 {
+		t.Errorf("wrote %v, want the three timers", *writes)
+	}
+}
+
+func TestSetTimersInvalid(t *testing.T) {
+	root := fakeSysfs(t, defaultBridgeFiles(t))
+	writes := recordWrites(t, root)
+
+	for _, tt := range []struct {
+		name    string
+		timers  Timers
+		wantErr error
+	}{
+		{
+			name:    "max age above forward delay",
+			timers:  Timers{ForwardDelay: 4 * time.Second, HelloTime: time.Second, MaxAge: 7 * time.Second},
+			wantErr: errTimerConstraint,
+		},
+		{
+			name:    "max age below hello time",
+			timers:  Timers{ForwardDelay: 15 * time.Second, HelloTime: 5 * time.Second, MaxAge: 10 * time.Second},
+			wantErr: errTimerConstraint,
+		},
+		{
+			name:    "negative hello time",
+			timers:  Timers{ForwardDelay: 15 * time.Second, HelloTime: -time.Second, MaxAge: 20 * time.Second},
+			wantErr: errNegativeDuration,
+		},
+		{
+			name:    "hello time below a jiffy",
+			timers:  Timers{ForwardDelay: 15 * time.Second, HelloTime: time.Nanosecond, MaxAge: 20 * time.Second},
+			wantErr: errBelowJiffy,
+		},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := SetTimers("br0", tt.timers); !errors.Is(err, tt.wantErr) {
+				t.Errorf("SetTimers(br0, %+v) = %v, want %v", tt.timers, err, tt.wantErr)
+			}
+		})
+	}
+	if len(*writes) != 0 {
+		t.Errorf("wrote %v, want nothing", *writes)
+	}
+}
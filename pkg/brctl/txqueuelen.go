@@ -0,0 +1,73 @@
+// Copyright 2024 the u-root Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package brctl
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+
+	"golang.org/x/sys/unix"
+)
+
+// txQueueLenIfreq returns the SIOCSIFTXQLEN request setting the transmit
+// queue length of dev to n. The kernel takes ifr_qlen as an int and rejects
+// negative values, which bounds n.
+func txQueueLenIfreq(dev string, n uint32) (*unix.Ifreq, error) {
+	if n > math.MaxInt32 {
+		return nil, fmt.Errorf("tx queue length %d out of range [0, %d]", n, math.MaxInt32)
+	}
+
+	ifr, err := unix.NewIfreq(dev)
+	if err != nil {
+		return nil, fmt.Errorf("unix.NewIfreq: %w", err)
+	}
+	ifr.SetUint32(n)
+
+	return ifr, nil
+}
+
+// SetTxQueueLen sets the transmit queue length of the bridge device.
+func SetTxQueueLen(bridge string, n uint32) error {
+	b, err := New()
+	if err != nil {
+		return err
+	}
+	defer b.Close()
+
+	return b.SetTxQueueLen(bridge, n)
+}
+
+// SetTxQueueLen is the handle version of the package level SetTxQueueLen.
+func (b *Brctl) SetTxQueueLen(bridge string, n uint32) (err error) {
+	defer observe("SetTxQueueLen", bridge)(&err)
+
+	ifr, err := txQueueLenIfreq(bridge, n)
+	if err != nil {
+		return err
+	}
+
+	return b.withSocket(func(fd int) error {
+		if err := ioctlIfreq(fd, unix.SIOCSIFTXQLEN, ifr); err != nil {
+			return fmt.Errorf("unix.IoctlIfreq: %w", err)
+		}
+		return nil
+	})
+}
+
+// TxQueueLen returns the transmit queue length of the bridge device.
+func TxQueueLen(bridge string) (uint32, error) {
+	qlen, err := getDeviceValue(bridge, BRCTL_TX_QUEUE_LEN)
+	if err != nil {
+		return 0, fmt.Errorf("getDeviceValue: %w", err)
+	}
+
+	n, err := strconv.ParseUint(qlen, 10, 32)
+	if err != nil {
+		return 0, fmt.Errorf("strconv.ParseUint: %w", err)
+	}
+
+	return uint32(n), nil
+}
@@ -0,0 +1,81 @@
+// Copyright 2024 the u-root Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package brctl
+
+import (
+	"math"
+	"testing"
+)
+
+func TestTxQueueLenIfreq(t *testing.T) {
+	for _, tt := range []struct {
+		name    string
+		n       uint32
+		wantErr bool
+	}{
+		{name: "zero", n: 0},
+		{name: "default", n: 1000},
+		{name: "max", n: math.MaxInt32},
+		{name: "negative as int", n: math.MaxInt32 + 1, wantErr: true},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			ifr, err := txQueueLenIfreq("br0", tt.n)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("txQueueLenIfreq(%q, %d) = %v, want error %v", "br0", tt.n, err, tt.wantErr)
+			}
+			if err != nil {
+				return
+			}
+			if got := ifr.Name(); got != "br0" {
+				t.Errorf("ifr.Name() = %q, want %q", got, "br0")
+			}
+			if got := ifr.Uint32(); got != tt.n {
+				t.Errorf("ifr.Uint32() = %d, want %d", got, tt.n)
+			}
+		})
+	}
+}
+
+func TestTxQueueLen(t *testing.T) {
+	for _, tt := range []struct {
+		name    string
+		files   map[string]string
+		want    uint32
+		wantErr bool
+	}{
+		{
+			name:  "default",
+			files: map[string]string{"br0/tx_queue_len": "1000\n"},
+			want:  1000,
+		},
+		{
+			name:  "no queue",
+			files: map[string]string{"br0/tx_queue_len": "0\n"},
+			want:  0,
+		},
+		{
+			name:    "garbage",
+			files:   map[string]string{"br0/tx_queue_len": "lots\n"},
+			wantErr: true,
+		},
+		{
+			name:    "no device",
+			files:   map[string]string{},
+			wantErr: true,
+		},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			fakeSysfs(t, tt.files)
+
+			got, err := TxQueueLen("br0")
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("TxQueueLen(%q) = %v, want error %v", "br0", err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("TxQueueLen(%q) = %d, want %d", "br0", got, tt.want)
+			}
+		})
+	}
+}
@@ -8,9 +8,14 @@
 package brctl
 
 import (
+	"encoding/json"
+	"errors"
 	"fmt"
+	"math"
+	"net"
 	"os"
 	"path"
+	"strconv"
 	"strings"
 	"syscall"
 	"time"
@@ -22,17 +27,105 @@ import (
 
 var errno0 = syscall.Errno(0)
 
+// ErrNotSupported is returned when the running kernel does not expose the
+// requested bridge attribute.
+var ErrNotSupported = errors.New("not supported by the running kernel")
+
+var (
+	errJiffiesOverflow = errors.New("duration exceeds the largest timer value")
+	errBelowJiffy      = errors.New("duration is shorter than half a clock tick")
+)
+
+// sysfsPath is the sysfs directory holding the network devices. All sysfs
+// accesses go through it, so tests point it at a fake tree, see fakeSysfs.
+var sysfsPath = BRCTL_SYS_NET
+
+// readFile and writeFile access sysfs attributes. Tests replace them to
+// simulate kernel errors or to observe writes.
+var (
+	readFile  = os.ReadFile
+	writeFile = writeSysfs
+)
+
+// ioctlStr and ioctlIfreq issue the control socket ioctls. Tests replace
+// them to observe requests without touching the host's devices.
+var (
+	ioctlStr   = executeIoctlStr
+	ioctlIfreq = unix.IoctlIfreq
+)
+
 // BridgeInfo contains information about a bridge
 // This information is not exhaustive, only the most important fields are included
 // Feel free to add more fields if needed.
 type BridgeInfo struct {
-	Name       string
-	BridgeID   string
-	StpState   bool
-	Interfaces []string
+	Name     string `json:"name"`
+	BridgeID string `json:"bridge_id"`
+	// MACAddress is the bridge's own MAC address. It is shown as a
+	// string in JSON.
+	MACAddress net.HardwareAddr `json:"mac_address"`
+	// RootID is the ID of the root bridge, and RootPort the number of
+	// the port towards it. If the bridge is the root, which includes
+	// bridges without STP, RootID is BridgeID and RootPort 0.
+	RootID   string  `json:"root_id"`
+	RootPort int     `json:"root_port"`
+	StpMode  StpMode `json:"stp_mode"`
+	// StpState is whether STP runs in any mode.
+	//
+	// Deprecated: Use StpMode, which tells kernel and user space STP apart.
+	StpState      bool `json:"stp_state"`
+	VLANFiltering bool `json:"vlan_filtering"`
+	// GroupFwdMask is the group_fwd_mask, see SetGroupForwardMask.
+	GroupFwdMask      uint16   `json:"group_fwd_mask,omitempty"`
+	MulticastSnooping bool     `json:"multicast_snooping"`
+	Interfaces        []string `json:"interfaces"`
+}
+
+// StpMode is how the bridge runs STP, as shown in bridge/stp_state.
+type StpMode int
+
+// STP modes.
+const (
+	// StpOff disables STP.
+	StpOff StpMode = iota
+	// StpKernel runs STP in the kernel.
+	StpKernel
+	// StpUser leaves STP, e.g. RSTP, to a user space daemon such as
+	// mstpd.
+	StpUser
+)
+
+var stpModeNames = map[StpMode]string{
+	StpOff:    "off",
+	StpKernel: "kernel",
+	StpUser:   "user",
 }
 
-func sysconfhz() (int, error) {
+func (m StpMode) String() string {
+	if name, ok := stpModeNames[m]; ok {
+		return name
+	}
+	return fmt.Sprintf("stp mode %d", int(m))
+}
+
+// MarshalJSON implements json.Marshaler. A bridge without interfaces has an
+// empty interfaces array rather than null, and the MAC address is a string
+// like "02:00:00:00:00:01".
+func (b BridgeInfo) MarshalJSON() ([]byte, error) {
+	// bridgeInfo has no methods, so that it does not recurse.
+	type bridgeInfo BridgeInfo
+	if b.Interfaces == nil {
+		b.Interfaces = []string{}
+	}
+	// MACAddress shadows the field of the embedded bridgeInfo.
+	return json.Marshal(struct {
+		bridgeInfo
+		MACAddress string `json:"mac_address"`
+	}{bridgeInfo(b), b.MACAddress.String()})
+}
+
+// sysconfhz returns USER_HZ, the unit of the timers in sysfs. Tests replace
+// it to simulate other tick rates.
+var sysconfhz = func() (int, error) {
 	clktck, err := sysconf.Sysconf(sysconf.SC_CLK_TCK)
 	if err != nil {
 		return 0, err
@@ -59,8 +152,9 @@ func getIndexFromInterfaceName(ifname string) (int, error) {
 	if err != nil {
 		return 0, err
 	}
+	defer unix.Close(brctlSocket)
 
-	err = unix.IoctlIfreq(brctlSocket, unix.SIOCGIFINDEX, ifreq)
+	err = ioctlIfreq(brctlSocket, unix.SIOCGIFINDEX, ifreq)
 	if err != nil {
 		return 0, err
 	}
@@ -73,10 +167,25 @@ func getIndexFromInterfaceName(ifname string) (int, error) {
 	return int(ifrIfindex), nil
 }
 
+// writeSysfs writes to an existing sysfs attribute.
+// Unlike os.WriteFile it never creates the file, so a missing attribute
+// reports an error instead of silently succeeding.
+func writeSysfs(name string, value []byte) error {
+	f, err := os.OpenFile(name, os.O_WRONLY|os.O_TRUNC, 0)
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(value); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
 // set values for the bridge
 // all values in the sysfs are of type <bytes> + '\n'
 func setBridgeValue(bridge string, name string, value []byte, _ uint64) error {
-	err := os.WriteFile(path.Join(BRCTL_SYS_NET, bridge, "bridge", name), append(value, BRCTL_SYS_SUFFIX), 0)
+	err := writeFile(path.Join(sysfsPath, bridge, "bridge", name), append(value, BRCTL_SYS_SUFFIX))
 	if err != nil {
 		return err
 	}
@@ -86,7 +195,7 @@ func setBridgeValue(bridge string, name string, value []byte, _ uint64) error {
 // Get values for the bridge
 // For some reason these values have a '\n' (0x0a) as a suffix, so we need to trim it
 func getBridgeValue(bridge string, name string) (string, error) {
-	out, err := os.ReadFile(path.Join(BRCTL_SYS_NET, bridge, "bridge", name))
+	out, err := readFile(path.Join(sysfsPath, bridge, "bridge", name))
 	if err != nil {
 		return "", err
 	}
@@ -94,7 +203,7 @@ func getBridgeValue(bridge string, name string) (string, error) {
 }
 
 func setPortBrportValue(port string, name string, value []byte) error {
-	err := os.WriteFile(path.Join(BRCTL_SYS_NET, port, "brport", name), append(value, BRCTL_SYS_SUFFIX), 0)
+	err := writeFile(path.Join(sysfsPath, port, "brport", name), append(value, BRCTL_SYS_SUFFIX))
 	if err != nil {
 		return err
 	}
@@ -102,24 +211,126 @@ func setPortBrportValue(port string, name string, value []byte) error {
 }
 
 func getPortBrportValue(port string, name string) (string, error) {
-	out, err := os.ReadFile(path.Join(BRCTL_SYS_NET, port, "brport", name))
+	out, err := readFile(path.Join(sysfsPath, port, "brport", name))
 	if err != nil {
 		return "", err
 	}
 	return string(out), nil
 }
 
+// getDeviceValue reads a generic attribute of the network device, e.g. /sys/class/net/<dev>/operstate.
+func getDeviceValue(dev string, name string) (string, error) {
+	out, err := readFile(path.Join(sysfsPath, dev, name))
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSuffix(string(out), "\n"), nil
+}
+
+// setBridgeBool writes a boolean bridge attribute as "1" or "0".
+// A missing attribute is reported as ErrNotSupported.
+func setBridgeBool(bridge string, name string, on bool) error {
+	value := "0"
+	if on {
+		value = "1"
+	}
+	if err := setBridgeValue(bridge, name, []byte(value), 0); err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return fmt.Errorf("%s: %w", name, ErrNotSupported)
+		}
+		return err
+	}
+	return nil
+}
+
+// getBridgeBool reads a boolean bridge attribute.
+// A missing attribute is reported as ErrNotSupported.
+func getBridgeBool(bridge string, name string) (bool, error) {
+	value, err := getBridgeValue(bridge, name)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return false, fmt.Errorf("%s: %w", name, ErrNotSupported)
+		}
+		return false, err
+	}
+	return strconv.ParseBool(value)
+}
+
+// setPortBool writes a boolean brport attribute as "1" or "0".
+// A missing attribute is reported as ErrNotSupported.
+func setPortBool(port string, name string, on bool) error {
+	value := "0"
+	if on {
+		value = "1"
+	}
+	if err := setPortBrportValue(port, name, []byte(value)); err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return fmt.Errorf("%s: %w", name, ErrNotSupported)
+		}
+		return err
+	}
+	return nil
+}
+
+// getPortBool reads a boolean brport attribute.
+// A missing attribute is reported as ErrNotSupported.
+func getPortBool(port string, name string) (bool, error) {
+	value, err := getPortBrportValue(port, name)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return false, fmt.Errorf("%s: %w", name, ErrNotSupported)
+		}
+		return false, err
+	}
+	return strconv.ParseBool(strings.TrimSuffix(value, "\n"))
+}
+
 // Convert a string representation of a time.Duration to jiffies
 func stringToJiffies(in string) (int, error) {
+	tv, err := time.ParseDuration(in)
+	if err != nil {
+		return 0, fmt.Errorf("ParseDuration(%q) = %w", in, err)
+	}
+
+	return durationToJiffies(tv)
+}
+
+// Convert a time.Duration to jiffies, rounded to the nearest jiffy.
+// The kernel takes at most math.MaxInt32 jiffies, and a non-zero duration
+// must not round to 0, which disables some timers.
+func durationToJiffies(tv time.Duration) (int, error) {
 	hz, err := sysconfhz()
 	if err != nil {
 		return 0, fmt.Errorf("sysconfhz():%w", err)
 	}
 
-	tv, err := time.ParseDuration(in)
+	abs := tv
+	if abs < 0 {
+		abs = -abs
+	}
+	// Split off the whole seconds, so that multiplying by hz cannot
+	// overflow.
+	secs, rem := int64(abs/time.Second), int64(abs%time.Second)
+	j := secs*int64(hz) + (rem*int64(hz)+int64(time.Second)/2)/int64(time.Second)
+
+	switch {
+	case j > math.MaxInt32:
+		return 0, fmt.Errorf("%v: %w", tv, errJiffiesOverflow)
+	case j == 0 && tv != 0:
+		return 0, fmt.Errorf("%v: %w", tv, errBelowJiffy)
+	}
+	if tv < 0 {
+		j = -j
+	}
+	return int(j), nil
+}
+
+// Convert jiffies read from sysfs to a time.Duration
+func jiffiesToDuration(j int) (time.Duration, error) {
+	hz, err := sysconfhz()
 	if err != nil {
-		return 0, fmt.Errorf("ParseDuration(%q) = %w", in, err)
+		return 0, fmt.Errorf("sysconfhz():%w", err)
 	}
 
-	return int(tv.Seconds() * float64(hz)), nil
+	return time.Duration(j) * time.Second / time.Duration(hz), nil
 }
@@ -0,0 +1,72 @@
+// Copyright 2024 the u-root Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package brctl
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// fakeSysfs points the package at a temporary sysfs tree populated with files.
+// The keys of files are paths relative to the sysfs root, e.g. "br0/bridge/stp_state".
+func fakeSysfs(t *testing.T, files map[string]string) string {
+	t.Helper()
+	root := t.TempDir()
+	for name, content := range files {
+		p := filepath.Join(root, name)
+		if err := os.MkdirAll(filepath.Dir(p), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(p, []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	old := sysfsPath
+	sysfsPath = root
+	t.Cleanup(func() { sysfsPath = old })
+	return root
+}
+
+// readSysfs returns the content of a file in the fake sysfs tree without the trailing newline.
+func readSysfs(t *testing.T, root, name string) string {
+	t.Helper()
+	b, err := os.ReadFile(filepath.Join(root, name))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return strings.TrimSuffix(string(b), "\n")
+}
+
+func TestSetValueMissingAttribute(t *testing.T) {
+	root := fakeSysfs(t, map[string]string{
+		"br0/bridge/stp_state": "0\n",
+		"eth0/brport/priority": "32\n",
+	})
+	b := sysfsHandle()
+
+	for _, tt := range []struct {
+		name string
+		set  func() error
+		file string
+	}{
+		{"setBridgeValue", func() error { return setBridgeValue("br0", "missing", []byte("1"), 0) }, "br0/bridge/missing"},
+		{"setPortBrportValue", func() error { return setPortBrportValue("eth0", "missing", []byte("1")) }, "eth0/brport/missing"},
+		{"Brctl.setBridgeValue", func() error { return b.setBridgeValue("br1", BRCTL_STP_STATE, []byte("1")) }, "br1/bridge/stp_state"},
+		{"Brctl.setPortBrportValue", func() error { return b.setPortBrportValue("eth1", BRCTL_PRIORITY, []byte("1")) }, "eth1/brport/priority"},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := tt.set(); !errors.Is(err, os.ErrNotExist) {
+				t.Errorf("%s = %v, want %v", tt.name, err, os.ErrNotExist)
+			}
+			if _, err := os.Stat(filepath.Join(root, tt.file)); !errors.Is(err, os.ErrNotExist) {
+				t.Errorf("%s created %s", tt.name, tt.file)
+			}
+		})
+	}
+}
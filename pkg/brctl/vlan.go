@@ -0,0 +1,210 @@
+// Copyright 2024 the u-root Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package brctl
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path"
+
+	"github.com/vishvananda/netlink"
+	"github.com/vishvananda/netlink/nl"
+	"golang.org/x/sys/unix"
+)
+
+// VLAN IDs 0 and 4095 are reserved by 802.1Q.
+const (
+	minVID = 1
+	maxVID = 4094
+)
+
+var (
+	errInvalidVID = errors.New("VLAN ID out of range 1-4094")
+	errNotPort    = errors.New("not a port of the bridge")
+)
+
+// SetVLANFiltering enables or disables 802.1Q VLAN filtering on the bridge.
+// ErrNotSupported is returned if the kernel lacks the feature.
+func SetVLANFiltering(bridge string, enabled bool) error {
+	if err := setBridgeBool(bridge, BRCTL_VLAN_FILTERING, enabled); err != nil {
+		return fmt.Errorf("setBridgeBool: %w", err)
+	}
+	return nil
+}
+
+// bridgeVlanList dumps the VLANs of all bridges and bridge ports, by ifindex.
+// Tests replace it.
+var bridgeVlanList = netlink.BridgeVlanList
+
+// VLANFilteringActive reports whether VLAN filtering takes effect on the
+// bridge. That is the case if vlan_filtering is set and the kernel reports
+// VLANs of the bridge or its ports over rtnetlink. Kernels built without
+// VLAN filtering report none, as does a bridge without any VLAN, e.g. with
+// default_pvid 0, which drops all frames.
+//
+// Unlike SetVLANFiltering, a kernel lacking the feature is not an error, the
+// filtering is just not active.
+func VLANFilteringActive(bridge string) (bool, error) {
+	on, err := getBridgeBool(bridge, BRCTL_VLAN_FILTERING)
+	if errors.Is(err, ErrNotSupported) {
+		return false, nil
+	}
+	if err != nil {
+		return false, fmt.Errorf("getBridgeBool: %w", err)
+	}
+	if !on {
+		return false, nil
+	}
+
+	vlans, err := bridgeVlanList()
+	if errors.Is(err, unix.EOPNOTSUPP) {
+		return false, nil
+	}
+	if err != nil {
+		return false, fmt.Errorf("netlink.BridgeVlanList: %w", err)
+	}
+
+	ports, err := os.ReadDir(path.Join(sysfsPath, bridge, BRCTL_BRIDGE_INTERFACE))
+	if err != nil {
+		return false, fmt.Errorf("os.ReadDir: %w", err)
+	}
+	devices := []string{bridge}
+	for _, port := range ports {
+		devices = append(devices, port.Name())
+	}
+	for _, dev := range devices {
+		index, err := linkIndex(dev)
+		if err != nil {
+			// The device was removed meanwhile.
+			continue
+		}
+		if len(vlans[int32(index)]) > 0 {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// SetVLANStats enables or disables per-VLAN statistics on the bridge.
+// ErrNotSupported is returned if the kernel lacks the feature.
+func SetVLANStats(bridge string, on bool) error {
+	if err := setBridgeBool(bridge, BRCTL_VLAN_STATS_ENABLED, on); err != nil {
+		return fmt.Errorf("setBridgeBool: %w", err)
+	}
+	return nil
+}
+
+// VLANStatsEnabled reports whether per-VLAN statistics are enabled on the bridge.
+func VLANStatsEnabled(bridge string) (bool, error) {
+	on, err := getBridgeBool(bridge, BRCTL_VLAN_STATS_ENABLED)
+	if err != nil {
+		return false, fmt.Errorf("getBridgeBool: %w", err)
+	}
+	return on, nil
+}
+
+// SetVLANStatsPerPort enables or disables per-port per-VLAN statistics on the bridge.
+// ErrNotSupported is returned if the kernel lacks the feature.
+func SetVLANStatsPerPort(bridge string, on bool) error {
+	if err := setBridgeBool(bridge, BRCTL_VLAN_STATS_PER_PORT, on); err != nil {
+		return fmt.Errorf("setBridgeBool: %w", err)
+	}
+	return nil
+}
+
+// VLANStatsPerPort reports whether per-port per-VLAN statistics are enabled on the bridge.
+func VLANStatsPerPort(bridge string) (bool, error) {
+	on, err := getBridgeBool(bridge, BRCTL_VLAN_STATS_PER_PORT)
+	if err != nil {
+		return false, fmt.Errorf("getBridgeBool: %w", err)
+	}
+	return on, nil
+}
+
+// SetVLANTunnel enables or disables VLAN tunnel mapping on the bridge port,
+// as used by e.g. VXLAN ports in EVPN setups.
+// ErrNotSupported is returned if the kernel lacks the feature.
+func SetVLANTunnel(port string, on bool) error {
+	if err := setPortBool(port, BRCTL_VLAN_TUNNEL, on); err != nil {
+		return fmt.Errorf("setPortBool: %w", err)
+	}
+	return nil
+}
+
+// VLANTunnel reports whether VLAN tunnel mapping is enabled on the bridge port.
+func VLANTunnel(port string) (bool, error) {
+	on, err := getPortBool(port, BRCTL_VLAN_TUNNEL)
+	if err != nil {
+		return false, fmt.Errorf("getPortBool: %w", err)
+	}
+	return on, nil
+}
+
+// AddVLAN adds the VLAN vid to iface, a port of bridge or the bridge itself.
+// With pvid, untagged frames received on iface are assigned to vid. With
+// untagged, frames of vid leave iface untagged. VLAN filtering must be
+// enabled on the bridge for the VLAN to take effect, see SetVLANFiltering.
+func AddVLAN(bridge, iface string, vid uint16, pvid, untagged bool) error {
+	var flags uint16
+	if pvid {
+		flags |= nl.BRIDGE_VLAN_INFO_PVID
+	}
+	if untagged {
+		flags |= nl.BRIDGE_VLAN_INFO_UNTAGGED
+	}
+	return modifyVLAN(unix.RTM_SETLINK, bridge, iface, vid, flags)
+}
+
+// DeleteVLAN removes the VLAN vid from iface, a port of bridge or the bridge
+// itself.
+func DeleteVLAN(bridge, iface string, vid uint16) error {
+	return modifyVLAN(unix.RTM_DELLINK, bridge, iface, vid, 0)
+}
+
+// modifyVLAN sends an AF_BRIDGE request adding or removing a VLAN of iface.
+// The kernel handles these with RTM_SETLINK and RTM_DELLINK, RTM_NEWLINK
+// only creates devices.
+func modifyVLAN(cmd int, bridge, iface string, vid uint16, flags uint16) error {
+	if vid < minVID || vid > maxVID {
+		return fmt.Errorf("%w: %d", errInvalidVID, vid)
+	}
+	// The bridge's own VLANs are configured on the bridge device itself,
+	// the ports' VLANs via their master.
+	self := iface == bridge
+	if !self && !isPortOf(bridge, iface) {
+		return fmt.Errorf("%s: %w %s", iface, errNotPort, bridge)
+	}
+
+	index, err := getIndexFromInterfaceName(iface)
+	if err != nil {
+		return fmt.Errorf("getIndexFromInterfaceName: %w", err)
+	}
+
+	if err := netlinkExecute(vlanRequest(cmd, index, self, vid, flags)); err != nil {
+		return fmt.Errorf("VLAN %d on %s: %w", vid, iface, err)
+	}
+	return nil
+}
+
+// vlanRequest builds the request for VLAN vid on the device with the given
+// ifindex.
+func vlanRequest(cmd int, index int, self bool, vid uint16, flags uint16) *nl.NetlinkRequest {
+	req := nl.NewNetlinkRequest(cmd, unix.NLM_F_ACK)
+
+	msg := nl.NewIfInfomsg(unix.AF_BRIDGE)
+	msg.Index = int32(index)
+	req.AddData(msg)
+
+	spec := nl.NewRtAttr(unix.IFLA_AF_SPEC, nil)
+	if self {
+		spec.AddRtAttr(nl.IFLA_BRIDGE_FLAGS, nl.Uint16Attr(nl.BRIDGE_FLAGS_SELF))
+	}
+	info := nl.BridgeVlanInfo{Flags: flags, Vid: vid}
+	spec.AddRtAttr(nl.IFLA_BRIDGE_VLAN_INFO, info.Serialize())
+	req.AddData(spec)
+
+	return req
+}
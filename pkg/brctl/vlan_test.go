@@ -0,0 +1,320 @@
+// Copyright 2024 the u-root Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package brctl
+
+import (
+	"errors"
+	"reflect"
+	"syscall"
+	"testing"
+
+	"github.com/vishvananda/netlink/nl"
+	"golang.org/x/sys/unix"
+)
+
+func TestVLANStats(t *testing.T) {
+	for _, tt := range []struct {
+		name string
+		file string
+		// dev is the device the attribute belongs to, br0 by default.
+		dev string
+		set func(string, bool) error
+		get func(string) (bool, error)
+	}{
+		{
+			name: "vlan_filtering",
+			file: "br0/bridge/vlan_filtering",
+			set:  SetVLANFiltering,
+			get: func(bridge string) (bool, error) {
+				return getBridgeBool(bridge, BRCTL_VLAN_FILTERING)
+			},
+		},
+		{
+			name: "vlan_stats_enabled",
+			file: "br0/bridge/vlan_stats_enabled",
+			set:  SetVLANStats,
+			get:  VLANStatsEnabled,
+		},
+		{
+			name: "vlan_stats_per_port",
+			file: "br0/bridge/vlan_stats_per_port",
+			set:  SetVLANStatsPerPort,
+			get:  VLANStatsPerPort,
+		},
+		{
+			name: "vlan_tunnel",
+			file: "vxlan0/brport/vlan_tunnel",
+			dev:  "vxlan0",
+			set:  SetVLANTunnel,
+			get:  VLANTunnel,
+		},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			root := fakeSysfs(t, map[string]string{tt.file: "0\n"})
+			dev := tt.dev
+			if dev == "" {
+				dev = "br0"
+			}
+
+			for _, on := range []bool{true, false} {
+				if err := tt.set(dev, on); err != nil {
+					t.Fatalf("set(%q, %v) = %v, want nil", dev, on, err)
+				}
+
+				want := "0"
+				if on {
+					want = "1"
+				}
+				if got := readSysfs(t, root, tt.file); got != want {
+					t.Errorf("%s = %q, want %q", tt.file, got, want)
+				}
+
+				got, err := tt.get(dev)
+				if err != nil {
+					t.Fatalf("get(%q) = %v, want nil", dev, err)
+				}
+				if got != on {
+					t.Errorf("get(%q) = %v, want %v", dev, got, on)
+				}
+			}
+		})
+	}
+}
+
+func TestVLANStatsNotSupported(t *testing.T) {
+	fakeSysfs(t, map[string]string{"br0/bridge/stp_state": "0\n"})
+
+	if err := SetVLANFiltering("br0", true); !errors.Is(err, ErrNotSupported) {
+		t.Errorf("SetVLANFiltering(%q, true) = %v, want %v", "br0", err, ErrNotSupported)
+	}
+	if err := SetVLANStats("br0", true); !errors.Is(err, ErrNotSupported) {
+		t.Errorf("SetVLANStats(%q, true) = %v, want %v", "br0", err, ErrNotSupported)
+	}
+	if _, err := VLANStatsEnabled("br0"); !errors.Is(err, ErrNotSupported) {
+		t.Errorf("VLANStatsEnabled(%q) = %v, want %v", "br0", err, ErrNotSupported)
+	}
+	if err := SetVLANStatsPerPort("br0", true); !errors.Is(err, ErrNotSupported) {
+		t.Errorf("SetVLANStatsPerPort(%q, true) = %v, want %v", "br0", err, ErrNotSupported)
+	}
+	if err := SetVLANTunnel("eth0", true); !errors.Is(err, ErrNotSupported) {
+		t.Errorf("SetVLANTunnel(%q, true) = %v, want %v", "eth0", err, ErrNotSupported)
+	}
+	if _, err := VLANTunnel("eth0"); !errors.Is(err, ErrNotSupported) {
+		t.Errorf("VLANTunnel(%q) = %v, want %v", "eth0", err, ErrNotSupported)
+	}
+}
+
+// vlanMsg is the decoded form of a request built by vlanRequest.
+type vlanMsg struct {
+	cmd   uint16
+	index int32
+	self  bool
+	info  nl.BridgeVlanInfo
+}
+
+func parseVLANRequest(t *testing.T, b []byte) vlanMsg {
+	t.Helper()
+	msgs, err := syscall.ParseNetlinkMessage(b)
+	if err != nil || len(msgs) != 1 {
+		t.Fatalf("ParseNetlinkMessage = %v, %v, want 1 message", msgs, err)
+	}
+	m := msgs[0]
+	ifi := nl.DeserializeIfInfomsg(m.Data)
+	if ifi.Family != unix.AF_BRIDGE {
+		t.Errorf("ifinfomsg family = %d, want AF_BRIDGE", ifi.Family)
+	}
+	got := vlanMsg{cmd: m.Header.Type, index: ifi.Index}
+
+	attrs, err := nl.ParseRouteAttr(m.Data[unix.SizeofIfInfomsg:])
+	if err != nil || len(attrs) != 1 || attrs[0].Attr.Type != unix.IFLA_AF_SPEC {
+		t.Fatalf("ParseRouteAttr = %v, %v, want IFLA_AF_SPEC", attrs, err)
+	}
+	spec, err := nl.ParseRouteAttr(attrs[0].Value)
+	if err != nil {
+		t.Fatalf("ParseRouteAttr(IFLA_AF_SPEC) = %v", err)
+	}
+	for _, a := range spec {
+		switch a.Attr.Type {
+		case nl.IFLA_BRIDGE_FLAGS:
+			got.self = nl.NativeEndian().Uint16(a.Value) == nl.BRIDGE_FLAGS_SELF
+		case nl.IFLA_BRIDGE_VLAN_INFO:
+			got.info = *nl.DeserializeBridgeVlanInfo(a.Value)
+		default:
+			t.Errorf("unexpected attribute %d in IFLA_AF_SPEC", a.Attr.Type)
+		}
+	}
+	return got
+}
+
+func TestVLANFilteringActive(t *testing.T) {
+	for _, tt := range []struct {
+		name  string
+		files map[string]string
+		vlans map[int32][]*nl.BridgeVlanInfo
+		err   error
+		want  bool
+	}{
+		{
+			name:  "active",
+			files: map[string]string{"br0/bridge/vlan_filtering": "1\n", "br0/brif/eth0/.keep": ""},
+			vlans: map[int32][]*nl.BridgeVlanInfo{7: {{Flags: nl.BRIDGE_VLAN_INFO_PVID, Vid: 1}}},
+			want:  true,
+		},
+		{
+			name:  "port VLANs only",
+			files: map[string]string{"br0/bridge/vlan_filtering": "1\n", "br0/brif/eth0/.keep": ""},
+			vlans: map[int32][]*nl.BridgeVlanInfo{8: {{Vid: 10}}},
+			want:  true,
+		},
+		{
+			name:  "flag off",
+			files: map[string]string{"br0/bridge/vlan_filtering": "0\n", "br0/brif/eth0/.keep": ""},
+			vlans: map[int32][]*nl.BridgeVlanInfo{7: {{Vid: 1}}},
+		},
+		{
+			name:  "flag on but unsupported",
+			files: map[string]string{"br0/bridge/vlan_filtering": "1\n", "br0/brif/eth0/.keep": ""},
+			vlans: map[int32][]*nl.BridgeVlanInfo{9: {{Vid: 1}}},
+		},
+		{
+			name:  "flag on but no netlink support",
+			files: map[string]string{"br0/bridge/vlan_filtering": "1\n", "br0/brif/eth0/.keep": ""},
+			err:   unix.EOPNOTSUPP,
+		},
+		{
+			name:  "no sysfs attribute",
+			files: map[string]string{"br0/brif/eth0/.keep": ""},
+			vlans: map[int32][]*nl.BridgeVlanInfo{7: {{Vid: 1}}},
+		},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			fakeSysfs(t, tt.files)
+			oldIndex, oldList := linkIndex, bridgeVlanList
+			linkIndex = func(name string) (int, error) {
+				return map[string]int{"br0": 7, "eth0": 8}[name], nil
+			}
+			bridgeVlanList = func() (map[int32][]*nl.BridgeVlanInfo, error) {
+				return tt.vlans, tt.err
+			}
+			t.Cleanup(func() { linkIndex, bridgeVlanList = oldIndex, oldList })
+
+			got, err := VLANFilteringActive("br0")
+			if err != nil || got != tt.want {
+				t.Errorf("VLANFilteringActive(br0) = %t, %v, want %t, nil", got, err, tt.want)
+			}
+		})
+	}
+}
+
+func TestVLANFilteringActiveError(t *testing.T) {
+	fakeSysfs(t, map[string]string{"br0/bridge/vlan_filtering": "1\n", "br0/brif/.keep": ""})
+	old := bridgeVlanList
+	bridgeVlanList = func() (map[int32][]*nl.BridgeVlanInfo, error) {
+		return nil, syscall.EPERM
+	}
+	t.Cleanup(func() { bridgeVlanList = old })
+
+	if _, err := VLANFilteringActive("br0"); !errors.Is(err, syscall.EPERM) {
+		t.Errorf("VLANFilteringActive(br0) = %v, want %v", err, syscall.EPERM)
+	}
+}
+
+func TestVLAN(t *testing.T) {
+	for _, tt := range []struct {
+		name string
+		do   func() error
+		want vlanMsg
+	}{
+		{
+			name: "add tagged",
+			do:   func() error { return AddVLAN("br0", "eth0", 10, false, false) },
+			want: vlanMsg{cmd: unix.RTM_SETLINK, index: 3, info: nl.BridgeVlanInfo{Vid: 10}},
+		},
+		{
+			name: "add pvid untagged",
+			do:   func() error { return AddVLAN("br0", "eth0", 1, true, true) },
+			want: vlanMsg{
+				cmd:   unix.RTM_SETLINK,
+				index: 3,
+				info:  nl.BridgeVlanInfo{Flags: nl.BRIDGE_VLAN_INFO_PVID | nl.BRIDGE_VLAN_INFO_UNTAGGED, Vid: 1},
+			},
+		},
+		{
+			name: "add pvid tagged",
+			do:   func() error { return AddVLAN("br0", "eth0", 4094, true, false) },
+			want: vlanMsg{cmd: unix.RTM_SETLINK, index: 3, info: nl.BridgeVlanInfo{Flags: nl.BRIDGE_VLAN_INFO_PVID, Vid: 4094}},
+		},
+		{
+			name: "add to bridge",
+			do:   func() error { return AddVLAN("br0", "br0", 10, false, true) },
+			want: vlanMsg{cmd: unix.RTM_SETLINK, index: 2, self: true, info: nl.BridgeVlanInfo{Flags: nl.BRIDGE_VLAN_INFO_UNTAGGED, Vid: 10}},
+		},
+		{
+			name: "delete",
+			do:   func() error { return DeleteVLAN("br0", "eth0", 10) },
+			want: vlanMsg{cmd: unix.RTM_DELLINK, index: 3, info: nl.BridgeVlanInfo{Vid: 10}},
+		},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			fakeSysfs(t, map[string]string{"br0/brif/eth0/.keep": ""})
+			fakeIndexes(t, map[string]uint32{"br0": 2, "eth0": 3})
+			reqs := recordNetlink(t, nil)
+
+			if err := tt.do(); err != nil {
+				t.Fatalf("got %v, want nil", err)
+			}
+			if len(*reqs) != 1 {
+				t.Fatalf("sent %d requests, want 1", len(*reqs))
+			}
+			if got := parseVLANRequest(t, (*reqs)[0]); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("request = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestVLANErrors(t *testing.T) {
+	for _, tt := range []struct {
+		name    string
+		do      func() error
+		nlErr   error
+		wantErr error
+	}{
+		{
+			name:    "vid 0",
+			do:      func() error { return AddVLAN("br0", "eth0", 0, false, false) },
+			wantErr: errInvalidVID,
+		},
+		{
+			name:    "vid 4095",
+			do:      func() error { return DeleteVLAN("br0", "eth0", 4095) },
+			wantErr: errInvalidVID,
+		},
+		{
+			name:    "not a port",
+			do:      func() error { return AddVLAN("br0", "eth1", 10, false, false) },
+			wantErr: errNotPort,
+		},
+		{
+			name:    "kernel error",
+			do:      func() error { return DeleteVLAN("br0", "eth0", 10) },
+			nlErr:   unix.ENOENT,
+			wantErr: unix.ENOENT,
+		},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			fakeSysfs(t, map[string]string{"br0/brif/eth0/.keep": ""})
+			fakeIndexes(t, map[string]uint32{"br0": 2, "eth0": 3, "eth1": 4})
+			reqs := recordNetlink(t, tt.nlErr)
+
+			if err := tt.do(); !errors.Is(err, tt.wantErr) {
+				t.Errorf("got %v, want %v", err, tt.wantErr)
+			}
+			if tt.nlErr == nil && len(*reqs) != 0 {
+				t.Errorf("sent %d requests, want none", len(*reqs))
+			}
+		})
+	}
+}
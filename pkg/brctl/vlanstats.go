@@ -0,0 +1,194 @@
+// Copyright 2024 the u-root Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package brctl
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/vishvananda/netlink/nl"
+	"golang.org/x/sys/unix"
+)
+
+// Attributes of RTM_GETVLAN dumps, from linux/if_bridge.h.
+const (
+	// rtmNewVLAN is RTM_NEWVLAN, which x/sys/unix misspells.
+	rtmNewVLAN = 0x70
+
+	bridgeVlandbDumpFlags = 1
+	bridgeVlandbDumpStats = 1 << 0
+
+	bridgeVlandbEntry = 1
+
+	bridgeVlandbEntryInfo  = 1
+	bridgeVlandbEntryRange = 2
+	bridgeVlandbEntryStats = 5
+
+	bridgeVlandbStatsRxBytes   = 1
+	bridgeVlandbStatsRxPackets = 2
+	bridgeVlandbStatsTxBytes   = 3
+	bridgeVlandbStatsTxPackets = 4
+)
+
+// sizeofBridgeMsg is the size of struct br_vlan_msg and struct br_port_msg.
+const sizeofBridgeMsg = 8
+
+var errVLANDump = errors.New("malformed VLAN dump")
+
+// VLANCounters are the traffic counters of one VLAN.
+type VLANCounters struct {
+	RxBytes   uint64 `json:"rx_bytes"`
+	RxPackets uint64 `json:"rx_packets"`
+	TxBytes   uint64 `json:"tx_bytes"`
+	TxPackets uint64 `json:"tx_packets"`
+}
+
+// netlinkDump sends a dump request on a NETLINK_ROUTE socket and returns the
+// payloads of the resType messages of the reply. Tests replace it.
+var netlinkDump = func(req *nl.NetlinkRequest, resType uint16) ([][]byte, error) {
+	return req.Execute(unix.NETLINK_ROUTE, resType)
+}
+
+// VLANStats returns the counters of the VLANs of the bridge, by VLAN ID.
+// The kernel counts only while per-VLAN statistics are enabled, see
+// SetVLANStats; the counters of a port's VLANs are those of the bridge's
+// unless SetVLANStatsPerPort is on as well.
+func VLANStats(bridge string) (map[uint16]VLANCounters, error) {
+	index, err := getIndexFromInterfaceName(bridge)
+	if err != nil {
+		return nil, fmt.Errorf("getIndexFromInterfaceName: %w", err)
+	}
+
+	msgs, err := netlinkDump(vlanStatsRequest(index), rtmNewVLAN)
+	if err != nil {
+		return nil, fmt.Errorf("dump VLANs of %s: %w", bridge, err)
+	}
+
+	stats := make(map[uint16]VLANCounters)
+	for _, msg := range msgs {
+		if err := parseVLANStats(msg, stats); err != nil {
+			return nil, fmt.Errorf("VLANs of %s: %w", bridge, err)
+		}
+	}
+	return stats, nil
+}
+
+// bridgeMsg is struct br_vlan_msg, the header of RTM_GETVLAN requests, or
+// struct br_port_msg, that of RTM_GETMDB requests. Both hold the AF_BRIDGE
+// family and an ifindex.
+type bridgeMsg struct {
+	index uint32
+}
+
+func (msg bridgeMsg) Len() int {
+	return sizeofBridgeMsg
+}
+
+func (msg bridgeMsg) Serialize() []byte {
+	b := make([]byte, sizeofBridgeMsg)
+	b[0] = unix.AF_BRIDGE
+	nl.NativeEndian().PutUint32(b[4:], msg.index)
+	return b
+}
+
+// vlanStatsRequest builds the RTM_GETVLAN request dumping the VLANs of the
+// device with the given ifindex, with statistics.
+func vlanStatsRequest(index int) *nl.NetlinkRequest {
+	req := nl.NewNetlinkRequest(unix.RTM_GETVLAN, unix.NLM_F_DUMP)
+	req.AddData(bridgeMsg{index: uint32(index)})
+	req.AddData(nl.NewRtAttr(bridgeVlandbDumpFlags, nl.Uint32Attr(bridgeVlandbDumpStats)))
+	return req
+}
+
+// parseVLANStats adds the counters of the VLAN entries of the RTM_NEWVLAN
+// payload msg to stats.
+//
+// The kernel compresses consecutive VLANs with equal settings into a range.
+// Every VLAN of such a range gets the counters reported for it.
+func parseVLANStats(msg []byte, stats map[uint16]VLANCounters) error {
+	if len(msg) < sizeofBridgeMsg {
+		return fmt.Errorf("%w: %d byte message", errVLANDump, len(msg))
+	}
+	attrs, err := nl.ParseRouteAttr(msg[sizeofBridgeMsg:])
+	if err != nil {
+		return fmt.Errorf("%w: %w", errVLANDump, err)
+	}
+
+	native := nl.NativeEndian()
+	for _, attr := range attrs {
+		if attr.Attr.Type&nl.NLA_TYPE_MASK != bridgeVlandbEntry {
+			continue
+		}
+		entry, err := nl.ParseRouteAttr(attr.Value)
+		if err != nil {
+			return fmt.Errorf("%w: %w", errVLANDump, err)
+		}
+
+		var info *nl.BridgeVlanInfo
+		var last uint16
+		var counters VLANCounters
+		for _, a := range entry {
+			switch a.Attr.Type & nl.NLA_TYPE_MASK {
+			case bridgeVlandbEntryInfo:
+				if len(a.Value) < 4 {
+					return fmt.Errorf("%w: short VLAN info", errVLANDump)
+				}
+				info = nl.DeserializeBridgeVlanInfo(a.Value)
+			case bridgeVlandbEntryRange:
+				if len(a.Value) < 2 {
+					return fmt.Errorf("%w: short VLAN range", errVLANDump)
+				}
+				last = native.Uint16(a.Value)
+			case bridgeVlandbEntryStats:
+				counters, err = parseVLANCounters(a.Value)
+				if err != nil {
+					return err
+				}
+			}
+		}
+		if info == nil {
+			return fmt.Errorf("%w: entry without VLAN info", errVLANDump)
+		}
+
+		if last < info.Vid {
+			last = info.Vid
+		}
+		for vid := uint32(info.Vid); vid <= uint32(last); vid++ {
+			stats[uint16(vid)] = counters
+		}
+	}
+	return nil
+}
+
+// parseVLANCounters decodes a nested BRIDGE_VLANDB_ENTRY_STATS attribute.
+func parseVLANCounters(b []byte) (VLANCounters, error) {
+	attrs, err := nl.ParseRouteAttr(b)
+	if err != nil {
+		return VLANCounters{}, fmt.Errorf("%w: %w", errVLANDump, err)
+	}
+
+	var c VLANCounters
+	for _, a := range attrs {
+		var counter *uint64
+		switch a.Attr.Type & nl.NLA_TYPE_MASK {
+		case bridgeVlandbStatsRxBytes:
+			counter = &c.RxBytes
+		case bridgeVlandbStatsRxPackets:
+			counter = &c.RxPackets
+		case bridgeVlandbStatsTxBytes:
+			counter = &c.TxBytes
+		case bridgeVlandbStatsTxPackets:
+			counter = &c.TxPackets
+		default:
+			// BRIDGE_VLANDB_STATS_PAD and newer counters.
+			continue
+		}
+		if len(a.Value) < 8 {
+			return VLANCounters{}, fmt.Errorf("%w: short counter %d", errVLANDump, a.Attr.Type)
+		}
+		*counter = nl.NativeEndian().Uint64(a.Value)
+	}
+	return c, nil
+}
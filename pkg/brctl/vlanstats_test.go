@@ -0,0 +1,111 @@
+// Copyright 2024 the u-root Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package brctl
+
+import (
+	"errors"
+	"reflect"
+	"syscall"
+	"testing"
+
+	"github.com/vishvananda/netlink/nl"
+	"golang.org/x/sys/unix"
+)
+
+// vlanStatsDump is an RTM_NEWVLAN payload of a little-endian kernel for
+// bridge 5: VLAN 1 as PVID and untagged, and VLANs 10-12 compressed into a
+// range, each with BRIDGE_VLANDB_STATS_PAD before the counters.
+const vlanStatsDump = "" +
+	"\x07\x00\x00\x00\x05\x00\x00\x00\x58\x00\x01\x80\x08\x00\x01\x00" +
+	"\x06\x00\x01\x00\x05\x00\x03\x00\x03\x00\x00\x00\x44\x00\x05\x80" +
+	"\x04\x00\x05\x00\x0c\x00\x01\x00\xdc\x05\x00\x00\x00\x00\x00\x00" +
+	"\x04\x00\x05\x00\x0c\x00\x02\x00\x0a\x00\x00\x00\x00\x00\x00\x00" +
+	"\x04\x00\x05\x00\x0c\x00\x03\x00\xb8\x0b\x00\x00\x00\x00\x00\x00" +
+	"\x04\x00\x05\x00\x0c\x00\x04\x00\x14\x00\x00\x00\x00\x00\x00\x00" +
+	"\x60\x00\x01\x80\x08\x00\x01\x00\x00\x00\x0a\x00\x06\x00\x02\x00" +
+	"\x0c\x00\x00\x00\x05\x00\x03\x00\x03\x00\x00\x00\x44\x00\x05\x80" +
+	"\x04\x00\x05\x00\x0c\x00\x01\x00\x40\x00\x00\x00\x00\x00\x00\x00" +
+	"\x04\x00\x05\x00\x0c\x00\x02\x00\x01\x00\x00\x00\x00\x00\x00\x00" +
+	"\x04\x00\x05\x00\x0c\x00\x03\x00\x80\x00\x00\x00\x00\x00\x00\x00" +
+	"\x04\x00\x05\x00\x0c\x00\x04\x00\x02\x00\x00\x00\x00\x00\x00\x00"
+
+// fakeNetlinkDump replies to dumps for messages of type wantType with msgs,
+// or fails them with err, and records the requests.
+func fakeNetlinkDump(t *testing.T, wantType uint16, msgs [][]byte, err error) *[][]byte {
+	t.Helper()
+	var reqs [][]byte
+	old := netlinkDump
+	netlinkDump = func(req *nl.NetlinkRequest, resType uint16) ([][]byte, error) {
+		if resType != wantType {
+			t.Errorf("dump for message type %d, want %d", resType, wantType)
+		}
+		reqs = append(reqs, req.Serialize())
+		return msgs, err
+	}
+	t.Cleanup(func() { netlinkDump = old })
+	return &reqs
+}
+
+func TestVLANStatsDump(t *testing.T) {
+	if nl.NativeEndian().Uint16([]byte{1, 0}) != 1 {
+		t.Skip("captured dump is little-endian")
+	}
+	fakeIndexes(t, map[string]uint32{"br0": 5})
+	reqs := fakeNetlinkDump(t, rtmNewVLAN, [][]byte{[]byte(vlanStatsDump)}, nil)
+
+	got, err := VLANStats("br0")
+	if err != nil {
+		t.Fatalf("VLANStats(br0) = %v, want nil", err)
+	}
+	rng := VLANCounters{RxBytes: 64, RxPackets: 1, TxBytes: 128, TxPackets: 2}
+	want := map[uint16]VLANCounters{
+		1:  {RxBytes: 1500, RxPackets: 10, TxBytes: 3000, TxPackets: 20},
+		10: rng,
+		11: rng,
+		12: rng,
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("VLANStats(br0) = %+v, want %+v", got, want)
+	}
+
+	if len(*reqs) != 1 {
+		t.Fatalf("sent %d dump requests, want 1", len(*reqs))
+	}
+	msgs, err := syscall.ParseNetlinkMessage((*reqs)[0])
+	if err != nil || len(msgs) != 1 {
+		t.Fatalf("ParseNetlinkMessage = %v, %v, want 1 message", msgs, err)
+	}
+	if h := msgs[0].Header; h.Type != unix.RTM_GETVLAN || h.Flags&unix.NLM_F_DUMP != unix.NLM_F_DUMP {
+		t.Errorf("request type %d flags %#x, want RTM_GETVLAN dump", h.Type, h.Flags)
+	}
+	data := msgs[0].Data
+	if data[0] != unix.AF_BRIDGE || nl.NativeEndian().Uint32(data[4:8]) != 5 {
+		t.Errorf("br_vlan_msg = %x, want AF_BRIDGE and ifindex 5", data[:8])
+	}
+	attrs, err := nl.ParseRouteAttr(data[sizeofBridgeMsg:])
+	if err != nil || len(attrs) != 1 || attrs[0].Attr.Type != bridgeVlandbDumpFlags || nl.NativeEndian().Uint32(attrs[0].Value) != bridgeVlandbDumpStats {
+		t.Errorf("request attributes = %v, %v, want BRIDGE_VLANDB_DUMPF_STATS", attrs, err)
+	}
+}
+
+func TestVLANStatsDumpErrors(t *testing.T) {
+	fakeIndexes(t, map[string]uint32{"br0": 5})
+
+	fakeNetlinkDump(t, rtmNewVLAN, nil, unix.EOPNOTSUPP)
+	if _, err := VLANStats("br0"); !errors.Is(err, unix.EOPNOTSUPP) {
+		t.Errorf("VLANStats(br0) = %v, want %v", err, unix.EOPNOTSUPP)
+	}
+
+	for _, msg := range []string{
+		"\x07\x00\x00",
+		// An entry without BRIDGE_VLANDB_ENTRY_INFO.
+		"\x07\x00\x00\x00\x05\x00\x00\x00\x0c\x00\x01\x80\x06\x00\x02\x00\x0c\x00\x00\x00",
+	} {
+		fakeNetlinkDump(t, rtmNewVLAN, [][]byte{[]byte(msg)}, nil)
+		if _, err := VLANStats("br0"); !errors.Is(err, errVLANDump) {
+			t.Errorf("VLANStats(br0) with dump %q = %v, want %v", msg, err, errVLANDump)
+		}
+	}
+}
@@ -0,0 +1,114 @@
+// Copyright 2024 the u-root Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package brctl
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path"
+	"time"
+)
+
+// PortState is the STP state of a bridge port, one of the BR_STATE_* values.
+type PortState int
+
+var portStateNames = map[PortState]string{
+	BR_STATE_DISABLED:   "disabled",
+	BR_STATE_LISTENING:  "listening",
+	BR_STATE_LEARNING:   "learning",
+	BR_STATE_FORWARDING: "forwarding",
+	BR_STATE_BLOCKING:   "blocking",
+}
+
+// String returns the name brctl uses for the state.
+func (s PortState) String() string {
+	if name, ok := portStateNames[s]; ok {
+		return name
+	}
+	return fmt.Sprintf("state %d", int(s))
+}
+
+// statePollInterval is how often WaitForForwarding and WaitForActive read the
+// port state.
+var statePollInterval = 100 * time.Millisecond
+
+// WaitForForwarding waits until the port is in the forwarding state, or fails
+// once timeout has passed.
+//
+// If onState is not nil, it is called with the first state read and then with
+// each state change, e.g. listening, learning and forwarding, so callers can
+// show progress. It is not called for reads that return the same state.
+func WaitForForwarding(port string, timeout time.Duration, onState func(PortState)) error {
+	deadline := time.Now().Add(timeout)
+	last := PortState(-1)
+	for {
+		n, err := getPortInt(port, BRCTL_PORT_STATE)
+		if err != nil {
+			return err
+		}
+
+		state := PortState(n)
+		if state != last && onState != nil {
+			onState(state)
+		}
+		last = state
+		if state == BR_STATE_FORWARDING {
+			return nil
+		}
+
+		if time.Now().After(deadline) {
+			return fmt.Errorf("port %s still %s after %v: %w", port, state, timeout, os.ErrDeadlineExceeded)
+		}
+		time.Sleep(statePollInterval)
+	}
+}
+
+// WaitForActive waits until the bridge carries traffic, i.e. at least one of
+// its ports is forwarding or the bridge has carrier. It fails once ctx is
+// done.
+//
+// Ports are re-read on every poll, so ports attached while waiting count.
+func WaitForActive(ctx context.Context, bridge string) error {
+	for {
+		active, err := isActive(bridge)
+		if err != nil {
+			return err
+		}
+		if active {
+			return nil
+		}
+
+		select {
+		case <-ctx.Done():
+			return fmt.Errorf("bridge %s has no forwarding port: %w", bridge, ctx.Err())
+		case <-time.After(statePollInterval):
+		}
+	}
+}
+
+// isActive reports whether a port of the bridge is forwarding or the bridge
+// has carrier.
+func isActive(bridge string) (bool, error) {
+	ports, err := os.ReadDir(path.Join(sysfsPath, bridge, BRCTL_BRIDGE_INTERFACE))
+	if err != nil {
+		return false, fmt.Errorf("os.ReadDir: %w", err)
+	}
+	for _, port := range ports {
+		n, err := getPortInt(port.Name(), BRCTL_PORT_STATE)
+		// The port may have been detached since the bridge was read.
+		if errors.Is(err, os.ErrNotExist) {
+			continue
+		}
+		if err != nil {
+			return false, err
+		}
+		if n == BR_STATE_FORWARDING {
+			return true, nil
+		}
+	}
+	return CarrierUp(bridge)
+}
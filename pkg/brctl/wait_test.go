@@ -0,0 +1,180 @@
+// Copyright 2024 the u-root Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package brctl
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"testing"
+	"time"
+)
+
+// fakePortStates makes successive reads of the port state return states,
+// repeating the last one.
+func fakePortStates(t *testing.T, states ...PortState) {
+	t.Helper()
+	oldRead, oldInterval := readFile, statePollInterval
+	statePollInterval = time.Millisecond
+	readFile = func(name string) ([]byte, error) {
+		state := states[0]
+		if len(states) > 1 {
+			states = states[1:]
+		}
+		return []byte(strconv.Itoa(int(state)) + "\n"), nil
+	}
+	t.Cleanup(func() { readFile, statePollInterval = oldRead, oldInterval })
+}
+
+func TestWaitForForwarding(t *testing.T) {
+	fakePortStates(t,
+		BR_STATE_BLOCKING,
+		BR_STATE_LISTENING, BR_STATE_LISTENING, BR_STATE_LISTENING,
+		BR_STATE_LEARNING, BR_STATE_LEARNING,
+		BR_STATE_FORWARDING,
+	)
+
+	var got []PortState
+	if err := WaitForForwarding("eth0", time.Minute, func(s PortState) { got = append(got, s) }); err != nil {
+		t.Fatalf("WaitForForwarding(%q) = %v, want nil", "eth0", err)
+	}
+	want := []PortState{BR_STATE_BLOCKING, BR_STATE_LISTENING, BR_STATE_LEARNING, BR_STATE_FORWARDING}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("WaitForForwarding(%q) reported states %v, want %v", "eth0", got, want)
+	}
+}
+
+func TestWaitForForwardingNilCallback(t *testing.T) {
+	fakePortStates(t, BR_STATE_LEARNING, BR_STATE_FORWARDING)
+
+	if err := WaitForForwarding("eth0", time.Minute, nil); err != nil {
+		t.Errorf("WaitForForwarding(%q) = %v, want nil", "eth0", err)
+	}
+}
+
+func TestWaitForForwardingTimeout(t *testing.T) {
+	fakePortStates(t, BR_STATE_LISTENING, BR_STATE_LEARNING)
+
+	var got []PortState
+	err := WaitForForwarding("eth0", 10*time.Millisecond, func(s PortState) { got = append(got, s) })
+	if !errors.Is(err, os.ErrDeadlineExceeded) {
+		t.Errorf("WaitForForwarding(%q) = %v, want %v", "eth0", err, os.ErrDeadlineExceeded)
+	}
+	want := []PortState{BR_STATE_LISTENING, BR_STATE_LEARNING}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("WaitForForwarding(%q) reported states %v, want %v", "eth0", got, want)
+	}
+}
+
+func TestPortStateString(t *testing.T) {
+	for state, want := range map[PortState]string{
+		BR_STATE_LISTENING:  "listening",
+		BR_STATE_FORWARDING: "forwarding",
+		7:                   "state 7",
+	} {
+		if got := state.String(); got != want {
+			t.Errorf("PortState(%d).String() = %q, want %q", int(state), got, want)
+		}
+	}
+}
+
+func TestWaitForActive(t *testing.T) {
+	for _, tt := range []struct {
+		name  string
+		files map[string]string
+		// after the port state of eth1 was read this many times, change
+		// is written to the fake sysfs.
+		after  int
+		change map[string]string
+	}{
+		{
+			name: "port becomes forwarding",
+			files: map[string]string{
+				"br0/carrier":         "0\n",
+				"br0/brif/eth0/.keep": "",
+				"br0/brif/eth1/.keep": "",
+				"eth0/brport/state":   "0\n",
+				"eth1/brport/state":   "1\n",
+			},
+			after:  3,
+			change: map[string]string{"eth1/brport/state": "3\n"},
+		},
+		{
+			name: "carrier comes up",
+			files: map[string]string{
+				"br0/carrier":         "0\n",
+				"br0/brif/eth1/.keep": "",
+				"eth1/brport/state":   "2\n",
+			},
+			after:  2,
+			change: map[string]string{"br0/carrier": "1\n"},
+		},
+		{
+			name: "detached port",
+			files: map[string]string{
+				"br0/carrier":         "0\n",
+				"br0/brif/eth0/.keep": "",
+				"br0/brif/eth1/.keep": "",
+				"eth1/brport/state":   "3\n",
+			},
+		},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			root := fakeSysfs(t, tt.files)
+			oldRead, oldInterval := readFile, statePollInterval
+			statePollInterval = time.Millisecond
+			t.Cleanup(func() { readFile, statePollInterval = oldRead, oldInterval })
+
+			reads := 0
+			readFile = func(name string) ([]byte, error) {
+				if name == filepath.Join(root, "eth1/brport/state") {
+					if reads++; reads == tt.after {
+						for name, content := range tt.change {
+							if err := os.WriteFile(filepath.Join(root, name), []byte(content), 0o644); err != nil {
+								t.Fatal(err)
+							}
+						}
+					}
+				}
+				return oldRead(name)
+			}
+
+			if err := WaitForActive(context.Background(), "br0"); err != nil {
+				t.Fatalf("WaitForActive(%q) = %v, want nil", "br0", err)
+			}
+			if reads < tt.after {
+				t.Errorf("WaitForActive(%q) returned after %d port state reads, want %d", "br0", reads, tt.after)
+			}
+		})
+	}
+}
+
+func TestWaitForActiveDeadline(t *testing.T) {
+	fakeSysfs(t, map[string]string{
+		"br0/carrier":         "0\n",
+		"br0/brif/eth0/.keep": "",
+		"eth0/brport/state":   "1\n",
+	})
+	oldInterval := statePollInterval
+	statePollInterval = time.Millisecond
+	t.Cleanup(func() { statePollInterval = oldInterval })
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if err := WaitForActive(ctx, "br0"); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("WaitForActive(%q) = %v, want %v", "br0", err, context.DeadlineExceeded)
+	}
+}
+
+func TestWaitForActiveNoBridge(t *testing.T) {
+	fakeSysfs(t, map[string]string{})
+
+	if err := WaitForActive(context.Background(), "br0"); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("WaitForActive(%q) = %v, want %v", "br0", err, os.ErrNotExist)
+	}
+}
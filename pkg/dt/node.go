@@ -224,6 +224,16 @@ func (n *Node) Update(prop Property) bool {
 	return false
 }
 
+// PropertyU32 creates a uint32 property.
+func PropertyU32(name string, value uint32) Property {
+	b := make([]byte, 4)
+	binary.BigEndian.PutUint32(b, value)
+	return Property{
+		Name:  name,
+		Value: b,
+	}
+}
+
 // PropertyU64 creates a uint64 property.
 func PropertyU64(name string, value uint64) Property {
 	b := make([]byte, 8)
@@ -0,0 +1,351 @@
+// Copyright 2024 the u-root Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package dt
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Nodes of overlays and of trees overlays are applied to, as generated by
+// dtc -@.
+const (
+	overlayNode     = "__overlay__"
+	symbolsNode     = "__symbols__"
+	fixupsNode      = "__fixups__"
+	localFixupsNode = "__local_fixups__"
+)
+
+var (
+	// ErrOverlayTarget is returned if the target of an overlay fragment
+	// is not in the base tree.
+	ErrOverlayTarget = errors.New("overlay target not found")
+
+	// ErrOverlayFixup is returned if a phandle reference of an overlay
+	// cannot be resolved.
+	ErrOverlayFixup = errors.New("cannot resolve overlay phandle reference")
+
+	errOverlayFragment = errors.New("overlay fragment has neither target nor target-path")
+)
+
+// ApplyOverlay applies the device tree overlay, compiled with dtc -@, to fdt.
+//
+// The phandles of the overlay are moved above those of fdt, and references
+// to labels of fdt, listed in the overlay's __fixups__, are resolved with
+// fdt's __symbols__. Then the __overlay__ node of each fragment is merged
+// into its target in fdt, given by phandle in "target" or by path in
+// "target-path". The labels of the overlay are added to fdt's __symbols__.
+//
+// A target or label missing in fdt is an error, wrapping ErrOverlayTarget or
+// ErrOverlayFixup. overlay is modified; fdt is only modified if the overlay
+// applies.
+func (fdt *FDT) ApplyOverlay(overlay *FDT) error {
+	delta := uint32(fdt.maxPHandle())
+	if err := overlay.RootNode.Walk(func(n *Node) error {
+		return adjustPHandles(n, delta)
+	}); err != nil {
+		return err
+	}
+	if fixups, ok := overlay.RootNode.LookupChildByName(localFixupsNode); ok {
+		if err := adjustLocalFixups(overlay.RootNode, fixups, delta); err != nil {
+			return err
+		}
+	}
+	if err := fdt.resolveFixups(overlay); err != nil {
+		return err
+	}
+
+	type fragment struct {
+		target     *Node
+		targetPath string
+		overlay    *Node
+	}
+	fragments := map[string]fragment{}
+	var order []string
+	for _, n := range overlay.RootNode.Children {
+		o, ok := n.LookupChildByName(overlayNode)
+		if !ok {
+			continue
+		}
+		target, path, err := fdt.fragmentTarget(n)
+		if err != nil {
+			return fmt.Errorf("fragment %s: %w", n.Name, err)
+		}
+		fragments[n.Name] = fragment{target: target, targetPath: path, overlay: o}
+		order = append(order, n.Name)
+	}
+
+	// Labels of the overlay point into fragments, e.g.
+	// /fragment@0/__overlay__/uart@1000. Only labels of nodes merged
+	// into fdt are kept.
+	var symbols []Property
+	if s, ok := overlay.RootNode.LookupChildByName(symbolsNode); ok {
+		for _, p := range s.Properties {
+			path, err := p.AsString()
+			if err != nil {
+				return fmt.Errorf("overlay label %s: %w", p.Name, err)
+			}
+			elems := strings.SplitN(strings.TrimPrefix(path, "/"), "/", 3)
+			frag, ok := fragments[elems[0]]
+			if len(elems) < 2 || elems[1] != overlayNode || !ok {
+				continue
+			}
+			newPath := frag.targetPath
+			if len(elems) == 3 {
+				newPath = strings.TrimSuffix(newPath, "/") + "/" + elems[2]
+			}
+			symbols = append(symbols, PropertyString(p.Name, newPath))
+		}
+	}
+
+	for _, name := range order {
+		mergeNode(fragments[name].target, fragments[name].overlay)
+	}
+	if len(symbols) > 0 {
+		s, ok := fdt.RootNode.LookupChildByName(symbolsNode)
+		if !ok {
+			s = NewNode(symbolsNode)
+			fdt.RootNode.Children = append(fdt.RootNode.Children, s)
+		}
+		for _, p := range symbols {
+			s.Update(p)
+		}
+	}
+	return nil
+}
+
+// phandleProperties are the properties holding a node's phandle.
+var phandleProperties = []string{"phandle", "linux,phandle"}
+
+// phandle returns the phandle of n.
+func (n *Node) phandle() (PHandle, bool) {
+	for _, name := range phandleProperties {
+		if p, ok := n.LookProperty(name); ok {
+			if h, err := p.AsPHandle(); err == nil {
+				return h, true
+			}
+		}
+	}
+	return 0, false
+}
+
+// maxPHandle returns the highest phandle of fdt, or 0 if it has none.
+func (fdt *FDT) maxPHandle() PHandle {
+	var highest PHandle
+	_ = fdt.RootNode.Walk(func(n *Node) error {
+		if h, ok := n.phandle(); ok && h > highest && h != ^PHandle(0) {
+			highest = h
+		}
+		return nil
+	})
+	return highest
+}
+
+// nodeByPHandle returns the node of fdt with phandle h.
+func (fdt *FDT) nodeByPHandle(h PHandle) (*Node, bool) {
+	return fdt.RootNode.Find(func(n *Node) bool {
+		nh, ok := n.phandle()
+		return ok && nh == h
+	})
+}
+
+// nodeByPath returns the node of fdt at the absolute path, e.g.
+// /soc/uart@1000.
+func (fdt *FDT) nodeByPath(path string) (*Node, bool) {
+	if !strings.HasPrefix(path, "/") {
+		return nil, false
+	}
+	n := fdt.RootNode
+	for _, name := range strings.Split(path, "/") {
+		if name == "" {
+			continue
+		}
+		var ok bool
+		if n, ok = n.LookupChildByName(name); !ok {
+			return nil, false
+		}
+	}
+	return n, true
+}
+
+// pathOf returns the absolute path of target in fdt.
+func (fdt *FDT) pathOf(target *Node) (string, bool) {
+	var find func(n *Node, path string) (string, bool)
+	find = func(n *Node, path string) (string, bool) {
+		if n == target {
+			return path, true
+		}
+		for _, c := range n.Children {
+			if p, ok := find(c, strings.TrimSuffix(path, "/")+"/"+c.Name); ok {
+				return p, true
+			}
+		}
+		return "", false
+	}
+	return find(fdt.RootNode, "/")
+}
+
+// adjustPHandles adds delta to the phandle of n.
+func adjustPHandles(n *Node, delta uint32) error {
+	for _, name := range phandleProperties {
+		p, ok := n.LookProperty(name)
+		if !ok {
+			continue
+		}
+		if len(p.Value) != 4 {
+			return fmt.Errorf("%w: %s of %s is not <u32>", ErrOverlayFixup, name, n.Name)
+		}
+		binary.BigEndian.PutUint32(p.Value, binary.BigEndian.Uint32(p.Value)+delta)
+	}
+	return nil
+}
+
+// adjustLocalFixups adds delta to the references to phandles of the overlay
+// itself. fixups mirrors n, with each property listing the offsets of the
+// references in the property of n of the same name.
+func adjustLocalFixups(n, fixups *Node, delta uint32) error {
+	for _, f := range fixups.Properties {
+		p, ok := n.LookProperty(f.Name)
+		if !ok || len(f.Value)%4 != 0 {
+			return fmt.Errorf("%w: local fixup %s of %s", ErrOverlayFixup, f.Name, n.Name)
+		}
+		for i := 0; i < len(f.Value); i += 4 {
+			off := binary.BigEndian.Uint32(f.Value[i:])
+			if uint64(off)+4 > uint64(len(p.Value)) {
+				return fmt.Errorf("%w: local fixup %s of %s at offset %d", ErrOverlayFixup, f.Name, n.Name, off)
+			}
+			binary.BigEndian.PutUint32(p.Value[off:], binary.BigEndian.Uint32(p.Value[off:])+delta)
+		}
+	}
+	for _, c := range fixups.Children {
+		child, ok := n.LookupChildByName(c.Name)
+		if !ok {
+			return fmt.Errorf("%w: local fixups for missing node %s", ErrOverlayFixup, c.Name)
+		}
+		if err := adjustLocalFixups(child, c, delta); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// resolveFixups writes the phandles of the labels of fdt that the overlay
+// refers to. Each property of the overlay's __fixups__ is named after a
+// label and lists the references as path:property:offset.
+func (fdt *FDT) resolveFixups(overlay *FDT) error {
+	fixups, ok := overlay.RootNode.LookupChildByName(fixupsNode)
+	if !ok {
+		return nil
+	}
+	for _, f := range fixups.Properties {
+		h, err := fdt.labelPHandle(f.Name)
+		if err != nil {
+			return err
+		}
+		for _, ref := range bytes.Split(bytes.TrimSuffix(f.Value, []byte{0}), []byte{0}) {
+			if err := overlay.writeFixup(string(ref), h); err != nil {
+				return fmt.Errorf("%w: label %s: %w", ErrOverlayFixup, f.Name, err)
+			}
+		}
+	}
+	return nil
+}
+
+// labelPHandle returns the phandle of the node of fdt with the given label.
+func (fdt *FDT) labelPHandle(label string) (PHandle, error) {
+	symbols, ok := fdt.RootNode.LookupChildByName(symbolsNode)
+	if !ok {
+		return 0, fmt.Errorf("%w: label %s: base tree has no %s", ErrOverlayFixup, label, symbolsNode)
+	}
+	p, ok := symbols.LookProperty(label)
+	if !ok {
+		return 0, fmt.Errorf("%w: label %s not in base tree", ErrOverlayFixup, label)
+	}
+	path, err := p.AsString()
+	if err != nil {
+		return 0, fmt.Errorf("%w: label %s: %w", ErrOverlayFixup, label, err)
+	}
+	n, ok := fdt.nodeByPath(path)
+	if !ok {
+		return 0, fmt.Errorf("%w: label %s: no node %s", ErrOverlayFixup, label, path)
+	}
+	h, ok := n.phandle()
+	if !ok {
+		return 0, fmt.Errorf("%w: label %s: %s has no phandle", ErrOverlayFixup, label, path)
+	}
+	return h, nil
+}
+
+// writeFixup writes phandle h to the reference ref, path:property:offset.
+func (fdt *FDT) writeFixup(ref string, h PHandle) error {
+	path, rest, ok := strings.Cut(ref, ":")
+	prop, offset, ok2 := strings.Cut(rest, ":")
+	if !ok || !ok2 {
+		return fmt.Errorf("malformed fixup %q", ref)
+	}
+	off, err := strconv.ParseUint(offset, 10, 32)
+	if err != nil {
+		return fmt.Errorf("malformed fixup %q: %w", ref, err)
+	}
+	n, ok := fdt.nodeByPath(path)
+	if !ok {
+		return fmt.Errorf("fixup %q: no node %s", ref, path)
+	}
+	p, ok := n.LookProperty(prop)
+	if !ok || off+4 > uint64(len(p.Value)) {
+		return fmt.Errorf("fixup %q: no property %s of 4 bytes at offset %d", ref, prop, off)
+	}
+	binary.BigEndian.PutUint32(p.Value[off:], uint32(h))
+	return nil
+}
+
+// fragmentTarget returns the node of fdt the overlay fragment n applies to,
+// and its path.
+func (fdt *FDT) fragmentTarget(n *Node) (*Node, string, error) {
+	if p, ok := n.LookProperty("target"); ok {
+		h, err := p.AsPHandle()
+		if err != nil {
+			return nil, "", err
+		}
+		target, ok := fdt.nodeByPHandle(h)
+		if !ok {
+			return nil, "", fmt.Errorf("%w: phandle %#x", ErrOverlayTarget, h)
+		}
+		path, _ := fdt.pathOf(target)
+		return target, path, nil
+	}
+	if p, ok := n.LookProperty("target-path"); ok {
+		path, err := p.AsString()
+		if err != nil {
+			return nil, "", err
+		}
+		target, ok := fdt.nodeByPath(path)
+		if !ok {
+			return nil, "", fmt.Errorf("%w: %s", ErrOverlayTarget, path)
+		}
+		return target, path, nil
+	}
+	return nil, "", errOverlayFragment
+}
+
+// mergeNode merges the properties and children of src into dst. Properties
+// of src replace those of dst of the same name, children of the same name
+// are merged.
+func mergeNode(dst, src *Node) {
+	for _, p := range src.Properties {
+		dst.Update(p)
+	}
+	for _, c := range src.Children {
+		if d, ok := dst.LookupChildByName(c.Name); ok {
+			mergeNode(d, c)
+			continue
+		}
+		dst.Children = append(dst.Children, c)
+	}
+}
@@ -0,0 +1,181 @@
+// Copyright 2024 the u-root Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package dt
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+)
+
+// overlayBase is a tree compiled with dtc -@, with labels uart0 and clk.
+func overlayBase() *FDT {
+	return &FDT{RootNode: NewNode("/", WithChildren(
+		NewNode("soc", WithChildren(
+			NewNode("uart@1000", WithProperty(
+				PropertyString("status", "disabled"),
+				PropertyU32("phandle", 1),
+			)),
+			NewNode("clk", WithProperty(PropertyU32("phandle", 2))),
+		)),
+		NewNode(symbolsNode, WithProperty(
+			PropertyString("uart0", "/soc/uart@1000"),
+			PropertyString("clk", "/soc/clk"),
+		)),
+	))}
+}
+
+// testOverlay is the overlay
+//
+//	&uart0 {
+//		status = "okay";
+//		clocks = <&clk>;
+//		dmas = <&dma0>;
+//		child { compatible = "child"; };
+//	};
+//	&{/soc} {
+//		dma0: dma@2000 { compatible = "dma"; };
+//	};
+//
+// compiled with dtc -@.
+func testOverlay() *FDT {
+	return &FDT{RootNode: NewNode("/", WithChildren(
+		NewNode("fragment@0",
+			WithProperty(PropertyU32("target", 0xffffffff)),
+			WithChildren(NewNode(overlayNode,
+				WithProperty(
+					PropertyString("status", "okay"),
+					PropertyU32("clocks", 0xffffffff),
+					PropertyU32("dmas", 1),
+				),
+				WithChildren(NewNode("child", WithProperty(PropertyString("compatible", "child")))),
+			)),
+		),
+		NewNode("fragment@1",
+			WithProperty(PropertyString("target-path", "/soc")),
+			WithChildren(NewNode(overlayNode, WithChildren(
+				NewNode("dma@2000", WithProperty(
+					PropertyString("compatible", "dma"),
+					PropertyU32("phandle", 1),
+				)),
+			))),
+		),
+		NewNode(symbolsNode, WithProperty(
+			PropertyString("dma0", "/fragment@1/__overlay__/dma@2000"),
+		)),
+		NewNode(fixupsNode, WithProperty(
+			PropertyString("uart0", "/fragment@0:target:0"),
+			PropertyString("clk", "/fragment@0/__overlay__:clocks:0"),
+		)),
+		NewNode(localFixupsNode, WithChildren(
+			NewNode("fragment@0", WithChildren(
+				NewNode(overlayNode, WithProperty(PropertyU32("dmas", 0))),
+			)),
+		)),
+	))}
+}
+
+func TestApplyOverlay(t *testing.T) {
+	fdt := overlayBase()
+	if err := fdt.ApplyOverlay(testOverlay()); err != nil {
+		t.Fatalf("ApplyOverlay() = %v, want nil", err)
+	}
+
+	uart, _ := fdt.nodeByPath("/soc/uart@1000")
+	dma, ok := fdt.nodeByPath("/soc/dma@2000")
+	if !ok {
+		t.Fatalf("no /soc/dma@2000 after ApplyOverlay:\n%s", fdt)
+	}
+	if _, ok := fdt.nodeByPath("/soc/uart@1000/child"); !ok {
+		t.Errorf("no /soc/uart@1000/child after ApplyOverlay:\n%s", fdt)
+	}
+
+	// The overlay's phandle 1 moves above the base's highest phandle 2.
+	for _, tt := range []struct {
+		node *Node
+		prop Property
+	}{
+		{uart, PropertyString("status", "okay")},
+		{uart, PropertyU32("phandle", 1)},
+		{uart, PropertyU32("clocks", 2)},
+		{uart, PropertyU32("dmas", 3)},
+		{dma, PropertyU32("phandle", 3)},
+	} {
+		p, ok := tt.node.LookProperty(tt.prop.Name)
+		if !ok || !reflect.DeepEqual(p.Value, tt.prop.Value) {
+			t.Errorf("%s/%s = %v, want %x", tt.node.Name, tt.prop.Name, p, tt.prop.Value)
+		}
+	}
+
+	symbols, _ := fdt.RootNode.LookupChildByName(symbolsNode)
+	p, ok := symbols.LookProperty("dma0")
+	if want := PropertyString("dma0", "/soc/dma@2000"); !ok || !reflect.DeepEqual(*p, want) {
+		t.Errorf("%s/dma0 = %v, want %v", symbolsNode, p, want)
+	}
+}
+
+func TestApplyOverlayErrors(t *testing.T) {
+	for _, tt := range []struct {
+		name   string
+		modify func(o *FDT)
+		want   error
+	}{
+		{
+			name: "missing target phandle",
+			modify: func(o *FDT) {
+				fixups, _ := o.RootNode.LookupChildByName(fixupsNode)
+				fixups.RemoveProperty("uart0")
+				frag, _ := o.RootNode.LookupChildByName("fragment@0")
+				frag.Update(PropertyU32("target", 0x99))
+			},
+			want: ErrOverlayTarget,
+		},
+		{
+			name: "missing target path",
+			modify: func(o *FDT) {
+				frag, _ := o.RootNode.LookupChildByName("fragment@1")
+				frag.Update(PropertyString("target-path", "/bus"))
+			},
+			want: ErrOverlayTarget,
+		},
+		{
+			name: "missing label",
+			modify: func(o *FDT) {
+				fixups, _ := o.RootNode.LookupChildByName(fixupsNode)
+				fixups.Update(PropertyString("uart1", "/fragment@0:target:0"))
+			},
+			want: ErrOverlayFixup,
+		},
+		{
+			name: "fixup out of range",
+			modify: func(o *FDT) {
+				fixups, _ := o.RootNode.LookupChildByName(fixupsNode)
+				fixups.Update(PropertyString("clk", "/fragment@0/__overlay__:clocks:4"))
+			},
+			want: ErrOverlayFixup,
+		},
+		{
+			name: "no target",
+			modify: func(o *FDT) {
+				frag, _ := o.RootNode.LookupChildByName("fragment@1")
+				frag.RemoveProperty("target-path")
+			},
+			want: errOverlayFragment,
+		},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			overlay := testOverlay()
+			tt.modify(overlay)
+
+			fdt := overlayBase()
+			if err := fdt.ApplyOverlay(overlay); !errors.Is(err, tt.want) {
+				t.Errorf("ApplyOverlay() = %v, want %v", err, tt.want)
+			}
+			if !reflect.DeepEqual(fdt, overlayBase()) {
+				t.Errorf("ApplyOverlay() changed the base tree:\n%s", fdt)
+			}
+		})
+	}
+}